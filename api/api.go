@@ -0,0 +1,284 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2022 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package api is the supported interface to deploy and manage sandboxes from Go programs.
+// Its functions never end the calling process: every failure is returned as an error,
+// whose category can be detected with errors.Is, using the Err* values of this package.
+// The context given to each function stops the commands that it runs. Commands queued
+// for concurrent execution (SandboxDef.RunConcurrently) are not interrupted.
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/user"
+	"path"
+	"sync"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/defaults"
+	"github.com/datacharmer/dbdeployer/globals"
+	"github.com/datacharmer/dbdeployer/ops"
+	"github.com/datacharmer/dbdeployer/sandbox"
+)
+
+// Categories of the errors returned by this package
+var (
+	ErrPortConflict    = sandbox.ErrPortConflict
+	ErrMissingBinaries = sandbox.ErrMissingBinaries
+	ErrUnsupported     = sandbox.ErrUnsupported
+	ErrStartTimeout    = sandbox.ErrStartTimeout
+	ErrSandboxExists   = sandbox.ErrSandboxExists
+	ErrSandboxNotFound = errors.New("sandbox not found")
+	ErrSandboxLocked   = errors.New("sandbox is locked")
+	ErrInvalidDefaults = errors.New("invalid defaults")
+)
+
+var (
+	loadFlavorsOnce sync.Once
+	flavorErrors    []error
+)
+
+// LoadCustomFlavors registers the custom flavors described in the flavors directory of
+// the dbdeployer configuration, as the command line does. It runs once, and the
+// functions of this package that detect or use flavors call it. Broken descriptors
+// are skipped: their errors are returned, and the other flavors are still available
+func LoadCustomFlavors() []error {
+	loadFlavorsOnce.Do(func() {
+		flavorErrors = common.LoadFlavorDescriptors(path.Join(defaults.ConfigurationDir, globals.FlavorsDirName))
+	})
+	return flavorErrors
+}
+
+// loadDefaults reads the dbdeployer defaults, which the command line would
+// refuse if its configuration file can't be read
+func loadDefaults() (defaults.DbdeployerDefaults, error) {
+	currentDefaults, err := defaults.LoadDefaults()
+	return currentDefaults, sandbox.WithKind(ErrInvalidDefaults, err)
+}
+
+// NewSandboxDef returns the definition of a sandbox using the binaries in sandboxBinary/version,
+// to be deployed in sandboxHome. Its other fields have the same defaults as the deploy command
+func NewSandboxDef(sandboxBinary, sandboxHome, version string) (sandbox.SandboxDef, error) {
+	var sd sandbox.SandboxDef
+	currentDefaults, err := loadDefaults()
+	if err != nil {
+		return sd, err
+	}
+	LoadCustomFlavors()
+	if !common.IsVersion(version) {
+		return sd, fmt.Errorf("no version detected in '%s'", version)
+	}
+	basedir := path.Join(sandboxBinary, version)
+	if !common.DirExists(basedir) {
+		return sd, sandbox.WithKind(ErrMissingBinaries, fmt.Errorf(globals.ErrBaseDirectoryNotFound, basedir))
+	}
+	port, err := common.VersionToPort(version)
+	if err != nil {
+		return sd, err
+	}
+	if port < 0 {
+		return sd, fmt.Errorf("unsupported version format (%s)", version)
+	}
+	flavor, err := sandbox.DetectFlavor("", basedir)
+	if err != nil {
+		return sd, err
+	}
+	err = sandbox.UseFlavorTemplates(flavor)
+	if err != nil {
+		return sd, err
+	}
+	err = common.CheckSandboxDir(sandboxHome)
+	if err != nil {
+		return sd, err
+	}
+	installedPorts, err := common.GetInstalledPorts(sandboxHome)
+	if err != nil {
+		return sd, err
+	}
+	var owner string
+	currentUser, err := user.Current()
+	if err == nil {
+		owner = currentUser.Username
+	}
+	sd = sandbox.SandboxDef{
+		Version:              version,
+		BasedirName:          version,
+		Basedir:              basedir,
+		SandboxDir:           sandboxHome,
+		SbHost:               globals.LocalHostIP,
+		Port:                 port,
+		Flavor:               flavor,
+		InstalledPorts:       append(installedPorts, currentDefaults.ReservedPorts...),
+		LoadGrants:           true,
+		DbUser:               globals.DbUserValue,
+		DbPassword:           globals.DbPasswordValue,
+		RplUser:              globals.RplUserValue,
+		RplPassword:          globals.RplPasswordValue,
+		RemoteAccess:         globals.RemoteAccessValue,
+		BindAddress:          globals.BindAddressValue,
+		DefaultRole:          globals.DefaultRoleValue,
+		CustomRoleName:       globals.CustomRoleNameValue,
+		CustomRolePrivileges: globals.CustomRolePrivilegesValue,
+		CustomRoleTarget:     globals.CustomRoleTargetValue,
+		CustomRoleExtra:      globals.CustomRoleExtraValue,
+		ShellPath:            currentDefaults.ShellPath,
+		MysqlshPath:          currentDefaults.MysqlshPath,
+		Owner:                owner,
+	}
+	return sd, nil
+}
+
+// DeploySingle deploys a standalone sandbox
+func DeploySingle(ctx context.Context, sd sandbox.SandboxDef) error {
+	_, err := loadDefaults()
+	if err != nil {
+		return err
+	}
+	LoadCustomFlavors()
+	sd.Context = ctx
+	// When deploying a single sandbox, we disable concurrency
+	sd.RunConcurrently = false
+	return sandbox.CreateStandaloneSandbox(sd)
+}
+
+// DeployMultiple deploys a sandbox with several unrelated servers of the same version
+func DeployMultiple(ctx context.Context, sd sandbox.SandboxDef, nodes int) error {
+	_, err := loadDefaults()
+	if err != nil {
+		return err
+	}
+	LoadCustomFlavors()
+	sd.Context = ctx
+	sd.SBType = globals.SbTypeMultiple
+	_, err = sandbox.CreateMultipleSandbox(sd, sd.BasedirName, nodes)
+	return err
+}
+
+// DeployReplication deploys a sandbox with the replication topology described in replData
+func DeployReplication(ctx context.Context, sd sandbox.SandboxDef, replData sandbox.ReplicationData) error {
+	_, err := loadDefaults()
+	if err != nil {
+		return err
+	}
+	LoadCustomFlavors()
+	if common.BaseFlavor(sd.Flavor) == common.TiDbFlavor {
+		return sandbox.WithKind(ErrUnsupported,
+			fmt.Errorf("flavor '%s' is not suitable to create replication sandboxes", common.TiDbFlavor))
+	}
+	sd.Context = ctx
+	if sd.ReplOptions == "" {
+		sd.ReplOptions = sandbox.SingleTemplates[globals.TmplReplicationOptions].Contents
+	}
+	return sandbox.CreateReplicationSandbox(sd, sd.BasedirName, replData)
+}
+
+// sandboxScript returns the path of a sandbox script, preferring
+// the one that acts on all the nodes of a composite sandbox
+func sandboxScript(sandboxPath, script, scriptAll string) (string, error) {
+	if !common.FileExists(path.Join(sandboxPath, globals.SandboxDescriptionName)) {
+		return "", fmt.Errorf("%w: %s", ErrSandboxNotFound, sandboxPath)
+	}
+	for _, name := range []string{scriptAll, script} {
+		scriptPath := path.Join(sandboxPath, name)
+		if common.ExecExists(scriptPath) {
+			return scriptPath, nil
+		}
+	}
+	return "", fmt.Errorf(globals.ErrExecutableNotFound, path.Join(sandboxPath, script))
+}
+
+// Start starts the servers of a sandbox, running the start hooks defined in the defaults
+func Start(ctx context.Context, sandboxPath string) error {
+	script, err := sandboxScript(sandboxPath, globals.ScriptStart, globals.ScriptStartAll)
+	if err != nil {
+		return err
+	}
+	_, err = loadDefaults()
+	if err != nil {
+		return err
+	}
+	_, err = defaults.RunHookCtrl(defaults.HookPreStart, sandboxPath, true)
+	if err != nil {
+		return err
+	}
+	out, err := common.RunCmdContext(ctx, script, nil, true)
+	if err != nil {
+		return sandbox.StartError(out, err)
+	}
+	_, err = defaults.RunHookCtrl(defaults.HookPostStart, sandboxPath, true)
+	return err
+}
+
+// Stop stops the servers of a sandbox, running the stop hooks defined in the defaults
+func Stop(ctx context.Context, sandboxPath string) error {
+	script, err := sandboxScript(sandboxPath, globals.ScriptStop, globals.ScriptStopAll)
+	if err != nil {
+		return err
+	}
+	_, err = loadDefaults()
+	if err != nil {
+		return err
+	}
+	_, err = defaults.RunHookCtrl(defaults.HookPreStop, sandboxPath, true)
+	if err != nil {
+		return err
+	}
+	_, err = common.RunCmdContext(ctx, script, nil, true)
+	if err != nil {
+		return err
+	}
+	_, err = defaults.RunHookCtrl(defaults.HookPostStop, sandboxPath, true)
+	return err
+}
+
+// Delete stops and removes a sandbox, and deletes it from the catalog. Locked sandboxes are not removed
+func Delete(ctx context.Context, sandboxHome, name string) error {
+	LoadCustomFlavors()
+	err := ctx.Err()
+	if err != nil {
+		return err
+	}
+	_, err = loadDefaults()
+	if err != nil {
+		return err
+	}
+	sandboxPath := path.Join(sandboxHome, name)
+	sbDesc, err := common.ReadSandboxDescription(sandboxPath)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrSandboxNotFound, sandboxPath)
+	}
+	if common.FileExists(path.Join(sandboxPath, globals.ScriptNoClear)) ||
+		common.FileExists(path.Join(sandboxPath, globals.ScriptNoClearAll)) {
+		return fmt.Errorf("%w: %s", ErrSandboxLocked, sandboxPath)
+	}
+	_, err = sandbox.RemoveCustomSandbox(sandboxHome, name, false, sandbox.UseStopForFlavor(sbDesc.Flavor, false))
+	if err != nil {
+		return err
+	}
+	return defaults.DeleteFromCatalog(sandboxPath)
+}
+
+// Status returns the live status of the servers of a sandbox.
+// When runQuery is set, running servers are also asked for version, uptime, and replication role
+func Status(sandboxPath string, runQuery bool) (ops.SandboxStatus, error) {
+	return ops.GetSandboxStatus(sandboxPath, runQuery)
+}
+
+// Query runs a SQL query in the server of a sandbox directory, and returns all its rows
+func Query(ctx context.Context, sandboxPath, query string) (ops.QueryResult, error) {
+	return ops.QuerySandbox(ctx, sandboxPath, query, false)
+}
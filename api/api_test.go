@@ -0,0 +1,133 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2022 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/defaults"
+	"github.com/datacharmer/dbdeployer/globals"
+	"github.com/stretchr/testify/require"
+)
+
+// makeFakeSandbox creates a sandbox directory whose start script runs startScript
+func makeFakeSandbox(t *testing.T, sandboxHome, name, startScript string) string {
+	sandboxPath := path.Join(sandboxHome, name)
+	require.NoError(t, os.MkdirAll(sandboxPath, globals.PublicDirectoryAttr))
+	err := common.WriteSandboxDescription(sandboxPath, common.SandboxDescription{
+		Basedir: path.Join(sandboxHome, "binaries"),
+		SBType:  globals.SbTypeSingle,
+		Version: "8.0.30",
+		Flavor:  common.MySQLFlavor,
+		Port:    []int{8030},
+		Nodes:   0,
+	})
+	require.NoError(t, err)
+	script := "#!/bin/sh\n" + startScript + "\n"
+	require.NoError(t, os.WriteFile(path.Join(sandboxPath, globals.ScriptStart), []byte(script), globals.ExecutableFileAttr))
+	return sandboxPath
+}
+
+func TestNewSandboxDef(t *testing.T) {
+	sandboxBinary := t.TempDir()
+	sandboxHome := t.TempDir()
+
+	_, err := NewSandboxDef(sandboxBinary, sandboxHome, "8.0.30")
+	require.ErrorIs(t, err, ErrMissingBinaries)
+
+	_, err = NewSandboxDef(sandboxBinary, sandboxHome, "no-version")
+	require.Error(t, err)
+	require.False(t, errors.Is(err, ErrMissingBinaries))
+
+	basedir := path.Join(sandboxBinary, "8.0.30")
+	require.NoError(t, os.MkdirAll(basedir, globals.PublicDirectoryAttr))
+	require.NoError(t, os.WriteFile(path.Join(basedir, globals.FlavorFileName), []byte(common.MySQLFlavor), 0600))
+	sd, err := NewSandboxDef(sandboxBinary, sandboxHome, "8.0.30")
+	require.NoError(t, err)
+	require.Equal(t, basedir, sd.Basedir)
+	require.Equal(t, common.MySQLFlavor, sd.Flavor)
+	require.Equal(t, 8030, sd.Port)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = DeploySingle(ctx, sd)
+	require.ErrorIs(t, err, context.Canceled)
+	require.False(t, common.DirExists(path.Join(sandboxHome, "msb_8_0_30")))
+}
+
+func TestStart(t *testing.T) {
+	sandboxHome := t.TempDir()
+
+	err := Start(context.Background(), path.Join(sandboxHome, "msb_none"))
+	require.ErrorIs(t, err, ErrSandboxNotFound)
+
+	sandboxPath := makeFakeSandbox(t, sandboxHome, "msb_ok", "echo 'sandbox server started'")
+	require.NoError(t, Start(context.Background(), sandboxPath))
+
+	sandboxPath = makeFakeSandbox(t, sandboxHome, "msb_slow", "echo 'sandbox server not started yet'\nexit 1")
+	err = Start(context.Background(), sandboxPath)
+	require.ErrorIs(t, err, ErrStartTimeout)
+
+	sandboxPath = makeFakeSandbox(t, sandboxHome, "msb_hanging", "sleep 30")
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	started := time.Now()
+	err = Start(ctx, sandboxPath)
+	require.ErrorIs(t, err, ErrStartTimeout)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Less(t, time.Since(started), 10*time.Second)
+
+	sandboxPath = makeFakeSandbox(t, sandboxHome, "msb_broken", "echo 'mysqld_safe not found' >&2\nexit 1")
+	err = Start(context.Background(), sandboxPath)
+	require.Error(t, err)
+	require.False(t, errors.Is(err, ErrStartTimeout))
+}
+
+func TestDelete(t *testing.T) {
+	sandboxHome := t.TempDir()
+
+	err := Delete(context.Background(), sandboxHome, "msb_none")
+	require.ErrorIs(t, err, ErrSandboxNotFound)
+
+	sandboxPath := makeFakeSandbox(t, sandboxHome, "msb_locked", "exit 0")
+	require.NoError(t, os.WriteFile(path.Join(sandboxPath, globals.ScriptNoClear), []byte{}, globals.ExecutableFileAttr))
+	err = Delete(context.Background(), sandboxHome, "msb_locked")
+	require.ErrorIs(t, err, ErrSandboxLocked)
+	require.True(t, common.DirExists(sandboxPath))
+}
+
+func TestInvalidDefaults(t *testing.T) {
+	// Runs after HOME is restored
+	t.Cleanup(defaults.ResetDefaults)
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	defaults.ResetDefaults()
+	require.NoError(t, os.MkdirAll(defaults.ConfigurationDir, globals.PublicDirectoryAttr))
+	require.NoError(t, os.WriteFile(defaults.ConfigurationFile, []byte("{ not json"), 0600))
+
+	_, err := NewSandboxDef(t.TempDir(), t.TempDir(), "8.0.30")
+	require.ErrorIs(t, err, ErrInvalidDefaults)
+
+	sandboxPath := makeFakeSandbox(t, t.TempDir(), "msb_8_0_30", "exit 0")
+	err = Start(context.Background(), sandboxPath)
+	require.ErrorIs(t, err, ErrInvalidDefaults)
+}
@@ -205,14 +205,6 @@ func DryRunCmd(cmd string, dryRun bool) (string, error) {
 }
 
 func upgradeSandbox(sandboxDir, oldSandbox, newSandbox string, verbose, dryRun bool) error {
-	var possibleUpgrades = map[string]string{
-		"5.0": "5.1",
-		"5.1": "5.5",
-		"5.5": "5.6",
-		"5.6": "5.7",
-		"5.7": "8.0",
-		"8.0": "8.0",
-	}
 	if dryRun {
 		verbose = true
 	}
@@ -229,7 +221,8 @@ func upgradeSandbox(sandboxDir, oldSandbox, newSandbox string, verbose, dryRun b
 		for _, script := range scripts {
 			if !common.ExecExists(path.Join(dir, script)) {
 				common.Exit(1, fmt.Sprintf(globals.ErrScriptNotFoundInUpper, script, dir),
-					"The upgrade only works between SINGLE deployments")
+					"The upgrade between two sandboxes only works between SINGLE deployments",
+					fmt.Sprintf("Use 'dbdeployer admin upgrade %s VERSION' to upgrade a composite sandbox in place", oldSandbox))
 			}
 		}
 	}
@@ -241,55 +234,24 @@ func upgradeSandbox(sandboxDir, oldSandbox, newSandbox string, verbose, dryRun b
 	if err != nil {
 		return errors.Wrapf(err, "error reading old sandbox description")
 	}
-	mysqlUpgrade := path.Join(newSbdesc.Basedir, "bin", "mysql_upgrade")
-	if !common.ExecExists(mysqlUpgrade) {
-		_ = common.WriteString("", path.Join(newSandbox, "no_upgrade"))
-		return errors.Errorf("mysql_upgrade not found in %s. Upgrade is not possible", newSbdesc.Basedir)
-	}
-	newVersionList, err := common.VersionToList(newSbdesc.Version)
-	if err != nil {
-		return errors.Wrapf(err, "error converting new sandbox version to major/minor/rev")
-	}
-	newMajor := newVersionList[0]
-	newMinor := newVersionList[1]
-	newRev := newVersionList[2]
-	oldVersionList, err := common.VersionToList(oldSbdesc.Version)
-	if err != nil {
-		return errors.Wrapf(err, "error converting old sandbox version to major/minor/rev")
-	}
-	oldMajor := oldVersionList[0]
-	oldMinor := oldVersionList[1]
-	oldRev := oldVersionList[2]
-	newUpgradeVersion := fmt.Sprintf("%d.%d", newVersionList[0], newVersionList[1])
-	oldUpgradeVersion := fmt.Sprintf("%d.%d", oldVersionList[0], oldVersionList[1])
-	if oldSbdesc.Flavor == common.MariaDbFlavor || newSbdesc.Flavor == common.MariaDbFlavor {
+	if common.BaseFlavor(oldSbdesc.Flavor) == common.MariaDbFlavor || common.BaseFlavor(newSbdesc.Flavor) == common.MariaDbFlavor {
 		common.Exit(1, "upgrade from and to MariaDB is not supported")
 	}
-	greaterThanNewVersion, err := common.GreaterOrEqualVersionList(oldVersionList, newVersionList)
-	common.ErrCheckExitf(err, 1, globals.ErrWhileComparingVersions)
-	if greaterThanNewVersion {
-		common.Exitf(1, "version %s must be greater than %s", newUpgradeVersion, oldUpgradeVersion)
-	}
 
 	// 8.0.16
 	upgradeWithServer, err := common.HasCapability(newSbdesc.Flavor, common.UpgradeWithServer, newSbdesc.Version)
 	if err != nil {
 		return errors.Wrapf(err, "error detecting upgrade capability")
 	}
-	canBeUpgraded := false
-	if oldMajor < newMajor {
-		canBeUpgraded = true
-	} else {
-		if oldMajor == newMajor && oldMinor < newMinor {
-			canBeUpgraded = true
-		} else {
-			if oldMajor == newMajor && oldMinor == newMinor && oldRev < newRev {
-				canBeUpgraded = true
-			}
-		}
+	// Since 8.0.16 the server upgrades itself, and mysql_upgrade was removed in 8.4
+	mysqlUpgrade := path.Join(newSbdesc.Basedir, "bin", "mysql_upgrade")
+	if !upgradeWithServer && !common.ExecExists(mysqlUpgrade) {
+		_ = common.WriteString("", path.Join(newSandbox, "no_upgrade"))
+		return errors.Errorf("mysql_upgrade not found in %s. Upgrade is not possible", newSbdesc.Basedir)
 	}
-	if !canBeUpgraded {
-		return fmt.Errorf("version '%s' can only be upgraded to '%s' or to the same version with a higher revision", oldUpgradeVersion, possibleUpgrades[oldUpgradeVersion])
+	err = sandbox.CheckUpgradePath(oldSbdesc.Version, newSbdesc.Version)
+	if err != nil {
+		return err
 	}
 	newSandboxOldData := path.Join(newSandbox, globals.DataDirName+"-"+newSandbox)
 	if common.DirExists(newSandboxOldData) {
@@ -364,23 +326,106 @@ func upgradeSandbox(sandboxDir, oldSandbox, newSandbox string, verbose, dryRun b
 }
 
 func runUpgradeSandbox(cmd *cobra.Command, args []string) {
-	if len(args) < 2 {
-		common.Exit(1,
-			"'upgrade' requires the name of two sandboxes ",
-			"Example: dbdeployer admin upgrade msb_5_7_23 msb_8_0_12")
-	}
 	oldSandbox := args[0]
 	newSandbox := args[1]
 	sandboxDir, err := getAbsolutePathFromFlag(cmd, "sandbox-home")
 	verbose, _ := cmd.Flags().GetBool(globals.VerboseLabel)
 	dryRun, _ := cmd.Flags().GetBool(globals.DryRunLabel)
+	switchover, _ := cmd.Flags().GetBool(globals.SwitchoverLabel)
 	if err != nil {
 		common.Exitf(1, "%+v", err)
 	}
-	err = upgradeSandbox(sandboxDir, oldSandbox, newSandbox, verbose, dryRun)
+	if common.DirExists(path.Join(sandboxDir, newSandbox)) {
+		if switchover {
+			common.Exitf(1, "option --%s is only used when upgrading a sandbox in place", globals.SwitchoverLabel)
+		}
+		err = upgradeSandbox(sandboxDir, oldSandbox, newSandbox, verbose, dryRun)
+		if err != nil {
+			common.Exitf(1, "%+v", err)
+		}
+		return
+	}
+	// The second argument is not a sandbox: it is the version of the binaries to upgrade to
+	sandboxBinary, err := getAbsolutePathFromFlag(cmd, globals.SandboxBinaryLabel)
+	common.ErrCheckExitf(err, 1, "error finding absolute path for '%s'", globals.SandboxBinaryLabel)
+	version, err := checkIfAbridgedVersion(newSandbox, sandboxBinary)
+	common.ErrCheckExitf(err, 1, "%s", err)
+	basedir := path.Join(sandboxBinary, version)
+	if !common.DirExists(basedir) {
+		common.Exitf(1, "'%s' is neither a sandbox in %s nor a version in %s", newSandbox, sandboxDir, sandboxBinary)
+	}
+	err = sandbox.RollingUpgrade(path.Join(sandboxDir, oldSandbox), sandbox.RollingUpgradeOptions{
+		Basedir:    basedir,
+		Version:    version,
+		Flavor:     getFlavor("", basedir),
+		Switchover: switchover,
+		DryRun:     dryRun,
+	})
 	if err != nil {
-		common.Exitf(1, "%+v", err)
+		common.Exitf(1, "error upgrading %s: %s", oldSandbox, err)
 	}
+	if !dryRun {
+		common.CondPrintf("Sandbox %s upgraded to %s\n", oldSandbox, version)
+	}
+}
+
+// upgradeArgs accepts a sandbox followed by either a newer sandbox or a version
+func upgradeArgs(cmd *cobra.Command, args []string) error {
+	err := cobra.ExactArgs(2)(cmd, args)
+	if err != nil {
+		return err
+	}
+	return SandboxNames(1)(cmd, args[:1])
+}
+
+func addNode(cmd *cobra.Command, args []string) {
+	sandboxHome, err := getAbsolutePathFromFlag(cmd, globals.SandboxHomeLabel)
+	common.ErrCheckExitf(err, 1, "error finding absolute path for '%s'", globals.SandboxHomeLabel)
+	useDump, _ := cmd.Flags().GetBool(globals.UseDumpLabel)
+	sandboxDir := path.Join(sandboxHome, args[0])
+	nodeName, err := sandbox.AddNode(sandboxDir, useDump)
+	if err != nil {
+		common.Exitf(1, "error adding node to %s: %s", args[0], err)
+	}
+	common.CondPrintf("Node %s added to %s\n", nodeName, args[0])
+}
+
+func removeNode(cmd *cobra.Command, args []string) {
+	sandboxHome, err := getAbsolutePathFromFlag(cmd, globals.SandboxHomeLabel)
+	common.ErrCheckExitf(err, 1, "error finding absolute path for '%s'", globals.SandboxHomeLabel)
+	nodeName := ""
+	if len(args) > 1 {
+		nodeName = args[1]
+	}
+	sandboxDir := path.Join(sandboxHome, args[0])
+	nodeName, err = sandbox.RemoveNode(sandboxDir, nodeName)
+	if err != nil {
+		common.Exitf(1, "error removing node from %s: %s", args[0], err)
+	}
+	common.CondPrintf("Node %s removed from %s\n", nodeName, args[0])
+}
+
+func switchover(cmd *cobra.Command, args []string) {
+	sandboxHome, err := getAbsolutePathFromFlag(cmd, globals.SandboxHomeLabel)
+	common.ErrCheckExitf(err, 1, "error finding absolute path for '%s'", globals.SandboxHomeLabel)
+	newMaster, _ := cmd.Flags().GetString(globals.NewMasterLabel)
+	failover, _ := cmd.Flags().GetBool(globals.FailoverLabel)
+	sandboxDir := path.Join(sandboxHome, args[0])
+	if failover {
+		newMaster, err = sandbox.Failover(sandboxDir, newMaster)
+		if err != nil {
+			common.Exitf(1, "error during failover of %s: %s", args[0], err)
+		}
+	} else {
+		if newMaster == "" {
+			common.Exitf(1, "option --%s is required for a switchover", globals.NewMasterLabel)
+		}
+		err = sandbox.Switchover(sandboxDir, newMaster)
+		if err != nil {
+			common.Exitf(1, "error during switchover of %s: %s", args[0], err)
+		}
+	}
+	common.CondPrintf("%s is the new master of %s\n", newMaster, args[0])
 }
 
 func showCapabilities(cmd *cobra.Command, args []string) {
@@ -412,6 +457,10 @@ func showCapabilities(cmd *cobra.Command, args []string) {
 			} else {
 				fmt.Printf("## %s\n", fl)
 			}
+			descriptor, isCustom := common.CustomFlavors[fl]
+			if isCustom {
+				fmt.Printf("# based on %s - defined in %s\n", descriptor.BasedOn, descriptor.FileName)
+			}
 			sort.Strings(featureNames)
 			for _, fn := range featureNames {
 				capability := features[fn]
@@ -456,15 +505,26 @@ Users can still delete locked sandboxes manually.`,
 	}
 
 	adminUpgradeCmd = &cobra.Command{
-		Use:   "upgrade sandbox_name newer_sandbox",
+		Use:   "upgrade sandbox_name {newer_sandbox|version}",
 		Short: "Upgrades a sandbox to a newer version",
 		Long: `Upgrades a sandbox to a newer version.
-The sandbox with the new version must exist already.
-The data directory of the old sandbox will be moved to the new one.`,
-		Example:     "dbdeployer admin upgrade msb_8_0_11 msb_8_0_12",
+When the second argument is a sandbox, both sandboxes must be single ones.
+The data directory of the old sandbox will be moved to the new one.
+
+When the second argument is a version, the sandbox (single, multiple, master-slave,
+group, all-masters, or fan-in) is upgraded in place, one server at a time.
+Slaves and group secondaries are upgraded first, and replication health is
+verified after each step. The master is then upgraded in place or, with
+--switchover, replaced by an upgraded slave. The primary of a single-primary
+group leaves the group before its upgrade, so that a new primary is elected.
+Use --dry-run to see the plan without running it.`,
+		Example: `dbdeployer admin upgrade msb_8_0_11 msb_8_0_12
+dbdeployer admin upgrade rsandbox_8_0_36 8.4.0 --dry-run
+dbdeployer admin upgrade rsandbox_8_4_0 9.1.0 --switchover
+dbdeployer admin upgrade group_msb_8_0_36 8.4.0`,
 		Run:         runUpgradeSandbox,
-		Args:        SandboxNames(2),
-		Annotations: map[string]string{"export": makeExportArgs(globals.ExportSandboxDir, 2)},
+		Args:        upgradeArgs,
+		Annotations: map[string]string{"export": ExportAnnotationToJson(UpgradeExport)},
 	}
 
 	adminCapabilitiesCmd = &cobra.Command{
@@ -472,7 +532,34 @@ The data directory of the old sandbox will be moved to the new one.`,
 		Short: "Shows capabilities of a given flavor [and optionally version]",
 		Long: `Shows the capabilities of all flavors. 
 If a flavor is specified, only the capabilities of that flavor are shown.
-If also a version is specified, we show what that version supports`,
+If also a version is specified, we show what that version supports.
+
+Besides the built-in flavors, dbdeployer loads the custom ones defined by JSON or YAML
+files in $HOME/.dbdeployer/flavors. A descriptor looks like this:
+
+    {
+      "flavor": "acme",
+      "description": "ACME patched MySQL",
+      "based-on": "mysql",
+      "markers": [ {"dir": "bin", "file": "acme-admin"} ],
+      "all-markers-needed": true,
+      "tarball-pattern": "acme-mysql",
+      "features": {
+        "acme-audit": {"description": "ACME audit log", "since": "8.0.30"},
+        "GTID": {"description": "Global transaction identifiers", "since": "8.0.11"}
+      },
+      "templates": {
+        "my_cnf": "acme_my_cnf.txt"
+      }
+    }
+
+* "based-on" is the built-in flavor whose features (and behavior) the custom flavor inherits;
+* "markers" are the files that identify the flavor in the binaries directory. With
+  "all-markers-needed", all of them must exist, otherwise one is enough;
+* "tarball-pattern" is a regular expression that detects the flavor from a tarball name;
+* "features" are added to (or replace) the ones of the base flavor. "until" is optional;
+* "templates" replace the built-in templates when deploying sandboxes of this flavor.
+  File names are relative to the descriptor directory.`,
 		Example: `dbdeployer admin capabilities
 dbdeployer admin capabilities mysql
 dbdeployer admin capabilities mysql 5.7.11
@@ -489,6 +576,63 @@ dbdeployer admin capabilities mysql 5.7.13
 		Annotations: map[string]string{"export": makeExportArgs(globals.ExportSandboxDir, 1)},
 	}
 
+	adminAddNodeCmd = &cobra.Command{
+		Use:   "add-node sandbox_name",
+		Short: "Adds a node to a replication or multiple sandbox",
+		Long: `Adds a node to an existing master-slave replication or multiple sandbox.
+The new node gets the next free port and server-id, and the same configuration of the existing nodes.
+In a replication sandbox, the new slave is provisioned from the master using clone, when the
+version supports it, or a dump of the master data otherwise. Then it starts replicating.
+The scripts that operate on all nodes (start_all, check_slaves, use_all, ...) are updated.`,
+		Example: `dbdeployer admin add-node rsandbox_8_0_36
+dbdeployer admin add-node rsandbox_8_0_36 --use-dump
+dbdeployer admin add-node multi_msb_8_0_36`,
+		Run:         addNode,
+		Args:        SandboxNames(1),
+		Annotations: map[string]string{"export": makeExportArgs(globals.ExportSandboxDir, 1)},
+	}
+
+	adminRemoveNodeCmd = &cobra.Command{
+		Use:   "remove-node sandbox_name [node_name]",
+		Short: "Removes a node from a replication or multiple sandbox",
+		Long: `Stops and removes a node from an existing master-slave replication or multiple sandbox.
+Without a node name, the node with the highest number is removed. The master cannot be removed.
+The scripts that operate on all nodes (start_all, check_slaves, use_all, ...) are updated.`,
+		Example: `dbdeployer admin remove-node rsandbox_8_0_36
+dbdeployer admin remove-node rsandbox_8_0_36 node2`,
+		Run: removeNode,
+		Args: func(cmd *cobra.Command, args []string) error {
+			// Only the first argument is a sandbox
+			err := cobra.RangeArgs(1, 2)(cmd, args)
+			if err != nil {
+				return err
+			}
+			return SandboxNames(1)(cmd, args[:1])
+		},
+		Annotations: map[string]string{"export": makeExportArgs(globals.ExportSandboxDir, 1)},
+	}
+
+	adminSwitchoverCmd = &cobra.Command{
+		Use:   "switchover sandbox_name",
+		Short: "Promotes a slave to master in a replication sandbox",
+		Long: `Promotes a slave of a master-slave replication sandbox to master.
+In a planned switchover (the default) the master is made read-only, and all the slaves wait until
+they have applied its transactions. Then the new master stops replicating and becomes writable,
+while the old master and the other slaves replicate from it, using auto-position when GTID is enabled.
+With --failover, the master is considered lost and is not contacted. The slaves apply the
+transactions they have received, and the one that has executed all the transactions of the others
+is promoted. This mode requires MySQL GTID, and is not available for MariaDB. The old master is left
+out of replication.
+In both cases, the role scripts (m, s1, use_all_slaves, check_slaves, ...) and the sandbox
+description are updated to match the new roles.`,
+		Example: `dbdeployer admin switchover rsandbox_8_0_36 --new-master=node2
+dbdeployer admin switchover rsandbox_8_0_36 --new-master=master
+dbdeployer admin switchover rsandbox_8_0_36 --failover`,
+		Run:         switchover,
+		Args:        SandboxNames(1),
+		Annotations: map[string]string{"export": makeExportArgs(globals.ExportSandboxDir, 1)},
+	}
+
 	adminRemoveDefaultCmd = &cobra.Command{
 		Use:         "remove-default",
 		Short:       "Removes default sandbox",
@@ -526,8 +670,15 @@ func init() {
 	adminCmd.AddCommand(adminCapabilitiesCmd)
 	adminCmd.AddCommand(adminSetDefaultCmd)
 	adminCmd.AddCommand(adminRemoveDefaultCmd)
+	adminCmd.AddCommand(adminAddNodeCmd)
+	adminCmd.AddCommand(adminRemoveNodeCmd)
+	adminCmd.AddCommand(adminSwitchoverCmd)
 	adminUpgradeCmd.Flags().BoolP(globals.VerboseLabel, "", false, "Shows upgrade operations")
 	adminUpgradeCmd.Flags().BoolP(globals.DryRunLabel, "", false, "Shows upgrade operations, but don't execute them")
+	adminUpgradeCmd.Flags().BoolP(globals.SwitchoverLabel, "", false, "Promotes an upgraded slave instead of upgrading the master in place")
+	adminAddNodeCmd.Flags().Bool(globals.UseDumpLabel, false, "Provision the new node with a dump, even when clone is available")
+	adminSwitchoverCmd.Flags().String(globals.NewMasterLabel, "", "Node to promote (node1, node2, ..., or master)")
+	adminSwitchoverCmd.Flags().Bool(globals.FailoverLabel, false, "Promote the most up-to-date slave, without contacting the master")
 
 	adminSetDefaultCmd.PersistentFlags().StringP(globals.DefaultSandboxExecutable, "",
 		defaults.Defaults().DefaultSandboxExecutable, "Name of the executable to run commands in the default sandbox")
@@ -0,0 +1,173 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2022 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+
+	"github.com/alexeyco/simpletable"
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/globals"
+	"github.com/datacharmer/dbdeployer/ops"
+	"github.com/spf13/cobra"
+)
+
+func groupSandboxDir(cmd *cobra.Command, sandboxName string) string {
+	sandboxHome, err := getAbsolutePathFromFlag(cmd, globals.SandboxHomeLabel)
+	common.ErrCheckExitf(err, 1, "error finding absolute path for '%s'", globals.SandboxHomeLabel)
+	return path.Join(sandboxHome, sandboxName)
+}
+
+func groupStatus(cmd *cobra.Command, args []string) {
+	output, _ := cmd.Flags().GetString(globals.OutputLabel)
+	if output != globals.OutputTable && output != globals.OutputJson {
+		common.Exitf(1, "unknown output format '%s'. Use '%s' or '%s'", output, globals.OutputTable, globals.OutputJson)
+	}
+	members, err := ops.GroupStatus(groupSandboxDir(cmd, args[0]))
+	if err != nil {
+		common.Exitf(1, "error getting group status of %s: %s", args[0], err)
+	}
+	if output == globals.OutputJson {
+		b, err := json.MarshalIndent(members, " ", " ")
+		common.ErrCheckExitf(err, 1, "error encoding group status: %s", err)
+		fmt.Println(string(b))
+		return
+	}
+	table := simpletable.New()
+	table.Header = &simpletable.Header{
+		Cells: []*simpletable.Cell{
+			{Align: simpletable.AlignCenter, Text: "node"},
+			{Align: simpletable.AlignCenter, Text: "host"},
+			{Align: simpletable.AlignCenter, Text: "port"},
+			{Align: simpletable.AlignCenter, Text: "state"},
+			{Align: simpletable.AlignCenter, Text: "role"},
+			{Align: simpletable.AlignCenter, Text: "version"},
+			{Align: simpletable.AlignCenter, Text: "member id"},
+		},
+	}
+	for _, member := range members {
+		table.Body.Cells = append(table.Body.Cells, []*simpletable.Cell{
+			{Text: member.Node},
+			{Text: member.Host},
+			{Align: simpletable.AlignRight, Text: fmt.Sprintf("%d", member.Port)},
+			{Text: member.State},
+			{Text: member.Role},
+			{Text: member.Version},
+			{Text: member.Id},
+		})
+	}
+	table.SetStyle(simpletable.StyleRounded)
+	table.Println()
+}
+
+func groupPrimary(cmd *cobra.Command, args []string) {
+	err := ops.GroupSetPrimary(groupSandboxDir(cmd, args[0]), args[1])
+	if err != nil {
+		common.Exitf(1, "error changing the primary of %s: %s", args[0], err)
+	}
+	common.CondPrintf("Node %s is the primary of %s\n", args[1], args[0])
+}
+
+func groupExpel(cmd *cobra.Command, args []string) {
+	err := ops.GroupExpel(groupSandboxDir(cmd, args[0]), args[1])
+	if err != nil {
+		common.Exitf(1, "error expelling %s from %s: %s", args[1], args[0], err)
+	}
+	common.CondPrintf("Node %s has left the group in %s\n", args[1], args[0])
+}
+
+func groupRejoin(cmd *cobra.Command, args []string) {
+	err := ops.GroupRejoin(groupSandboxDir(cmd, args[0]), args[1])
+	if err != nil {
+		common.Exitf(1, "error rejoining %s to %s: %s", args[1], args[0], err)
+	}
+	common.CondPrintf("Node %s has rejoined the group in %s\n", args[1], args[0])
+}
+
+// sandboxAndNode checks that the first argument is a sandbox, followed by a node name
+func sandboxAndNode(cmd *cobra.Command, args []string) error {
+	err := cobra.ExactArgs(2)(cmd, args)
+	if err != nil {
+		return err
+	}
+	return SandboxNames(1)(cmd, args[:1])
+}
+
+var (
+	adminGroupCmd = &cobra.Command{
+		Use:   "group",
+		Short: "Manages the members of a group replication sandbox",
+		Long: `Manages the members of a group replication sandbox (group_msb_* or group_sp_msb_*).
+The commands connect to the nodes using the credentials in their connection files.`,
+	}
+
+	adminGroupStatusCmd = &cobra.Command{
+		Use:   "status sandbox_name",
+		Short: "Shows the members of a group",
+		Long: `Shows the contents of performance_schema.replication_group_members, as seen
+by the first node that is ONLINE. Nodes that are not in the group are listed as OFFLINE
+(or with the state they report, such as ERROR) when they are running, and as UNREACHABLE otherwise.`,
+		Example: `dbdeployer admin group status group_msb_8_0_36
+dbdeployer admin group status group_sp_msb_8_0_36 --output=json`,
+		Run:         groupStatus,
+		Args:        SandboxNames(1),
+		Annotations: map[string]string{"export": makeExportArgs(globals.ExportSandboxDir, 1)},
+	}
+
+	adminGroupPrimaryCmd = &cobra.Command{
+		Use:   "primary sandbox_name node_name",
+		Short: "Makes a node the primary of a single-primary group",
+		Long: `Makes a node the primary of a single-primary group, using group_replication_set_as_primary().
+It requires MySQL 8.0.13+. In multi-primary groups, all nodes are primary.`,
+		Example:     `dbdeployer admin group primary group_sp_msb_8_0_36 node2`,
+		Run:         groupPrimary,
+		Args:        sandboxAndNode,
+		Annotations: map[string]string{"export": makeExportArgs(globals.ExportSandboxDir, 1)},
+	}
+
+	adminGroupExpelCmd = &cobra.Command{
+		Use:   "expel sandbox_name node_name",
+		Short: "Removes a node from a group",
+		Long: `Stops group replication in a node, which leaves the group. The server keeps running.
+Use 'dbdeployer admin group rejoin' to add it again.`,
+		Example:     `dbdeployer admin group expel group_msb_8_0_36 node3`,
+		Run:         groupExpel,
+		Args:        sandboxAndNode,
+		Annotations: map[string]string{"export": makeExportArgs(globals.ExportSandboxDir, 1)},
+	}
+
+	adminGroupRejoinCmd = &cobra.Command{
+		Use:         "rejoin sandbox_name node_name",
+		Short:       "Adds a node back to a group",
+		Long:        `Starts group replication in a running node that is OFFLINE or in ERROR state.`,
+		Example:     `dbdeployer admin group rejoin group_msb_8_0_36 node3`,
+		Run:         groupRejoin,
+		Args:        sandboxAndNode,
+		Annotations: map[string]string{"export": makeExportArgs(globals.ExportSandboxDir, 1)},
+	}
+)
+
+func init() {
+	adminCmd.AddCommand(adminGroupCmd)
+	adminGroupCmd.AddCommand(adminGroupStatusCmd)
+	adminGroupCmd.AddCommand(adminGroupPrimaryCmd)
+	adminGroupCmd.AddCommand(adminGroupExpelCmd)
+	adminGroupCmd.AddCommand(adminGroupRejoinCmd)
+	adminGroupStatusCmd.Flags().String(globals.OutputLabel, globals.OutputTable,
+		fmt.Sprintf("Output format (%s or %s)", globals.OutputTable, globals.OutputJson))
+}
@@ -0,0 +1,91 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2022 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/globals"
+	"github.com/datacharmer/dbdeployer/sandbox"
+	"github.com/spf13/cobra"
+)
+
+func copySandbox(cmd *cobra.Command, args []string) {
+	sandboxHome, err := getAbsolutePathFromFlag(cmd, globals.SandboxHomeLabel)
+	common.ErrCheckExitf(err, 1, "error finding absolute path for '%s'", globals.SandboxHomeLabel)
+	destination, err := sandbox.CopySandbox(sandboxHome, args[0], args[1])
+	if err != nil {
+		common.Exitf(1, "error copying %s: %s", args[0], err)
+	}
+	common.CondPrintf("Sandbox %s copied to %s\n", args[0], common.ReplaceLiteralHome(destination))
+}
+
+func moveSandbox(cmd *cobra.Command, args []string) {
+	sandboxHome, err := getAbsolutePathFromFlag(cmd, globals.SandboxHomeLabel)
+	common.ErrCheckExitf(err, 1, "error finding absolute path for '%s'", globals.SandboxHomeLabel)
+	destination, err := sandbox.MoveSandbox(sandboxHome, args[0], args[1])
+	if err != nil {
+		common.Exitf(1, "error moving %s: %s", args[0], err)
+	}
+	common.CondPrintf("Sandbox %s moved to %s\n", args[0], common.ReplaceLiteralHome(destination))
+}
+
+// relocationArgs accepts a sandbox followed by a destination
+func relocationArgs(cmd *cobra.Command, args []string) error {
+	// Only the first argument is a sandbox
+	err := cobra.ExactArgs(2)(cmd, args)
+	if err != nil {
+		return err
+	}
+	return SandboxNames(1)(cmd, args[:1])
+}
+
+var (
+	adminCopyCmd = &cobra.Command{
+		Use:   "copy sandbox_name destination",
+		Short: "Duplicates a sandbox",
+		Long: `Copies a sandbox (single or composite) to a new sandbox, which gets new ports, server IDs,
+and server UUIDs. The destination is a sandbox name in $SANDBOX_HOME, or an absolute path.
+The source is stopped while it is being copied, and started again if it was running.
+The copy is left running if the source was running. Replication among the nodes of the copy
+uses the new ports. Group replication, InnoDB Cluster, ClusterSet, and NDB sandboxes cannot be copied.`,
+		Example: `dbdeployer admin copy msb_8_0_36 msb_8_0_36_copy
+dbdeployer admin copy rsandbox_8_0_36 /opt/sandboxes/rsandbox_test`,
+		Run:         copySandbox,
+		Args:        relocationArgs,
+		Annotations: map[string]string{"export": makeExportArgs(globals.ExportSandboxDir, 1)},
+	}
+
+	adminMoveCmd = &cobra.Command{
+		Use:   "move sandbox_name destination",
+		Short: "Renames or relocates a sandbox",
+		Long: `Moves a sandbox (single or composite) to a new name or directory, rewriting the paths
+in its scripts, its description, and the catalog. Ports do not change.
+The destination is a sandbox name in $SANDBOX_HOME, or an absolute path.
+The sandbox is stopped during the move, and started again if it was running.
+In group sandboxes, the group is then bootstrapped again from the first node.
+Locked sandboxes cannot be moved.`,
+		Example: `dbdeployer admin move msb_8_0_36 msb_test
+dbdeployer admin move rsandbox_8_0_36 /opt/sandboxes/rsandbox_8_0_36`,
+		Run:         moveSandbox,
+		Args:        relocationArgs,
+		Annotations: map[string]string{"export": makeExportArgs(globals.ExportSandboxDir, 1)},
+	}
+)
+
+func init() {
+	adminCmd.AddCommand(adminCopyCmd)
+	adminCmd.AddCommand(adminMoveCmd)
+}
@@ -0,0 +1,143 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2022 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"strings"
+
+	"github.com/alexeyco/simpletable"
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/globals"
+	"github.com/datacharmer/dbdeployer/sandbox"
+	"github.com/dustin/go-humanize"
+	"github.com/spf13/cobra"
+)
+
+func listSnapshots(sandboxHome, sandboxName string) {
+	snapshots, err := sandbox.ListSnapshots(sandboxHome, sandboxName)
+	if err != nil {
+		common.Exitf(1, "error listing snapshots of %s: %s", sandboxName, err)
+	}
+	if len(snapshots) == 0 {
+		common.CondPrintf("No snapshots found for %s\n", sandboxName)
+		return
+	}
+	table := simpletable.New()
+	table.Header = &simpletable.Header{
+		Cells: []*simpletable.Cell{
+			{Align: simpletable.AlignCenter, Text: "name"},
+			{Align: simpletable.AlignCenter, Text: "created"},
+			{Align: simpletable.AlignCenter, Text: "servers"},
+			{Align: simpletable.AlignCenter, Text: "size"},
+		},
+	}
+	for _, snapshot := range snapshots {
+		servers := strings.Join(snapshot.Servers, " ")
+		if servers == "" {
+			servers = "single"
+		}
+		table.Body.Cells = append(table.Body.Cells, []*simpletable.Cell{
+			{Text: snapshot.Name},
+			{Text: snapshot.Timestamp},
+			{Text: servers},
+			{Align: simpletable.AlignRight, Text: humanize.Bytes(uint64(snapshot.Size))},
+		})
+	}
+	table.SetStyle(simpletable.StyleRounded)
+	table.Println()
+}
+
+func snapshotSandbox(cmd *cobra.Command, args []string) {
+	sandboxHome, err := getAbsolutePathFromFlag(cmd, globals.SandboxHomeLabel)
+	common.ErrCheckExitf(err, 1, "error finding absolute path for '%s'", globals.SandboxHomeLabel)
+	list, _ := cmd.Flags().GetBool(globals.ListLabel)
+	if list {
+		if len(args) > 1 {
+			common.Exitf(1, "option --%s does not accept a snapshot name", globals.ListLabel)
+		}
+		listSnapshots(sandboxHome, args[0])
+		return
+	}
+	snapshotName := ""
+	if len(args) > 1 {
+		snapshotName = args[1]
+	}
+	snapshot, err := sandbox.CreateSnapshot(sandboxHome, args[0], snapshotName)
+	if err != nil {
+		common.Exitf(1, "error creating snapshot of %s: %s", args[0], err)
+	}
+	common.CondPrintf("Snapshot '%s' of %s created (%s)\n", snapshot.Name, args[0], humanize.Bytes(uint64(snapshot.Size)))
+}
+
+func restoreSandbox(cmd *cobra.Command, args []string) {
+	sandboxHome, err := getAbsolutePathFromFlag(cmd, globals.SandboxHomeLabel)
+	common.ErrCheckExitf(err, 1, "error finding absolute path for '%s'", globals.SandboxHomeLabel)
+	err = sandbox.RestoreSnapshot(sandboxHome, args[0], args[1])
+	if err != nil {
+		common.Exitf(1, "error restoring %s: %s", args[0], err)
+	}
+	common.CondPrintf("Sandbox %s restored from snapshot '%s'\n", args[0], args[1])
+}
+
+var (
+	adminSnapshotCmd = &cobra.Command{
+		Use:   "snapshot sandbox_name [snapshot_name]",
+		Short: "Saves the data directories of a sandbox",
+		Long: `Stops the servers of a sandbox and archives their data directories (all the nodes, for
+a multiple sandbox). The servers that were running are started again.
+Without a snapshot name, the snapshot is named after the current date and time.
+The snapshots are stored in $SANDBOX_HOME/` + globals.SnapshotsDirName + `/sandbox_name, and are removed
+together with the sandbox. Use 'dbdeployer admin restore' to bring the sandbox back to a snapshot.`,
+		Example: `dbdeployer admin snapshot msb_8_0_36 before-test
+dbdeployer admin snapshot rsandbox_8_0_36
+dbdeployer admin snapshot rsandbox_8_0_36 --list`,
+		Run: snapshotSandbox,
+		Args: func(cmd *cobra.Command, args []string) error {
+			// Only the first argument is a sandbox
+			err := cobra.RangeArgs(1, 2)(cmd, args)
+			if err != nil {
+				return err
+			}
+			return SandboxNames(1)(cmd, args[:1])
+		},
+		Annotations: map[string]string{"export": makeExportArgs(globals.ExportSandboxDir, 1)},
+	}
+
+	adminRestoreCmd = &cobra.Command{
+		Use:   "restore sandbox_name snapshot_name",
+		Short: "Restores the data directories of a sandbox from a snapshot",
+		Long: `Stops the servers of a sandbox and replaces their data directories with the ones saved
+by 'dbdeployer admin snapshot'. The servers that were running are started again.
+The sandbox must have the same nodes that it had when the snapshot was taken.`,
+		Example: `dbdeployer admin restore msb_8_0_36 before-test`,
+		Run:     restoreSandbox,
+		Args: func(cmd *cobra.Command, args []string) error {
+			// Only the first argument is a sandbox
+			err := cobra.ExactArgs(2)(cmd, args)
+			if err != nil {
+				return err
+			}
+			return SandboxNames(1)(cmd, args[:1])
+		},
+		Annotations: map[string]string{"export": makeExportArgs(globals.ExportSandboxDir, 1)},
+	}
+)
+
+func init() {
+	adminCmd.AddCommand(adminSnapshotCmd)
+	adminCmd.AddCommand(adminRestoreCmd)
+	adminSnapshotCmd.Flags().Bool(globals.ListLabel, false, "Lists the snapshots of the sandbox")
+}
@@ -0,0 +1,86 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2022 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/globals"
+	"github.com/datacharmer/dbdeployer/sandbox"
+	"github.com/spf13/cobra"
+)
+
+func clusterSetSandbox(cmd *cobra.Command, args []string) {
+	common.CheckOrigin(args)
+	sd, err := fillSandboxDefinition(cmd, args, false)
+	common.ErrCheckExitf(err, 1, "%s", err)
+	flags := cmd.Flags()
+	clusters, _ := flags.GetInt(globals.ClustersLabel)
+	nodes, _ := flags.GetInt(globals.NodesLabel)
+	clusterType, _ := flags.GetString(globals.ClusterTypeLabel)
+	masterIp, _ := flags.GetString(globals.MasterIpLabel)
+	sd.SinglePrimary, _ = flags.GetBool(globals.SinglePrimaryLabel)
+	if sd.SinglePrimary && clusterType != globals.GroupLabel {
+		common.Exitf(1, "option '%s' can only be used with cluster type '%s'",
+			globals.SinglePrimaryLabel,
+			globals.GroupLabel)
+	}
+	origin := args[0]
+	if args[0] != sd.BasedirName {
+		origin = sd.BasedirName
+	}
+	err = sandbox.CreateClusterSet(sd, origin,
+		sandbox.ClusterSetData{
+			Clusters:    clusters,
+			Nodes:       nodes,
+			ClusterType: clusterType,
+			MasterIp:    masterIp,
+		})
+	if err != nil {
+		common.Exitf(1, globals.ErrCreatingSandbox, err)
+	}
+}
+
+var clusterSetCmd = &cobra.Command{
+	Use:   "clusterset MySQL-Version",
+	Short: "create clusters connected by replication",
+	Long: `Creates several group replication clusters (or InnoDB Clusters, with --cluster-type=innodb-cluster)
+inside one sandbox directory. The first cluster is the primary one: the node1 of every other cluster
+replicates from its node1 through an asynchronous channel named "clusterset".
+The scripts in the top directory run on all clusters. "check_clusterset" shows the status of every
+cluster and every channel. The whole clusterset is a single entry in the catalog, and
+"dbdeployer delete" removes all its clusters.
+For this command to work, there must be a directory $HOME/opt/mysql/8.0.36, containing
+the binary files from mysql-8.0.36-$YOUR_OS-x86_64.tar.xz
+Use the "unpack" command to get the tarball into the right directory.
+`,
+	Run: clusterSetSandbox,
+	Example: `
+	$ dbdeployer deploy clusterset 8.0
+	$ dbdeployer deploy clusterset 8.0 --clusters=3 --single-primary
+	$ dbdeployer deploy clusterset 8.0 --cluster-type=innodb-cluster
+	`,
+	Annotations: map[string]string{"export": makeExportArgs(globals.ExportVersionDir, 1)},
+}
+
+func init() {
+	deployCmd.AddCommand(clusterSetCmd)
+	clusterSetCmd.PersistentFlags().Int(globals.ClustersLabel, globals.ClustersValue, "How many clusters will be installed")
+	clusterSetCmd.PersistentFlags().IntP(globals.NodesLabel, "n", globals.NodesValue, "How many nodes will be installed in each cluster")
+	clusterSetCmd.PersistentFlags().String(globals.ClusterTypeLabel, globals.GroupLabel,
+		"Type of each cluster ('"+globals.GroupLabel+"' or '"+globals.InnoDBClusterLabel+"')")
+	clusterSetCmd.PersistentFlags().String(globals.MasterIpLabel, globals.MasterIpValue, "Which IP the replication channels will connect to")
+	clusterSetCmd.PersistentFlags().Bool(globals.SinglePrimaryLabel, false, "Using single primary for group replication")
+}
@@ -163,9 +163,18 @@ such as showing the defaults and saving new ones.`,
 		Short: "Change defaults value",
 		Example: `
 	$ dbdeployer defaults update master-slave-base-port 17500		
+	$ dbdeployer defaults update hooks.post-deploy 'echo $DBDEPLOYER_SANDBOX_DIR >> $HOME/sandboxes.txt'
 `,
 		Long: `Updates one field of the defaults. Stores the result in the dbdeployer configuration file.
-Use "dbdeployer defaults show" to see which values are available`,
+Use "dbdeployer defaults show" to see which values are available.
+
+Hooks are shell commands that run around sandbox operations, set with the label "hooks.OPERATION".
+Operations: post-deploy, pre-delete, post-delete, pre-start, post-start, pre-stop, post-stop.
+Start and stop hooks run when the operation is requested through dbdeployer (global start/stop,
+the API server, or the Go API). A failing pre-* hook aborts the operation. An empty command removes the hook.
+Hooks receive the variables DBDEPLOYER_HOOK, DBDEPLOYER_SANDBOX_NAME, DBDEPLOYER_SANDBOX_DIR,
+DBDEPLOYER_SANDBOX_PORTS, DBDEPLOYER_SANDBOX_VERSION, DBDEPLOYER_SANDBOX_FLAVOR, DBDEPLOYER_SANDBOX_TYPE,
+and DBDEPLOYER_SANDBOX_CREDENTIALS (the options file with user and password)`,
 		Run:         updateDefaults,
 		Annotations: map[string]string{"export": ExportAnnotationToJson(DoubleStringExport)},
 	}
@@ -30,7 +30,6 @@ import (
 )
 
 func deleteSandbox(cmd *cobra.Command, args []string) {
-	var execLists []concurrent.ExecutionList
 	if len(args) < 1 {
 		common.Exit(1,
 			"Sandbox name (or \"ALL\") required.",
@@ -70,14 +69,18 @@ func deleteSandbox(cmd *cobra.Command, args []string) {
 			common.Exitf(1, "sandbox %s not found", sandboxName)
 		}
 	}
+	tagFilters, ownerFilter := getLabelFilters(flags)
+	var selectedList common.SandboxInfoList
+	for _, sb := range deletionList {
+		if matchesLabels(sb.SandboxDesc.Tags, sb.SandboxDesc.Owner, tagFilters, ownerFilter) {
+			selectedList = append(selectedList, sb)
+		}
+	}
+	deletionList = selectedList
 	if len(deletionList) == 0 {
 		common.CondPrintf("Nothing to delete in %s\n", sandboxDir)
 		return
 	}
-	if len(deletionList) > 60 && runConcurrently {
-		fmt.Println("# Concurrency disabled. Can't run more than 60 concurrent operations")
-		runConcurrently = false
-	}
 	common.CondPrintf("List of deployed sandboxes:\n")
 	unlockedFound := false
 	for _, sb := range deletionList {
@@ -109,31 +112,39 @@ func deleteSandbox(cmd *cobra.Command, args []string) {
 			}
 		}
 	}
+	removeSandboxes(sandboxDir, deletionList, runConcurrently, useStop)
+}
+
+// removeSandboxes halts and removes the sandboxes of a list, and deletes them from the catalog.
+// Locked sandboxes are skipped. Used by "delete" and "deploy teardown"
+func removeSandboxes(sandboxHome string, deletionList common.SandboxInfoList, runConcurrently, useStop bool) {
+	var execLists []concurrent.ExecutionList
+	if len(deletionList) > 60 && runConcurrently {
+		fmt.Println("# Concurrency disabled. Can't run more than 60 concurrent operations")
+		runConcurrently = false
+	}
 	for _, sb := range deletionList {
 		if sb.Locked {
 			common.CondPrintf("Sandbox %s is locked\n", sb.SandboxName)
-		} else {
-			useStopForSb := useStop
-			if !useStopForSb && (sb.SandboxDesc.Flavor == common.NdbFlavor || sb.SandboxDesc.Flavor == common.PxcFlavor) {
-				fmt.Printf("%s: Using 'stop' for '%s' flavor\n",
-					sb.SandboxName, sb.SandboxDesc.Flavor)
-				useStopForSb = true
-			}
-			if !useStopForSb && sb.SandboxDesc.Flavor == "" {
-				fmt.Printf("%s: no flavor detected: using stop to halt the servers\n",
-					sb.SandboxName)
-				useStopForSb = true
-			}
-			execList, err := sandbox.RemoveCustomSandbox(sandboxDir, sb.SandboxName, runConcurrently, useStopForSb)
-			if err != nil {
-				common.Exitf(1, globals.ErrWhileDeletingSandbox, err)
+			continue
+		}
+		useStopForSb := sandbox.UseStopForFlavor(sb.SandboxDesc.Flavor, useStop)
+		if useStopForSb && !useStop {
+			if sb.SandboxDesc.Flavor == "" {
+				fmt.Printf("%s: no flavor detected: using stop to halt the servers\n", sb.SandboxName)
+			} else {
+				fmt.Printf("%s: Using 'stop' for '%s' flavor\n", sb.SandboxName, sb.SandboxDesc.Flavor)
 			}
-			execLists = append(execLists, execList...)
 		}
+		execList, err := sandbox.RemoveCustomSandbox(sandboxHome, sb.SandboxName, runConcurrently, useStopForSb)
+		if err != nil {
+			common.Exitf(1, globals.ErrWhileDeletingSandbox, err)
+		}
+		execLists = append(execLists, execList...)
 	}
 	concurrent.RunParallelTasksByPriority(execLists)
 	for _, sb := range deletionList {
-		fullPath := path.Join(sandboxDir, sb.SandboxName)
+		fullPath := path.Join(sandboxHome, sb.SandboxName)
 		if !sb.Locked {
 			err := defaults.DeleteFromCatalog(fullPath)
 			if err != nil {
@@ -150,7 +161,8 @@ var deleteCmd = &cobra.Command{
 	Aliases: []string{"remove", "destroy"},
 	Example: `
 	$ dbdeployer delete msb_8_0_4
-	$ dbdeployer delete rsandbox_5_7_21`,
+	$ dbdeployer delete rsandbox_5_7_21
+	$ dbdeployer delete ALL --tag=team=billing --owner=$USER`,
 	Long: `Halts the sandbox (and its depending sandboxes, if any), and removes it.
 Warning: this command is irreversible!`,
 	Run:         deleteSandbox,
@@ -163,5 +175,7 @@ func init() {
 	deleteCmd.Flags().BoolP(globals.SkipConfirmLabel, "", false, "Skips confirmation with multiple deletions.")
 	deleteCmd.Flags().BoolP(globals.ConfirmLabel, "", false, "Requires confirmation.")
 	deleteCmd.Flags().BoolP(globals.ConcurrentLabel, "", false, "Runs multiple deletion tasks concurrently.")
+	deleteCmd.Flags().StringArray(globals.TagLabel, nil, "Deletes only sandboxes with the given tag (key or key=value)")
+	deleteCmd.Flags().String(globals.OwnerLabel, "", "Deletes only sandboxes of the given owner")
 	deleteCmd.Flags().BoolP(globals.UseStopLabel, "", false, "Use 'stop' instead of 'send_kill destroy' to halt the database servers")
 }
@@ -67,6 +67,8 @@ func init() {
 	deployCmd.PersistentFlags().Bool(globals.SocketInDatadirLabel, false, "Create socket in datadir instead of $TMPDIR")
 	deployCmd.PersistentFlags().Bool(globals.FlavorInPromptLabel, false, "Add flavor values to prompt")
 	deployCmd.PersistentFlags().Bool(globals.PortAsServerIdLabel, false, "Use the port number as server ID")
+	deployCmd.PersistentFlags().Bool(globals.TlsLabel, false, "Generates certificates and enables TLS connections")
+	deployCmd.PersistentFlags().Bool(globals.SecureTransportLabel, false, "Refuses connections without TLS (requires --tls, MySQL 5.7.8+)")
 
 	setPflag(deployCmd, globals.LogLogDirectoryLabel, "", "", defaults.Defaults().LogDirectory, "Where to store dbdeployer logs", false)
 	setPflag(deployCmd, globals.RemoteAccessLabel, "", "", globals.RemoteAccessValue, "defines the database access ", false)
@@ -92,11 +94,15 @@ func init() {
 	setPflag(deployCmd, globals.HistoryDirLabel, "", "", "", "Where to store mysql client history (default: in sandbox directory)", false)
 	setPflag(deployCmd, globals.FlavorLabel, "", "", "", "Defines the tarball flavor (MySQL, NDB, Percona Server, etc)", false)
 	setPflag(deployCmd, globals.ClientFromLabel, "", "", "", "Where to get the client binaries from", false)
-	setPflag(deployCmd, globals.DefaultRoleLabel, "", "", "R_DO_IT_ALL", "Which role to assign to default user (8.0+)", false)
+	setPflag(deployCmd, globals.DefaultRoleLabel, "", "", globals.DefaultRoleValue, "Which role to assign to default user (8.0+)", false)
 	setPflag(deployCmd, globals.TaskUserLabel, "", "", "", "Task user to be created (8.0+)", false)
 	setPflag(deployCmd, globals.TaskUserRoleLabel, "", "", "", "Role to be assigned to task user (8.0+)", false)
-	setPflag(deployCmd, globals.CustomRoleNameLabel, "", "", "R_CUSTOM", "Name for custom role (8.0+)", false)
-	setPflag(deployCmd, globals.CustomRolePrivilegesLabel, "", "", "ALL PRIVILEGES", "Privileges for custom role (8.0+)", false)
-	setPflag(deployCmd, globals.CustomRoleTargetLabel, "", "", "*.*", "Target for custom role (8.0+)", false)
-	setPflag(deployCmd, globals.CustomRoleExtraLabel, "", "", "WITH GRANT OPTION", "Extra instructions for custom role (8.0+)", false)
+	setPflag(deployCmd, globals.CustomRoleNameLabel, "", "", globals.CustomRoleNameValue, "Name for custom role (8.0+)", false)
+	setPflag(deployCmd, globals.CustomRolePrivilegesLabel, "", "", globals.CustomRolePrivilegesValue, "Privileges for custom role (8.0+)", false)
+	setPflag(deployCmd, globals.CustomRoleTargetLabel, "", "", globals.CustomRoleTargetValue, "Target for custom role (8.0+)", false)
+	setPflag(deployCmd, globals.CustomRoleExtraLabel, "", "", globals.CustomRoleExtraValue, "Extra instructions for custom role (8.0+)", false)
+	setPflag(deployCmd, globals.TagLabel, "", "", "", "Attaches a label to the sandbox (--tag=key=value)", true)
+	setPflag(deployCmd, globals.OwnerLabel, "", "", "", "Who owns the sandbox (default: current OS user)", false)
+	setPflag(deployCmd, globals.NoteLabel, "", "", "", "Free-form note about the sandbox", false)
+	setPflag(deployCmd, globals.TtlLabel, "", "", "", "Time to live of the sandbox (30m, 4h, 2d), after which 'dbdeployer reap' removes it", false)
 }
@@ -0,0 +1,285 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2022 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/globals"
+	"github.com/datacharmer/dbdeployer/ops"
+	"github.com/datacharmer/dbdeployer/sandbox"
+	"github.com/spf13/cobra"
+)
+
+// topologySandboxDef builds a sandbox definition from the deploy flags, and then
+// applies the values set for one sandbox in the topology file
+func topologySandboxDef(cmd *cobra.Command, tf ops.TopologyFile, entry ops.TopologySandbox) (sandbox.SandboxDef, error) {
+	sd, err := fillSandboxDefinition(cmd, []string{entry.Version}, false)
+	if err != nil {
+		return sd, err
+	}
+	sd.DirName = entry.Name
+	if entry.Flavor != "" {
+		sd.Flavor = entry.Flavor
+	}
+	if entry.ServerId > 0 {
+		sd.ServerId = entry.ServerId
+		sd.PortAsServerId = false
+	}
+	if entry.BasePort > 0 {
+		sd.BasePort = entry.BasePort
+	}
+	if entry.Port > 0 {
+		sd.UserPort = entry.Port
+		sd.Port = entry.Port
+	} else if sd.UserPort == 0 {
+		// Sandboxes of the same version in one file would otherwise claim the same port
+		sd.Port, err = common.FindFreePort(sd.Port, sd.InstalledPorts, 1)
+		if err != nil {
+			return sd, err
+		}
+	}
+	sd.MyCnfOptions = append(sd.MyCnfOptions, entry.MyCnfOptions...)
+	sd.SinglePrimary = entry.SinglePrimary
+	if entry.Gtid {
+		err = sandbox.SetGtidOptions(&sd)
+		if err != nil {
+			return sd, err
+		}
+	}
+	if tf.IsLinked(entry.Name) && sd.ReplOptions == "" {
+		sandbox.SetMasterOptions(&sd)
+	}
+	return sd, nil
+}
+
+// topologyNodes returns the number of nodes for a composite sandbox in a topology file
+func topologyNodes(entry ops.TopologySandbox) int {
+	if entry.Nodes > 0 {
+		return entry.Nodes
+	}
+	if entry.Topology == globals.TreeLabel {
+		fanOut := entry.FanOut
+		if fanOut == "" {
+			fanOut = globals.FanOutValue
+		}
+		fanOutList, err := common.StringToIntSlice(fanOut)
+		if err == nil {
+			return sandbox.TreeNodes(fanOutList)
+		}
+	}
+	return globals.NodesValue
+}
+
+func deployTopologySandbox(sd sandbox.SandboxDef, entry ops.TopologySandbox) error {
+	origin := sd.BasedirName
+	nodes := topologyNodes(entry)
+	switch entry.Topology {
+	case "", globals.SbTypeSingle:
+		sd.RunConcurrently = false
+		return sandbox.CreateStandaloneSandbox(sd)
+	case globals.SbTypeMultiple:
+		sd.SBType = globals.SbTypeMultiple
+		_, err := sandbox.CreateMultipleSandbox(sd, origin, nodes)
+		return err
+	default:
+		sd.ReplOptions = sandbox.SingleTemplates[globals.TmplReplicationOptions].Contents
+		return sandbox.CreateReplicationSandbox(sd, origin,
+			sandbox.ReplicationData{
+				Topology:   entry.Topology,
+				Nodes:      nodes,
+				NdbNodes:   globals.NdbNodesValue,
+				MasterIp:   globals.MasterIpValue,
+				MasterList: globals.MasterListValue,
+				SlaveList:  globals.SlaveListValue,
+				FanOut:     entry.FanOut})
+	}
+}
+
+func describeTopologySandbox(sd sandbox.SandboxDef, entry ops.TopologySandbox) string {
+	topology := entry.Topology
+	if topology == "" {
+		topology = globals.SbTypeSingle
+	}
+	description := fmt.Sprintf("%-20s %-12s %-8s %-12s", entry.Name, topology, sd.Flavor, sd.Version)
+	if topology == globals.SbTypeSingle {
+		description += fmt.Sprintf(" port %d", sd.Port)
+	} else {
+		description += fmt.Sprintf(" nodes %d", topologyNodes(entry))
+		if sd.BasePort > 0 {
+			description += fmt.Sprintf(" base-port %d", sd.BasePort)
+		}
+	}
+	return description
+}
+
+func deployFromFile(cmd *cobra.Command, args []string) {
+	flags := cmd.Flags()
+	dryRun, _ := flags.GetBool(globals.DryRunLabel)
+	tf, err := ops.ReadTopologyFile(args[0])
+	common.ErrCheckExitf(err, 1, "%s", err)
+
+	sandboxHome, err := getAbsolutePathFromFlag(cmd, globals.SandboxHomeLabel)
+	common.ErrCheckExitf(err, 1, "error finding absolute path for '%s'", globals.SandboxHomeLabel)
+	for _, entry := range tf.Sandboxes {
+		if common.DirExists(path.Join(sandboxHome, entry.Name)) {
+			common.Exitf(1, globals.ErrNamedDirectoryAlreadyExists, "sandbox", path.Join(sandboxHome, entry.Name))
+		}
+	}
+
+	for _, entry := range tf.Sandboxes {
+		// The definition is filled right before each deployment, so that the list of
+		// installed ports includes the sandboxes deployed in the previous steps
+		sd, err := topologySandboxDef(cmd, tf, entry)
+		common.ErrCheckExitf(err, 1, "error filling sandbox definition for '%s': %s", entry.Name, err)
+		if dryRun {
+			fmt.Printf("deploy %s\n", describeTopologySandbox(sd, entry))
+			continue
+		}
+		err = deployTopologySandbox(sd, entry)
+		if err != nil {
+			common.Exitf(1, globals.ErrCreatingSandbox, err)
+		}
+	}
+
+	if len(tf.Replication) > 0 {
+		_ = os.Setenv("SANDBOX_HOME", sandboxHome)
+	}
+	for _, link := range tf.Replication {
+		replicateFrom := path.Join(sandboxHome, link.Replica, globals.ScriptReplicateFrom)
+		if dryRun {
+			fmt.Printf("replicate %s from %s\n", link.Replica, link.Source)
+			continue
+		}
+		if !common.ExecExists(replicateFrom) {
+			common.Exitf(1, globals.ErrExecutableNotFound, replicateFrom)
+		}
+		_, err = common.RunCmdWithArgs(replicateFrom, []string{link.Source})
+		common.ErrCheckExitf(err, 1, "error setting replication from %s to %s: %s", link.Source, link.Replica, err)
+	}
+}
+
+func teardownFromFile(cmd *cobra.Command, args []string) {
+	flags := cmd.Flags()
+	dryRun, _ := flags.GetBool(globals.DryRunLabel)
+	useStop, _ := flags.GetBool(globals.UseStopLabel)
+	runConcurrently, _ := flags.GetBool(globals.ConcurrentLabel)
+	if common.IsEnvSet("RUN_CONCURRENTLY") {
+		runConcurrently = true
+	}
+	tf, err := ops.ReadTopologyFile(args[0])
+	common.ErrCheckExitf(err, 1, "%s", err)
+
+	sandboxHome, err := getAbsolutePathFromFlag(cmd, globals.SandboxHomeLabel)
+	common.ErrCheckExitf(err, 1, "error finding absolute path for '%s'", globals.SandboxHomeLabel)
+	installed, err := common.GetInstalledSandboxes(sandboxHome)
+	common.ErrCheckExitf(err, 1, globals.ErrRetrievingSandboxList, err)
+
+	var deletionList common.SandboxInfoList
+	// Sandboxes are removed in reverse order of deployment, so that replicas go before their sources
+	for i := len(tf.Sandboxes) - 1; i >= 0; i-- {
+		name := tf.Sandboxes[i].Name
+		found := false
+		for _, sb := range installed {
+			if sb.SandboxName == name {
+				found = true
+				if sb.Locked {
+					common.CondPrintf("Sandbox %s is locked\n", name)
+				} else {
+					deletionList = append(deletionList, sb)
+				}
+				break
+			}
+		}
+		if !found {
+			common.CondPrintf("Sandbox %s not found in %s\n", name, sandboxHome)
+		}
+	}
+	if len(deletionList) == 0 {
+		common.CondPrintf("Nothing to delete in %s\n", sandboxHome)
+		return
+	}
+	if dryRun {
+		for _, sb := range deletionList {
+			fmt.Printf("delete %s\n", path.Join(sandboxHome, sb.SandboxName))
+		}
+		return
+	}
+	removeSandboxes(sandboxHome, deletionList, runConcurrently, useStop)
+}
+
+var deployFromFileCmd = &cobra.Command{
+	Use:   "from-file topology-file",
+	Args:  cobra.ExactArgs(1),
+	Short: "deploys the sandboxes described in a topology file",
+	Long: `Deploys a set of sandboxes described in a YAML or JSON file.
+Each sandbox has a name (used as sandbox directory), a version, and optionally
+flavor, topology (single, multiple, or any replication topology), nodes, fan-out, port,
+base-port, server-id, gtid, single-primary, and my-cnf-options.
+The "replication" section links sandboxes of the same file, using the script
+'replicate_from' in the replica.
+All the other deploy options apply to every sandbox in the file.
+The same file can be used with 'dbdeployer teardown' to remove the deployment.
+`,
+	Example: `
+    $ cat topology.yaml
+    sandboxes:
+      - name: primary
+        version: 8.0.40
+        gtid: true
+      - name: replica
+        version: 8.4.3
+        gtid: true
+        my-cnf-options:
+          - "log-replica-updates=ON"
+    replication:
+      - source: primary
+        replica: replica
+
+    $ dbdeployer deploy from-file topology.yaml --dry-run
+    $ dbdeployer deploy from-file topology.yaml
+`,
+	Run:         deployFromFile,
+	Annotations: map[string]string{"export": ExportAnnotationToJson(StringExport)},
+}
+
+var teardownCmd = &cobra.Command{
+	Use:   "teardown topology-file",
+	Args:  cobra.ExactArgs(1),
+	Short: "deletes the sandboxes described in a topology file",
+	Long: `Removes the sandboxes that were deployed using 'dbdeployer deploy from-file'.
+Sandboxes are removed in reverse order. Locked sandboxes are skipped.
+Warning: this command is irreversible!`,
+	Example: `
+    $ dbdeployer teardown topology.yaml --dry-run
+    $ dbdeployer teardown topology.yaml
+`,
+	Run:         teardownFromFile,
+	Annotations: map[string]string{"export": ExportAnnotationToJson(StringExport)},
+}
+
+func init() {
+	deployCmd.AddCommand(deployFromFileCmd)
+	rootCmd.AddCommand(teardownCmd)
+	deployFromFileCmd.Flags().Bool(globals.DryRunLabel, false, "Show the deployment plan, but do not run it")
+
+	teardownCmd.Flags().Bool(globals.DryRunLabel, false, "Show which sandboxes would be deleted, but do not delete them")
+	teardownCmd.Flags().Bool(globals.ConcurrentLabel, false, "Runs multiple deletion tasks concurrently.")
+	teardownCmd.Flags().Bool(globals.UseStopLabel, false, "Use 'stop' instead of 'send_kill destroy' to halt the database servers")
+}
@@ -0,0 +1,87 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2022 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/globals"
+	"github.com/datacharmer/dbdeployer/sandbox"
+)
+
+func runDoctor(cmd *cobra.Command, args []string) {
+	sandboxHome, err := getAbsolutePathFromFlag(cmd, globals.SandboxHomeLabel)
+	common.ErrCheckExitf(err, 1, "error finding absolute path for '%s'", globals.SandboxHomeLabel)
+	fix, _ := cmd.Flags().GetBool(globals.FixLabel)
+
+	issues, err := sandbox.DiagnoseSandboxes(sandboxHome)
+	common.ErrCheckExitf(err, 1, "error checking sandboxes: %s", err)
+	if len(issues) == 0 {
+		fmt.Println("No issues found")
+		return
+	}
+	fixableIssues := 0
+	for _, issue := range issues {
+		fmt.Println(issue)
+		if issue.Fixable {
+			fixableIssues++
+		}
+	}
+	if !fix {
+		if fixableIssues > 0 {
+			fmt.Printf("%d of %d issues can be fixed using --%s\n", fixableIssues, len(issues), globals.FixLabel)
+		}
+		common.Exitf(1, "%d issues found", len(issues))
+	}
+	fixed, err := sandbox.FixIssues(issues)
+	common.ErrCheckExitf(err, 1, "error fixing the catalog: %s", err)
+	for _, issue := range fixed {
+		fmt.Printf("fixed [%s] %s\n", issue.Category, issue.Sandbox)
+	}
+	if len(fixed) < len(issues) {
+		common.Exitf(1, "%d issues need manual intervention", len(issues)-len(fixed))
+	}
+}
+
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Detects and repairs inconsistencies between catalog and sandboxes",
+	Long: `Cross-checks the sandboxes catalog with the sandboxes installed in $SANDBOX_HOME,
+their ports, and the binaries they were deployed with.
+Every issue is reported with its category:
+  stale-entry         the catalog lists a sandbox whose directory no longer exists
+  missing-entry       a sandbox is not in the catalog
+  mismatched-entry    the catalog disagrees with the sandbox description
+  broken-description  the sandbox description can't be read
+  duplicate-port      the same port is used by more than one sandbox
+  missing-basedir     the binaries used by a sandbox were removed
+With --fix, the catalog entries are rebuilt from the sandbox descriptions, and the stale
+ones are removed. The other issues need manual intervention.
+The command exits with an error when issues remain.
+`,
+	Example: `$ dbdeployer doctor
+$ dbdeployer doctor --fix`,
+	Args: cobra.NoArgs,
+	Run:  runDoctor,
+}
+
+func init() {
+	rootCmd.AddCommand(doctorCmd)
+	doctorCmd.Flags().BoolP(globals.FixLabel, "", false, "Rebuild missing or mismatched catalog entries and remove stale ones")
+}
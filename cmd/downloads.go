@@ -311,6 +311,7 @@ func getCommonFlags(cmd *cobra.Command) ops.DownloadsOptions {
 	options.VerbosityLevel, _ = cmd.Flags().GetInt(globals.VerbosityLabel)
 	options.Version, _ = cmd.Flags().GetString(globals.UnpackVersionLabel)
 	options.Retries, _ = cmd.Flags().GetInt64(globals.RetriesOnFailureLabel)
+	options.Chunks, _ = cmd.Flags().GetInt(globals.ChunksLabel)
 	return options
 }
 
@@ -835,6 +836,7 @@ func addCommonDownloadsFlags(cmd *cobra.Command) {
 	cmd.Flags().Int64P(globals.ProgressStepLabel, "", globals.ProgressStepValue, "Progress interval")
 	cmd.Flags().BoolP(globals.DeleteAfterUnpackLabel, "", false, "Delete the tarball after successful unpack")
 	cmd.Flags().Int64P(globals.RetriesOnFailureLabel, "", 0, "How many times retry a download if a failure occurs on first try")
+	cmd.Flags().IntP(globals.ChunksLabel, "", 1, "Download the tarball in this many parallel chunks, if the server allows it")
 }
 
 func init() {
@@ -105,6 +105,15 @@ var ReplicationExport = ExportAnnotation{
 	},
 }
 
+// An annotation defining the arguments of an upgrade:
+// the sandbox to upgrade, followed by the newer sandbox or, for a rolling upgrade, the version
+var UpgradeExport = ExportAnnotation{
+	Arguments: []RequiredInfo{
+		{HowMany: 1, Name: globals.ExportSandboxDir},
+		{HowMany: 1, Name: globals.ExportSandboxDirOrVersion},
+	},
+}
+
 // An annotation defining an argument as a generic string
 var StringExport = ExportAnnotation{
 	Arguments: []RequiredInfo{
@@ -93,7 +93,7 @@ func TestExportImport(t *testing.T) {
 			subCommandName:      "",
 			expectedName:        "admin",
 			expectedAncestors:   2,
-			expectedSubCommands: 6,
+			expectedSubCommands: 14,
 			expectedArgument:    "",
 		},
 		{
@@ -112,6 +112,46 @@ func TestExportImport(t *testing.T) {
 			expectedSubCommands: 0,
 			expectedArgument:    globals.ExportSandboxDir,
 		},
+		{
+			commandName:         "admin",
+			subCommandName:      "add-node",
+			expectedName:        "add-node",
+			expectedAncestors:   3,
+			expectedSubCommands: 0,
+			expectedArgument:    globals.ExportSandboxDir,
+		},
+		{
+			commandName:         "admin",
+			subCommandName:      "copy",
+			expectedName:        "copy",
+			expectedAncestors:   3,
+			expectedSubCommands: 0,
+			expectedArgument:    globals.ExportSandboxDir,
+		},
+		{
+			commandName:         "admin",
+			subCommandName:      "group",
+			expectedName:        "group",
+			expectedAncestors:   3,
+			expectedSubCommands: 4,
+			expectedArgument:    "",
+		},
+		{
+			commandName:         "admin",
+			subCommandName:      "snapshot",
+			expectedName:        "snapshot",
+			expectedAncestors:   3,
+			expectedSubCommands: 0,
+			expectedArgument:    globals.ExportSandboxDir,
+		},
+		{
+			commandName:         "admin",
+			subCommandName:      "switchover",
+			expectedName:        "switchover",
+			expectedAncestors:   3,
+			expectedSubCommands: 0,
+			expectedArgument:    globals.ExportSandboxDir,
+		},
 		{
 			commandName:         "admin",
 			subCommandName:      "unlock",
@@ -165,7 +205,7 @@ func TestExportImport(t *testing.T) {
 			subCommandName:      "",
 			expectedName:        "deploy",
 			expectedAncestors:   2,
-			expectedSubCommands: 3,
+			expectedSubCommands: 5,
 			expectedArgument:    "",
 		},
 		{
@@ -192,6 +232,14 @@ func TestExportImport(t *testing.T) {
 			expectedSubCommands: 0,
 			expectedArgument:    globals.ExportVersionDir,
 		},
+		{
+			commandName:         "deploy",
+			subCommandName:      "clusterset",
+			expectedName:        "clusterset",
+			expectedAncestors:   3,
+			expectedSubCommands: 0,
+			expectedArgument:    globals.ExportVersionDir,
+		},
 		{
 			commandName:         "export",
 			subCommandName:      "",
@@ -382,3 +430,15 @@ func TestExportImport(t *testing.T) {
 		}
 	}
 }
+
+func TestExportUpgradeArguments(t *testing.T) {
+	var commandSample Command
+	err := json.Unmarshal([]byte(ExportJsonNamed("admin", "upgrade")), &commandSample)
+	compare.OkIsNil("JSON export of admin upgrade", err, t)
+	arguments := commandSample.Annotations.Arguments
+	compare.OkEqualInt("admin upgrade arguments", len(arguments), 2, t)
+	if len(arguments) == 2 {
+		compare.OkEqualString("first argument", arguments[0].Name, globals.ExportSandboxDir, t)
+		compare.OkEqualString("second argument", arguments[1].Name, globals.ExportSandboxDirOrVersion, t)
+	}
+}
@@ -16,17 +16,193 @@
 package cmd
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 	"path"
 	"regexp"
 	"strings"
+	"time"
 
+	"github.com/alexeyco/simpletable"
 	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/concurrent"
+	"github.com/datacharmer/dbdeployer/defaults"
 	"github.com/datacharmer/dbdeployer/globals"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 )
 
+// globalTask is a command that "global" runs in a sandbox, or in one of its nodes
+type globalTask struct {
+	sandbox string
+	node    string
+	dir     string
+	command string
+	args    []string
+}
+
+// globalResult is the outcome of a globalTask, as reported with --output=json
+type globalResult struct {
+	Sandbox  string  `json:"sandbox"`
+	Node     string  `json:"node"`
+	ExitCode int     `json:"exit_code"`
+	Stdout   string  `json:"stdout"`
+	Stderr   string  `json:"stderr"`
+	Duration float64 `json:"duration"` // seconds
+	Error    string  `json:"error,omitempty"`
+}
+
+// Scripts that can run on their own in each node of a composite sandbox.
+// Starting and stopping are left to the "_all" scripts, which know the order of the nodes
+var nodeScripts = map[string]bool{
+	globals.ScriptUse:      true,
+	globals.ScriptMetadata: true,
+	globals.ScriptStatus:   true,
+	globals.ScriptTestSb:   true,
+}
+
+// Hooks that run before and after a script, for each sandbox where a global command runs it
+var globalHooks = map[string][2]string{
+	globals.ScriptStart: {defaults.HookPreStart, defaults.HookPostStart},
+	globals.ScriptStop:  {defaults.HookPreStop, defaults.HookPostStop},
+}
+
+func scriptArgs(executable string, singleUse bool, args []string) []string {
+	var cmdArgs []string
+	if singleUse && executable == globals.ScriptUse {
+		cmdArgs = append(cmdArgs, "-e")
+	}
+	return append(cmdArgs, args...)
+}
+
+// globalScriptTasks returns the tasks that run a script in a sandbox, or nothing if the script is missing.
+// In composite sandboxes, the scripts in nodeScripts run separately in each node
+func globalScriptTasks(sandboxPath, sandboxName, executable string, args []string) []globalTask {
+	cmdFile := path.Join(sandboxPath, executable)
+	if common.ExecExists(cmdFile) {
+		return []globalTask{{sandbox: sandboxName, dir: sandboxPath, command: cmdFile, args: scriptArgs(executable, true, args)}}
+	}
+	cmdFileAll := cmdFile + "_all"
+	if !common.ExecExists(cmdFileAll) {
+		return nil
+	}
+	if nodeScripts[executable] {
+		var tasks []globalTask
+		nodes, err := common.GetInstalledSandboxes(sandboxPath)
+		if err == nil {
+			for _, node := range nodes {
+				nodePath := path.Join(sandboxPath, node.SandboxName)
+				nodeFile := path.Join(nodePath, executable)
+				if common.ExecExists(nodeFile) {
+					tasks = append(tasks, globalTask{sandbox: sandboxName, node: node.SandboxName, dir: nodePath,
+						command: nodeFile, args: scriptArgs(executable, true, args)})
+				}
+			}
+		}
+		if len(tasks) > 0 {
+			return tasks
+		}
+	}
+	return []globalTask{{sandbox: sandboxName, dir: sandboxPath, command: cmdFileAll, args: scriptArgs(executable, false, args)}}
+}
+
+// runGlobalTasks runs the tasks in parallel, and reports their results once they are all finished.
+// When postHook is set, it runs in the directory of every successful task, and its outcome is added to the task result.
+// It exits with an error if any of the tasks failed
+func runGlobalTasks(tasks []globalTask, parallel int, timeout time.Duration, output string, postHook string) {
+	var operations concurrent.ExecCommands
+	for _, task := range tasks {
+		operations = append(operations, concurrent.ExecCommand{Cmd: task.command, Args: task.args, Dir: task.dir})
+	}
+	var results []globalResult
+	failures := 0
+	for N, taskResult := range concurrent.RunLimitedTasks(operations, parallel, timeout) {
+		result := globalResult{
+			Sandbox:  tasks[N].sandbox,
+			Node:     tasks[N].node,
+			ExitCode: taskResult.ExitCode,
+			Stdout:   taskResult.Stdout,
+			Stderr:   taskResult.Stderr,
+			Duration: taskResult.Duration.Round(time.Millisecond).Seconds(),
+		}
+		if taskResult.Err != nil {
+			result.Error = taskResult.Err.Error()
+		}
+		if postHook != "" && result.ExitCode == 0 && taskResult.Err == nil {
+			hookOutput, err := defaults.RunHookCtrl(postHook, tasks[N].dir, true)
+			result.Stdout += hookOutput
+			if err != nil {
+				result.ExitCode = 1
+				result.Error = err.Error()
+			}
+		}
+		if result.ExitCode != 0 {
+			failures++
+		}
+		results = append(results, result)
+	}
+	if output == globals.OutputJson {
+		if results == nil {
+			results = []globalResult{}
+		}
+		b, err := json.MarshalIndent(results, " ", " ")
+		common.ErrCheckExitf(err, 1, "error encoding results: %s", err)
+		fmt.Println(string(b))
+		if failures > 0 {
+			// No message, to keep the output parseable
+			os.Exit(1)
+		}
+		return
+	}
+	table := simpletable.New()
+	table.Header = &simpletable.Header{
+		Cells: []*simpletable.Cell{
+			{Align: simpletable.AlignCenter, Text: "sandbox"},
+			{Align: simpletable.AlignCenter, Text: "node"},
+			{Align: simpletable.AlignCenter, Text: "exit code"},
+			{Align: simpletable.AlignCenter, Text: "duration"},
+			{Align: simpletable.AlignCenter, Text: "error"},
+		},
+	}
+	for _, result := range results {
+		name := result.Sandbox
+		if result.Node != "" {
+			name = path.Join(result.Sandbox, result.Node)
+		}
+		fmt.Printf("# %s\n", name)
+		fmt.Print(result.Stdout)
+		fmt.Print(result.Stderr)
+		table.Body.Cells = append(table.Body.Cells, []*simpletable.Cell{
+			{Text: result.Sandbox},
+			{Text: result.Node},
+			{Align: simpletable.AlignRight, Text: fmt.Sprintf("%d", result.ExitCode)},
+			{Align: simpletable.AlignRight, Text: fmt.Sprintf("%.3fs", result.Duration)},
+			{Text: result.Error},
+		})
+	}
+	table.SetStyle(simpletable.StyleRounded)
+	table.Println()
+	if failures > 0 {
+		common.Exitf(1, "%d of %d tasks failed", failures, len(results))
+	}
+}
+
+// getLabelFilters returns the values of --tag and --owner, used to select sandboxes
+func getLabelFilters(flags *pflag.FlagSet) (tagFilters []string, ownerFilter string) {
+	tagFilters, _ = flags.GetStringArray(globals.TagLabel)
+	ownerFilter, _ = flags.GetString(globals.OwnerLabel)
+	return
+}
+
+// matchesLabels tells whether a sandbox with the given tags and owner satisfies the filters
+func matchesLabels(tags map[string]string, owner string, tagFilters []string, ownerFilter string) bool {
+	if ownerFilter != "" && !common.OptionCompare(ownerFilter, owner) {
+		return false
+	}
+	return common.TagsMatch(tags, tagFilters)
+}
+
 func getRange(s string) (min, max int, negation bool, err error) {
 	if s == "" {
 		return 0, 0, false, nil
@@ -63,6 +239,16 @@ func globalRunCommand(cmd *cobra.Command, executable string, args []string, requ
 	sbPortRange, _ := flags.GetString(globals.PortRangeLabel)
 	verbose, _ := flags.GetBool(globals.VerboseLabel)
 	dryRun, _ := flags.GetBool(globals.DryRunLabel)
+	tagFilters, ownerFilter := getLabelFilters(flags)
+	parallel, _ := flags.GetInt(globals.ParallelLabel)
+	timeout, _ := flags.GetDuration(globals.TimeoutLabel)
+	output, _ := flags.GetString(globals.OutputLabel)
+	if output != globals.OutputTable && output != globals.OutputJson {
+		common.Exitf(1, "unknown output format '%s'. Use '%s' or '%s'", output, globals.OutputTable, globals.OutputJson)
+	}
+	// Without these options, the commands run one at a time, showing their output as they go
+	collectResults := !dryRun && (parallel > 0 || timeout > 0 || flags.Changed(globals.OutputLabel))
+	var tasks []globalTask
 	sbPortValue, sbPortNegation := common.OptionComponents(sbPortOpt)
 	sbPort := 0
 	if sbPortValue != "" {
@@ -131,6 +317,12 @@ func globalRunCommand(cmd *cobra.Command, executable string, args []string, requ
 				continue
 			}
 		}
+		if !matchesLabels(sbDescription.Tags, sbDescription.Owner, tagFilters, ownerFilter) {
+			if verbose {
+				common.CondPrintf("Skipping %s - owner %s tags [%s] \n", sb, sbDescription.Owner, common.TagsToString(sbDescription.Tags))
+			}
+			continue
+		}
 		if sbPort != 0 {
 			found := false
 			for _, port := range sbDescription.Port {
@@ -166,6 +358,25 @@ func globalRunCommand(cmd *cobra.Command, executable string, args []string, requ
 			}
 		}
 
+		if executable == "exec" && collectResults {
+			tasks = append(tasks, globalTask{sandbox: sb, dir: fullDirPath, command: args[0], args: args[1:]})
+			continue
+		}
+		if collectResults {
+			sbTasks := globalScriptTasks(fullDirPath, sb, executable, args)
+			if len(sbTasks) == 0 {
+				if skipMissing {
+					common.CondPrintf("# Sandbox %s: executable %s not found\n", fullDirPath, executable)
+					continue
+				}
+				common.Exitf(1, "no %s or %s found in %s", executable, executable+"_all", fullDirPath)
+			}
+			// Pre-operation hooks run before any task, so that a failure leaves all sandboxes untouched
+			_, err = defaults.RunHookCtrl(globalHooks[executable][0], fullDirPath, output == globals.OutputJson)
+			common.ErrCheckExitf(err, 1, "%s", err)
+			tasks = append(tasks, sbTasks...)
+			continue
+		}
 		if executable == "exec" {
 			if dryRun {
 				fmt.Printf("%v\n", args)
@@ -224,16 +435,22 @@ func globalRunCommand(cmd *cobra.Command, executable string, args []string, requ
 
 			common.CondPrintf("would run '%s %s'\n", cmdFile, argsStr)
 		} else {
-
+			err = defaults.RunHook(globalHooks[executable][0], fullDirPath)
+			common.ErrCheckExitf(err, 1, "%s", err)
 			if len(cmdArgs) > 0 {
 				_, err = common.RunCmdWithArgs(cmdFile, cmdArgs)
 			} else {
 				_, err = common.RunCmd(cmdFile)
 			}
 			common.ErrCheckExitf(err, 1, "error while running %s\n", cmdFile)
+			err = defaults.RunHook(globalHooks[executable][1], fullDirPath)
+			common.ErrCheckExitf(err, 1, "%s", err)
 		}
 		fmt.Println("")
 	}
+	if collectResults {
+		runGlobalTasks(tasks, parallel, timeout, output, globalHooks[executable][1])
+	}
 }
 
 func startAllSandboxes(cmd *cobra.Command, args []string) {
@@ -276,7 +493,15 @@ var (
 	globalCmd = &cobra.Command{
 		Use:   "global",
 		Short: "Runs a given command in every sandbox",
-		Long:  `This command can propagate the given action through all sandboxes.`,
+		Long: `This command can propagate the given action through all sandboxes.
+By default, the command runs in one sandbox at a time, showing its output as it goes,
+and stops at the first failure.
+Using --parallel, --timeout, or --output, the command runs in several sandboxes at once.
+Scripts that query the servers (use, metadata, status, test) run separately in each node.
+The output of every sandbox is collected, and shown when all are finished, followed by
+a summary of the exit codes. With --output=json, the results are a list of
+{sandbox, node, exit_code, stdout, stderr, duration}.
+The command exits with an error when any sandbox fails.`,
 		Example: `
 	$ dbdeployer global use "select version()"
 	$ dbdeployer global status
@@ -287,6 +512,9 @@ var (
 	$ dbdeployer global start --flavor=percona
 	$ dbdeployer global start --flavor='!percona' --type=single
 	$ dbdeployer global metadata version --flavor='!percona' --type=single
+	$ dbdeployer global stop --tag=team=billing --owner=$USER
+	$ dbdeployer global status --tag=ttl --tag='!team=search'
+	$ dbdeployer global use "select @@version" --parallel=8 --timeout=30s --output=json
 	`,
 	}
 
@@ -399,7 +627,12 @@ func init() {
 	setPflag(globalCmd, globals.TypeLabel, "", "", "", "Runs command only in sandboxes of the given type", false)
 	setPflag(globalCmd, globals.NameLabel, "", "", "", "Runs command only in sandboxes of the given name", false)
 	setPflag(globalCmd, globals.PortRangeLabel, "", "", "", "Runs command only in sandboxes containing a port in the given range", false)
+	setPflag(globalCmd, globals.TagLabel, "", "", "", "Runs command only in sandboxes with the given tag (key or key=value)", true)
+	setPflag(globalCmd, globals.OwnerLabel, "", "", "", "Runs command only in sandboxes of the given owner", false)
 	globalCmd.PersistentFlags().String(globals.PortLabel, "", "Runs commands only in sandboxes containing the given port")
 	globalCmd.PersistentFlags().Bool(globals.VerboseLabel, false, "Show what is matched when filters are used")
 	globalCmd.PersistentFlags().Bool(globals.DryRunLabel, false, "Show what would be executed, without doing it")
+	globalCmd.PersistentFlags().Int(globals.ParallelLabel, 0, "Runs the command in up to N sandboxes at once, collecting the results")
+	globalCmd.PersistentFlags().Duration(globals.TimeoutLabel, 0, "Stops the command in a sandbox after the given time (e.g. 30s, 2m)")
+	globalCmd.PersistentFlags().String(globals.OutputLabel, globals.OutputTable, "Format of the collected results (table, json)")
 }
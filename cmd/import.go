@@ -63,7 +63,7 @@ func importSingleSandbox(cmd *cobra.Command, args []string) {
 	var sd sandbox.SandboxDef
 	sd, err = fillSandboxDefinition(cmd, []string{versionString}, true)
 	if err != nil {
-		common.Exitf(1, "error while filling the sandbox definition: %+v", err)
+		common.Exitf(1, "%s", err)
 	}
 	// When deploying a single sandbox, we disable concurrency
 	sd.RunConcurrently = false
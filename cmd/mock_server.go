@@ -0,0 +1,108 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2022 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/spf13/cobra"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/globals"
+	"github.com/datacharmer/dbdeployer/mockserver"
+)
+
+func runMockServer(cmd *cobra.Command, args []string) {
+	flags := cmd.Flags()
+	defaultsFile, _ := flags.GetString(globals.DefaultsFileLabel)
+	options := mockserver.Options{Variables: make(map[string]string)}
+	var err error
+	if defaultsFile != "" {
+		options, err = mockserver.OptionsFromDefaultsFile(defaultsFile)
+		common.ErrCheckExitf(err, 1, "error reading %s: %s", defaultsFile, err)
+	}
+	// Explicit options replace the ones from the defaults file
+	if flags.Changed(globals.PortLabel) || options.Address == "" {
+		port, _ := flags.GetInt(globals.PortLabel)
+		options.Address = net.JoinHostPort(globals.LocalHostIP, strconv.Itoa(port))
+		options.Variables["port"] = strconv.Itoa(port)
+	}
+	if flags.Changed(globals.SocketLabel) {
+		options.Socket, _ = flags.GetString(globals.SocketLabel)
+		options.Variables["socket"] = options.Socket
+	}
+	if flags.Changed(globals.PidFileLabel) {
+		options.PidFile, _ = flags.GetString(globals.PidFileLabel)
+	}
+	if flags.Changed(globals.VersionLabel) || options.Version == "" {
+		options.Version, _ = flags.GetString(globals.VersionLabel)
+	}
+	if flags.Changed(globals.ServerIdLabel) {
+		options.ServerId, _ = flags.GetInt(globals.ServerIdLabel)
+		options.Variables["server_id"] = strconv.Itoa(options.ServerId)
+	}
+	scriptFile, _ := flags.GetString(globals.ScriptLabel)
+	if scriptFile != "" {
+		script, err := mockserver.ReadScript(scriptFile)
+		common.ErrCheckExitf(err, 1, "error reading script %s: %s", scriptFile, err)
+		options.AddScript(script)
+	}
+	fmt.Printf("dbdeployer mock server %s listening on %s\n", options.Version, options.Address)
+	err = mockserver.Run(options)
+	common.ErrCheckExitf(err, 1, "error running the mock server: %s", err)
+}
+
+// mockServerCmd is only intended for tests
+var mockServerCmd = &cobra.Command{
+	Use:    "mock-server",
+	Short:  "Runs a mock MySQL server for tests",
+	Hidden: true,
+	Long: `Runs a server that speaks the MySQL protocol, without storing any data.
+It answers the handshake, system variables (@@version, @@server_id, @@port, and the
+options in the [mysqld] section of --defaults-file), replication status queries,
+and the scripted answers of --script (or of the file mock_server.json in the sandbox
+directory). Statements that change data are accepted, and do nothing.
+With --defaults-file, port, socket, PID file, and server ID come from the configuration file,
+and the version from the sandbox description. The server stops on SIGTERM, on SHUTDOWN,
+or when its PID file is removed.
+
+A script is a JSON file such as:
+  {
+    "variables": {"read_only": "1"},
+    "queries": [
+      {"query": "select count\\(\\*\\) from test\\.t1", "columns": ["count(*)"], "rows": [["3"]]},
+      {"query": "drop database .*", "error": "Access denied", "error_code": 1044}
+    ]
+  }
+`,
+	Example: `$ dbdeployer mock-server --defaults-file=$HOME/sandboxes/msb_8_0_36/my.sandbox.cnf
+$ dbdeployer mock-server --port=19999 --version=8.0.36 --script=queries.json`,
+	Args: cobra.NoArgs,
+	Run:  runMockServer,
+}
+
+func init() {
+	rootCmd.AddCommand(mockServerCmd)
+	mockServerCmd.Flags().String(globals.DefaultsFileLabel, "", "Sandbox configuration file with the server options")
+	mockServerCmd.Flags().Int(globals.PortLabel, 0, "TCP port (0: any free port)")
+	mockServerCmd.Flags().String(globals.SocketLabel, "", "Unix socket")
+	mockServerCmd.Flags().String(globals.PidFileLabel, "", "PID file, removed when the server stops")
+	mockServerCmd.Flags().String(globals.VersionLabel, mockserver.DefaultVersion, "Version reported by the server")
+	mockServerCmd.Flags().Int(globals.ServerIdLabel, 0, "Server ID")
+	mockServerCmd.Flags().String(globals.ScriptLabel, "", "JSON file with scripted answers")
+}
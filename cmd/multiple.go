@@ -16,6 +16,9 @@
 package cmd
 
 import (
+	"context"
+
+	"github.com/datacharmer/dbdeployer/api"
 	"github.com/datacharmer/dbdeployer/common"
 	"github.com/datacharmer/dbdeployer/globals"
 	"github.com/datacharmer/dbdeployer/sandbox"
@@ -27,14 +30,9 @@ func multipleSandbox(cmd *cobra.Command, args []string) {
 	common.CheckOrigin(args)
 	flags := cmd.Flags()
 	sd, err := fillSandboxDefinition(cmd, args, false)
-	common.ErrCheckExitf(err, 1, "error filling sandbox definition")
+	common.ErrCheckExitf(err, 1, "%s", err)
 	nodes, _ := flags.GetInt(globals.NodesLabel)
-	sd.SBType = "multiple"
-	origin := args[0]
-	if args[0] != sd.BasedirName {
-		origin = sd.BasedirName
-	}
-	_, err = sandbox.CreateMultipleSandbox(sd, origin, nodes)
+	err = api.DeployMultiple(context.Background(), sd, nodes)
 	if err != nil {
 		common.Exitf(1, globals.ErrCreatingSandbox, err)
 	}
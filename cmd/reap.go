@@ -0,0 +1,92 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2022 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/spf13/cobra"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/globals"
+	"github.com/datacharmer/dbdeployer/sandbox"
+)
+
+func reapSandboxes(cmd *cobra.Command, args []string) {
+	sandboxHome, err := getAbsolutePathFromFlag(cmd, globals.SandboxHomeLabel)
+	common.ErrCheckExitf(err, 1, "error finding absolute path for '%s'", globals.SandboxHomeLabel)
+	dryRun, _ := cmd.Flags().GetBool(globals.DryRunLabel)
+
+	if !common.DirExists(sandboxHome) {
+		fmt.Println("No expired sandboxes found")
+		return
+	}
+	expired, err := sandbox.FindExpiredSandboxes(sandboxHome, time.Now())
+	common.ErrCheckExitf(err, 1, "error searching for expired sandboxes: %s", err)
+	if len(expired) == 0 {
+		fmt.Println("No expired sandboxes found")
+		return
+	}
+	var reaped int
+	var reclaimedSize int64
+	var reclaimedPorts []int
+	for _, sb := range expired {
+		expiredSince := time.Since(sb.Expires).Round(time.Second)
+		if sb.Locked {
+			fmt.Printf("# Skipping %s: expired %s ago, but it is locked\n", sb.Name, expiredSince)
+			continue
+		}
+		if dryRun {
+			fmt.Printf("# Would reap %s: expired %s ago - %s - ports %v\n",
+				sb.Name, expiredSince, humanize.Bytes(uint64(sb.Size)), sb.Ports)
+		} else {
+			err = sandbox.ReapSandbox(sandboxHome, sb)
+			common.ErrCheckExitf(err, 1, "error reaping sandbox %s: %s", sb.Name, err)
+			fmt.Printf("# Reaped %s: expired %s ago - %s - ports %v\n",
+				sb.Name, expiredSince, humanize.Bytes(uint64(sb.Size)), sb.Ports)
+		}
+		reaped++
+		reclaimedSize += sb.Size
+		reclaimedPorts = append(reclaimedPorts, sb.Ports...)
+	}
+	verb := "Reclaimed"
+	if dryRun {
+		verb = "Would reclaim"
+	}
+	fmt.Printf("%s %s and %d ports from %d sandboxes\n", verb, humanize.Bytes(uint64(reclaimedSize)), len(reclaimedPorts), reaped)
+}
+
+var reapCmd = &cobra.Command{
+	Use:   "reap",
+	Short: "Removes expired sandboxes",
+	Long: `Stops and removes every sandbox in $SANDBOX_HOME whose time to live has passed.
+The time to live is set at deployment with --ttl (for example: --ttl=4h).
+Sandboxes deployed without --ttl never expire, and locked sandboxes are skipped.
+For each sandbox, it reports the disk space and the ports that were reclaimed.
+`,
+	Example: `$ dbdeployer deploy single 8.0 --ttl=4h
+$ dbdeployer reap --dry-run
+$ dbdeployer reap`,
+	Args: cobra.NoArgs,
+	Run:  reapSandboxes,
+}
+
+func init() {
+	rootCmd.AddCommand(reapCmd)
+	reapCmd.Flags().Bool(globals.DryRunLabel, false, "Show what would be removed, without doing it")
+}
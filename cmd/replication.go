@@ -16,6 +16,9 @@
 package cmd
 
 import (
+	"context"
+
+	"github.com/datacharmer/dbdeployer/api"
 	"github.com/datacharmer/dbdeployer/common"
 	"github.com/datacharmer/dbdeployer/globals"
 	"github.com/datacharmer/dbdeployer/sandbox"
@@ -27,11 +30,7 @@ func replicationSandbox(cmd *cobra.Command, args []string) {
 	var semisync bool
 	common.CheckOrigin(args)
 	sd, err := fillSandboxDefinition(cmd, args, false)
-	common.ErrCheckExitf(err, 1, "error filling sandbox definition : %s", err)
-	if sd.Flavor == common.TiDbFlavor {
-		common.Exitf(1, "flavor '%s' is not suitable to create replication sandboxes", common.TiDbFlavor)
-	}
-	sd.ReplOptions = sandbox.SingleTemplates[globals.TmplReplicationOptions].Contents
+	common.ErrCheckExitf(err, 1, "%s", err)
 	flags := cmd.Flags()
 	semisync, _ = flags.GetBool(globals.SemiSyncLabel)
 	ndbNodes, _ := flags.GetInt(globals.NdbNodesLabel)
@@ -40,7 +39,9 @@ func replicationSandbox(cmd *cobra.Command, args []string) {
 	masterIp, _ := flags.GetString(globals.MasterIpLabel)
 	masterList, _ := flags.GetString(globals.MasterListLabel)
 	slaveList, _ := flags.GetString(globals.SlaveListLabel)
+	fanOut, _ := flags.GetString(globals.FanOutLabel)
 	sd.SinglePrimary, _ = flags.GetBool(globals.SinglePrimaryLabel)
+	sd.WithRouter, _ = flags.GetBool(globals.WithRouterLabel)
 	replHistoryDir, _ := flags.GetBool(globals.ReplHistoryDirLabel)
 	if replHistoryDir {
 		sd.HistoryDir = "REPL_DIR"
@@ -71,24 +72,36 @@ func replicationSandbox(cmd *cobra.Command, args []string) {
 			globals.SinglePrimaryLabel,
 			globals.GroupLabel)
 	}
+	if sd.WithRouter && topology != globals.InnoDBClusterLabel {
+		common.Exitf(1, "option '%s' can only be used with '%s' topology ",
+			globals.WithRouterLabel,
+			globals.InnoDBClusterLabel)
+	}
 	if ndbNodes != globals.NdbNodesValue && topology != globals.NdbLabel {
 		common.Exitf(1, "option '%s' can only be used with '%s' topology ",
 			globals.NdbNodesLabel,
 			globals.NdbLabel)
 
 	}
-	origin := args[0]
-	if args[0] != sd.BasedirName {
-		origin = sd.BasedirName
+	if flags.Changed(globals.FanOutLabel) && topology != globals.TreeLabel {
+		common.Exitf(1, "option '%s' can only be used with '%s' topology ",
+			globals.FanOutLabel,
+			globals.TreeLabel)
+	}
+	if topology == globals.TreeLabel && !flags.Changed(globals.NodesLabel) {
+		fanOutList, err := common.StringToIntSlice(fanOut)
+		common.ErrCheckExitf(err, 1, "error parsing --%s: %s", globals.FanOutLabel, err)
+		nodes = sandbox.TreeNodes(fanOutList)
 	}
-	err = sandbox.CreateReplicationSandbox(sd, origin,
+	err = api.DeployReplication(context.Background(), sd,
 		sandbox.ReplicationData{
 			Topology:   topology,
 			Nodes:      nodes,
 			NdbNodes:   ndbNodes,
 			MasterIp:   masterIp,
 			MasterList: masterList,
-			SlaveList:  slaveList})
+			SlaveList:  slaveList,
+			FanOut:     fanOut})
 	if err != nil {
 		common.Exitf(1, globals.ErrCreatingSandbox, err)
 	}
@@ -102,6 +115,13 @@ var replicationCmd = &cobra.Command{
 Allowed topologies are "master-slave" for all versions, and  "group", "all-masters", "fan-in"
 for  5.7.17+.
 Topologies "pcx" and "ndb" are available for binaries of type Percona Xtradb Cluster and MySQL Cluster.
+Topology "galera" is available for MariaDB 10.1+ binaries that include the wsrep provider library (libgalera_smm).
+Topology "chain" deploys cascading replication, where every node replicates from the previous one.
+Topology "tree" deploys a master with replicas that are sources for other replicas. The number of
+replicas for each node at every level is set with --fan-out (e.g. --fan-out=2,3 gives 2 replicas
+to the master and 3 to each of them). Without --nodes, the tree is complete for the given fan-out.
+Topology "innodb-cluster" accepts --with-router, which deploys MySQL Router in the "router"
+directory of the sandbox, when the mysqlrouter binary is in the same basedir as the server.
 For this command to work, there must be a directory $HOME/opt/mysql/5.7.21, containing
 the binary files from mysql-5.7.21-$YOUR_OS-x86_64.tar.gz
 Use the "unpack" command to get the tarball into the right directory.
@@ -125,7 +145,12 @@ Use the "unpack" command to get the tarball into the right directory.
 		$ dbdeployer deploy --topology=all-masters replication 5.7
 		$ dbdeployer deploy --topology=fan-in replication 5.7
 		$ dbdeployer deploy --topology=pxc replication pxc5.7.25
+		$ dbdeployer deploy --topology=galera replication ma10.11.6
+		$ dbdeployer deploy --gtid replication ma10.11.6
 		$ dbdeployer deploy --topology=ndb replication ndb8.0.14
+		$ dbdeployer deploy --topology=chain replication 8.0 --nodes=4
+		$ dbdeployer deploy --topology=tree replication 8.0 --fan-out=2,3
+		$ dbdeployer deploy --topology=innodb-cluster replication 8.0 --with-router
 	`,
 	Annotations: map[string]string{"export": ExportAnnotationToJson(ReplicationExport)},
 }
@@ -138,7 +163,9 @@ func init() {
 	replicationCmd.PersistentFlags().StringP(globals.TopologyLabel, "t", globals.TopologyValue, "Which topology will be installed")
 	replicationCmd.PersistentFlags().IntP(globals.NodesLabel, "n", globals.NodesValue, "How many nodes will be installed")
 	replicationCmd.PersistentFlags().IntP(globals.NdbNodesLabel, "", globals.NdbNodesValue, "How many NDB nodes will be installed")
+	replicationCmd.PersistentFlags().String(globals.FanOutLabel, globals.FanOutValue, "Replicas for each node at every level of a tree topology (comma separated list)")
 	replicationCmd.PersistentFlags().BoolP(globals.SinglePrimaryLabel, "", false, "Using single primary for group replication")
+	replicationCmd.PersistentFlags().Bool(globals.WithRouterLabel, false, "Deploy MySQL Router with InnoDB Cluster (requires mysqlrouter in the basedir)")
 	replicationCmd.PersistentFlags().BoolP(globals.SemiSyncLabel, "", false, "Use semi-synchronous plugin")
 	replicationCmd.PersistentFlags().BoolP(globals.ReadOnlyLabel, "", false, "Set read-only for slaves")
 	replicationCmd.PersistentFlags().BoolP(globals.SuperReadOnlyLabel, "", false, "Set super-read-only for slaves")
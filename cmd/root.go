@@ -18,6 +18,7 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"path"
 	"strings"
 
 	"github.com/spf13/cobra"
@@ -113,6 +114,9 @@ func checkDefaultsFile() {
 	}
 	globals.MockTemplatesFilled = true
 	loadTemplates()
+	for _, err := range common.LoadFlavorDescriptors(path.Join(defaults.ConfigurationDir, globals.FlavorsDirName)) {
+		fmt.Fprintf(os.Stderr, "custom flavor skipped: %s\n", err)
+	}
 	if downloads.TarballRegistryFileExist() {
 		err = downloads.LoadTarballFileInfo()
 		if err != nil {
@@ -16,6 +16,7 @@
 package cmd
 
 import (
+	"encoding/json"
 	"fmt"
 	"sort"
 	"time"
@@ -23,18 +24,25 @@ import (
 	"github.com/alexeyco/simpletable"
 	"github.com/araddon/dateparse"
 	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
 
 	"github.com/datacharmer/dbdeployer/common"
 	"github.com/datacharmer/dbdeployer/defaults"
 	"github.com/datacharmer/dbdeployer/globals"
+	"github.com/datacharmer/dbdeployer/ops"
 )
 
-func showSandboxesFromCatalog(currentSandboxHome string, useFlavor, useHeader, useTable bool) {
+func showSandboxesFromCatalog(currentSandboxHome string, useFlavor, useHeader, useTable bool, tagFilters []string, ownerFilter string) {
 	var sandboxList defaults.SandboxCatalog
 	var err error
 	sandboxList, err = defaults.ReadCatalog()
 
 	common.ErrCheckExitf(err, 1, "error getting sandboxes from catalog: %s", err)
+	for name, contents := range sandboxList {
+		if !matchesLabels(contents.Tags, contents.Owner, tagFilters, ownerFilter) {
+			delete(sandboxList, name)
+		}
+	}
 	if len(sandboxList) == 0 {
 		return
 	}
@@ -82,6 +90,98 @@ func showSandboxesFromCatalog(currentSandboxHome string, useFlavor, useHeader, u
 	table.Println()
 }
 
+// showSandboxesStatus shows the live status of the servers in each sandbox
+func showSandboxesStatus(sandboxHome string, runQuery bool, output string) {
+	if output != globals.OutputTable && output != globals.OutputJson && output != globals.OutputYaml {
+		common.Exitf(1, "unknown output format '%s'. Use '%s', '%s', or '%s'",
+			output, globals.OutputTable, globals.OutputJson, globals.OutputYaml)
+	}
+	var statusList []ops.SandboxStatus
+	// If the sandbox directory hasn't been created yet, we start with an empty list
+	if common.DirExists(sandboxHome) {
+		var err error
+		statusList, err = ops.GetSandboxesStatus(sandboxHome, runQuery)
+		common.ErrCheckExitf(err, 1, "error getting sandboxes status: %s", err)
+	}
+	switch output {
+	case globals.OutputJson:
+		if statusList == nil {
+			statusList = []ops.SandboxStatus{}
+		}
+		b, err := json.MarshalIndent(statusList, " ", " ")
+		common.ErrCheckExitf(err, 1, "error encoding sandboxes status: %s", err)
+		fmt.Println(string(b))
+		return
+	case globals.OutputYaml:
+		b, err := yaml.Marshal(statusList)
+		common.ErrCheckExitf(err, 1, "error encoding sandboxes status: %s", err)
+		fmt.Print(string(b))
+		return
+	}
+	if len(statusList) == 0 {
+		return
+	}
+	table := simpletable.New()
+	table.Header = &simpletable.Header{
+		Cells: []*simpletable.Cell{
+			{Align: simpletable.AlignCenter, Text: "name"},
+			{Align: simpletable.AlignCenter, Text: "node"},
+			{Align: simpletable.AlignCenter, Text: "port"},
+			{Align: simpletable.AlignCenter, Text: "state"},
+			{Align: simpletable.AlignCenter, Text: "pid"},
+			{Align: simpletable.AlignCenter, Text: "socket"},
+			{Align: simpletable.AlignCenter, Text: "uptime"},
+			{Align: simpletable.AlignCenter, Text: "version"},
+			{Align: simpletable.AlignCenter, Text: "role"},
+			{Align: simpletable.AlignCenter, Text: "lag"},
+			{Align: simpletable.AlignCenter, Text: "error"},
+		},
+	}
+	for _, sbStatus := range statusList {
+		if sbStatus.Error != "" {
+			table.Body.Cells = append(table.Body.Cells, []*simpletable.Cell{
+				{Text: sbStatus.Name}, {}, {}, {}, {}, {}, {}, {}, {}, {}, {Text: sbStatus.Error},
+			})
+			continue
+		}
+		for _, server := range sbStatus.Servers {
+			pid := ""
+			uptime := ""
+			if server.State == ops.ServerRunning {
+				pid = fmt.Sprintf("%d", server.Pid)
+				uptime = (time.Duration(server.Uptime) * time.Second).String()
+			}
+			lag := ""
+			if server.Lag != nil {
+				lag = fmt.Sprintf("%d", *server.Lag)
+			}
+			socket := "no"
+			if server.Socket {
+				socket = "yes"
+			}
+			version := server.Version
+			if version == "" {
+				version = sbStatus.Version
+			}
+			table.Body.Cells = append(table.Body.Cells, []*simpletable.Cell{
+				{Text: sbStatus.Name},
+				{Text: server.Node},
+				{Align: simpletable.AlignRight, Text: fmt.Sprintf("%d", server.Port)},
+				{Text: server.State},
+				{Align: simpletable.AlignRight, Text: pid},
+				{Text: socket},
+				{Align: simpletable.AlignRight, Text: uptime},
+				{Text: version},
+				{Text: server.Role},
+				{Align: simpletable.AlignRight, Text: lag},
+				{Text: server.Error},
+			})
+		}
+	}
+	table.SetStyle(simpletable.StyleRounded)
+	table.Println()
+}
+
 // Shows installed sandboxes
 func showSandboxes(cmd *cobra.Command, args []string) {
 	flags := cmd.Flags()
@@ -114,8 +214,16 @@ func showSandboxes(cmd *cobra.Command, args []string) {
 		useTable = true
 		useHost = true
 	}
+	tagFilters, ownerFilter := getLabelFilters(flags)
 	if readCatalog {
-		showSandboxesFromCatalog(SandboxHome, useFlavor, useHeader, useTable)
+		showSandboxesFromCatalog(SandboxHome, useFlavor, useHeader, useTable, tagFilters, ownerFilter)
+		return
+	}
+	showStatus, _ := flags.GetBool(globals.StatusLabel)
+	if showStatus {
+		runQuery, _ := flags.GetBool(globals.QueryLabel)
+		output, _ := flags.GetString(globals.OutputLabel)
+		showSandboxesStatus(SandboxHome, runQuery, output)
 		return
 	}
 	var sandboxList common.SandboxInfoList
@@ -131,6 +239,13 @@ func showSandboxes(cmd *cobra.Command, args []string) {
 			sandboxList = common.GetFullSandboxInfo(SandboxHome)
 		}
 	}
+	var selectedList common.SandboxInfoList
+	for _, sb := range sandboxList {
+		if matchesLabels(sb.SandboxDesc.Tags, sb.SandboxDesc.Owner, tagFilters, ownerFilter) {
+			selectedList = append(selectedList, sb)
+		}
+	}
+	sandboxList = selectedList
 	for _, sb := range sandboxList {
 		if sb.SandboxDesc.Host != "" && sb.SandboxDesc.Host != globals.LocalHostIP {
 			useHost = true
@@ -195,6 +310,13 @@ func showSandboxes(cmd *cobra.Command, args []string) {
 			table.Header.Cells = append(table.Header.Cells,
 				&simpletable.Cell{Align: simpletable.AlignCenter, Text: "created"})
 		}
+		if useFullInfo {
+			table.Header.Cells = append(table.Header.Cells,
+				&simpletable.Cell{Align: simpletable.AlignCenter, Text: "owner"},
+				&simpletable.Cell{Align: simpletable.AlignCenter, Text: "tags"},
+				&simpletable.Cell{Align: simpletable.AlignCenter, Text: "note"},
+			)
+		}
 	}
 	for _, sb := range sandboxList {
 		var cells []*simpletable.Cell
@@ -245,6 +367,13 @@ func showSandboxes(cmd *cobra.Command, args []string) {
 			cells = append(cells, &simpletable.Cell{Text: timestamp.Format(time.RFC3339)})
 			//cells = append(cells, &simpletable.Cell{Text: timestamp.Format("2006-01-02 15:04:05Z -07:00")})
 		}
+		if useFullInfo {
+			cells = append(cells,
+				&simpletable.Cell{Text: sb.SandboxDesc.Owner},
+				&simpletable.Cell{Text: common.TagsToString(sb.SandboxDesc.Tags)},
+				&simpletable.Cell{Text: sb.SandboxDesc.Note},
+			)
+		}
 		table.Body.Cells = append(table.Body.Cells, cells)
 	}
 	table.SetStyle(simpletable.StyleCompactLite)
@@ -262,7 +391,15 @@ If sandboxes are installed in a different location, use --sandbox-home to
 indicate where to look.
 Alternatively, using --catalog will list all sandboxes, regardless of where 
 they were deployed.
+Using --status will show the live state of each server (running, stopped, or crashed),
+based on its PID file and socket. With --query, running servers are also asked for
+the version they run, their uptime, their replication role and lag.
+The status can be shown as a table, or as JSON or YAML for scripts.
+Using --tag and --owner, only the sandboxes with the given labels are listed.
 `,
+	Example: `dbdeployer sandboxes --status
+dbdeployer sandboxes --status --query --output=json
+dbdeployer sandboxes --full-info --tag=team=billing`,
 	Aliases: []string{"installed", "deployed"},
 	Run:     showSandboxes,
 }
@@ -280,4 +417,9 @@ func init() {
 	sandboxesCmd.Flags().BoolP(globals.ByVersionLabel, "", false, "Show sandboxes sorted by version")
 	sandboxesCmd.Flags().BoolP(globals.LatestLabel, "", false, "Show only latest sandbox")
 	sandboxesCmd.Flags().BoolP(globals.OldestLabel, "", false, "Show only oldest sandbox")
+	sandboxesCmd.Flags().StringArray(globals.TagLabel, nil, "Shows only sandboxes with the given tag (key or key=value)")
+	sandboxesCmd.Flags().String(globals.OwnerLabel, "", "Shows only sandboxes of the given owner")
+	sandboxesCmd.Flags().BoolP(globals.StatusLabel, "", false, "Show the live status of the servers in each sandbox")
+	sandboxesCmd.Flags().BoolP(globals.QueryLabel, "", false, "With --status, query running servers for version, uptime, role, and lag")
+	sandboxesCmd.Flags().StringP(globals.OutputLabel, "", globals.OutputTable, "Format of the --status output (table, json, yaml)")
 }
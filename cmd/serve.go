@@ -0,0 +1,108 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2022 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/globals"
+	"github.com/datacharmer/dbdeployer/ops"
+)
+
+func serveApi(cmd *cobra.Command, args []string) {
+	flags := cmd.Flags()
+	sandboxHome, err := getAbsolutePathFromFlag(cmd, globals.SandboxHomeLabel)
+	common.ErrCheckExitf(err, 1, "error finding absolute path for '%s'", globals.SandboxHomeLabel)
+	sandboxBinary, err := getAbsolutePathFromFlag(cmd, globals.SandboxBinaryLabel)
+	common.ErrCheckExitf(err, 1, "error finding absolute path for '%s'", globals.SandboxBinaryLabel)
+	executable, err := os.Executable()
+	common.ErrCheckExitf(err, 1, "error finding the dbdeployer executable: %s", err)
+
+	listen, _ := flags.GetString(globals.ListenLabel)
+	socket, _ := flags.GetString(globals.SocketLabel)
+	if socket != "" {
+		socket, err = common.AbsolutePath(socket)
+		common.ErrCheckExitf(err, 1, "error finding absolute path for '%s'", globals.SocketLabel)
+	}
+	// The token is not a default value, so that it never shows in the help
+	token, _ := flags.GetString(globals.TokenLabel)
+	if token == "" {
+		token = os.Getenv(globals.TokenEnvValue)
+	}
+	if token == "" {
+		token, err = ops.NewToken()
+		common.ErrCheckExitf(err, 1, "error generating the API token: %s", err)
+		fmt.Printf("API token: %s\n", token)
+	}
+	err = ops.Serve(ops.ServeOptions{
+		Listen:        listen,
+		Socket:        socket,
+		Token:         token,
+		SandboxHome:   sandboxHome,
+		SandboxBinary: sandboxBinary,
+		Executable:    executable,
+	})
+	common.ErrCheckExitf(err, 1, "error running the API server: %s", err)
+}
+
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Runs a local JSON HTTP API",
+	Long: `Exposes the sandboxes in $SANDBOX_HOME through a JSON HTTP API, listening
+on a local address (--listen) or on a unix socket (--socket).
+Every request needs the header "Authorization: Bearer <token>". The token is set with
+--token or $DBDEPLOYER_API_TOKEN. Without either, a random token is generated and printed.
+Request bodies must be sent as "Content-Type: application/json". Requests from web pages
+(with an "Origin" header) are refused, and so are host names other than localhost
+when the server listens on a local address or on a socket.
+
+  GET    /versions                  versions available in $SANDBOX_BINARY
+  GET    /sandboxes[?query=true]    live status of the sandboxes (query: ask the servers)
+  GET    /sandboxes/{name}          live status of one sandbox
+  POST   /sandboxes                 deploy a sandbox (job)
+  DELETE /sandboxes/{name}          delete a sandbox (job)
+  POST   /sandboxes/{name}/start    start a sandbox (job)
+  POST   /sandboxes/{name}/stop     stop a sandbox (job)
+  POST   /sandboxes/{name}/query    run a query: {"query": "...", "node": "node1"}
+  GET    /catalog                   the sandbox catalog
+  GET    /jobs                      all the jobs
+  GET    /jobs/{id}                 state and output of one job
+
+The deployment body uses the names of the deploy options, plus "topology"
+(single, multiple, or any replication topology), for example:
+  {"version": "8.0.36", "topology": "group", "nodes": 3, "tags": ["team=qa"]}
+Operations that take time return a job (HTTP 202). Jobs run one at a time.
+The server remembers the latest 100 jobs.
+`,
+	Example: `$ dbdeployer serve --socket=/tmp/dbdeployer.sock --token=secret &
+$ curl -s --unix-socket /tmp/dbdeployer.sock -H 'Authorization: Bearer secret' \
+    -H 'Content-Type: application/json' \
+    -d '{"version": "8.0.36", "sandbox-directory": "test1"}' http://localhost/sandboxes
+$ curl -s --unix-socket /tmp/dbdeployer.sock -H 'Authorization: Bearer secret' http://localhost/jobs/1`,
+	Args: cobra.NoArgs,
+	Run:  serveApi,
+}
+
+func init() {
+	rootCmd.AddCommand(serveCmd)
+	serveCmd.Flags().String(globals.ListenLabel, globals.ListenValue, "TCP address where the API listens")
+	serveCmd.Flags().String(globals.SocketLabel, "", "Unix socket where the API listens, instead of the TCP address")
+	serveCmd.Flags().String(globals.TokenLabel, "", "Token required in every request (default $"+globals.TokenEnvValue+", or a random token)")
+}
@@ -16,12 +16,16 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"os/user"
 	"path"
 	"regexp"
 	"strings"
+	"time"
 
+	"github.com/datacharmer/dbdeployer/api"
 	"github.com/datacharmer/dbdeployer/common"
 	"github.com/datacharmer/dbdeployer/defaults"
 	"github.com/datacharmer/dbdeployer/globals"
@@ -89,64 +93,38 @@ func getAbsolutePathFromFlag(cmd *cobra.Command, name string) (string, error) {
 	}
 }
 
-func checkIfAbridgedVersion(version, basedir string) string {
+func checkIfAbridgedVersion(version, basedir string) (string, error) {
 	fullPattern := regexp.MustCompile(`\d\.\d+\.\d+$`)
 	if fullPattern.MatchString(version) {
-		return version
+		return version, nil
 	}
 	validPattern := regexp.MustCompile(`\d+\.\d+$`)
 	if !validPattern.MatchString(version) {
-		return version
+		return version, nil
 	}
 	fullVersion := common.LatestVersion(basedir, version)
 	if fullVersion == "" {
-		common.Exitf(1, "FATAL: no full version found for %s in %s\n", version, basedir)
-	} else {
-		common.CondPrintf("# %s => %s\n", version, fullVersion)
-		version = fullVersion
+		return version, fmt.Errorf("no full version found for %s in %s", version, basedir)
 	}
-	return version
+	common.CondPrintf("# %s => %s\n", version, fullVersion)
+	return fullVersion, nil
 }
 
-func checkForRootValue(value, label, defaultVal string) {
+func checkForRootValue(value, label, defaultVal string) error {
 	if value == "root" {
-		common.Exit(1, fmt.Sprintf("option --%s cannot be 'root'", label),
+		return fmt.Errorf("option --%s cannot be 'root'\n%s\n%s\n%s", label,
 			"The 'root' user will be initialized regardless,",
 			"using the same password defined for the default db-user.",
 			fmt.Sprintf("The default user for this option is '%s'.", defaultVal))
 	}
+	return nil
 }
 
 // Gets the Database flavor
 // If none is found, defaults to MySQL
 func getFlavor(userDefinedFlavor, basedir string) string {
-	flavorOrigin := ""
-	flavor := userDefinedFlavor
-	if userDefinedFlavor != "" {
-		flavorOrigin = "flag"
-	}
-	flavorFile := path.Join(basedir, globals.FlavorFileName)
-	if common.FileExists(flavorFile) {
-		flavorText, err := common.SlurpAsString(flavorFile)
-		if err != nil {
-			common.Exitf(1, "error reading flavor file %s: %s", flavorFile, err)
-		}
-		flavorText = strings.TrimSpace(flavorText)
-		if userDefinedFlavor != "" && userDefinedFlavor != flavorText {
-			common.Exitf(1, "user defined flavor %s doesn't match found flavor %s", userDefinedFlavor, flavorText)
-		}
-		flavor = flavorText
-		flavorOrigin = "FLAVOR file"
-	}
-	// Flavor detection based on tarball contents
-	if flavor == "" {
-		flavor = common.DetectBinaryFlavor(basedir)
-		flavorOrigin = "Binary examination"
-	}
-	err := common.CheckFlavorSupport(flavor)
-	if err != nil {
-		common.Exitf(1, "flavor detected from %s unsupported: %s", flavorOrigin, err)
-	}
+	flavor, err := sandbox.DetectFlavor(userDefinedFlavor, basedir)
+	common.ErrCheckExitf(err, 1, "%s", err)
 	return flavor
 }
 
@@ -173,12 +151,6 @@ func fillSandboxDefinition(cmd *cobra.Command, args []string, usingImport bool)
 			defaults.UpdateDefaults(globals.LogLogDirectoryLabel, logDir, false)
 		}
 	}
-	templateRequests, _ := flags.GetStringArray(globals.UseTemplateLabel)
-	for _, request := range templateRequests {
-		tname, fname := checkTemplateChangeRequest(request)
-		replaceTemplate(tname, fname)
-	}
-
 	basedir, err := getAbsolutePathFromFlag(cmd, globals.SandboxBinaryLabel)
 	if err != nil {
 		return sd, err
@@ -199,7 +171,10 @@ func fillSandboxDefinition(cmd *cobra.Command, args []string, usingImport bool)
 	if sd.Version == "" {
 		sd.Version = args[0]
 		oldVersion := sd.Version
-		sd.Version = checkIfAbridgedVersion(sd.Version, basedir)
+		sd.Version, err = checkIfAbridgedVersion(sd.Version, basedir)
+		if err != nil {
+			return sd, err
+		}
 		if oldVersion != sd.Version {
 			sd.BasedirName = sd.Version
 		}
@@ -223,8 +198,8 @@ func fillSandboxDefinition(cmd *cobra.Command, args []string, usingImport bool)
 			// or a command line option
 			if oldBasedir != basedir {
 				// The new basedir is different from the one given by command line or env
-				common.Exit(1, "the Sandbox Binary directory was set twice,",
-					fmt.Sprintf(" using conflicting values: '%s' and '%s' ", oldBasedir, basedir))
+				return sd, fmt.Errorf("the Sandbox Binary directory was set twice, using conflicting values: '%s' and '%s'",
+					oldBasedir, basedir)
 			}
 		}
 		sd.BasedirName = common.BaseName(sd.BasedirName)
@@ -232,15 +207,17 @@ func fillSandboxDefinition(cmd *cobra.Command, args []string, usingImport bool)
 			sd.Version = sd.BasedirName
 		}
 		if !common.IsVersion(sd.Version) {
-			common.Exitf(1, "no version detected for directory %s", target)
+			return sd, fmt.Errorf("no version detected for directory %s", target)
 		}
 		// common.CondPrintf("NEW bd <%s> - v: <%s>\n",basedir, sd.Version )
 	}
 
 	sd.Port, err = common.VersionToPort(sd.Version)
-	common.ErrCheckExitf(err, 1, "can't convert '%s' into port number", sd.Version)
+	if err != nil {
+		return sd, fmt.Errorf("can't convert '%s' into port number", sd.Version)
+	}
 	if sd.Port < 0 {
-		common.Exitf(1, "unsupported version format (%s)", sd.Version)
+		return sd, fmt.Errorf("unsupported version format (%s)", sd.Version)
 	}
 	sd.UserPort, _ = flags.GetInt(globals.PortLabel)
 	sd.BasePort, _ = flags.GetInt(globals.BasePortLabel)
@@ -253,13 +230,19 @@ func fillSandboxDefinition(cmd *cobra.Command, args []string, usingImport bool)
 
 	sd.Basedir = path.Join(basedir, sd.BasedirName)
 	if !common.DirExists(sd.Basedir) && !sd.Imported {
-		common.Exitf(1, "basedir '%s' not found", sd.Basedir)
+		return sd, sandbox.WithKind(sandbox.ErrMissingBinaries, fmt.Errorf("basedir '%s' not found", sd.Basedir))
 	}
 
 	skipLibraryCheck, _ := flags.GetBool(globals.SkipLibraryCheck)
 	if os.Getenv("SB_MOCKING") != "" {
 		skipLibraryCheck = true
 	}
+	// The library check looks for the libraries needed by MySQL servers
+	userDefinedFlavor, _ := flags.GetString(globals.FlavorLabel)
+	if common.BaseFlavor(userDefinedFlavor) == common.PostgreSQLFlavor ||
+		common.BaseFlavor(common.DetectBinaryFlavor(sd.Basedir)) == common.PostgreSQLFlavor {
+		skipLibraryCheck = true
+	}
 	if !skipLibraryCheck {
 		err = common.CheckLibraries(sd.Basedir)
 		if err != nil {
@@ -271,13 +254,15 @@ func fillSandboxDefinition(cmd *cobra.Command, args []string, usingImport bool)
 	if sd.ClientBasedir != "" {
 		clientBasedir := path.Join(basedir, sd.ClientBasedir)
 		if !common.DirExists(clientBasedir) {
-			common.Exitf(1, globals.ErrDirectoryNotFound, clientBasedir)
+			return sd, sandbox.WithKind(sandbox.ErrMissingBinaries, fmt.Errorf(globals.ErrDirectoryNotFound, clientBasedir))
 		}
 		sd.ClientBasedir = clientBasedir
 	}
 	if !sd.Imported {
 		err = common.CheckTarballOperatingSystem(sd.Basedir)
-		common.ErrCheckExitf(err, 1, "incorrect tarball detected")
+		if err != nil {
+			return sd, errors.Wrapf(err, "incorrect tarball detected")
+		}
 	}
 	sd.SandboxDir, err = getAbsolutePathFromFlag(cmd, globals.SandboxHomeLabel)
 	if err != nil {
@@ -320,6 +305,15 @@ func fillSandboxDefinition(cmd *cobra.Command, args []string, usingImport bool)
 		return sd, fmt.Errorf("options --%s and --%s should not be provided together",
 			globals.PortAsServerIdLabel, globals.ServerIdLabel)
 	}
+	sd.EnableTls, _ = flags.GetBool(globals.TlsLabel)
+	sd.SecureTransportOnly, _ = flags.GetBool(globals.SecureTransportLabel)
+	if sd.SecureTransportOnly && !sd.EnableTls {
+		return sd, fmt.Errorf("option --%s requires --%s", globals.SecureTransportLabel, globals.TlsLabel)
+	}
+	if sd.EnableTls {
+		// All the sandboxes in the same sandbox home share one certificate authority
+		sd.TlsCaDir = path.Join(sd.SandboxDir, globals.TlsCaDirName)
+	}
 	sd.FlavorInPrompt, _ = flags.GetBool(globals.FlavorInPromptLabel)
 	sd.HistoryDir, _ = flags.GetString(globals.HistoryDirLabel)
 	sd.DbUser, _ = flags.GetString(globals.DbUserLabel)
@@ -334,9 +328,28 @@ func fillSandboxDefinition(cmd *cobra.Command, args []string, usingImport bool)
 	sd.TaskUserRole, _ = flags.GetString(globals.TaskUserRoleLabel)
 	sd.Flavor, _ = flags.GetString(globals.FlavorLabel)
 
-	sd.Flavor = getFlavor(sd.Flavor, sd.Basedir)
-	checkForRootValue(sd.DbUser, globals.DbUserLabel, globals.DbUserValue)
-	checkForRootValue(sd.RplUser, globals.RplUserLabel, globals.RplUserValue)
+	sd.Flavor, err = sandbox.DetectFlavor(sd.Flavor, sd.Basedir)
+	if err != nil {
+		return sd, err
+	}
+	// Templates requested by the user have precedence over the ones of a custom flavor
+	err = sandbox.UseFlavorTemplates(sd.Flavor)
+	if err != nil {
+		return sd, err
+	}
+	templateRequests, _ := flags.GetStringArray(globals.UseTemplateLabel)
+	for _, request := range templateRequests {
+		tname, fname := checkTemplateChangeRequest(request)
+		replaceTemplate(tname, fname)
+	}
+	err = checkForRootValue(sd.DbUser, globals.DbUserLabel, globals.DbUserValue)
+	if err != nil {
+		return sd, err
+	}
+	err = checkForRootValue(sd.RplUser, globals.RplUserLabel, globals.RplUserValue)
+	if err != nil {
+		return sd, err
+	}
 
 	sd.RplPassword, _ = flags.GetString(globals.RplPasswordLabel)
 	sd.RemoteAccess, _ = flags.GetString(globals.RemoteAccessLabel)
@@ -356,11 +369,32 @@ func fillSandboxDefinition(cmd *cobra.Command, args []string, usingImport bool)
 	sd.ExposeDdTables, _ = flags.GetBool(globals.ExposeDdTablesLabel)
 	sd.InitGeneralLog, _ = flags.GetBool(globals.InitGeneralLogLabel)
 	sd.EnableGeneralLog, _ = flags.GetBool(globals.EnableGeneralLogLabel)
+	tags, _ := flags.GetStringArray(globals.TagLabel)
+	sd.Tags, err = common.ParseTags(tags)
+	if err != nil {
+		return sd, err
+	}
+	sd.Owner, _ = flags.GetString(globals.OwnerLabel)
+	if sd.Owner == "" {
+		currentUser, err := user.Current()
+		if err == nil {
+			sd.Owner = currentUser.Username
+		}
+	}
+	sd.Note, _ = flags.GetString(globals.NoteLabel)
+	ttl, _ := flags.GetString(globals.TtlLabel)
+	if ttl != "" {
+		duration, err := common.ParseTtl(ttl)
+		if err != nil {
+			return sd, err
+		}
+		sd.Expires = time.Now().Add(duration).Format(time.RFC3339)
+	}
 	sd.ShellPath = defaults.Defaults().ShellPath
 	sd.MysqlshPath = defaults.Defaults().MysqlshPath
 
 	if sd.DisableMysqlX && sd.EnableMysqlX {
-		common.Exit(1, "flags --enable-mysqlx and --disable-mysqlx cannot be used together")
+		return sd, fmt.Errorf("flags --enable-mysqlx and --disable-mysqlx cannot be used together")
 	}
 	sd.RunConcurrently, _ = flags.GetBool(globals.ConcurrentLabel)
 	if common.IsEnvSet("RUN_CONCURRENTLY") {
@@ -377,40 +411,12 @@ func fillSandboxDefinition(cmd *cobra.Command, args []string, usingImport bool)
 	gtid, _ = flags.GetBool(globals.GtidLabel)
 	replCrashSafe, _ = flags.GetBool(globals.ReplCrashSafeLabel)
 	if master {
-		sd.ReplOptions = sandbox.SingleTemplates[globals.TmplReplicationOptions].Contents
-		if sd.ServerId == 0 {
-			sd.PortAsServerId = true
-		} else {
-			sd.PortAsServerId = false
-		}
+		sandbox.SetMasterOptions(&sd)
 	}
 	if gtid {
-		templateName := globals.TmplGtidOptions56
-		// 5.7.0
-		// isEnhancedGtid, err := common.GreaterOrEqualVersion(sd.Version, globals.MinimumEnhancedGtidVersion)
-		isEnhancedGtid, err := common.HasCapability(sd.Flavor, common.EnhancedGTID, sd.Version)
-		common.ErrCheckExitf(err, 1, globals.ErrWhileComparingVersions)
-		if isEnhancedGtid {
-			templateName = globals.TmplGtidOptions57
-		}
-		// 5.6.9
-		//isMinimumGtid, err := common.GreaterOrEqualVersion(sd.Version, globals.MinimumGtidVersion)
-		isMinimumGtid, err := common.HasCapability(sd.Flavor, common.GTID, sd.Version)
-		common.ErrCheckExitf(err, 1, globals.ErrWhileComparingVersions)
-		if isMinimumGtid {
-			sd.GtidOptions = sandbox.SingleTemplates[templateName].Contents
-			sd.ReplCrashSafeOptions = sandbox.SingleTemplates[globals.TmplReplCrashSafeOptions84].Contents
-			if strings.HasPrefix(sd.Version, "5") || strings.HasPrefix(sd.Version, "8.0") {
-				sd.ReplCrashSafeOptions = sandbox.SingleTemplates[globals.TmplReplCrashSafeOptions].Contents
-			}
-			sd.ReplOptions = sandbox.SingleTemplates[globals.TmplReplicationOptions].Contents
-			if sd.ServerId == 0 {
-				sd.PortAsServerId = true
-			} else {
-				sd.PortAsServerId = false
-			}
-		} else {
-			common.Exitf(1, globals.ErrOptionRequiresVersion, globals.GtidLabel, common.IntSliceToDottedString(globals.MinimumGtidVersion))
+		err = sandbox.SetGtidOptions(&sd)
+		if err != nil {
+			return sd, err
 		}
 	}
 	if replCrashSafe && sd.ReplCrashSafeOptions == "" {
@@ -418,14 +424,17 @@ func fillSandboxDefinition(cmd *cobra.Command, args []string, usingImport bool)
 
 		// isMinimumCrashSafe, err := common.GreaterOrEqualVersion(sd.Version, globals.MinimumCrashSafeVersion)
 		isMinimumCrashSafe, err := common.HasCapability(sd.Flavor, common.CrashSafe, sd.Version)
-		common.ErrCheckExitf(err, 1, globals.ErrWhileComparingVersions)
+		if err != nil {
+			return sd, errors.Wrapf(err, globals.ErrWhileComparingVersions)
+		}
 		if isMinimumCrashSafe {
 			sd.ReplCrashSafeOptions = sandbox.SingleTemplates[globals.TmplReplCrashSafeOptions84].Contents
 			if strings.HasPrefix(sd.Version, "5") || strings.HasPrefix(sd.Version, "8.0") {
 				sd.ReplCrashSafeOptions = sandbox.SingleTemplates[globals.TmplReplCrashSafeOptions].Contents
 			}
 		} else {
-			common.Exitf(1, globals.ErrOptionRequiresVersion, globals.ReplCrashSafeLabel, common.IntSliceToDottedString(globals.MinimumCrashSafeVersion))
+			return sd, sandbox.WithKind(sandbox.ErrUnsupported, fmt.Errorf(globals.ErrOptionRequiresVersion,
+				globals.ReplCrashSafeLabel, common.IntSliceToDottedString(globals.MinimumCrashSafeVersion)))
 		}
 	}
 	if flags.Changed(globals.DefaultRoleLabel) ||
@@ -436,9 +445,11 @@ func fillSandboxDefinition(cmd *cobra.Command, args []string, usingImport bool)
 		flags.Changed(globals.CustomRoleTargetLabel) ||
 		flags.Changed(globals.CustomRoleExtraLabel) {
 		isRoleEnabled, err := common.HasCapability(sd.Flavor, common.Roles, sd.Version)
-		common.ErrCheckExitf(err, 1, globals.ErrWhileComparingVersions)
+		if err != nil {
+			return sd, errors.Wrapf(err, globals.ErrWhileComparingVersions)
+		}
 		if !isRoleEnabled {
-			common.Exitf(1, "options about roles requires version 8.0+")
+			return sd, sandbox.WithKind(sandbox.ErrUnsupported, fmt.Errorf("options about roles requires version 8.0+"))
 		}
 	}
 	return sd, nil
@@ -450,11 +461,9 @@ func singleSandbox(cmd *cobra.Command, args []string) {
 	common.CheckOrigin(args)
 	sd, err = fillSandboxDefinition(cmd, args, false)
 	if err != nil {
-		common.Exitf(1, "error while filling the sandbox definition: %+v", err)
+		common.Exitf(1, "%s", err)
 	}
-	// When deploying a single sandbox, we disable concurrency
-	sd.RunConcurrently = false
-	err = sandbox.CreateStandaloneSandbox(sd)
+	err = api.DeploySingle(context.Background(), sd)
 	if err != nil {
 		common.Exitf(1, globals.ErrCreatingSandbox, err)
 	}
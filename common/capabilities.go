@@ -52,6 +52,7 @@ const (
 	NdbFlavor           = "ndb"
 	PxcFlavor           = "pxc"
 	TiDbFlavor          = "tidb"
+	PostgreSQLFlavor    = "postgresql"
 
 	// Feature names
 	InstallDb                   = "installdb"
@@ -85,6 +86,12 @@ const (
 	CloneServer                 = "clone-server"
 	CircularReplication         = "circular-replication"
 	InnoDBCluster               = "innodb-cluster"
+	PgStreamingReplication      = "pg-streaming-replication"
+	PgWalLevelReplica           = "pg-wal-level-replica"
+	PgScramAuth                 = "pg-scram-auth"
+	PgReplicationSlots          = "pg-replication-slots"
+	GaleraCluster               = "galera-cluster"
+	MariaDbGTID                 = "mariadb-gtid"
 )
 
 var MySQLCapabilities = Capabilities{
@@ -267,6 +274,15 @@ var FlavorCompositionList = []flavorIndicator{
 		},
 		flavor: TiDbFlavor,
 	},
+	{
+		AllNeeded: true,
+		elements: []elementPath{
+			{"bin", globals.FnPostgres},
+			{"bin", globals.FnInitdb},
+			{"bin", globals.FnPgCtl},
+		},
+		flavor: PostgreSQLFlavor,
+	},
 	{
 		AllNeeded: false,
 		elements: []elementPath{
@@ -299,6 +315,32 @@ var TiDBCapabilities = Capabilities{
 		// No capabilities so far
 	},
 }
+
+// PostgreSQL versions have two components since 10.
+// dbdeployer uses them as X.Y.0
+var PostgreSQLCapabilities = Capabilities{
+	Flavor:      PostgreSQLFlavor,
+	Description: "PostgreSQL server",
+	Features: FeatureList{
+		PgStreamingReplication: {
+			Description: "streaming replication with pg_basebackup",
+			Since:       globals.MinimumPgStreamingReplication,
+		},
+		PgWalLevelReplica: {
+			Description: "wal_level 'replica'",
+			Since:       globals.MinimumPgWalLevelReplica,
+		},
+		PgScramAuth: {
+			Description: "SCRAM-SHA-256 authentication",
+			Since:       globals.MinimumPgScramAuth,
+		},
+		PgReplicationSlots: {
+			Description: "replication slots created by pg_basebackup",
+			Since:       globals.MinimumPgReplicationSlots,
+		},
+	},
+}
+
 var NdbCapabilities = Capabilities{
 	Flavor:      NdbFlavor,
 	Description: "MySQL NDB Cluster",
@@ -368,6 +410,14 @@ var MariadbCapabilities = Capabilities{
 			Description: "Root Authentication during install",
 			Since:       globals.MinimumRootAuthVersion,
 		},
+		MariaDbGTID: {
+			Description: "MariaDB global transaction identifiers, with replication domains",
+			Since:       globals.MariaDbMinimumGtidVersion,
+		},
+		GaleraCluster: {
+			Description: "Galera cluster with built-in wsrep API",
+			Since:       globals.MariaDbMinimumGaleraVersion,
+		},
 		DynVariables: MySQLCapabilities.Features[DynVariables],
 		SemiSynch:    MySQLCapabilities.Features[SemiSynch],
 	},
@@ -392,6 +442,7 @@ var AllCapabilities = map[string]Capabilities{
 	NdbFlavor:           NdbCapabilities,
 	PxcFlavor:           PxcCapabilities,
 	MySQLShellFlavor:    MySQLShellCapabilities,
+	PostgreSQLFlavor:    PostgreSQLCapabilities,
 }
 
 // Returns a set of existing capabilities with custom ones
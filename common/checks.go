@@ -209,7 +209,8 @@ func GetVersionsFromDir(basedir string) ([]string, error) {
 			mysqld := path.Join(basedir, fname, "bin", "mysqld")
 			mysqldDebug := path.Join(basedir, fname, "bin", "mysqld-debug")
 			tidb := path.Join(basedir, fname, "bin", "tidb-server")
-			if FileExists(mysqld) || FileExists(mysqldDebug) || FileExists(tidb) {
+			postgres := path.Join(basedir, fname, "bin", globals.FnPostgres)
+			if FileExists(mysqld) || FileExists(mysqldDebug) || FileExists(tidb) || FileExists(postgres) {
 				dirs = append(dirs, fname)
 			}
 		}
@@ -447,6 +448,7 @@ func CheckTarballOperatingSystem(basedir string) error {
 		globals.FnLibPerconaServerClientDylib: {"lib", "darwin", PerconaServerFlavor, true},
 		globals.FnLibMySQLClientDylib:         {"lib", "darwin", MySQLFlavor, true},
 		globals.FnTiDbServer:                  {"bin", "any", TiDbFlavor, true},
+		globals.FnPgCtl:                       {"bin", "any", PostgreSQLFlavor, true},
 		globals.FnTableH:                      {"sql", "source", "any", false},
 		globals.FnMysqlProvisionZip:           {"share/mysqlsh", "shell", "any", false},
 	}
@@ -886,6 +888,12 @@ func FindTarballInfo(fileName string) (flavor, version, shortVersion string, err
 // Tries to detect the database flavor from tarball name
 func DetectTarballFlavor(tarballName string) string {
 	flavor := ""
+	// Custom flavors come first, as their tarballs may match a built-in pattern
+	for _, pattern := range customFlavorPatterns {
+		if pattern.re.MatchString(tarballName) {
+			return pattern.flavor
+		}
+	}
 	flavorsRegexps := map[string]string{
 		PerconaServerFlavor: `Percona-Server`,
 		MariaDbFlavor:       `mariadb`,
@@ -893,6 +901,7 @@ func DetectTarballFlavor(tarballName string) string {
 		TiDbFlavor:          `tidb`,
 		PxcFlavor:           `Percona-XtraDB-Cluster`,
 		MySQLShellFlavor:    `mysql-shell`,
+		PostgreSQLFlavor:    `postgresql`,
 		MySQLFlavor:         `mysql`,
 	}
 
@@ -905,6 +914,7 @@ func DetectTarballFlavor(tarballName string) string {
 		TiDbFlavor,
 		PxcFlavor,
 		MySQLShellFlavor,
+		PostgreSQLFlavor,
 		MySQLFlavor,
 	}
 
@@ -17,6 +17,7 @@ package common
 
 import (
 	"bufio"
+	"context"
 	"crypto/md5"  // #nosec G501 need to compute legacy checksums
 	"crypto/sha1" // #nosec G505 need to compute legacy checksums
 	"crypto/sha256"
@@ -33,6 +34,7 @@ import (
 	"regexp"
 	"runtime"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/pkg/errors"
@@ -48,19 +50,24 @@ type SandboxUser struct {
 }
 
 type SandboxDescription struct {
-	Basedir           string `json:"basedir"`
-	ClientBasedir     string `json:"client_basedir,omitempty"`
-	SBType            string `json:"type"` // single multi master-slave group
-	Version           string `json:"version"`
-	Flavor            string `json:"flavor,omitempty"`
-	Host              string `json:"host,omitempty"`
-	Port              []int  `json:"port"`
-	Nodes             int    `json:"nodes"`
-	NodeNum           int    `json:"node_num"`
-	DbDeployerVersion string `json:"dbdeployer-version"`
-	Timestamp         string `json:"timestamp"`
-	CommandLine       string `json:"command-line"`
-	LogFile           string `json:"log-file,omitempty"`
+	Basedir           string            `json:"basedir"`
+	ClientBasedir     string            `json:"client_basedir,omitempty"`
+	SBType            string            `json:"type"` // single multi master-slave group
+	Version           string            `json:"version"`
+	Flavor            string            `json:"flavor,omitempty"`
+	Host              string            `json:"host,omitempty"`
+	Port              []int             `json:"port"`
+	Nodes             int               `json:"nodes"`
+	NodeNum           int               `json:"node_num"`
+	Master            string            `json:"master,omitempty"` // current master directory, when it was changed by a switchover
+	DbDeployerVersion string            `json:"dbdeployer-version"`
+	Timestamp         string            `json:"timestamp"`
+	CommandLine       string            `json:"command-line"`
+	LogFile           string            `json:"log-file,omitempty"`
+	Owner             string            `json:"owner,omitempty"`
+	Tags              map[string]string `json:"tags,omitempty"`
+	Note              string            `json:"note,omitempty"`
+	Expires           string            `json:"expires,omitempty"` // RFC3339 timestamp after which the sandbox can be reaped
 }
 
 type KeyValue struct {
@@ -257,24 +264,37 @@ func WriteStrings(lines []string, filename string, termination string) error {
 	return w.Flush()
 }
 
-// Get a file checksum, choosing among MD5, SH1, SHA256, and SHA512
-func GetFileChecksum(fileName, crcType string) (string, error) {
-	var hasher hash.Hash
+// NewChecksumHasher returns the hash for a checksum type, choosing among MD5, SH1, SHA256, and SHA512
+func NewChecksumHasher(crcType string) (hash.Hash, error) {
 	switch strings.ToLower(crcType) {
 	case "md5":
-		hasher = md5.New() // #nosec G401 need to compute legacy checksums
+		return md5.New(), nil // #nosec G401 need to compute legacy checksums
 	case "sha1":
-		hasher = sha1.New() // #nosec G401 need to compute legacy checksums
+		return sha1.New(), nil // #nosec G401 need to compute legacy checksums
 	case "sha256":
-		hasher = sha256.New()
+		return sha256.New(), nil
 	case "sha512":
-		hasher = sha512.New()
-	default:
-		return globals.EmptyString, fmt.Errorf("unsupported checksum type %s", crcType)
+		return sha512.New(), nil
+	}
+	return nil, fmt.Errorf("unsupported checksum type %s", crcType)
+}
+
+var reChecksum = regexp.MustCompile(`(MD5|SHA1|SHA256|SHA512)\s*:\s*(\S+)`)
+
+// ParseChecksum splits a checksum in the format "TYPE:CHECKSUM_STRING", such as "SHA256:abc123"
+func ParseChecksum(checksum string) (crcType, crcText string, err error) {
+	crcList := reChecksum.FindAllStringSubmatch(checksum, -1)
+	if len(crcList) < 1 || len(crcList[0]) < 3 {
+		return "", "", fmt.Errorf("not a valid CRC pattern found. Expected: (MD5|SHA1|SHA256|SHA512):CHECKSUM_STRING")
 	}
+	return crcList[0][1], crcList[0][2], nil
+}
 
-	if hasher == nil {
-		return globals.EmptyString, fmt.Errorf("unhandled checksum error")
+// Get a file checksum, choosing among MD5, SH1, SHA256, and SHA512
+func GetFileChecksum(fileName, crcType string) (string, error) {
+	hasher, err := NewChecksumHasher(crcType)
+	if err != nil {
+		return globals.EmptyString, err
 	}
 	f, err := os.Open(fileName) // #nosec G304
 	if err != nil {
@@ -524,7 +544,29 @@ func runCmdCtrlArgsSimple(c string, silent bool, args ...string) (string, error)
 }
 
 func runCmdCtrlArgs(c string, silent bool, args ...string) (string, string, error) {
-	cmd := exec.Command(c, args...) // #nosec G204
+	return runCmdCtrlArgsContext(context.Background(), c, silent, args...)
+}
+
+// RunCmdContext runs a command with arguments, with optional quiet output.
+// When the context is cancelled, the command and the processes it started are killed
+func RunCmdContext(ctx context.Context, c string, args []string, silent bool) (string, error) {
+	out, _, err := runCmdCtrlArgsContext(ctx, c, silent, args...)
+	if err != nil && ctx.Err() != nil {
+		return out, ctx.Err()
+	}
+	return out, err
+}
+
+func runCmdCtrlArgsContext(ctx context.Context, c string, silent bool, args ...string) (string, string, error) {
+	cmd := exec.CommandContext(ctx, c, args...) // #nosec G204
+	if ctx.Done() != nil {
+		// Scripts start servers in the background: a cancellation must reach them too
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+		cmd.Cancel = func() error {
+			return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		}
+		cmd.WaitDelay = time.Second
+	}
 	stderr, err := cmd.StderrPipe()
 	if err != nil {
 		return "", "", err
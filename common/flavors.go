@@ -0,0 +1,252 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2022 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"slices"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+// FlavorMarker is a file whose presence in the binaries directory identifies a flavor
+type FlavorMarker struct {
+	Dir  string `json:"dir" yaml:"dir"`
+	File string `json:"file" yaml:"file"`
+}
+
+// FlavorFeature is a capability of a custom flavor, with versions in dotted format
+type FlavorFeature struct {
+	Description string `json:"description" yaml:"description"`
+	Since       string `json:"since" yaml:"since"`
+	Until       string `json:"until,omitempty" yaml:"until,omitempty"`
+}
+
+// FlavorDescriptor defines a flavor outside of dbdeployer code.
+// Features are added to (or replace) the ones of the flavor named in BasedOn.
+// Templates maps template names to files, relative to the descriptor directory
+type FlavorDescriptor struct {
+	Flavor           string                   `json:"flavor" yaml:"flavor"`
+	Description      string                   `json:"description" yaml:"description"`
+	BasedOn          string                   `json:"based-on" yaml:"based-on"`
+	Markers          []FlavorMarker           `json:"markers" yaml:"markers"`
+	AllMarkersNeeded bool                     `json:"all-markers-needed" yaml:"all-markers-needed"`
+	TarballPattern   string                   `json:"tarball-pattern" yaml:"tarball-pattern"`
+	Features         map[string]FlavorFeature `json:"features" yaml:"features"`
+	Templates        map[string]string        `json:"templates" yaml:"templates"`
+	FileName         string                   `json:"-" yaml:"-"`
+}
+
+// CustomFlavors are the flavors loaded from descriptor files
+var CustomFlavors = make(map[string]FlavorDescriptor)
+
+// builtinFlavors are the flavors defined in dbdeployer code, which descriptors can't replace
+var builtinFlavors = []string{
+	MySQLFlavor,
+	MySQLShellFlavor,
+	PerconaServerFlavor,
+	MariaDbFlavor,
+	NdbFlavor,
+	PxcFlavor,
+	TiDbFlavor,
+	PostgreSQLFlavor,
+}
+
+// customFlavorPatterns holds the tarball patterns of custom flavors, in loading order
+var customFlavorPatterns []flavorPattern
+
+type flavorPattern struct {
+	flavor string
+	re     *regexp.Regexp
+}
+
+var reFlavorName = regexp.MustCompile(`^[a-z][a-z0-9_-]*$`)
+
+// BaseFlavor returns the built-in flavor from which a custom flavor derives,
+// or the flavor itself when it is not a custom one
+func BaseFlavor(flavor string) string {
+	descriptor, ok := CustomFlavors[flavor]
+	if ok {
+		return descriptor.BasedOn
+	}
+	return flavor
+}
+
+// versionFromText converts a feature version, which can be empty only when allowed
+func versionFromText(version string, allowEmpty bool) ([]int, error) {
+	if version == "" && allowEmpty {
+		return nil, nil
+	}
+	return VersionToList(version)
+}
+
+// ReadFlavorDescriptor reads a flavor descriptor from a JSON or YAML file
+func ReadFlavorDescriptor(fileName string) (FlavorDescriptor, error) {
+	var descriptor FlavorDescriptor
+	contents, err := os.ReadFile(fileName) // #nosec G304
+	if err != nil {
+		return descriptor, err
+	}
+	switch path.Ext(fileName) {
+	case ".json":
+		err = json.Unmarshal(contents, &descriptor)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(contents, &descriptor)
+	default:
+		return descriptor, fmt.Errorf("file %s is neither JSON nor YAML", fileName)
+	}
+	if err != nil {
+		return descriptor, fmt.Errorf("error decoding flavor descriptor %s: %s", fileName, err)
+	}
+	descriptor.FileName = fileName
+	for name, templateFile := range descriptor.Templates {
+		if !path.IsAbs(templateFile) {
+			descriptor.Templates[name] = path.Join(path.Dir(fileName), templateFile)
+		}
+	}
+	return descriptor, nil
+}
+
+// flavorCapabilities checks a descriptor and returns the capabilities of its flavor
+func flavorCapabilities(descriptor FlavorDescriptor) (Capabilities, error) {
+	var capabilities Capabilities
+	if !reFlavorName.MatchString(descriptor.Flavor) {
+		return capabilities, fmt.Errorf("invalid flavor name '%s'", descriptor.Flavor)
+	}
+	if slices.Contains(builtinFlavors, descriptor.Flavor) {
+		return capabilities, fmt.Errorf("flavor '%s' is built-in and can't be redefined", descriptor.Flavor)
+	}
+	if _, exists := CustomFlavors[descriptor.Flavor]; exists {
+		return capabilities, fmt.Errorf("flavor '%s' already defined in %s", descriptor.Flavor, CustomFlavors[descriptor.Flavor].FileName)
+	}
+	if !slices.Contains(builtinFlavors, descriptor.BasedOn) {
+		return capabilities, fmt.Errorf("flavor '%s' must be based on one of %v - found '%s'",
+			descriptor.Flavor, builtinFlavors, descriptor.BasedOn)
+	}
+	if len(descriptor.Markers) == 0 {
+		return capabilities, fmt.Errorf("flavor '%s' has no markers", descriptor.Flavor)
+	}
+	for _, marker := range descriptor.Markers {
+		if marker.File == "" {
+			return capabilities, fmt.Errorf("flavor '%s' has a marker without file name", descriptor.Flavor)
+		}
+	}
+	if descriptor.TarballPattern != "" {
+		_, err := regexp.Compile(descriptor.TarballPattern)
+		if err != nil {
+			return capabilities, fmt.Errorf("invalid tarball pattern for flavor '%s': %s", descriptor.Flavor, err)
+		}
+	}
+	features := make(FeatureList)
+	for name, feature := range descriptor.Features {
+		since, err := versionFromText(feature.Since, false)
+		if err != nil {
+			return capabilities, fmt.Errorf("invalid 'since' version for feature %s of flavor '%s': %s", name, descriptor.Flavor, err)
+		}
+		until, err := versionFromText(feature.Until, true)
+		if err != nil {
+			return capabilities, fmt.Errorf("invalid 'until' version for feature %s of flavor '%s': %s", name, descriptor.Flavor, err)
+		}
+		features[name] = Capability{
+			Description: feature.Description,
+			Since:       since,
+			Until:       until,
+		}
+	}
+	for name, templateFile := range descriptor.Templates {
+		if !FileExists(templateFile) {
+			return capabilities, fmt.Errorf("template file %s for %s (flavor '%s') not found", templateFile, name, descriptor.Flavor)
+		}
+	}
+	return Capabilities{
+		Flavor:      descriptor.Flavor,
+		Description: descriptor.Description,
+		Features:    addCapabilities(AllCapabilities[descriptor.BasedOn].Features, features),
+	}, nil
+}
+
+// RegisterFlavor adds a custom flavor to capabilities and flavor detection
+func RegisterFlavor(descriptor FlavorDescriptor) error {
+	capabilities, err := flavorCapabilities(descriptor)
+	if err != nil {
+		return err
+	}
+	indicator := flavorIndicator{
+		AllNeeded: descriptor.AllMarkersNeeded,
+		flavor:    descriptor.Flavor,
+	}
+	for _, marker := range descriptor.Markers {
+		indicator.elements = append(indicator.elements, elementPath{marker.Dir, marker.File})
+	}
+	// Custom flavors are usually forks of the built-in ones, and contain their files.
+	// Thus, they must be detected before them
+	FlavorCompositionList = append([]flavorIndicator{indicator}, FlavorCompositionList...)
+	if descriptor.TarballPattern != "" {
+		customFlavorPatterns = append(customFlavorPatterns,
+			flavorPattern{descriptor.Flavor, regexp.MustCompile(descriptor.TarballPattern)})
+	}
+	AllCapabilities[descriptor.Flavor] = capabilities
+	CustomFlavors[descriptor.Flavor] = descriptor
+	return nil
+}
+
+// LoadFlavorDescriptors registers the flavors defined in the JSON and YAML files of a directory.
+// A missing directory is not an error. A file that can't be read or registered is skipped,
+// and its error is returned together with the ones of the other files, so that a single
+// broken descriptor does not prevent the use of the others. Files that were already
+// registered are skipped, so that the directory can be loaded more than once
+func LoadFlavorDescriptors(directory string) []error {
+	if !DirExists(directory) {
+		return nil
+	}
+	entries, err := os.ReadDir(directory)
+	if err != nil {
+		return []error{err}
+	}
+	loaded := make(map[string]bool)
+	for _, descriptor := range CustomFlavors {
+		loaded[descriptor.FileName] = true
+	}
+	var fileNames []string
+	for _, entry := range entries {
+		switch path.Ext(entry.Name()) {
+		case ".json", ".yaml", ".yml":
+			fileName := path.Join(directory, entry.Name())
+			if !entry.IsDir() && !loaded[fileName] {
+				fileNames = append(fileNames, fileName)
+			}
+		}
+	}
+	sort.Strings(fileNames)
+	var errs []error
+	for _, fileName := range fileNames {
+		descriptor, err := ReadFlavorDescriptor(fileName)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		err = RegisterFlavor(descriptor)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("error in flavor descriptor %s: %s", fileName, err))
+		}
+	}
+	return errs
+}
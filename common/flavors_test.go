@@ -0,0 +1,150 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2022 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/datacharmer/dbdeployer/compare"
+)
+
+// restoreFlavors undoes the registration of custom flavors at the end of a test
+func restoreFlavors(t *testing.T) {
+	compositionList := FlavorCompositionList
+	allCapabilities := make(map[string]Capabilities)
+	for name, capabilities := range AllCapabilities {
+		allCapabilities[name] = capabilities
+	}
+	t.Cleanup(func() {
+		FlavorCompositionList = compositionList
+		AllCapabilities = allCapabilities
+		CustomFlavors = make(map[string]FlavorDescriptor)
+		customFlavorPatterns = nil
+	})
+}
+
+func TestLoadFlavorDescriptors(t *testing.T) {
+	restoreFlavors(t)
+	flavorsDir := t.TempDir()
+	descriptor := `{
+  "flavor": "acme",
+  "description": "ACME patched MySQL",
+  "based-on": "mysql",
+  "markers": [ {"dir": "bin", "file": "acme-admin"}, {"dir": "bin", "file": "mysqld"} ],
+  "all-markers-needed": true,
+  "tarball-pattern": "acme-mysql",
+  "features": {
+    "acme-audit": {"description": "ACME audit log", "since": "8.0.30", "until": "8.0.40"},
+    "GTID": {"description": "Global transaction identifiers", "since": "8.0.11"}
+  },
+  "templates": {"my_cnf": "acme_my_cnf.txt"}
+}`
+	err := WriteString(descriptor, path.Join(flavorsDir, "acme.json"))
+	compare.OkIsNil("writing descriptor", err, t)
+	err = WriteString("[mysql]\n", path.Join(flavorsDir, "acme_my_cnf.txt"))
+	compare.OkIsNil("writing template", err, t)
+
+	// A broken descriptor is skipped, without preventing the load of the others
+	err = WriteString("{ not json", path.Join(flavorsDir, "broken.json"))
+	compare.OkIsNil("writing broken descriptor", err, t)
+
+	errs := LoadFlavorDescriptors(flavorsDir)
+	compare.OkEqualInt("descriptor errors", len(errs), 1, t)
+	if len(errs) > 0 {
+		compare.OkMatchesString("broken descriptor error", errs[0].Error(), "broken.json", t)
+	}
+	// Loading again skips the descriptors already registered
+	errs = LoadFlavorDescriptors(flavorsDir)
+	compare.OkEqualInt("descriptor errors on reload", len(errs), 1, t)
+	compare.OkEqualString("base flavor", BaseFlavor("acme"), MySQLFlavor, t)
+	compare.OkEqualString("base of built-in flavor", BaseFlavor(MariaDbFlavor), MariaDbFlavor, t)
+	compare.OkEqualString("template path", CustomFlavors["acme"].Templates["my_cnf"], path.Join(flavorsDir, "acme_my_cnf.txt"), t)
+	compare.OkIsNil("flavor support", CheckFlavorSupport("acme"), t)
+
+	var capabilitiesList = []TestCapabilities{
+		{[]string{"acme"}, "acme-audit", "8.0.29", false},
+		{[]string{"acme"}, "acme-audit", "8.0.30", true},
+		{[]string{"acme"}, "acme-audit", "8.0.40", true},
+		{[]string{"acme"}, "acme-audit", "8.0.41", false},
+		{[]string{"acme"}, GTID, "5.7.30", false},
+		{[]string{"acme"}, GTID, "8.0.11", true},
+		// inherited from the base flavor
+		{[]string{"acme"}, Roles, "8.0.0", true},
+		{[]string{"acme"}, Roles, "5.7.30", false},
+		{[]string{MySQLFlavor}, "acme-audit", "8.0.30", false},
+		{[]string{MySQLFlavor}, GTID, "5.7.30", true},
+	}
+	for _, st := range capabilitiesList {
+		for _, flavor := range st.flavors {
+			result, err := HasCapability(flavor, st.feature, st.version)
+			compare.OkIsNil("capability", err, t)
+			compare.OkEqualBool(flavor+" "+st.feature+" "+st.version, result, st.expected, t)
+		}
+	}
+
+	compare.OkEqualString("tarball flavor",
+		DetectTarballFlavor("acme-mysql-8.0.35-linux-glibc2.17-x86_64.tar.gz"), "acme", t)
+	compare.OkEqualString("tarball flavor",
+		DetectTarballFlavor("mysql-8.0.35-linux-glibc2.17-x86_64.tar.gz"), MySQLFlavor, t)
+
+	basedir := t.TempDir()
+	err = os.Mkdir(path.Join(basedir, "bin"), 0755)
+	compare.OkIsNil("creating bin dir", err, t)
+	err = WriteString("", path.Join(basedir, "bin", "mysqld"))
+	compare.OkIsNil("writing mysqld", err, t)
+	compare.OkEqualString("binary flavor without marker", DetectBinaryFlavor(basedir), MySQLFlavor, t)
+	err = WriteString("", path.Join(basedir, "bin", "acme-admin"))
+	compare.OkIsNil("writing marker", err, t)
+	compare.OkEqualString("binary flavor with marker", DetectBinaryFlavor(basedir), "acme", t)
+}
+
+func TestRegisterFlavorErrors(t *testing.T) {
+	restoreFlavors(t)
+	markers := []FlavorMarker{{Dir: "bin", File: "acme-admin"}}
+	var descriptors = []struct {
+		label      string
+		descriptor FlavorDescriptor
+		errorText  string
+	}{
+		{"invalid name", FlavorDescriptor{Flavor: "Acme!", BasedOn: MySQLFlavor, Markers: markers}, "invalid flavor name"},
+		{"built-in name", FlavorDescriptor{Flavor: MySQLFlavor, BasedOn: MySQLFlavor, Markers: markers}, "is built-in"},
+		{"no base", FlavorDescriptor{Flavor: "acme", Markers: markers}, "must be based on"},
+		{"unknown base", FlavorDescriptor{Flavor: "acme", BasedOn: "oracle", Markers: markers}, "must be based on"},
+		{"no markers", FlavorDescriptor{Flavor: "acme", BasedOn: MySQLFlavor}, "has no markers"},
+		{"invalid pattern", FlavorDescriptor{Flavor: "acme", BasedOn: MySQLFlavor, Markers: markers, TarballPattern: "acme("}, "invalid tarball pattern"},
+		{"invalid since", FlavorDescriptor{Flavor: "acme", BasedOn: MySQLFlavor, Markers: markers,
+			Features: map[string]FlavorFeature{"audit": {Since: "8.0"}}}, "invalid 'since' version"},
+		{"invalid until", FlavorDescriptor{Flavor: "acme", BasedOn: MySQLFlavor, Markers: markers,
+			Features: map[string]FlavorFeature{"audit": {Since: "8.0.1", Until: "eight"}}}, "invalid 'until' version"},
+		{"missing template", FlavorDescriptor{Flavor: "acme", BasedOn: MySQLFlavor, Markers: markers,
+			Templates: map[string]string{"my_cnf": "/no/such/file"}}, "not found"},
+	}
+	for _, d := range descriptors {
+		err := RegisterFlavor(d.descriptor)
+		compare.OkIsNotNil(d.label, err, t)
+		if err != nil {
+			compare.OkMatchesString(d.label, err.Error(), d.errorText, t)
+		}
+	}
+	compare.OkEqualInt("custom flavors", len(CustomFlavors), 0, t)
+
+	err := RegisterFlavor(FlavorDescriptor{Flavor: "acme", BasedOn: MySQLFlavor, Markers: markers})
+	compare.OkIsNil("valid descriptor", err, t)
+	err = RegisterFlavor(FlavorDescriptor{Flavor: "acme", BasedOn: MySQLFlavor, Markers: markers})
+	compare.OkIsNotNil("duplicate flavor", err, t)
+}
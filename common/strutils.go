@@ -22,6 +22,7 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/datacharmer/dbdeployer/globals"
 )
@@ -401,3 +402,73 @@ func OptionCompare(option, value string) bool {
 	}
 	return matches
 }
+
+// ParseTtl converts a time to live into a duration.
+// Besides the units accepted by time.ParseDuration, it accepts days, as in "2d"
+func ParseTtl(ttl string) (time.Duration, error) {
+	var duration time.Duration
+	var err error
+	if strings.HasSuffix(ttl, "d") {
+		var days float64
+		days, err = strconv.ParseFloat(strings.TrimSuffix(ttl, "d"), 64)
+		duration = time.Duration(days * float64(24*time.Hour))
+	} else {
+		duration, err = time.ParseDuration(ttl)
+	}
+	if err != nil || duration <= 0 {
+		return 0, fmt.Errorf("invalid time to live '%s': expected a positive duration, such as 30m, 4h, or 2d", ttl)
+	}
+	return duration, nil
+}
+
+var tagKeyRe = regexp.MustCompile(`^[\w.-]+$`)
+
+// ParseTags converts a list of "key=value" labels into a map.
+// A label without "=" is a tag with an empty value. Empty labels are ignored
+func ParseTags(labels []string) (map[string]string, error) {
+	tags := make(map[string]string)
+	for _, label := range labels {
+		if label == "" {
+			continue
+		}
+		key, value, _ := strings.Cut(label, "=")
+		key = strings.TrimSpace(key)
+		if !tagKeyRe.MatchString(key) {
+			return nil, fmt.Errorf("invalid tag '%s': the key can only contain letters, digits, '_', '.', and '-'", label)
+		}
+		tags[key] = strings.TrimSpace(value)
+	}
+	if len(tags) == 0 {
+		return nil, nil
+	}
+	return tags, nil
+}
+
+// TagsMatch tells whether the tags satisfy all the filters.
+// A filter is either "key", matching when the tag exists, or "key=value".
+// Like other options, a filter can be negated with '!', 'no-', or 'not-'
+func TagsMatch(tags map[string]string, filters []string) bool {
+	for _, filter := range filters {
+		if filter == "" {
+			continue
+		}
+		option, negation := OptionComponents(filter)
+		key, value, hasValue := strings.Cut(option, "=")
+		tagValue, found := tags[key]
+		matches := found && (!hasValue || tagValue == value)
+		if matches == negation {
+			return false
+		}
+	}
+	return true
+}
+
+// TagsToString returns the tags as a sorted list of "key=value"
+func TagsToString(tags map[string]string) string {
+	var labels []string
+	for key, value := range tags {
+		labels = append(labels, key+"="+value)
+	}
+	sort.Strings(labels)
+	return strings.Join(labels, ",")
+}
@@ -22,6 +22,7 @@ import (
 	"os"
 	"strings"
 	"testing"
+	"time"
 )
 
 type pathInfo struct {
@@ -433,3 +434,70 @@ func TestOptionComponents(t *testing.T) {
 		}
 	}
 }
+
+func TestParseTags(t *testing.T) {
+	tags, err := ParseTags([]string{"", "team=billing", "ttl = 2h", "ci"})
+	compare.OkIsNil("parse tags", err, t)
+	compare.OkEqualInt("tags count", len(tags), 3, t)
+	compare.OkEqualString("team", tags["team"], "billing", t)
+	compare.OkEqualString("ttl", tags["ttl"], "2h", t)
+	compare.OkEqualString("ci", tags["ci"], "", t)
+	compare.OkEqualString("tags string", TagsToString(tags), "ci=,team=billing,ttl=2h", t)
+
+	tags, err = ParseTags([]string{""})
+	compare.OkIsNil("no tags", err, t)
+	compare.OkEqualInt("no tags count", len(tags), 0, t)
+
+	_, err = ParseTags([]string{"my team=billing"})
+	compare.OkIsNotNil("invalid key", err, t)
+	_, err = ParseTags([]string{"=billing"})
+	compare.OkIsNotNil("empty key", err, t)
+}
+
+func TestTagsMatch(t *testing.T) {
+	tags := map[string]string{"team": "billing", "ci": ""}
+	var testCases = []struct {
+		filters  []string
+		expected bool
+	}{
+		{nil, true},
+		{[]string{""}, true},
+		{[]string{"team"}, true},
+		{[]string{"team=billing"}, true},
+		{[]string{"team=search"}, false},
+		{[]string{"owner"}, false},
+		{[]string{"!team=search"}, true},
+		{[]string{"no-team"}, false},
+		{[]string{"team=billing", "ci"}, true},
+		{[]string{"team=billing", "!ci"}, false},
+	}
+	for _, tc := range testCases {
+		compare.OkEqualBool(fmt.Sprintf("%v", tc.filters), TagsMatch(tags, tc.filters), tc.expected, t)
+	}
+	compare.OkEqualBool("no tags", TagsMatch(nil, []string{"team"}), false, t)
+	compare.OkEqualBool("no tags, negated", TagsMatch(nil, []string{"!team"}), true, t)
+}
+
+func TestParseTtl(t *testing.T) {
+	var testCases = []struct {
+		input    string
+		expected time.Duration
+		valid    bool
+	}{
+		{"30m", 30 * time.Minute, true},
+		{"4h", 4 * time.Hour, true},
+		{"1h30m", 90 * time.Minute, true},
+		{"2d", 48 * time.Hour, true},
+		{"0.5d", 12 * time.Hour, true},
+		{"0h", 0, false},
+		{"-1h", 0, false},
+		{"4", 0, false},
+		{"d", 0, false},
+		{"four hours", 0, false},
+	}
+	for _, tc := range testCases {
+		duration, err := ParseTtl(tc.input)
+		compare.OkEqualBool(tc.input+" valid", err == nil, tc.valid, t)
+		compare.OkEqualInt(tc.input+" duration", int(duration/time.Minute), int(tc.expected/time.Minute), t)
+	}
+}
@@ -16,9 +16,13 @@
 package concurrent
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"os/exec"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/datacharmer/dbdeployer/common"
@@ -37,6 +41,7 @@ type Trace func(ti TraceInfo)
 type ExecCommand struct {
 	Cmd    string
 	Args   []string
+	Dir    string // working directory. When empty, the current one
 	Tracer Trace
 }
 
@@ -168,6 +173,85 @@ func RunParallelTasksByPriority(execLists []ExecutionList) {
 	}
 }
 
+// TaskResult is the outcome of a command run by RunLimitedTasks
+type TaskResult struct {
+	Command  ExecCommand
+	ExitCode int
+	Stdout   string
+	Stderr   string
+	Duration time.Duration
+	Err      error // the command could not start, or it was killed when its time ran out
+}
+
+func runTask(ec ExecCommand, timeout time.Duration) TaskResult {
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	var stdout, stderr bytes.Buffer
+	// #nosec G204
+	cmd := exec.CommandContext(ctx, ec.Cmd, ec.Args...)
+	cmd.Dir = ec.Dir
+	// The command runs in its own process group, so that a timeout
+	// also stops the processes it started
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	cmd.WaitDelay = time.Second
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	start := time.Now()
+	err := cmd.Run()
+	result := TaskResult{
+		Command:  ec,
+		Stdout:   stdout.String(),
+		Stderr:   stderr.String(),
+		Duration: time.Since(start),
+	}
+	var exitError *exec.ExitError
+	switch {
+	case ctx.Err() == context.DeadlineExceeded:
+		result.ExitCode = -1
+		result.Err = fmt.Errorf("timed out after %s", timeout)
+	case errors.As(err, &exitError):
+		result.ExitCode = exitError.ExitCode()
+	case err != nil:
+		result.ExitCode = -1
+		result.Err = err
+	}
+	return result
+}
+
+// RunLimitedTasks runs the commands, with at most maxParallel of them at the same time,
+// and collects their output. A command running longer than timeout is killed.
+// With a zero timeout, the commands can run indefinitely.
+// The results are in the same order as the commands
+func RunLimitedTasks(operations ExecCommands, maxParallel int, timeout time.Duration) []TaskResult {
+	if maxParallel < 1 {
+		maxParallel = 1
+	}
+	results := make([]TaskResult, len(operations))
+	slots := make(chan struct{}, maxParallel)
+	var wg sync.WaitGroup
+	for N, ec := range operations {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(N int, ec ExecCommand) {
+			defer wg.Done()
+			if ec.Tracer != nil {
+				ec.Tracer(TraceInfo{Time: time.Now(), Cmd: ec.Cmd, Args: ec.Args})
+			}
+			results[N] = runTask(ec, timeout)
+			<-slots
+		}(N, ec)
+	}
+	wg.Wait()
+	return results
+}
+
 func init() {
 	if common.IsEnvSet("DEBUG_CONCURRENCY") {
 		DebugConcurrency = true
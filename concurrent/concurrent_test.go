@@ -19,6 +19,7 @@ import (
 	"fmt"
 	"sort"
 	"testing"
+	"time"
 )
 
 type Times []int64
@@ -118,3 +119,50 @@ func TestConcurrency(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestRunLimitedTasks(t *testing.T) {
+	workDir := t.TempDir()
+	operations := ExecCommands{
+		{Cmd: "sh", Args: []string{"-c", "echo one"}},
+		{Cmd: "sh", Args: []string{"-c", "echo two >&2; exit 3"}},
+		{Cmd: "sh", Args: []string{"-c", "sleep 5"}},
+		{Cmd: "pwd", Dir: workDir},
+		{Cmd: "/no/such/command"},
+	}
+	start := time.Now()
+	results := RunLimitedTasks(operations, 2, time.Second)
+	elapsed := time.Since(start)
+	if elapsed > 4*time.Second {
+		t.Logf("not ok - the timeout was not applied: %s", elapsed)
+		t.Fail()
+	}
+	if len(results) != len(operations) {
+		t.Fatalf("expected %d results - found %d", len(operations), len(results))
+	}
+	var expected = []struct {
+		exitCode int
+		stdout   string
+		stderr   string
+		hasError bool
+	}{
+		{0, "one\n", "", false},
+		{3, "", "two\n", false},
+		{-1, "", "", true},
+		{0, workDir + "\n", "", false},
+		{-1, "", "", true},
+	}
+	for N, want := range expected {
+		result := results[N]
+		if result.Command.Cmd != operations[N].Cmd {
+			t.Logf("not ok - result %d is for command %s", N, result.Command.Cmd)
+			t.Fail()
+		}
+		if result.ExitCode != want.exitCode || result.Stdout != want.stdout ||
+			result.Stderr != want.stderr || (result.Err != nil) != want.hasError {
+			t.Logf("not ok - result %d: %+v", N, result)
+			t.Fail()
+		} else {
+			t.Logf("ok - result %d: exit code %d", N, result.ExitCode)
+		}
+	}
+}
@@ -29,18 +29,23 @@ import (
 )
 
 type SandboxItem struct {
-	Origin            string   `json:"origin"`
-	SBType            string   `json:"type"` // single multi master-slave group all-masters fan-in ndb pxc
-	Version           string   `json:"version"`
-	Flavor            string   `json:"flavor,omitempty"`
-	Host              string   `json:"host,omitempty"`
-	Port              []int    `json:"port"`
-	Nodes             []string `json:"nodes"`
-	Destination       string   `json:"destination"`
-	DbDeployerVersion string   `json:"dbdeployer-version"`
-	Timestamp         string   `json:"timestamp"`
-	LogDirectory      string   `json:"log-directory,omitempty"`
-	CommandLine       string   `json:"command-line"`
+	Origin            string            `json:"origin"`
+	SBType            string            `json:"type"` // single multi master-slave group all-masters fan-in ndb pxc
+	Version           string            `json:"version"`
+	Flavor            string            `json:"flavor,omitempty"`
+	Host              string            `json:"host,omitempty"`
+	Port              []int             `json:"port"`
+	RouterPorts       []int             `json:"router-ports,omitempty"` // read-write, read-only, X read-write, X read-only
+	Nodes             []string          `json:"nodes"`
+	Destination       string            `json:"destination"`
+	DbDeployerVersion string            `json:"dbdeployer-version"`
+	Timestamp         string            `json:"timestamp"`
+	LogDirectory      string            `json:"log-directory,omitempty"`
+	CommandLine       string            `json:"command-line"`
+	Owner             string            `json:"owner,omitempty"`
+	Tags              map[string]string `json:"tags,omitempty"`
+	Note              string            `json:"note,omitempty"`
+	Expires           string            `json:"expires,omitempty"`
 }
 
 type SandboxCatalog map[string]SandboxItem
@@ -57,7 +62,9 @@ func writeCatalog(sc SandboxCatalog) error {
 		return nil
 	}
 	byteBuf, err := json.MarshalIndent(sc, " ", "\t")
-	common.ErrCheckExitf(err, 1, "error encoding sandbox catalog: %s", err)
+	if err != nil {
+		return fmt.Errorf("error encoding sandbox catalog: %s", err)
+	}
 	jsonString := string(byteBuf)
 	filename := SandboxRegistry
 	return common.WriteString(jsonString, filename)
@@ -185,6 +192,43 @@ func DeleteFromCatalog(sbName string) error {
 	return err
 }
 
+// Safe repair of the catalog: adds or replaces the given entries, as they are,
+// and removes the stale ones, all under the same lock
+func RepairCatalog(entries SandboxCatalog, staleEntries []string) error {
+	if !enableCatalogManagement {
+		return nil
+	}
+	lock, err := setLock("repair")
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+	err = checkCatalog()
+	if err != nil {
+		return err
+	}
+	current, err := unsafeReadCatalog()
+	if err != nil {
+		return err
+	}
+	if current == nil {
+		current = make(SandboxCatalog)
+	}
+	for _, name := range staleEntries {
+		delete(current, name)
+	}
+	for name, details := range entries {
+		current[name] = details
+	}
+	return writeCatalog(current)
+}
+
+// IsCatalogEnabled tells whether the catalog is being maintained.
+// It is disabled by setting SKIP_DBDEPLOYER_CATALOG
+func IsCatalogEnabled() bool {
+	return enableCatalogManagement
+}
+
 // Check that the configuration directory exists and creates it if needed
 // If no catalog exists, creates an empty one.
 func checkCatalog() error {
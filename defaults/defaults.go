@@ -17,8 +17,10 @@ package defaults
 
 import (
 	"encoding/json"
+	"fmt"
 	"os"
 	"path"
+	"strings"
 	"time"
 
 	"github.com/datacharmer/dbdeployer/common"
@@ -42,6 +44,7 @@ type DbdeployerDefaults struct {
 	AllMastersReplicationBasePort int    `json:"all-masters-replication-base-port"`
 	MultipleBasePort              int    `json:"multiple-base-port"`
 	PxcBasePort                   int    `json:"pxc-base-port"`
+	GaleraBasePort                int    `json:"galera-base-port"`
 	NdbBasePort                   int    `json:"ndb-base-port"`
 	NdbClusterPort                int    `json:"ndb-cluster-port"`
 	GroupPortDelta                int    `json:"group-port-delta"`
@@ -66,12 +69,17 @@ type DbdeployerDefaults struct {
 	RemoteCompletionUrl           string `json:"remote-completion-url"`
 	RemoteTarballUrl              string `json:"remote-tarball-url"`
 	PxcPrefix                     string `json:"pxc-prefix"`
+	GaleraPrefix                  string `json:"galera-prefix"`
 	NdbPrefix                     string `json:"ndb-prefix"`
 	InnoDBClusterPrefix           string `json:"innodb-cluster-prefix"`
+	ChainPrefix                   string `json:"chain-prefix"`
+	TreePrefix                    string `json:"tree-prefix"`
+	ClusterSetPrefix              string `json:"clusterset-prefix"`
 	DefaultSandboxExecutable      string `json:"default-sandbox-executable"`
 	DownloadNameLinux             string `json:"download-name-linux"`
 	DownloadNameMacOs             string `json:"download-name-macos"`
 	DownloadUrl                   string `json:"download-url"`
+	Hooks                         Hooks  `json:"hooks"`
 	Timestamp                     string `json:"timestamp"`
 }
 
@@ -112,6 +120,7 @@ var (
 		AllMastersReplicationBasePort: 15000,
 		MultipleBasePort:              16000,
 		PxcBasePort:                   18000,
+		GaleraBasePort:                21000,
 		NdbBasePort:                   19000,
 		NdbClusterPort:                20000,
 		GroupPortDelta:                125,
@@ -137,20 +146,37 @@ var (
 		RemoteTarballUrl:              "https://raw.githubusercontent.com/datacharmer/dbdeployer/master/downloads/tarball_list.json",
 		NdbPrefix:                     "ndb_msb_",
 		InnoDBClusterPrefix:           "innodb_msb_",
+		ChainPrefix:                   "chain_msb_",
+		TreePrefix:                    "tree_msb_",
+		ClusterSetPrefix:              "clusterset_msb_",
 		PxcPrefix:                     "pxc_msb_",
+		GaleraPrefix:                  "galera_msb_",
 		DefaultSandboxExecutable:      "default",
 		DownloadNameLinux:             "mysql-{{.Version}}-linux-glibc2.17-x86_64{{.Minimal}}.{{.Ext}}",
 		DownloadNameMacOs:             "mysql-{{.Version}}-macos11-x86_64.{{.Ext}}",
 		DownloadUrl:                   "https://dev.mysql.com/get/Downloads/MySQL",
+		Hooks:                         Hooks{},
 		Timestamp:                     time.Now().Format(time.UnixDate),
 	}
 	currentDefaults DbdeployerDefaults
 )
 
 func Defaults() DbdeployerDefaults {
+	defaults, err := LoadDefaults()
+	common.ErrCheckExitf(err, 1, "%s", err)
+	return defaults
+}
+
+// LoadDefaults returns the current defaults, as Defaults does,
+// but returns an error when the defaults file can't be read, instead of ending the program
+func LoadDefaults() (DbdeployerDefaults, error) {
 	if currentDefaults.Version == "" {
 		if common.FileExists(ConfigurationFile) {
-			currentDefaults = ReadDefaultsFile(ConfigurationFile)
+			defaults, err := LoadDefaultsFile(ConfigurationFile)
+			if err != nil {
+				return defaults, err
+			}
+			currentDefaults = defaults
 		} else {
 			currentDefaults = factoryDefaults
 		}
@@ -158,7 +184,7 @@ func Defaults() DbdeployerDefaults {
 	if currentDefaults.LogSBOperations {
 		LogSBOperations = true
 	}
-	return currentDefaults
+	return currentDefaults, nil
 }
 
 func ShowDefaults(defaults DbdeployerDefaults) {
@@ -202,14 +228,48 @@ func replaceLiteralEnvValues(defaults DbdeployerDefaults) DbdeployerDefaults {
 	return defaults
 }
 
-func ReadDefaultsFile(filename string) (defaults DbdeployerDefaults) {
+func ReadDefaultsFile(filename string) DbdeployerDefaults {
+	defaults, err := LoadDefaultsFile(filename)
+	common.ErrCheckExitf(err, 1, "%s", err)
+	return defaults
+}
+
+// LoadDefaultsFile reads a defaults file, as ReadDefaultsFile does,
+// but returns an error instead of ending the program
+func LoadDefaultsFile(filename string) (defaults DbdeployerDefaults, err error) {
 	defaultsBlob, err := common.SlurpAsBytes(filename)
-	common.ErrCheckExitf(err, 1, "error reading defaults file %s: %s", filename, err)
+	if err != nil {
+		return defaults, fmt.Errorf("error reading defaults file %s: %s", filename, err)
+	}
 
 	err = json.Unmarshal(defaultsBlob, &defaults)
-	common.ErrCheckExitf(err, 1, globals.ErrEncodingDefaults, err)
+	if err != nil {
+		return defaults, fmt.Errorf(globals.ErrEncodingDefaults, err)
+	}
+	// Files written by older versions have no hooks
+	if defaults.Hooks == nil {
+		defaults.Hooks = Hooks{}
+	}
+	// ... nor Galera settings
+	if defaults.GaleraBasePort == 0 {
+		defaults.GaleraBasePort = factoryDefaults.GaleraBasePort
+	}
+	if defaults.GaleraPrefix == "" {
+		defaults.GaleraPrefix = factoryDefaults.GaleraPrefix
+	}
+	// ... nor chain and tree prefixes
+	if defaults.ChainPrefix == "" {
+		defaults.ChainPrefix = factoryDefaults.ChainPrefix
+	}
+	if defaults.TreePrefix == "" {
+		defaults.TreePrefix = factoryDefaults.TreePrefix
+	}
+	// ... nor the ClusterSet prefix
+	if defaults.ClusterSetPrefix == "" {
+		defaults.ClusterSetPrefix = factoryDefaults.ClusterSetPrefix
+	}
 	defaults = expandEnvironmentVariables(defaults)
-	return
+	return defaults, nil
 }
 
 func checkInt(name string, val, min, max int) bool {
@@ -228,6 +288,7 @@ func ValidateDefaults(nd DbdeployerDefaults) bool {
 		checkInt("fan-in-base-port", nd.FanInReplicationBasePort, minPortValue, maxPortValue) &&
 		checkInt("all-masters-base-port", nd.AllMastersReplicationBasePort, minPortValue, maxPortValue) &&
 		checkInt("pxc-base-port", nd.PxcBasePort, minPortValue, maxPortValue) &&
+		checkInt("galera-base-port", nd.GaleraBasePort, minPortValue, maxPortValue) &&
 		checkInt("ndb-base-port", nd.NdbBasePort, minPortValue, maxPortValue) &&
 		checkInt("ndb-cluster-port", nd.NdbClusterPort, minPortValue, maxPortValue) &&
 		checkInt("group-port-delta", nd.GroupPortDelta, 101, 299) &&
@@ -244,6 +305,7 @@ func ValidateDefaults(nd DbdeployerDefaults) bool {
 		nd.MultipleBasePort != nd.NdbBasePort &&
 		nd.MultipleBasePort != nd.NdbClusterPort &&
 		nd.MultipleBasePort != nd.PxcBasePort &&
+		nd.MultipleBasePort != nd.GaleraBasePort &&
 		nd.MultiplePrefix != nd.GroupSpPrefix &&
 		nd.MultiplePrefix != nd.GroupPrefix &&
 		nd.MultiplePrefix != nd.MasterSlavePrefix &&
@@ -254,6 +316,10 @@ func ValidateDefaults(nd DbdeployerDefaults) bool {
 		nd.MasterAbbr != nd.SlaveAbbr &&
 		nd.MultiplePrefix != nd.NdbPrefix &&
 		nd.MultiplePrefix != nd.PxcPrefix &&
+		nd.MultiplePrefix != nd.GaleraPrefix &&
+		nd.MultiplePrefix != nd.ChainPrefix &&
+		nd.MultiplePrefix != nd.TreePrefix &&
+		nd.MultiplePrefix != nd.ClusterSetPrefix &&
 		nd.SandboxHome != nd.SandboxBinary
 	if !noConflicts {
 		common.CondPrintf("Conflicts found in defaults values:\n")
@@ -273,6 +339,10 @@ func ValidateDefaults(nd DbdeployerDefaults) bool {
 		nd.GroupSpPrefix != "" &&
 		nd.MultiplePrefix != "" &&
 		nd.PxcPrefix != "" &&
+		nd.GaleraPrefix != "" &&
+		nd.ChainPrefix != "" &&
+		nd.TreePrefix != "" &&
+		nd.ClusterSetPrefix != "" &&
 		nd.NdbPrefix != "" &&
 		nd.DefaultSandboxExecutable != "" &&
 		nd.DownloadUrl != "" &&
@@ -289,6 +359,12 @@ func ValidateDefaults(nd DbdeployerDefaults) bool {
 		ShowDefaults(nd)
 		return false
 	}
+	for hook := range nd.Hooks {
+		if !isHookName(hook) {
+			common.CondPrintf("Unknown hook '%s'. Available hooks: %s\n", hook, strings.Join(HookNames, ", "))
+			return false
+		}
+	}
 	compatibleVersionList, err := common.VersionToList(common.CompatibleVersion)
 	if err != nil {
 		return false
@@ -363,6 +439,8 @@ func UpdateDefaults(label, value string, storeDefaults bool) {
 		newDefaults.NdbClusterPort = common.Atoi(value)
 	case "pxc-base-port":
 		newDefaults.PxcBasePort = common.Atoi(value)
+	case "galera-base-port":
+		newDefaults.GaleraBasePort = common.Atoi(value)
 	case "group-port-delta":
 		newDefaults.GroupPortDelta = common.Atoi(value)
 	case "mysqlx-port-delta":
@@ -407,10 +485,18 @@ func UpdateDefaults(label, value string, storeDefaults bool) {
 		newDefaults.ReservedPorts = strToSlice("reserved-ports", value)
 	case "pxc-prefix":
 		newDefaults.PxcPrefix = value
+	case "galera-prefix":
+		newDefaults.GaleraPrefix = value
 	case "ndb-prefix":
 		newDefaults.NdbPrefix = value
 	case "innodb-cluster-prefix":
 		newDefaults.InnoDBClusterPrefix = value
+	case "chain-prefix":
+		newDefaults.ChainPrefix = value
+	case "tree-prefix":
+		newDefaults.TreePrefix = value
+	case "clusterset-prefix":
+		newDefaults.ClusterSetPrefix = value
 	case "default-sandbox-executable":
 		newDefaults.DefaultSandboxExecutable = value
 	case "download-url":
@@ -420,7 +506,11 @@ func UpdateDefaults(label, value string, storeDefaults bool) {
 	case "download-name-macos":
 		newDefaults.DownloadNameMacOs = value
 	default:
-		common.Exitf(1, "unrecognized label %s", label)
+		if !strings.HasPrefix(label, hookLabelPrefix) {
+			common.Exitf(1, "unrecognized label %s", label)
+		}
+		err := updateHook(&newDefaults, label, value)
+		common.ErrCheckExitf(err, 1, "%s", err)
 	}
 	if ValidateDefaults(newDefaults) {
 		currentDefaults = newDefaults
@@ -463,7 +553,7 @@ func DefaultsToMap() common.StringMap {
 			currentDefaults.ShellPath = globals.ShellPathValue
 		}
 	}
-	return common.StringMap{
+	defaultsMap := common.StringMap{
 		"Version":                           currentDefaults.Version,
 		"version":                           currentDefaults.Version,
 		"SandboxHome":                       currentDefaults.SandboxHome,
@@ -496,6 +586,8 @@ func DefaultsToMap() common.StringMap {
 		"multiple-base-port":                currentDefaults.MultipleBasePort,
 		"PxcBasePort":                       currentDefaults.PxcBasePort,
 		"pxc-base-port":                     currentDefaults.PxcBasePort,
+		"GaleraBasePort":                    currentDefaults.GaleraBasePort,
+		"galera-base-port":                  currentDefaults.GaleraBasePort,
 		"NdbBasePort":                       currentDefaults.NdbBasePort,
 		"ndb-base-port":                     currentDefaults.NdbBasePort,
 		"NdbClusterPort":                    currentDefaults.NdbClusterPort,
@@ -546,9 +638,17 @@ func DefaultsToMap() common.StringMap {
 		"remote-github":                     currentDefaults.RemoteTarballUrl,
 		"PxcPrefix":                         currentDefaults.PxcPrefix,
 		"pxc-prefix":                        currentDefaults.PxcPrefix,
+		"GaleraPrefix":                      currentDefaults.GaleraPrefix,
+		"galera-prefix":                     currentDefaults.GaleraPrefix,
 		"NdbPrefix":                         currentDefaults.NdbPrefix,
 		"ndb-prefix":                        currentDefaults.NdbPrefix,
 		"innodb-cluster-prefix":             currentDefaults.InnoDBClusterPrefix,
+		"ChainPrefix":                       currentDefaults.ChainPrefix,
+		"chain-prefix":                      currentDefaults.ChainPrefix,
+		"TreePrefix":                        currentDefaults.TreePrefix,
+		"tree-prefix":                       currentDefaults.TreePrefix,
+		"ClusterSetPrefix":                  currentDefaults.ClusterSetPrefix,
+		"clusterset-prefix":                 currentDefaults.ClusterSetPrefix,
 		"DefaultSandboxExecutable":          currentDefaults.DefaultSandboxExecutable,
 		"default-sandbox-executable":        currentDefaults.DefaultSandboxExecutable,
 		"download-url":                      currentDefaults.DownloadUrl,
@@ -557,9 +657,15 @@ func DefaultsToMap() common.StringMap {
 		"DownloadNameMacOs":                 currentDefaults.DownloadNameMacOs,
 		"download-name-linux":               currentDefaults.DownloadNameLinux,
 		"DownloadNameLinux":                 currentDefaults.DownloadNameLinux,
+		"Hooks":                             currentDefaults.Hooks,
+		"hooks":                             currentDefaults.Hooks,
 		"Timestamp":                         currentDefaults.Timestamp,
 		"timestamp":                         currentDefaults.Timestamp,
 	}
+	for hook, command := range currentDefaults.Hooks {
+		defaultsMap[hookLabelPrefix+hook] = command
+	}
+	return defaultsMap
 }
 
 func ResetDefaults() {
@@ -0,0 +1,158 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2020 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package defaults
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/globals"
+)
+
+// Operations that can run a hook. The hooks are shell commands,
+// defined in the defaults as "hooks.<operation>"
+const (
+	HookPostDeploy = "post-deploy"
+	HookPreDelete  = "pre-delete"
+	HookPostDelete = "post-delete"
+	HookPreStart   = "pre-start"
+	HookPostStart  = "post-start"
+	HookPreStop    = "pre-stop"
+	HookPostStop   = "post-stop"
+
+	hookLabelPrefix = "hooks."
+)
+
+// Hooks maps operation names to shell commands
+type Hooks map[string]string
+
+var HookNames = []string{
+	HookPostDeploy,
+	HookPreDelete,
+	HookPostDelete,
+	HookPreStart,
+	HookPostStart,
+	HookPreStop,
+	HookPostStop,
+}
+
+func isHookName(name string) bool {
+	for _, hook := range HookNames {
+		if hook == name {
+			return true
+		}
+	}
+	return false
+}
+
+// HasHook tells whether a hook is defined for an operation
+func HasHook(hook string) bool {
+	return Defaults().Hooks[hook] != ""
+}
+
+// hookCredentialsFile returns the options file with the credentials of a sandbox.
+// For composite sandboxes, it is the one of the master or of the first node.
+// PostgreSQL sandboxes keep their credentials in a password file
+func hookCredentialsFile(sandboxPath string) string {
+	for _, dir := range []string{"", Defaults().MasterName, Defaults().NodePrefix + "1"} {
+		for _, fileName := range []string{globals.ScriptMySandboxCnf, globals.ScriptPgPass} {
+			credentials := path.Join(sandboxPath, dir, fileName)
+			if common.FileExists(credentials) {
+				return credentials
+			}
+		}
+	}
+	return ""
+}
+
+// HookEnvironment returns the variables that describe a sandbox to a hook
+func HookEnvironment(hook, sandboxPath string) ([]string, error) {
+	sbDesc, err := common.ReadSandboxDescription(sandboxPath)
+	if err != nil {
+		return nil, err
+	}
+	return []string{
+		"DBDEPLOYER_HOOK=" + hook,
+		"DBDEPLOYER_SANDBOX_NAME=" + path.Base(sandboxPath),
+		"DBDEPLOYER_SANDBOX_DIR=" + sandboxPath,
+		"DBDEPLOYER_SANDBOX_PORTS=" + common.IntSliceToSeparatedString(sbDesc.Port, ","),
+		"DBDEPLOYER_SANDBOX_VERSION=" + sbDesc.Version,
+		"DBDEPLOYER_SANDBOX_FLAVOR=" + sbDesc.Flavor,
+		"DBDEPLOYER_SANDBOX_TYPE=" + sbDesc.SBType,
+		"DBDEPLOYER_SANDBOX_CREDENTIALS=" + hookCredentialsFile(sandboxPath),
+	}, nil
+}
+
+// HookCommand returns the command that runs a hook with the given environment.
+// It returns false if no hook is defined for the operation
+func HookCommand(hook string, env []string) (string, []string, bool) {
+	if !HasHook(hook) {
+		return "", nil, false
+	}
+	shell := Defaults().ShellPath
+	if shell == "" {
+		shell = globals.ShellPathValue
+	}
+	var args []string
+	args = append(args, env...)
+	args = append(args, shell, "-c", Defaults().Hooks[hook])
+	return "env", args, true
+}
+
+// RunHook runs the hook defined for an operation on a sandbox, if any
+func RunHook(hook, sandboxPath string) error {
+	_, err := RunHookCtrl(hook, sandboxPath, false)
+	return err
+}
+
+// RunHookCtrl runs the hook defined for an operation on a sandbox, if any, with optional quiet output
+func RunHookCtrl(hook, sandboxPath string, silent bool) (string, error) {
+	if !HasHook(hook) {
+		return "", nil
+	}
+	env, err := HookEnvironment(hook, sandboxPath)
+	if err != nil {
+		return "", fmt.Errorf("error preparing hook %s for %s: %s", hook, sandboxPath, err)
+	}
+	command, args, _ := HookCommand(hook, env)
+	out, err := common.RunCmdCtrlWithArgs(command, args, silent)
+	if err != nil {
+		return out, fmt.Errorf("hook %s failed for %s: %s", hook, sandboxPath, err)
+	}
+	return out, nil
+}
+
+// updateHook sets or removes (with an empty command) the hook named in a "hooks.<operation>" label
+func updateHook(defaults *DbdeployerDefaults, label, value string) error {
+	hook := strings.TrimPrefix(label, hookLabelPrefix)
+	if !isHookName(hook) {
+		return fmt.Errorf("unknown hook '%s'. Available hooks: %s", hook, strings.Join(HookNames, ", "))
+	}
+	// The map of the current defaults must not change before validation
+	hooks := make(Hooks)
+	for name, command := range defaults.Hooks {
+		hooks[name] = command
+	}
+	if value == "" {
+		delete(hooks, hook)
+	} else {
+		hooks[hook] = value
+	}
+	defaults.Hooks = hooks
+	return nil
+}
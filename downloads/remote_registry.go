@@ -21,7 +21,6 @@ import (
 	"net/http"
 	"os"
 	"path"
-	"regexp"
 	"runtime"
 	"sort"
 	"strconv"
@@ -268,16 +267,11 @@ func CompareTarballChecksum(tarball TarballDescription, fileName string) error {
 	if tarball.Checksum == "" {
 		return nil
 	}
-	reCRC := regexp.MustCompile(`(MD5|SHA1|SHA256|SHA512)\s*:\s*(\S+)`)
-	crcList := reCRC.FindAllStringSubmatch(tarball.Checksum, -1)
-
-	if len(crcList) < 1 || len(crcList[0]) < 2 {
-		return fmt.Errorf("not a valid CRC pattern found. Expected: (MD5|SHA1|SHA256|SHA512):CHECKSUM_STRING")
+	crcType, crcText, err := common.ParseChecksum(tarball.Checksum)
+	if err != nil {
+		return err
 	}
 
-	crcType := crcList[0][1]
-	crcText := crcList[0][2]
-
 	if crcType == "" {
 		return fmt.Errorf("no CRC type detected in checksum field for %s", tarball.Name)
 	}
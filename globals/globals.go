@@ -24,9 +24,12 @@ var UsingDbDeployer = false
 
 const (
 	// Sandbox types
-	SbTypeSingle         = "single"
-	SbTypeMultiple       = "multiple"
-	SbTypeSingleImported = "single-imported"
+	SbTypeSingle             = "single"
+	SbTypeMultiple           = "multiple"
+	SbTypeSingleImported     = "single-imported"
+	SbTypeGroupMultiPrimary  = "group-multi-primary"
+	SbTypeGroupSinglePrimary = "group-single-primary"
+	SbTypeClusterSet         = "clusterset"
 
 	// Instantiated in cmd/root.go
 	ConfigLabel        = "config"
@@ -54,6 +57,11 @@ const (
 	CustomRolePrivilegesLabel = "custom-role-privileges"
 	CustomRoleTargetLabel     = "custom-role-target"
 	CustomRoleExtraLabel      = "custom-role-extra"
+	DefaultRoleValue          = "R_DO_IT_ALL"
+	CustomRoleNameValue       = "R_CUSTOM"
+	CustomRolePrivilegesValue = "ALL PRIVILEGES"
+	CustomRoleTargetValue     = "*.*"
+	CustomRoleExtraValue      = "WITH GRANT OPTION"
 	TaskUserLabel             = "task-user"
 	TaskUserRoleLabel         = "task-user-role"
 	BasePortLabel             = "base-port"
@@ -110,7 +118,13 @@ const (
 	PromptValue               = "mysql"
 	SocketInDatadirLabel      = "socket-in-datadir"
 	PortAsServerIdLabel       = "port-as-server-id"
+	TlsLabel                  = "tls"
+	SecureTransportLabel      = "require-secure-transport"
 	MysqlshPathLabel          = "mysqlsh-path"
+	TagLabel                  = "tag"
+	OwnerLabel                = "owner"
+	NoteLabel                 = "note"
+	TtlLabel                  = "ttl"
 
 	// Instantiated in cmd/single.go
 	MasterLabel    = "master"
@@ -139,6 +153,7 @@ const (
 	UnpackLabel            = "unpack"
 	GuessLatestLabel       = "guess-latest"
 	RetriesOnFailureLabel  = "retries-on-failure"
+	ChunksLabel            = "chunks"
 	MergeImportedLabel     = "merge-imported"
 	MinimalLabel           = "minimal"
 	NewestLabel            = "newest"
@@ -148,8 +163,19 @@ const (
 	ChangeUserAgentLabel   = "change-user-agent"
 
 	// Instantiated in cmd/admin.go
-	VerboseLabel = "verbose"
-	DryRunLabel  = "dry-run"
+	VerboseLabel    = "verbose"
+	DryRunLabel     = "dry-run"
+	UseDumpLabel    = "use-dump"
+	NewMasterLabel  = "new-master"
+	FailoverLabel   = "failover"
+	ListLabel       = "list"
+	SwitchoverLabel = "switchover"
+
+	// Instantiated in cmd/admin_group.go
+	OutputLabel = "output"
+	OutputTable = "table"
+	OutputJson  = "json"
+	OutputYaml  = "yaml"
 
 	// Instantiated in cmd/replication.go
 	AllMastersLabel     = "all-masters"
@@ -174,10 +200,25 @@ const (
 	TopologyLabel       = "topology"
 	TopologyValue       = "master-slave"
 	PxcLabel            = "pxc"
+	GaleraLabel         = "galera"
 	NdbLabel            = "ndb"
 	InnoDBClusterLabel  = "innodb-cluster"
+	WithRouterLabel     = "with-router"
+	RouterDirName       = "router"
+	RouterBasePortValue = 6446
+	ChainLabel          = "chain"
+	TreeLabel           = "tree"
+	FanOutLabel         = "fan-out"
+	FanOutValue         = "2,2"
 	ChangeMasterOptions = "change-master-options"
 
+	// Instantiated in cmd/clusterset.go
+	ClustersLabel     = "clusters"
+	ClustersValue     = 2
+	ClusterTypeLabel  = "cluster-type"
+	ClusterLabel      = "cluster"
+	ClusterSetChannel = "clusterset"
+
 	// Instantiated in cmd/unpack.go and unpack/unpack.go
 	GzExt              = ".gz"
 	PrefixLabel        = "prefix"
@@ -197,6 +238,7 @@ const (
 	VersionLabel       = "version"
 	ShortVersionLabel  = "short-version"
 	FlavorFileName     = "FLAVOR"
+	FlavorsDirName     = "flavors"
 
 	// Instantiated in cmd/use.go
 	RunLabel = "run"
@@ -216,8 +258,29 @@ const (
 	ByVersionLabel = "by-version"
 	LatestLabel    = "latest"
 	OldestLabel    = "oldest"
+	StatusLabel    = "status"
+	QueryLabel     = "query"
 	LocalHostIP    = "127.0.0.1"
 
+	// Instantiated in cmd/global.go
+	ParallelLabel = "parallel"
+	TimeoutLabel  = "timeout"
+
+	// Instantiated in cmd/doctor.go
+	FixLabel = "fix"
+
+	// Instantiated in cmd/serve.go
+	ListenLabel   = "listen"
+	SocketLabel   = "socket"
+	TokenLabel    = "token"
+	ListenValue   = "127.0.0.1:8091"
+	TokenEnvValue = "DBDEPLOYER_API_TOKEN"
+
+	// Instantiated in cmd/mock_server.go
+	DefaultsFileLabel = "defaults-file"
+	PidFileLabel      = "pid-file"
+	ScriptLabel       = "script"
+
 	// Instantiated in cmd/templates.go
 	SimpleLabel       = "simple"
 	WithContentsLabel = "with-contents"
@@ -238,6 +301,7 @@ const (
 	ForceOutputToTermLabel       = "force-output-to-terminal"
 	ExportSandboxDir             = "sandbox-dir"
 	ExportVersionDir             = "version-dir"
+	ExportSandboxDirOrVersion    = "sandbox-dir-or-version"
 	ExportTemplateGroup          = "template-group"
 	ExportTemplateName           = "template-name"
 	ExportString                 = "string"
@@ -252,6 +316,10 @@ const (
 	// Instantiated in sandbox package
 	AutoCnfName               = "auto.cnf"
 	DataDirName               = "data"
+	TlsDirName                = "tls"
+	TlsCaDirName              = ".ca"
+	SnapshotsDirName          = ".snapshots"
+	SnapshotDescriptionName   = "snapshot.json"
 	ScriptAddOption           = "add_option"
 	ScriptClear               = "clear"
 	ScriptGrantsMysql         = "grants.mysql"
@@ -289,16 +357,21 @@ const (
 	ScriptSysbench            = "sysbench"
 	ScriptSysbenchReady       = "sysbench_ready"
 	ScriptWipeAndRestart      = "wipe_and_restart"
+	ScriptPgSandboxConf       = "postgresql.sandbox.conf"
+	ScriptPgPass              = "pgpass"
 
 	ScriptCheckMsNodes           = "check_ms_nodes"
 	ScriptCheckNodes             = "check_nodes"
 	ScriptCheckNodesCluster      = "check_nodes_cluster"
+	ScriptCheckClusterSet        = "check_clusterset"
 	ScriptClearAll               = "clear_all"
 	ScriptInitializeMsNodes      = "initialize_ms_nodes"
 	ScriptInitializeNodes        = "initialize_nodes"
 	ScriptInitializeNodesCluster = "initialize_nodes_cluster"
+	ScriptInitializeClusterSet   = "initialize_clusterset"
 	ScriptNoClearAll             = "no_clear_all"
 	ScriptRestartAll             = "restart_all"
+	ScriptRouterBootstrap        = "bootstrap"
 	ScriptSendKillAll            = "send_kill_all"
 	ScriptStartAll               = "start_all"
 	ScriptStatusAll              = "status_all"
@@ -398,6 +471,7 @@ var (
 	MinimumDefaultInitializeVersion           = NumericVersion{5, 7, 0}
 	MinimumCreateUserVersion                  = NumericVersion{5, 7, 6}
 	MinimumSuperReadOnly                      = NumericVersion{5, 7, 8}
+	MinimumSecureTransportVersion             = NumericVersion{5, 7, 8}
 	MinimumMultiSourceReplVersion             = NumericVersion{5, 7, 9}
 	MinimumMysqlxVersion                      = NumericVersion{5, 7, 12}
 	MinimumGroupReplVersion                   = NumericVersion{5, 7, 17}
@@ -408,6 +482,7 @@ var (
 	MinimumMysqlxDefaultVersion               = NumericVersion{8, 0, 11}
 	MariaDbMinimumGtidVersion                 = NumericVersion{10, 0, 0}
 	MariaDbMinimumMultiSourceVersion          = NumericVersion{10, 0, 0}
+	MariaDbMinimumGaleraVersion               = NumericVersion{10, 1, 0}
 	MinimumXtradbClusterVersion               = NumericVersion{5, 6, 14}
 	MinimumXtradbClusterNoSlaveUpdatesVersion = NumericVersion{5, 7, 14}
 	MinimumXtradbClusterEncryptCluster        = NumericVersion{5, 7, 14}
@@ -422,6 +497,12 @@ var (
 	MinimumAdminAddressVersion                = NumericVersion{8, 0, 14}
 	MinimumMySQLShellEmbed                    = NumericVersion{8, 0, 4}
 	MinimumInnoDBCluster                      = NumericVersion{8, 0, 0}
+	MinimumRouterHttpsPort                    = NumericVersion{8, 0, 29}
+	MinimumMysqldumpSourceDataVersion         = NumericVersion{8, 0, 26}
+	MinimumPgStreamingReplication             = NumericVersion{9, 3, 0}
+	MinimumPgWalLevelReplica                  = NumericVersion{9, 6, 0}
+	MinimumPgScramAuth                        = NumericVersion{10, 0, 0}
+	MinimumPgReplicationSlots                 = NumericVersion{10, 0, 0}
 )
 
 const (
@@ -455,6 +536,7 @@ const (
 	FnMysqld                      = "mysqld"
 	FnMysqldDebug                 = "mysqld-debug"
 	FnMysqldSafe                  = "mysqld_safe"
+	FnMockServerJson              = "mock_server.json"
 	FnNdbd                        = "ndbd"
 	FnNdbdEngineSo                = "ndb_engine.so"
 	FnNdbdMgm                     = "ndb_mgm"
@@ -462,16 +544,28 @@ const (
 	FnNdbdMtd                     = "ndbmtd"
 	FnTableH                      = "table.h"
 	FnTiDbServer                  = "tidb-server"
+	FnPostgres                    = "postgres"
+	FnInitdb                      = "initdb"
+	FnPgCtl                       = "pg_ctl"
+	FnPsql                        = "psql"
+	FnPgBasebackup                = "pg_basebackup"
+	FnPgPid                       = "postmaster.pid"
+
+	// Top directory of the PostgreSQL binary tarballs
+	PgTarballTopDir = "pgsql"
 )
 
 var AllowedTopologies = []string{
 	MasterSlaveLabel,
 	GroupLabel,
 	PxcLabel,
+	GaleraLabel,
 	FanInLabel,
 	AllMastersLabel,
 	NdbLabel,
 	InnoDBClusterLabel,
+	ChainLabel,
+	TreeLabel,
 }
 
 // This structure is not used directly by dbdeployer.
@@ -513,6 +607,10 @@ var (
 	}
 	// Extra executables needed for PXC
 	NeededPxcExecutables = []string{"rsync", "lsof", "socat"}
+	// Extra executables needed for MariaDB Galera (rsync SST)
+	NeededGaleraExecutables = []string{"rsync", "lsof"}
+	// Locations of the wsrep provider library in MariaDB tarballs, relative to the basedir
+	GaleraProviderDirs = []string{"lib/galera-4", "lib/galera", "lib"}
 )
 
 var ShellScriptCopyright string = `
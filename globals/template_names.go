@@ -84,6 +84,9 @@ const (
 	TmplUseAll                 = "use_all"
 	TmplStopAll                = "stop_all"
 	TmplCheckSlaves            = "check_slaves"
+	TmplCheckSlavesTree        = "check_slaves_tree"
+	TmplUseAllTree             = "use_all_tree"
+	TmplTestReplicationTree    = "test_replication_tree"
 	TmplMultiSourceExecSlaves  = "multi_source_exec_slaves"
 	TmplStartAll               = "start_all"
 	TmplMultiSourceExecMasters = "multi_source_exec_masters"
@@ -93,9 +96,10 @@ const (
 	TmplMultiSourceUseMasters  = "multi_source_use_masters"
 
 	// mock
-	TmplNoOpMock       = "no_op_mock"
-	TmplMysqldSafeMock = "mysqld_safe_mock"
-	TmplTidbMock       = "tidb_mock"
+	TmplNoOpMock             = "no_op_mock"
+	TmplMysqldSafeMock       = "mysqld_safe_mock"
+	TmplMysqldSafeServerMock = "mysqld_safe_server_mock"
+	TmplTidbMock             = "tidb_mock"
 
 	// single
 	TmplShowBinlog              = "show_binlog"
@@ -137,6 +141,7 @@ const (
 	TmplReplicateFrom           = "replicate_from"
 	TmplGtidOptions56           = "gtid_options_56"
 	TmplGtidOptions57           = "gtid_options_57"
+	TmplGtidOptionsMariaDb      = "gtid_options_mariadb"
 	TmplCloneConnectionSql      = "clone_connection_sql"
 	TmplInitDb                  = "init_db"
 	TmplUse                     = "use"
@@ -152,6 +157,11 @@ const (
 	TmplPxcStart       = "pxc_start"
 	TmplPxcCheckNodes  = "check_pxc_nodes"
 
+	// galera
+	TmplGaleraReplication = "galera_replication"
+	TmplGaleraStart       = "galera_start"
+	TmplGaleraCheckNodes  = "check_galera_nodes"
+
 	//ndb
 	TmplNdbStartCluster = "ndb_start_cluster"
 	TmplNdbStopCluster  = "ndb_stop_cluster"
@@ -168,6 +178,28 @@ const (
 	TmplTidbInitDb     = "tidb_init_db"
 	TmplTidbMyCnf      = "tidb_my_cnf"
 
+	// postgresql
+	TmplPgSbInclude       = "pg_sb_include"
+	TmplPgInitDb          = "pg_init_db"
+	TmplPgStandbyInit     = "pg_standby_init"
+	TmplPgConf            = "pg_conf"
+	TmplPgPass            = "pg_pass"
+	TmplPgStart           = "pg_start"
+	TmplPgStop            = "pg_stop"
+	TmplPgStatus          = "pg_status"
+	TmplPgRestart         = "pg_restart"
+	TmplPgSendKill        = "pg_send_kill"
+	TmplPgUse             = "pg_use"
+	TmplPgClear           = "pg_clear"
+	TmplPgShowLog         = "pg_show_log"
+	TmplPgTestSb          = "pg_test_sb"
+	TmplPgAfterStart      = "pg_after_start"
+	TmplPgStartAll        = "pg_start_all"
+	TmplPgStatusAll       = "pg_status_all"
+	TmplPgClearAll        = "pg_clear_all"
+	TmplPgCheckStandbys   = "pg_check_standbys"
+	TmplPgTestReplication = "pg_test_replication"
+
 	// group
 	TmplInitNodes          = "init_nodes"
 	TmplInitNodes84        = "init_nodes84"
@@ -175,9 +207,19 @@ const (
 	TmplGroupReplOptions   = "group_repl_options"
 	TmplGroupReplOptions84 = "group_repl_options84"
 
+	// clusterset
+	TmplClusterSetAll        = "clusterset_all"
+	TmplClusterSetInitialize = "clusterset_initialize"
+	TmplClusterSetCheck      = "clusterset_check"
+
 	// cluster
 	TmplClusterOptions         = "cluster_options"
 	TmplClusterOptions84       = "cluster_options84"
 	TmplInitializeNodesCluster = "initialize_nodes_cluster"
 	TmplCheckClusterNodes      = "check_nodes_cluster"
+	TmplRouterBootstrap        = "router_bootstrap"
+	TmplRouterStart            = "router_start"
+	TmplRouterStop             = "router_stop"
+	TmplRouterStatus           = "router_status"
+	TmplRouterConnectionJson   = "router_connection_json"
 )
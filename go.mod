@@ -17,6 +17,7 @@ require (
 	golang.org/x/exp v0.0.0-20220722155223-a9213eeb770e
 	golang.org/x/term v0.39.0
 	golang.org/x/text v0.33.0
+	gopkg.in/yaml.v3 v3.0.1
 	jaytaylor.com/html2text/2 v0.0.0-20230321000545-74c2419ad056
 )
 
@@ -38,5 +39,4 @@ require (
 	golang.org/x/sys v0.40.0 // indirect
 	golang.org/x/tools v0.40.0 // indirect
 	gopkg.in/check.v1 v1.0.0-20180628173108-788fd7840127 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
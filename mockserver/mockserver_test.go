@@ -0,0 +1,305 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2022 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mockserver
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/globals"
+	"github.com/datacharmer/dbdeployer/importing"
+	"github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/require"
+)
+
+// startServer runs a mock server on a free port, and returns a connection to it
+func startServer(t *testing.T, options Options) (*Server, *sql.DB) {
+	if options.Address == "" {
+		options.Address = "127.0.0.1:0"
+	}
+	server, err := New(options)
+	require.NoError(t, err)
+	serveError := make(chan error, 1)
+	go func() {
+		serveError <- server.Serve()
+	}()
+	t.Cleanup(func() {
+		require.NoError(t, server.Close())
+		require.NoError(t, <-serveError)
+	})
+	config := importing.ParamsToConfig("127.0.0.1", "msandbox", "msandbox", server.Port())
+	db, err := importing.Connect(config)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+	return server, db.DB
+}
+
+func TestBuiltinQueries(t *testing.T) {
+	server, db := startServer(t, Options{Version: "8.0.98", ServerId: 100})
+
+	var version, port, serverId string
+	require.NoError(t, db.QueryRow("select @@version, @@port, @@global.server_id").Scan(&version, &port, &serverId))
+	require.Equal(t, "8.0.98", version)
+	require.Equal(t, fmt.Sprintf("%d", server.Port()), port)
+	require.Equal(t, "100", serverId)
+
+	var number int
+	require.NoError(t, db.QueryRow("SELECT 1;").Scan(&number))
+	require.Equal(t, 1, number)
+
+	// The query that the mysql client sends when it connects
+	var comment string
+	require.NoError(t, db.QueryRow("select @@version_comment limit 1").Scan(&comment))
+	require.Equal(t, "dbdeployer mock server", comment)
+
+	var versionFunction, alias string
+	var nullValue sql.NullString
+	rows, err := db.Query("select version() as v, 'text' as alias, NULL")
+	require.NoError(t, err)
+	columns, err := rows.Columns()
+	require.NoError(t, err)
+	require.Equal(t, []string{"v", "alias", "NULL"}, columns)
+	require.True(t, rows.Next())
+	require.NoError(t, rows.Scan(&versionFunction, &alias, &nullValue))
+	require.NoError(t, rows.Close())
+	require.Equal(t, "8.0.98", versionFunction)
+	require.Equal(t, "text", alias)
+	require.False(t, nullValue.Valid)
+
+	var uptime int
+	require.NoError(t, db.QueryRow("select @@version, variable_value from performance_schema.global_status where variable_name = 'Uptime'").
+		Scan(&version, &uptime))
+	require.GreaterOrEqual(t, uptime, 0)
+
+	var name, value string
+	require.NoError(t, db.QueryRow("show global variables like 'server%id'").Scan(&name, &value))
+	require.Equal(t, "server_id", name)
+	require.Equal(t, "100", value)
+
+	_, err = db.Exec("create database test")
+	require.NoError(t, err)
+
+	err = db.QueryRow("select @@no_such_variable").Scan(&value)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "Unknown system variable 'no_such_variable'")
+
+	err = db.QueryRow("select count(*) from test.t1").Scan(&number)
+	var mysqlError *mysql.MySQLError
+	require.ErrorAs(t, err, &mysqlError)
+	require.Equal(t, uint16(1064), mysqlError.Number)
+}
+
+func TestReplicationQueries(t *testing.T) {
+	_, db := startServer(t, Options{Version: "5.7.98", ServerId: 200})
+
+	rows, err := db.Query("SHOW SLAVE STATUS")
+	require.NoError(t, err)
+	require.False(t, rows.Next())
+	require.NoError(t, rows.Close())
+
+	_, err = db.Exec("START REPLICA")
+	require.Error(t, err)
+
+	_, err = db.Exec("CHANGE MASTER TO master_host='127.0.0.1', master_port=5798, master_user='rsandbox', master_password='rsandbox'")
+	require.NoError(t, err)
+	_, err = db.Exec("START SLAVE")
+	require.NoError(t, err)
+
+	status := func(query string) map[string]sql.NullString {
+		rows, err := db.Query(query)
+		require.NoError(t, err)
+		defer rows.Close()
+		columns, err := rows.Columns()
+		require.NoError(t, err)
+		require.True(t, rows.Next())
+		values := make([]sql.NullString, len(columns))
+		pointers := make([]interface{}, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		require.NoError(t, rows.Scan(pointers...))
+		result := make(map[string]sql.NullString)
+		for i, column := range columns {
+			result[column] = values[i]
+		}
+		return result
+	}
+	slaveStatus := status("SHOW SLAVE STATUS")
+	require.Equal(t, "127.0.0.1", slaveStatus["Master_Host"].String)
+	require.Equal(t, "5798", slaveStatus["Master_Port"].String)
+	require.Equal(t, "Yes", slaveStatus["Slave_IO_Running"].String)
+	require.Equal(t, sql.NullString{String: "0", Valid: true}, slaveStatus["Seconds_Behind_Master"])
+
+	_, err = db.Exec("STOP REPLICA")
+	require.NoError(t, err)
+	replicaStatus := status("SHOW REPLICA STATUS")
+	require.Equal(t, "No", replicaStatus["Replica_SQL_Running"].String)
+	require.False(t, replicaStatus["Seconds_Behind_Source"].Valid)
+
+	_, err = db.Exec("RESET REPLICA ALL")
+	require.NoError(t, err)
+	rows, err = db.Query("SHOW REPLICA STATUS")
+	require.NoError(t, err)
+	require.False(t, rows.Next())
+	require.NoError(t, rows.Close())
+
+	sourceStatus := status("SHOW MASTER STATUS")
+	require.Equal(t, "binlog.000001", sourceStatus["File"].String)
+}
+
+func TestScriptedQueries(t *testing.T) {
+	scriptFile := path.Join(t.TempDir(), "script.json")
+	script := `{
+  "variables": {"read-only": "1"},
+  "queries": [
+    {"query": "select count\\(\\*\\) from test\\.t1", "columns": ["count(*)"], "rows": [["3"]]},
+    {"query": "select id, name from test\\.t1.*", "columns": ["id", "name"], "rows": [["1", "one"], ["2", null]]},
+    {"query": "drop database important", "error": "Access denied", "error_code": 1044},
+    {"query": "delete from test\\.t1", "affected_rows": 3}
+  ]
+}`
+	require.NoError(t, os.WriteFile(scriptFile, []byte(script), 0600))
+	loaded, err := ReadScript(scriptFile)
+	require.NoError(t, err)
+	options := Options{}
+	options.AddScript(loaded)
+	_, db := startServer(t, options)
+
+	var count int
+	require.NoError(t, db.QueryRow("SELECT COUNT(*) FROM test.t1").Scan(&count))
+	require.Equal(t, 3, count)
+
+	var readOnly int
+	require.NoError(t, db.QueryRow("select @@read_only").Scan(&readOnly))
+	require.Equal(t, 1, readOnly)
+
+	rows, err := db.Query("select id, name from test.t1 order by id")
+	require.NoError(t, err)
+	var names []sql.NullString
+	for rows.Next() {
+		var id int
+		var name sql.NullString
+		require.NoError(t, rows.Scan(&id, &name))
+		names = append(names, name)
+	}
+	require.NoError(t, rows.Close())
+	require.Equal(t, []sql.NullString{{String: "one", Valid: true}, {}}, names)
+
+	_, err = db.Exec("drop database important")
+	var mysqlError *mysql.MySQLError
+	require.ErrorAs(t, err, &mysqlError)
+	require.Equal(t, uint16(1044), mysqlError.Number)
+	require.Equal(t, "Access denied", mysqlError.Message)
+
+	result, err := db.Exec("delete from test.t1")
+	require.NoError(t, err)
+	affected, err := result.RowsAffected()
+	require.NoError(t, err)
+	require.Equal(t, int64(3), affected)
+
+	_, err = New(Options{Address: "127.0.0.1:0", Queries: []ScriptedQuery{{Query: "select ("}}})
+	require.Error(t, err)
+}
+
+func TestOptionsFromDefaultsFile(t *testing.T) {
+	sandboxDir := t.TempDir()
+	require.NoError(t, common.WriteSandboxDescription(sandboxDir, common.SandboxDescription{
+		SBType: "single", Version: "8.0.98", Port: []int{8098}}))
+	cnf := `[client]
+port               = 9999
+[mysqld]
+port               = 8098
+socket             = /tmp/mysql_sandbox8098.sock
+pid-file           = ` + sandboxDir + `/data/mysql_sandbox8098.pid
+bind-address       = 127.0.0.1
+server-id=100
+log-slave-updates
+gtid_mode=ON
+`
+	defaultsFile := path.Join(sandboxDir, globals.ScriptMySandboxCnf)
+	require.NoError(t, os.WriteFile(defaultsFile, []byte(cnf), 0600))
+	script := `{"queries": [{"query": "select 42", "columns": ["answer"], "rows": [["42"]]}]}`
+	require.NoError(t, os.WriteFile(path.Join(sandboxDir, globals.FnMockServerJson), []byte(script), 0600))
+
+	options, err := OptionsFromDefaultsFile(defaultsFile)
+	require.NoError(t, err)
+	require.Equal(t, "127.0.0.1:8098", options.Address)
+	require.Equal(t, "/tmp/mysql_sandbox8098.sock", options.Socket)
+	require.Equal(t, path.Join(sandboxDir, "data", "mysql_sandbox8098.pid"), options.PidFile)
+	require.Equal(t, 100, options.ServerId)
+	require.Equal(t, "8.0.98", options.Version)
+	require.Equal(t, "ON", options.Variables["log_slave_updates"])
+	require.Equal(t, "ON", options.Variables["gtid_mode"])
+	require.Len(t, options.Queries, 1)
+
+	require.NoError(t, os.WriteFile(defaultsFile, []byte("[mysqld]\nserver-id=1\n"), 0600))
+	_, err = OptionsFromDefaultsFile(defaultsFile)
+	require.Error(t, err)
+}
+
+func TestRunWithSocket(t *testing.T) {
+	// Unix socket paths have a short length limit
+	dir, err := os.MkdirTemp("/tmp", "mock")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	socket := path.Join(dir, "mysql.sock")
+	pidFile := path.Join(dir, "mysql.pid")
+
+	runError := make(chan error, 1)
+	go func() {
+		runError <- Run(Options{Address: "127.0.0.1:0", Socket: socket, PidFile: pidFile, ServerId: 1})
+	}()
+	require.Eventually(t, func() bool { return common.FileExists(pidFile) }, 5*time.Second, 20*time.Millisecond)
+
+	config := mysql.NewConfig()
+	config.User = "root"
+	config.Net = "unix"
+	config.Addr = socket
+	config.DBName = "test"
+	db, err := sql.Open("mysql", config.FormatDSN())
+	require.NoError(t, err)
+	defer db.Close()
+	var schema, user string
+	require.NoError(t, db.QueryRow("select database(), current_user()").Scan(&schema, &user))
+	require.Equal(t, "test", schema)
+	require.Equal(t, "root@localhost", user)
+
+	// Removing the PID file stops the server, as the sandbox scripts expect
+	require.NoError(t, os.Remove(pidFile))
+	select {
+	case err = <-runError:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the server did not stop after the PID file was removed")
+	}
+	require.False(t, common.FileExists(socket))
+}
+
+func TestShutdown(t *testing.T) {
+	server, db := startServer(t, Options{})
+	_, err := db.Exec("shutdown")
+	require.NoError(t, err)
+	select {
+	case <-server.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("the server did not stop after SHUTDOWN")
+	}
+}
@@ -0,0 +1,352 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2022 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mockserver
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+)
+
+// Code in this module implements the parts of the MySQL client/server protocol
+// that a mock server needs: handshake, commands, OK, ERR, EOF, and text result sets.
+// See https://dev.mysql.com/doc/dev/mysql-server/latest/PAGE_PROTOCOL.html
+
+const (
+	comQuit     byte = 0x01
+	comInitDb   byte = 0x02
+	comQuery    byte = 0x03
+	comShutdown byte = 0x08
+	comPing     byte = 0x0e
+
+	clientLongPassword     uint32 = 0x00000001
+	clientFoundRows        uint32 = 0x00000002
+	clientLongFlag         uint32 = 0x00000004
+	clientConnectWithDb    uint32 = 0x00000008
+	clientProtocol41       uint32 = 0x00000200
+	clientTransactions     uint32 = 0x00002000
+	clientSecureConnection uint32 = 0x00008000
+	clientMultiStatements  uint32 = 0x00010000
+	clientMultiResults     uint32 = 0x00020000
+	clientPluginAuth       uint32 = 0x00080000
+	clientPluginAuthLenenc uint32 = 0x00200000
+
+	// SSL and CLIENT_DEPRECATE_EOF are not advertised: connections are in clear,
+	// and result sets always end with an EOF packet
+	serverCapabilities = clientLongPassword | clientFoundRows | clientLongFlag | clientConnectWithDb |
+		clientProtocol41 | clientTransactions | clientSecureConnection | clientMultiStatements |
+		clientMultiResults | clientPluginAuth | clientPluginAuthLenenc
+
+	serverStatusAutocommit uint16 = 0x0002
+
+	charsetUtf8mb4     byte   = 255
+	charsetUtf8        uint16 = 33
+	typeVarString      byte   = 0xfd
+	nativePasswordAuth        = "mysql_native_password"
+	maxPacketSize             = 1<<24 - 1
+	nullValue          byte   = 0xfb
+)
+
+// packetConn reads and writes MySQL packets, keeping track of the sequence number
+type packetConn struct {
+	net.Conn
+	reader   *bufio.Reader
+	sequence byte
+}
+
+func newPacketConn(conn net.Conn) *packetConn {
+	return &packetConn{Conn: conn, reader: bufio.NewReader(conn)}
+}
+
+// readPacket returns the payload of the next packet, joining the packets of a large payload
+func (c *packetConn) readPacket() ([]byte, error) {
+	var payload []byte
+	for {
+		header := make([]byte, 4)
+		_, err := io.ReadFull(c.reader, header)
+		if err != nil {
+			return nil, err
+		}
+		length := int(uint32(header[0]) | uint32(header[1])<<8 | uint32(header[2])<<16)
+		c.sequence = header[3] + 1
+		data := make([]byte, length)
+		_, err = io.ReadFull(c.reader, data)
+		if err != nil {
+			return nil, err
+		}
+		payload = append(payload, data...)
+		if length < maxPacketSize {
+			return payload, nil
+		}
+	}
+}
+
+// appendPacket adds the header and a payload of a packet to buf.
+// The answers of a mock server never need more than one packet for a payload
+func (c *packetConn) appendPacket(buf, payload []byte) ([]byte, error) {
+	if len(payload) >= maxPacketSize {
+		return buf, fmt.Errorf("packet of %d bytes is too large", len(payload))
+	}
+	buf = append(buf, byte(len(payload)), byte(len(payload)>>8), byte(len(payload)>>16), c.sequence)
+	c.sequence++
+	return append(buf, payload...), nil
+}
+
+// writePacket sends a payload
+func (c *packetConn) writePacket(payload []byte) error {
+	packet, err := c.appendPacket(make([]byte, 0, len(payload)+4), payload)
+	if err != nil {
+		return err
+	}
+	_, err = c.Write(packet)
+	return err
+}
+
+func appendLengthEncodedInt(buf []byte, n uint64) []byte {
+	switch {
+	case n < 251:
+		return append(buf, byte(n))
+	case n < 1<<16:
+		return append(buf, 0xfc, byte(n), byte(n>>8))
+	case n < 1<<24:
+		return append(buf, 0xfd, byte(n), byte(n>>8), byte(n>>16))
+	default:
+		buf = append(buf, 0xfe)
+		return binary.LittleEndian.AppendUint64(buf, n)
+	}
+}
+
+func appendLengthEncodedString(buf []byte, s string) []byte {
+	buf = appendLengthEncodedInt(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+// newScramble returns the random data used by the client to encode its password.
+// The bytes are printable, as the real server does, since the data is also NUL terminated
+func newScramble() ([]byte, error) {
+	scramble := make([]byte, 20)
+	_, err := rand.Read(scramble)
+	if err != nil {
+		return nil, err
+	}
+	for i, b := range scramble {
+		scramble[i] = b%94 + 33
+	}
+	return scramble, nil
+}
+
+// writeHandshake sends the initial handshake, protocol version 10
+func (c *packetConn) writeHandshake(version string, connectionId uint32, scramble []byte) error {
+	buf := []byte{10}
+	buf = append(buf, version...)
+	buf = append(buf, 0)
+	buf = binary.LittleEndian.AppendUint32(buf, connectionId)
+	buf = append(buf, scramble[:8]...)
+	buf = append(buf, 0)
+	buf = binary.LittleEndian.AppendUint16(buf, uint16(serverCapabilities&0xffff))
+	buf = append(buf, charsetUtf8mb4)
+	buf = binary.LittleEndian.AppendUint16(buf, serverStatusAutocommit)
+	buf = binary.LittleEndian.AppendUint16(buf, uint16(serverCapabilities>>16))
+	buf = append(buf, byte(len(scramble)+1))
+	buf = append(buf, make([]byte, 10)...)
+	buf = append(buf, scramble[8:]...)
+	buf = append(buf, 0)
+	buf = append(buf, nativePasswordAuth...)
+	buf = append(buf, 0)
+	return c.writePacket(buf)
+}
+
+// handshakeResponse is the part of the client answer to the handshake that the mock server uses.
+// Passwords are not checked
+type handshakeResponse struct {
+	user   string
+	schema string
+}
+
+var errMalformedPacket = fmt.Errorf("malformed packet")
+
+// readNulString returns the string that starts at a given position, and the position after its terminator
+func readNulString(data []byte, pos int) (string, int, error) {
+	if pos > len(data) {
+		return "", pos, errMalformedPacket
+	}
+	end := bytes.IndexByte(data[pos:], 0)
+	if end < 0 {
+		return "", pos, errMalformedPacket
+	}
+	return string(data[pos : pos+end]), pos + end + 1, nil
+}
+
+// readLengthEncodedInt returns the integer that starts at a given position, and the position after it
+func readLengthEncodedInt(data []byte, pos int) (uint64, int, error) {
+	if pos >= len(data) {
+		return 0, pos, errMalformedPacket
+	}
+	size := 0
+	switch data[pos] {
+	case 0xfc:
+		size = 2
+	case 0xfd:
+		size = 3
+	case 0xfe:
+		size = 8
+	default:
+		return uint64(data[pos]), pos + 1, nil
+	}
+	if pos+1+size > len(data) {
+		return 0, pos, errMalformedPacket
+	}
+	var n uint64
+	for i := size; i > 0; i-- {
+		n = n<<8 | uint64(data[pos+i])
+	}
+	return n, pos + 1 + size, nil
+}
+
+func parseHandshakeResponse(data []byte) (handshakeResponse, error) {
+	var response handshakeResponse
+	// capabilities (4), max packet size (4), character set (1), filler (23)
+	if len(data) < 32 {
+		return response, errMalformedPacket
+	}
+	capabilities := binary.LittleEndian.Uint32(data)
+	if capabilities&clientProtocol41 == 0 {
+		return response, fmt.Errorf("client does not support protocol 4.1")
+	}
+	user, pos, err := readNulString(data, 32)
+	if err != nil {
+		return response, err
+	}
+	response.user = user
+	var authLength uint64
+	switch {
+	case capabilities&clientPluginAuthLenenc != 0:
+		authLength, pos, err = readLengthEncodedInt(data, pos)
+		if err != nil {
+			return response, err
+		}
+	case capabilities&clientSecureConnection != 0:
+		if pos >= len(data) {
+			return response, errMalformedPacket
+		}
+		authLength = uint64(data[pos])
+		pos++
+	default:
+		_, pos, err = readNulString(data, pos)
+		if err != nil {
+			return response, err
+		}
+	}
+	pos += int(authLength)
+	if pos > len(data) {
+		return response, errMalformedPacket
+	}
+	if capabilities&clientConnectWithDb != 0 && pos < len(data) {
+		response.schema, _, err = readNulString(data, pos)
+		if err != nil {
+			return response, err
+		}
+	}
+	return response, nil
+}
+
+func (c *packetConn) writeOk(affectedRows uint64) error {
+	buf := []byte{0x00}
+	buf = appendLengthEncodedInt(buf, affectedRows)
+	buf = appendLengthEncodedInt(buf, 0)
+	buf = binary.LittleEndian.AppendUint16(buf, serverStatusAutocommit)
+	buf = binary.LittleEndian.AppendUint16(buf, 0)
+	return c.writePacket(buf)
+}
+
+func (c *packetConn) writeError(queryError *QueryError) error {
+	sqlState := queryError.SqlState
+	if len(sqlState) != 5 {
+		sqlState = "HY000"
+	}
+	buf := []byte{0xff}
+	buf = binary.LittleEndian.AppendUint16(buf, queryError.Code)
+	buf = append(buf, '#')
+	buf = append(buf, sqlState...)
+	buf = append(buf, queryError.Message...)
+	return c.writePacket(buf)
+}
+
+func eofPayload() []byte {
+	buf := []byte{0xfe}
+	buf = binary.LittleEndian.AppendUint16(buf, 0)
+	return binary.LittleEndian.AppendUint16(buf, serverStatusAutocommit)
+}
+
+func (c *packetConn) writeEof() error {
+	return c.writePacket(eofPayload())
+}
+
+// writeResultSet sends a text result set, where all columns are strings. NULL values are nil.
+// The whole result set is sent with a single write, as the real server does: some clients
+// keep references to their read buffer until the final EOF, and break when it arrives later
+func (c *packetConn) writeResultSet(columns []string, rows [][]*string) error {
+	packets, err := c.appendPacket(nil, appendLengthEncodedInt(nil, uint64(len(columns))))
+	if err != nil {
+		return err
+	}
+	for _, column := range columns {
+		buf := appendLengthEncodedString(nil, "def")
+		buf = appendLengthEncodedString(buf, "") // schema
+		buf = appendLengthEncodedString(buf, "") // table
+		buf = appendLengthEncodedString(buf, "") // original table
+		buf = appendLengthEncodedString(buf, column)
+		buf = appendLengthEncodedString(buf, column)
+		buf = append(buf, 0x0c)
+		buf = binary.LittleEndian.AppendUint16(buf, charsetUtf8)
+		buf = binary.LittleEndian.AppendUint32(buf, 1024)
+		buf = append(buf, typeVarString)
+		buf = binary.LittleEndian.AppendUint16(buf, 0) // flags
+		buf = append(buf, 0, 0, 0)                     // decimals, filler
+		packets, err = c.appendPacket(packets, buf)
+		if err != nil {
+			return err
+		}
+	}
+	packets, err = c.appendPacket(packets, eofPayload())
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		var buf []byte
+		for i := range columns {
+			if i >= len(row) || row[i] == nil {
+				buf = append(buf, nullValue)
+				continue
+			}
+			buf = appendLengthEncodedString(buf, *row[i])
+		}
+		packets, err = c.appendPacket(packets, buf)
+		if err != nil {
+			return err
+		}
+	}
+	packets, err = c.appendPacket(packets, eofPayload())
+	if err != nil {
+		return err
+	}
+	_, err = c.Write(packets)
+	return err
+}
@@ -0,0 +1,361 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2022 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mockserver
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// queryResult is the answer to a query: a result set, an error, or the number of affected rows
+type queryResult struct {
+	columns      []string
+	rows         [][]*string
+	affectedRows uint64
+	err          error
+}
+
+// replicaState is what the server remembers of CHANGE REPLICATION SOURCE and START REPLICA
+type replicaState struct {
+	configured bool
+	running    bool
+	host       string
+	port       string
+	user       string
+}
+
+var (
+	reShowReplicaStatus = regexp.MustCompile(`(?i)^show\s+(slave|replica)\s+status(\s+for\s+channel\s+.*)?$`)
+	reShowSourceStatus  = regexp.MustCompile(`(?i)^show\s+(master|binary\s+log)\s+status$`)
+	reChangeSource      = regexp.MustCompile(`(?is)^change\s+(master|replication\s+source)\s+to\s+(.*?)(\s+for\s+channel\s+.*)?$`)
+	reSourceOption      = regexp.MustCompile(`(?i)(\w+)\s*=\s*('[^']*'|"[^"]*"|\w+)`)
+	reReplicaCommand    = regexp.MustCompile(`(?i)^(start|stop|reset)\s+(slave|replica)(\s+all)?\b`)
+	reShowVariables     = regexp.MustCompile(`(?i)^show\s+(?:(?:global|session)\s+)?(variables|status)(?:\s+like\s+'([^']*)')?$`)
+	reDumpThreads       = regexp.MustCompile(`(?is)^select\s+count\(\*\)\s+from\s+information_schema\.processlist\s+where\s+command\s+like\s+'binlog dump%'$`)
+	reUse               = regexp.MustCompile("(?i)^use\\s+`?(\\w+)`?$")
+	reSelect            = regexp.MustCompile(`(?is)^select\s+(.+?)(?:\s+from\s+performance_schema\.global_status\s+where\s+variable_name\s*=\s*'(\w+)')?(?:\s+limit\s+\d+)?$`)
+	reAlias             = regexp.MustCompile("(?is)^(.+?)\\s+as\\s+`?(\\w+)`?$")
+	reVariable          = regexp.MustCompile(`(?i)^@@(?:(?:global|session)\.)?(\w+)$`)
+	reNumber            = regexp.MustCompile(`^-?\d+(\.\d+)?$`)
+	reQuoted            = regexp.MustCompile(`^'([^']*)'$|^"([^"]*)"$`)
+
+	// Statements that change data or settings are accepted, and do nothing
+	acceptedStatements = []string{
+		"alter", "analyze", "begin", "commit", "create", "delete", "do", "drop", "flush",
+		"grant", "insert", "install", "kill", "lock", "optimize", "purge", "rename", "replace",
+		"revoke", "rollback", "set", "start transaction", "truncate", "uninstall", "unlock", "update",
+	}
+)
+
+func errUnsupported(query string) error {
+	return &QueryError{Code: 1064, SqlState: "42000",
+		Message: fmt.Sprintf("the mock server does not know how to answer '%s'", query)}
+}
+
+func stringPointer(s string) *string {
+	return &s
+}
+
+// execute finds the answer to a query: a scripted one, if any matches, or a built-in one
+func (s *Server) execute(sess *session, query string) queryResult {
+	query = strings.TrimSpace(strings.TrimRight(strings.TrimSpace(query), ";"))
+	for _, scripted := range s.queries {
+		if !scripted.matcher.MatchString(query) {
+			continue
+		}
+		if scripted.Error != "" {
+			code := scripted.ErrorCode
+			if code == 0 {
+				code = 1105
+			}
+			return queryResult{err: &QueryError{Code: code, SqlState: "HY000", Message: scripted.Error}}
+		}
+		return queryResult{columns: scripted.Columns, rows: scripted.Rows, affectedRows: scripted.AffectedRows}
+	}
+
+	if matches := reShowReplicaStatus.FindStringSubmatch(query); matches != nil {
+		return s.replicaStatus(strings.EqualFold(matches[1], "slave"))
+	}
+	if matches := reShowSourceStatus.FindStringSubmatch(query); matches != nil {
+		return queryResult{
+			columns: []string{"File", "Position", "Binlog_Do_DB", "Binlog_Ignore_DB", "Executed_Gtid_Set"},
+			rows: [][]*string{{stringPointer("binlog.000001"), stringPointer("157"), stringPointer(""),
+				stringPointer(""), stringPointer(s.variables["gtid_executed"])}},
+		}
+	}
+	if matches := reChangeSource.FindStringSubmatch(query); matches != nil {
+		s.changeSource(matches[2])
+		return queryResult{}
+	}
+	if matches := reReplicaCommand.FindStringSubmatch(query); matches != nil {
+		return s.replicaCommand(strings.ToLower(matches[1]), matches[3] != "")
+	}
+	if matches := reShowVariables.FindStringSubmatch(query); matches != nil {
+		return s.showVariables(strings.EqualFold(matches[1], "status"), matches[2])
+	}
+	if reDumpThreads.MatchString(query) {
+		// Replicas of a mock server never connect to it
+		return queryResult{columns: []string{"count(*)"}, rows: [][]*string{{stringPointer("0")}}}
+	}
+	if matches := reUse.FindStringSubmatch(query); matches != nil {
+		sess.schema = matches[1]
+		return queryResult{}
+	}
+	if strings.EqualFold(query, "shutdown") {
+		go func() {
+			// Gives the client the time to read the answer
+			time.Sleep(100 * time.Millisecond)
+			_ = s.Close()
+		}()
+		return queryResult{}
+	}
+	if matches := reSelect.FindStringSubmatch(query); matches != nil {
+		return s.selectExpressions(sess, matches[1], matches[2])
+	}
+	lowerQuery := strings.ToLower(strings.Join(strings.Fields(query), " "))
+	for _, statement := range acceptedStatements {
+		if lowerQuery == statement || strings.HasPrefix(lowerQuery, statement+" ") {
+			return queryResult{}
+		}
+	}
+	return queryResult{err: errUnsupported(query)}
+}
+
+// statusVariables returns the status variables that the server keeps
+func (s *Server) statusVariables() map[string]string {
+	return map[string]string{
+		"Uptime":            strconv.FormatInt(int64(time.Since(s.started).Seconds()), 10),
+		"Questions":         strconv.FormatUint(atomic.LoadUint64(&s.questions), 10),
+		"Connections":       strconv.FormatUint(uint64(atomic.LoadUint32(&s.connectionId)), 10),
+		"Threads_connected": strconv.FormatInt(atomic.LoadInt64(&s.connected), 10),
+	}
+}
+
+// showVariables answers SHOW VARIABLES and SHOW STATUS, with an optional LIKE pattern
+func (s *Server) showVariables(isStatus bool, pattern string) queryResult {
+	values := s.variables
+	if isStatus {
+		values = s.statusVariables()
+	}
+	var matcher *regexp.Regexp
+	if pattern != "" {
+		expression := regexp.QuoteMeta(pattern)
+		expression = strings.ReplaceAll(expression, "%", ".*")
+		expression = strings.ReplaceAll(expression, "_", ".")
+		matcher = regexp.MustCompile(`(?i)^` + expression + `$`)
+	}
+	var names []string
+	for name := range values {
+		if matcher == nil || matcher.MatchString(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	result := queryResult{columns: []string{"Variable_name", "Value"}}
+	for _, name := range names {
+		result.rows = append(result.rows, []*string{stringPointer(name), stringPointer(values[name])})
+	}
+	return result
+}
+
+// splitExpressions splits a list of expressions at the commas that are not inside quotes or parentheses
+func splitExpressions(text string) []string {
+	var expressions []string
+	var quote rune
+	depth := 0
+	start := 0
+	for i, c := range text {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == ',' && depth == 0:
+			expressions = append(expressions, strings.TrimSpace(text[start:i]))
+			start = i + 1
+		}
+	}
+	return append(expressions, strings.TrimSpace(text[start:]))
+}
+
+// selectExpressions answers a SELECT without tables, or one that reads a status variable
+// from performance_schema.global_status
+func (s *Server) selectExpressions(sess *session, list, statusVariable string) queryResult {
+	var result queryResult
+	var row []*string
+	for _, expression := range splitExpressions(list) {
+		name := expression
+		if matches := reAlias.FindStringSubmatch(expression); matches != nil {
+			expression = strings.TrimSpace(matches[1])
+			name = matches[2]
+		}
+		value, err := s.evaluate(sess, expression, statusVariable)
+		if err != nil {
+			return queryResult{err: err}
+		}
+		result.columns = append(result.columns, name)
+		row = append(row, value)
+	}
+	if statusVariable != "" {
+		// No rows when the status variable does not exist
+		if _, found := s.statusVariables()[statusVariable]; !found {
+			return result
+		}
+	}
+	result.rows = [][]*string{row}
+	return result
+}
+
+// evaluate returns the value of a single expression. NULL is nil
+func (s *Server) evaluate(sess *session, expression, statusVariable string) (*string, error) {
+	if matches := reVariable.FindStringSubmatch(expression); matches != nil {
+		value, found := s.variables[strings.ToLower(matches[1])]
+		if !found {
+			return nil, &QueryError{Code: 1193, SqlState: "HY000",
+				Message: fmt.Sprintf("Unknown system variable '%s'", matches[1])}
+		}
+		return stringPointer(value), nil
+	}
+	if reNumber.MatchString(expression) {
+		return stringPointer(expression), nil
+	}
+	if matches := reQuoted.FindStringSubmatch(expression); matches != nil {
+		return stringPointer(matches[1] + matches[2]), nil
+	}
+	if statusVariable != "" {
+		switch strings.ToLower(expression) {
+		case "variable_name":
+			return stringPointer(statusVariable), nil
+		case "variable_value":
+			value := s.statusVariables()[statusVariable]
+			return stringPointer(value), nil
+		}
+	}
+	switch strings.ToLower(strings.ReplaceAll(expression, " ", "")) {
+	case "null":
+		return nil, nil
+	case "version()":
+		return stringPointer(s.options.Version), nil
+	case "database()", "schema()":
+		if sess.schema == "" {
+			return nil, nil
+		}
+		return stringPointer(sess.schema), nil
+	case "user()", "current_user()", "current_user", "session_user()", "system_user()":
+		return stringPointer(sess.user + "@" + sess.host), nil
+	case "connection_id()":
+		return stringPointer(strconv.FormatUint(uint64(sess.id), 10)), nil
+	case "now()", "current_timestamp", "current_timestamp()", "sysdate()":
+		return stringPointer(time.Now().Format("2006-01-02 15:04:05")), nil
+	}
+	return nil, errUnsupported(expression)
+}
+
+// changeSource records the options of CHANGE REPLICATION SOURCE TO (or CHANGE MASTER TO)
+func (s *Server) changeSource(options string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, option := range reSourceOption.FindAllStringSubmatch(options, -1) {
+		value := strings.Trim(option[2], `'"`)
+		switch strings.ToLower(option[1]) {
+		case "master_host", "source_host":
+			s.replica.host = value
+		case "master_port", "source_port":
+			s.replica.port = value
+		case "master_user", "source_user":
+			s.replica.user = value
+		}
+	}
+	s.replica.configured = true
+}
+
+// replicaCommand runs START, STOP, and RESET REPLICA (or SLAVE)
+func (s *Server) replicaCommand(command string, all bool) queryResult {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	switch command {
+	case "start":
+		if !s.replica.configured {
+			return queryResult{err: &QueryError{Code: 1200, SqlState: "HY000",
+				Message: "The server is not configured as replica; fix in config file or with CHANGE REPLICATION SOURCE TO"}}
+		}
+		s.replica.running = true
+	case "stop":
+		s.replica.running = false
+	case "reset":
+		if all {
+			s.replica = replicaState{}
+		}
+	}
+	return queryResult{}
+}
+
+// replicaStatus answers SHOW REPLICA STATUS, or SHOW SLAVE STATUS with the old column names.
+// There are no rows when the server is not a replica
+func (s *Server) replicaStatus(legacyNames bool) queryResult {
+	s.mutex.Lock()
+	replica := s.replica
+	s.mutex.Unlock()
+	running := "No"
+	var lag *string
+	state := ""
+	if replica.running {
+		running = "Yes"
+		lag = stringPointer("0")
+		state = "Waiting for source to send event"
+	}
+	columns := []struct {
+		name       string
+		legacyName string
+		value      *string
+	}{
+		{"Replica_IO_State", "Slave_IO_State", stringPointer(state)},
+		{"Source_Host", "Master_Host", stringPointer(replica.host)},
+		{"Source_User", "Master_User", stringPointer(replica.user)},
+		{"Source_Port", "Master_Port", stringPointer(replica.port)},
+		{"Replica_IO_Running", "Slave_IO_Running", stringPointer(running)},
+		{"Replica_SQL_Running", "Slave_SQL_Running", stringPointer(running)},
+		{"Last_IO_Error", "Last_IO_Error", stringPointer("")},
+		{"Last_SQL_Error", "Last_SQL_Error", stringPointer("")},
+		{"Seconds_Behind_Source", "Seconds_Behind_Master", lag},
+		{"Executed_Gtid_Set", "Executed_Gtid_Set", stringPointer(s.variables["gtid_executed"])},
+	}
+	var result queryResult
+	var row []*string
+	for _, column := range columns {
+		name := column.name
+		if legacyNames {
+			name = column.legacyName
+		}
+		result.columns = append(result.columns, name)
+		row = append(row, column.value)
+	}
+	if replica.configured {
+		result.rows = [][]*string{row}
+	}
+	return result
+}
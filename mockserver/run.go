@@ -0,0 +1,168 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2022 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mockserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/globals"
+)
+
+// Script is the contents of a file of scripted answers
+type Script struct {
+	Variables map[string]string `json:"variables,omitempty"`
+	Queries   []ScriptedQuery   `json:"queries,omitempty"`
+}
+
+// ReadScript reads a JSON file of scripted answers
+func ReadScript(fileName string) (Script, error) {
+	var script Script
+	text, err := os.ReadFile(fileName) // #nosec G304
+	if err != nil {
+		return script, err
+	}
+	err = json.Unmarshal(text, &script)
+	if err != nil {
+		return script, fmt.Errorf("error decoding script %s: %s", fileName, err)
+	}
+	return script, nil
+}
+
+// AddScript merges a script into the options. Scripted variables replace the existing ones
+func (o *Options) AddScript(script Script) {
+	if o.Variables == nil {
+		o.Variables = make(map[string]string)
+	}
+	for name, value := range script.Variables {
+		o.Variables[normalizeVariableName(name)] = value
+	}
+	o.Queries = append(o.Queries, script.Queries...)
+}
+
+// OptionsFromDefaultsFile creates the options of a mock server from the [mysqld] section
+// of a sandbox configuration file. All the options become system variables.
+// The version comes from the sandbox description in the same directory, and the
+// scripted answers from the file globals.FnMockServerJson, when they exist
+func OptionsFromDefaultsFile(defaultsFile string) (Options, error) {
+	options := Options{Variables: make(map[string]string)}
+	lines, err := common.SlurpAsLines(defaultsFile)
+	if err != nil {
+		return options, err
+	}
+	inServerSection := false
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			inServerSection = line == "[mysqld]"
+			continue
+		}
+		if !inServerSection {
+			continue
+		}
+		name, value, found := strings.Cut(line, "=")
+		name = normalizeVariableName(name)
+		value = strings.TrimSpace(value)
+		if !found {
+			// Boolean options, such as log-slave-updates
+			value = "ON"
+		}
+		options.Variables[name] = value
+	}
+	port := options.Variables["port"]
+	if port == "" {
+		return options, fmt.Errorf("no port found in %s", defaultsFile)
+	}
+	host := options.Variables["bind_address"]
+	if host == "" || host == "*" {
+		host = "127.0.0.1"
+	}
+	options.Address = net.JoinHostPort(host, port)
+	options.Socket = options.Variables["socket"]
+	options.PidFile = options.Variables["pid_file"]
+	if serverId := options.Variables["server_id"]; serverId != "" {
+		options.ServerId, err = strconv.Atoi(serverId)
+		if err != nil {
+			return options, fmt.Errorf("invalid server-id '%s' in %s", serverId, defaultsFile)
+		}
+	}
+
+	sandboxDir := path.Dir(defaultsFile)
+	sbDescription, err := common.ReadSandboxDescription(sandboxDir)
+	if err == nil {
+		options.Version = sbDescription.Version
+	}
+	scriptFile := path.Join(sandboxDir, globals.FnMockServerJson)
+	if common.FileExists(scriptFile) {
+		script, err := ReadScript(scriptFile)
+		if err != nil {
+			return options, err
+		}
+		options.AddScript(script)
+	}
+	return options, nil
+}
+
+// Run starts a mock server, and writes its PID file. It returns when the server
+// receives SIGINT or SIGTERM, when a client asks for a shutdown, or when the PID file is removed
+func Run(options Options) error {
+	server, err := New(options)
+	if err != nil {
+		return err
+	}
+	if options.PidFile != "" {
+		err = os.WriteFile(options.PidFile, []byte(fmt.Sprintf("%d\n", os.Getpid())), 0600)
+		if err != nil {
+			_ = server.Close()
+			return err
+		}
+		defer os.Remove(options.PidFile)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		ticker := time.NewTicker(200 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				_ = server.Close()
+				return
+			case <-server.Done():
+				return
+			case <-ticker.C:
+				if options.PidFile != "" && !common.FileExists(options.PidFile) {
+					_ = server.Close()
+					return
+				}
+			}
+		}
+	}()
+	return server.Serve()
+}
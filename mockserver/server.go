@@ -0,0 +1,343 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2022 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package mockserver implements a server that speaks the MySQL protocol, without storing any data.
+// It answers the handshake, the queries that dbdeployer uses to inspect a server
+// (version, server ID, replication status, status variables), and scripted result sets,
+// so that deployment, import, and replication checks can be tested without MySQL binaries.
+package mockserver
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultVersion is the version that a server reports when none is given
+const DefaultVersion = "8.0.36"
+
+// QueryError is an error sent to the client, with a MySQL error code
+type QueryError struct {
+	Code     uint16
+	SqlState string
+	Message  string
+}
+
+func (e *QueryError) Error() string {
+	return fmt.Sprintf("ERROR %d (%s): %s", e.Code, e.SqlState, e.Message)
+}
+
+// ScriptedQuery is a canned answer to the queries that match a regular expression.
+// The expression is case-insensitive, and must match the whole statement, without the final semicolon.
+// The answer is a result set (columns and rows, where null values are nil), an error,
+// or the number of affected rows when there are no columns
+type ScriptedQuery struct {
+	Query        string      `json:"query"`
+	Columns      []string    `json:"columns,omitempty"`
+	Rows         [][]*string `json:"rows,omitempty"`
+	AffectedRows uint64      `json:"affected_rows,omitempty"`
+	Error        string      `json:"error,omitempty"`
+	ErrorCode    uint16      `json:"error_code,omitempty"`
+	matcher      *regexp.Regexp
+}
+
+// Options define the identity of a mock server and where it listens
+type Options struct {
+	Address   string            // TCP address. With port 0, a free port is chosen
+	Socket    string            // Unix socket, optional
+	PidFile   string            // Written by Run, and removed when the server stops
+	Version   string            // Returned by @@version
+	ServerId  int               // Returned by @@server_id
+	Variables map[string]string // System variables, added to or replacing the built-in ones
+	Queries   []ScriptedQuery   // Checked before the built-in answers, in order
+}
+
+// Server is a mock MySQL server
+type Server struct {
+	options      Options
+	listeners    []net.Listener
+	variables    map[string]string
+	queries      []ScriptedQuery
+	started      time.Time
+	connectionId uint32
+	connections  sync.WaitGroup
+	questions    uint64
+	connected    int64
+	mutex        sync.Mutex
+	open         map[net.Conn]bool
+	replica      replicaState
+	done         chan struct{}
+	closeOnce    sync.Once
+}
+
+// New creates a mock server, and starts listening. Connections are accepted by Serve
+func New(options Options) (*Server, error) {
+	if options.Address == "" && options.Socket == "" {
+		return nil, fmt.Errorf("mock server needs a TCP address or a socket")
+	}
+	if options.Version == "" {
+		options.Version = DefaultVersion
+	}
+	server := &Server{
+		options: options,
+		started: time.Now(),
+		open:    make(map[net.Conn]bool),
+		done:    make(chan struct{}),
+	}
+	for _, query := range options.Queries {
+		matcher, err := regexp.Compile(`(?is)^(?:` + query.Query + `)$`)
+		if err != nil {
+			return nil, fmt.Errorf("invalid scripted query '%s': %s", query.Query, err)
+		}
+		query.matcher = matcher
+		server.queries = append(server.queries, query)
+	}
+	if options.Address != "" {
+		listener, err := net.Listen("tcp", options.Address)
+		if err != nil {
+			return nil, err
+		}
+		server.listeners = append(server.listeners, listener)
+	}
+	if options.Socket != "" {
+		// A socket left behind by a server that was killed is replaced
+		if info, err := os.Stat(options.Socket); err == nil && info.Mode()&os.ModeSocket != 0 {
+			_ = os.Remove(options.Socket)
+		}
+		listener, err := net.Listen("unix", options.Socket)
+		if err != nil {
+			server.closeListeners()
+			return nil, err
+		}
+		server.listeners = append(server.listeners, listener)
+	}
+	server.variables = server.builtinVariables()
+	for name, value := range options.Variables {
+		server.variables[normalizeVariableName(name)] = value
+	}
+	return server, nil
+}
+
+// normalizeVariableName turns an option name, such as "server-id", into a variable name
+func normalizeVariableName(name string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(name), "-", "_"))
+}
+
+func (s *Server) builtinVariables() map[string]string {
+	hostname, _ := os.Hostname()
+	return map[string]string{
+		"version":                s.options.Version,
+		"version_comment":        "dbdeployer mock server",
+		"version_compile_os":     runtime.GOOS,
+		"server_id":              strconv.Itoa(s.options.ServerId),
+		"server_uuid":            fmt.Sprintf("00000000-0000-0000-0000-%012d", s.options.ServerId),
+		"port":                   strconv.Itoa(s.Port()),
+		"socket":                 s.options.Socket,
+		"hostname":               hostname,
+		"max_allowed_packet":     "67108864",
+		"autocommit":             "1",
+		"read_only":              "0",
+		"super_read_only":        "0",
+		"log_bin":                "1",
+		"gtid_mode":              "OFF",
+		"gtid_executed":          "",
+		"character_set_server":   "utf8mb4",
+		"transaction_isolation":  "REPEATABLE-READ",
+		"lower_case_table_names": "0",
+		"sql_mode":               "",
+	}
+}
+
+// Port returns the TCP port of the server, or 0 when it only listens on a socket
+func (s *Server) Port() int {
+	for _, listener := range s.listeners {
+		if address, ok := listener.Addr().(*net.TCPAddr); ok {
+			return address.Port
+		}
+	}
+	return 0
+}
+
+// Address returns the TCP address of the server, or an empty string when it only listens on a socket
+func (s *Server) Address() string {
+	for _, listener := range s.listeners {
+		if address, ok := listener.Addr().(*net.TCPAddr); ok {
+			return address.String()
+		}
+	}
+	return ""
+}
+
+// Done is closed when the server stops
+func (s *Server) Done() <-chan struct{} {
+	return s.done
+}
+
+// Serve accepts connections until the server is closed
+func (s *Server) Serve() error {
+	errorChannel := make(chan error, len(s.listeners))
+	for _, listener := range s.listeners {
+		go func(listener net.Listener) {
+			errorChannel <- s.accept(listener)
+		}(listener)
+	}
+	var err error
+	for range s.listeners {
+		listenerErr := <-errorChannel
+		if err == nil && listenerErr != nil {
+			err = listenerErr
+			// One failed listener stops the whole server
+			_ = s.Close()
+		}
+	}
+	s.connections.Wait()
+	return err
+}
+
+func (s *Server) accept(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			select {
+			case <-s.done:
+				return nil
+			default:
+				return err
+			}
+		}
+		s.mutex.Lock()
+		s.open[conn] = true
+		s.mutex.Unlock()
+		s.connections.Add(1)
+		go func() {
+			defer s.connections.Done()
+			s.handleConnection(conn)
+			s.mutex.Lock()
+			delete(s.open, conn)
+			s.mutex.Unlock()
+		}()
+	}
+}
+
+func (s *Server) closeListeners() {
+	for _, listener := range s.listeners {
+		_ = listener.Close()
+	}
+}
+
+// Close stops the server, and closes all the client connections
+func (s *Server) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.done)
+		s.closeListeners()
+		s.mutex.Lock()
+		for conn := range s.open {
+			_ = conn.Close()
+		}
+		s.mutex.Unlock()
+	})
+	return nil
+}
+
+// session is the state of one client connection
+type session struct {
+	id     uint32
+	user   string
+	host   string
+	schema string
+}
+
+func (s *Server) handleConnection(netConn net.Conn) {
+	conn := newPacketConn(netConn)
+	defer conn.Close()
+	atomic.AddInt64(&s.connected, 1)
+	defer atomic.AddInt64(&s.connected, -1)
+
+	sess := &session{id: atomic.AddUint32(&s.connectionId, 1), host: "localhost"}
+	if address, ok := netConn.RemoteAddr().(*net.TCPAddr); ok {
+		sess.host = address.IP.String()
+	}
+	scramble, err := newScramble()
+	if err != nil {
+		return
+	}
+	if conn.writeHandshake(s.options.Version, sess.id, scramble) != nil {
+		return
+	}
+	data, err := conn.readPacket()
+	if err != nil {
+		return
+	}
+	response, err := parseHandshakeResponse(data)
+	if err != nil {
+		_ = conn.writeError(&QueryError{Code: 1043, SqlState: "08S01", Message: "Bad handshake"})
+		return
+	}
+	sess.user = response.user
+	sess.schema = response.schema
+	if conn.writeOk(0) != nil {
+		return
+	}
+
+	for {
+		data, err := conn.readPacket()
+		if err != nil || len(data) == 0 {
+			return
+		}
+		switch data[0] {
+		case comQuit:
+			return
+		case comPing:
+			err = conn.writeOk(0)
+		case comInitDb:
+			sess.schema = string(data[1:])
+			err = conn.writeOk(0)
+		case comShutdown:
+			err = conn.writeEof()
+			_ = s.Close()
+		case comQuery:
+			atomic.AddUint64(&s.questions, 1)
+			err = s.answer(conn, s.execute(sess, string(data[1:])))
+		default:
+			err = conn.writeError(&QueryError{Code: 1047, SqlState: "08S01", Message: "Unknown command"})
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// answer sends the result of a query to the client
+func (s *Server) answer(conn *packetConn, result queryResult) error {
+	var queryError *QueryError
+	switch {
+	case errors.As(result.err, &queryError):
+		return conn.writeError(queryError)
+	case result.err != nil:
+		return conn.writeError(&QueryError{Code: 1105, SqlState: "HY000", Message: result.err.Error()})
+	case len(result.columns) > 0:
+		return conn.writeResultSet(result.columns, result.rows)
+	default:
+		return conn.writeOk(result.affectedRows)
+	}
+}
@@ -16,6 +16,7 @@
 package ops
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path"
@@ -50,6 +51,7 @@ type DownloadsOptions struct {
 	IsShell           bool
 	Quiet             bool
 	Retries           int64
+	Chunks            int
 	VerbosityLevel    int
 	ProgressStep      int64
 }
@@ -104,15 +106,24 @@ func GetRemoteTarball(options DownloadsOptions) error {
 		if !options.Quiet {
 			fmt.Printf("Downloading %s\n", tarball.Name)
 		}
-		err = rest.DownloadFileWithRetry(absPath, tarball.Url, options.Quiet, options.ProgressStep, options.Retries)
-		if err != nil {
+		// The checksum is verified during the download, and its result is reported by postDownloadOps
+		err = rest.DownloadWithOptions(rest.DownloadOptions{
+			Url:          tarball.Url,
+			FileName:     absPath,
+			Progress:     !options.Quiet,
+			ProgressStep: options.ProgressStep,
+			Retries:      options.Retries,
+			Parallel:     options.Chunks,
+			Checksum:     tarball.Checksum,
+		})
+		if err != nil && !errors.Is(err, rest.ErrChecksumMismatch) {
 			return fmt.Errorf("error getting remote file %s - %s", fileName, err)
 		}
-		downloadedTarball = absPath
-		err = postDownloadOps(tarball, fileName, absPath)
+		err = postDownloadOps(tarball, fileName, absPath, err)
 		if err != nil {
 			return err
 		}
+		downloadedTarball = absPath
 	}
 	if options.Unpack {
 		target := path.Join(options.SandboxBinary) // add target here
@@ -151,16 +162,17 @@ func GetRemoteTarball(options DownloadsOptions) error {
 	return nil
 }
 
-func postDownloadOps(tarball downloads.TarballDescription, fileName, absPath string) error {
+// postDownloadOps reports the result of a download, where checksumErr is the
+// outcome of the checksum verification made while the data arrived
+func postDownloadOps(tarball downloads.TarballDescription, fileName, absPath string, checksumErr error) error {
+	if checksumErr != nil {
+		return fmt.Errorf("error comparing checksum for tarball %s - %s", fileName, checksumErr)
+	}
 	fmt.Printf("File %s downloaded\n", absPath)
 
 	if tarball.Checksum == "" {
 		fmt.Println("No checksum to compare")
 	} else {
-		err := downloads.CompareTarballChecksum(tarball, absPath)
-		if err != nil {
-			return fmt.Errorf("error comparing checksum for tarball %s - %s", fileName, err)
-		}
 		fmt.Println("Checksum matches")
 	}
 	warningMsg := getOSWarning(tarball)
@@ -0,0 +1,326 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2022 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ops
+
+import (
+	"fmt"
+	"path"
+	"sort"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/defaults"
+	"github.com/datacharmer/dbdeployer/globals"
+)
+
+// GroupMember is one row of performance_schema.replication_group_members,
+// with the name of the sandbox node that runs the member
+type GroupMember struct {
+	Node    string `json:"node"`
+	Id      string `json:"member_id"`
+	Host    string `json:"member_host"`
+	Port    int    `json:"member_port"`
+	State   string `json:"member_state"`
+	Role    string `json:"member_role"`
+	Version string `json:"member_version"`
+}
+
+// Member states and roles. OFFLINE and UNREACHABLE are also used for nodes that are not in the group view
+const (
+	GroupStateOffline     = "OFFLINE"
+	GroupStateUnreachable = "UNREACHABLE"
+	GroupStateOnline      = "ONLINE"
+	GroupStateError       = "ERROR"
+	GroupRolePrimary      = "PRIMARY"
+)
+
+// Member role and version were added to replication_group_members in 8.0.2
+var minimumGroupMemberRoleVersion = []int{8, 0, 2}
+
+// group_replication_set_as_primary() was added in 8.0.13
+var minimumGroupSetPrimaryVersion = []int{8, 0, 13}
+
+type groupSandbox struct {
+	sandboxDir    string
+	sbDesc        common.SandboxDescription
+	singlePrimary bool
+	nodes         []string
+	ports         map[int]string // client port of each node
+}
+
+func readGroupSandbox(sandboxDir string) (groupSandbox, error) {
+	gs := groupSandbox{sandboxDir: sandboxDir, ports: make(map[int]string)}
+	if !common.DirExists(sandboxDir) {
+		return gs, fmt.Errorf(globals.ErrDirectoryNotFound, sandboxDir)
+	}
+	sbDesc, err := common.ReadSandboxDescription(sandboxDir)
+	if err != nil {
+		return gs, err
+	}
+	gs.sbDesc = sbDesc
+	switch sbDesc.SBType {
+	case globals.SbTypeGroupSinglePrimary:
+		gs.singlePrimary = true
+	case globals.SbTypeGroupMultiPrimary:
+	default:
+		return gs, fmt.Errorf("sandbox %s has type '%s'. Only '%s' or '%s' sandboxes are supported",
+			sandboxDir, sbDesc.SBType, globals.SbTypeGroupMultiPrimary, globals.SbTypeGroupSinglePrimary)
+	}
+	for n := 1; n <= sbDesc.Nodes; n++ {
+		node := fmt.Sprintf("%s%d", defaults.Defaults().NodePrefix, n)
+		nodeDesc, err := common.ReadSandboxDescription(path.Join(sandboxDir, node))
+		if err != nil {
+			return gs, err
+		}
+		if len(nodeDesc.Port) == 0 {
+			return gs, fmt.Errorf("no ports found in %s", path.Join(sandboxDir, node))
+		}
+		gs.nodes = append(gs.nodes, node)
+		gs.ports[nodeDesc.Port[0]] = node
+	}
+	if len(gs.nodes) == 0 {
+		return gs, fmt.Errorf("no nodes found in %s", sandboxDir)
+	}
+	return gs, nil
+}
+
+func (gs groupSandbox) checkNode(node string) error {
+	for _, n := range gs.nodes {
+		if n == node {
+			return nil
+		}
+	}
+	return fmt.Errorf("node %s not found in %s", node, gs.sandboxDir)
+}
+
+// runInGroupNode runs a statement that does not return rows in one node of the group
+func (gs groupSandbox) runInGroupNode(node, statement string) error {
+	db, config, err := connectToSandbox(path.Join(gs.sandboxDir, node), true)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	_, err = db.Exec(statement)
+	if err != nil {
+		return fmt.Errorf("error running '%s' in server %s (%s): %s", statement, config.Addr, node, err)
+	}
+	return nil
+}
+
+// nodeMembers returns the group members as seen by one node
+func (gs groupSandbox) nodeMembers(node string) ([]GroupMember, error) {
+	db, config, err := connectToSandbox(path.Join(gs.sandboxDir, node), true)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	hasRole, err := common.GreaterOrEqualVersion(gs.sbDesc.Version, minimumGroupMemberRoleVersion)
+	if err != nil {
+		return nil, err
+	}
+	roleColumns := "'', ''"
+	if hasRole {
+		roleColumns = "ifnull(member_role, ''), ifnull(member_version, '')"
+	}
+	// A node where group replication was never started has a row with empty values
+	query := "select ifnull(member_id, ''), ifnull(member_host, ''), ifnull(member_port, 0), ifnull(member_state, ''), " +
+		roleColumns + " from performance_schema.replication_group_members"
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("error getting group members from server %s (%s): %s", config.Addr, node, err)
+	}
+	defer rows.Close()
+	var members []GroupMember
+	for rows.Next() {
+		var member GroupMember
+		err = rows.Scan(&member.Id, &member.Host, &member.Port, &member.State, &member.Role, &member.Version)
+		if err != nil {
+			return nil, fmt.Errorf("error reading group members from server %s (%s): %s", config.Addr, node, err)
+		}
+		member.Node = gs.ports[member.Port]
+		members = append(members, member)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	if !hasRole && gs.singlePrimary {
+		var primary string
+		err = db.GetSingleResult(config, "select variable_value from performance_schema.global_status "+
+			"where variable_name='group_replication_primary_member'", &primary)
+		if err == nil {
+			for i := range members {
+				if members[i].Id == primary {
+					members[i].Role = GroupRolePrimary
+				}
+			}
+		}
+	}
+	return members, nil
+}
+
+// GroupStatus returns the members of a group replication sandbox.
+// The group view is taken from the first node that is ONLINE. Nodes that are not part
+// of that view are listed as OFFLINE when their server answers, or UNREACHABLE otherwise
+func GroupStatus(sandboxDir string) ([]GroupMember, error) {
+	gs, err := readGroupSandbox(sandboxDir)
+	if err != nil {
+		return nil, err
+	}
+	var view []GroupMember
+	answering := make(map[string]bool)
+	// How each node sees itself: a node outside the group reports its own state (OFFLINE, ERROR)
+	ownView := make(map[string]GroupMember)
+	for _, node := range gs.nodes {
+		members, err := gs.nodeMembers(node)
+		if err != nil {
+			continue
+		}
+		answering[node] = true
+		for _, member := range members {
+			if member.Node != node {
+				continue
+			}
+			ownView[node] = member
+			if view == nil && member.State == GroupStateOnline {
+				view = members
+			}
+		}
+	}
+	if len(answering) == 0 {
+		return nil, fmt.Errorf("none of the nodes in %s is reachable", sandboxDir)
+	}
+	inView := make(map[string]bool)
+	for _, member := range view {
+		inView[member.Node] = true
+	}
+	for port, node := range gs.ports {
+		if inView[node] {
+			continue
+		}
+		member, found := ownView[node]
+		if !found {
+			host := gs.sbDesc.Host
+			if host == "" {
+				host = globals.LocalHostIP
+			}
+			member = GroupMember{Node: node, Host: host, Port: port, State: GroupStateUnreachable}
+			if answering[node] {
+				member.State = GroupStateOffline
+			}
+		}
+		view = append(view, member)
+	}
+	// Ports follow the order of the nodes
+	sort.Slice(view, func(i, j int) bool { return view[i].Port < view[j].Port })
+	return view, nil
+}
+
+// findMember returns the member for a node. GroupStatus lists all the nodes
+func findMember(members []GroupMember, node string) GroupMember {
+	for _, member := range members {
+		if member.Node == node {
+			return member
+		}
+	}
+	return GroupMember{Node: node, State: GroupStateUnreachable}
+}
+
+// GroupSetPrimary makes a node the primary of a single-primary group
+func GroupSetPrimary(sandboxDir, node string) error {
+	gs, err := readGroupSandbox(sandboxDir)
+	if err != nil {
+		return err
+	}
+	if !gs.singlePrimary {
+		return fmt.Errorf("sandbox %s is multi-primary: all its nodes are primary", sandboxDir)
+	}
+	err = gs.checkNode(node)
+	if err != nil {
+		return err
+	}
+	canSetPrimary, err := common.GreaterOrEqualVersion(gs.sbDesc.Version, minimumGroupSetPrimaryVersion)
+	if err != nil {
+		return err
+	}
+	if !canSetPrimary {
+		return fmt.Errorf(globals.ErrFeatureRequiresVersion, "changing the group primary",
+			common.IntSliceToDottedString(minimumGroupSetPrimaryVersion))
+	}
+	members, err := GroupStatus(sandboxDir)
+	if err != nil {
+		return err
+	}
+	member := findMember(members, node)
+	if member.State != GroupStateOnline {
+		return fmt.Errorf("node %s is not an online member of the group (state: %s)", node, member.State)
+	}
+	if member.Role == GroupRolePrimary {
+		return fmt.Errorf("node %s is already the primary", node)
+	}
+	return gs.runInGroupNode(node, fmt.Sprintf("SELECT group_replication_set_as_primary('%s')", member.Id))
+}
+
+// GroupExpel stops group replication in a node, which leaves the group
+func GroupExpel(sandboxDir, node string) error {
+	gs, err := readGroupSandbox(sandboxDir)
+	if err != nil {
+		return err
+	}
+	err = gs.checkNode(node)
+	if err != nil {
+		return err
+	}
+	members, err := GroupStatus(sandboxDir)
+	if err != nil {
+		return err
+	}
+	member := findMember(members, node)
+	if member.State == GroupStateOffline || member.State == GroupStateUnreachable {
+		return fmt.Errorf("node %s is not a member of the group (state: %s)", node, member.State)
+	}
+	return gs.runInGroupNode(node, "STOP GROUP_REPLICATION")
+}
+
+// GroupRejoin starts group replication in a node that has left the group
+func GroupRejoin(sandboxDir, node string) error {
+	gs, err := readGroupSandbox(sandboxDir)
+	if err != nil {
+		return err
+	}
+	err = gs.checkNode(node)
+	if err != nil {
+		return err
+	}
+	members, err := GroupStatus(sandboxDir)
+	if err != nil {
+		return err
+	}
+	member := findMember(members, node)
+	switch member.State {
+	case GroupStateUnreachable:
+		return fmt.Errorf("node %s is not running. Start it before rejoining the group", node)
+	case GroupStateOffline, GroupStateError:
+	default:
+		return fmt.Errorf("node %s is already a member of the group (state: %s)", node, member.State)
+	}
+	if member.State == GroupStateError {
+		// A member in ERROR state must leave the group before joining it again
+		err = gs.runInGroupNode(node, "STOP GROUP_REPLICATION")
+		if err != nil {
+			return err
+		}
+	}
+	return gs.runInGroupNode(node, "START GROUP_REPLICATION")
+}
@@ -0,0 +1,103 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2022 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ops
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/globals"
+	"github.com/stretchr/testify/require"
+)
+
+// closedPort returns a port where nothing is listening
+func closedPort(t *testing.T) int {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	port := listener.Addr().(*net.TCPAddr).Port
+	require.NoError(t, listener.Close())
+	return port
+}
+
+func makeGroupSandbox(t *testing.T, sbType string, nodes int) string {
+	sandboxDir := t.TempDir()
+	require.NoError(t, common.WriteSandboxDescription(sandboxDir, common.SandboxDescription{
+		SBType: sbType, Version: "8.0.36", Nodes: nodes}))
+	for n := 1; n <= nodes; n++ {
+		nodeDir := path.Join(sandboxDir, fmt.Sprintf("node%d", n))
+		require.NoError(t, os.Mkdir(nodeDir, 0700))
+		port := closedPort(t)
+		require.NoError(t, common.WriteSandboxDescription(nodeDir, common.SandboxDescription{
+			SBType: "group-node", Version: "8.0.36", Port: []int{port, port + 1}}))
+		connection := fmt.Sprintf(`{"master_host": "127.0.0.1", "master_port": %d, "master_user": "msandbox", "master_password": "msandbox"}`, port)
+		require.NoError(t, os.WriteFile(path.Join(nodeDir, globals.ScriptConnectionSuperJson), []byte(connection), 0600))
+	}
+	return sandboxDir
+}
+
+func TestReadGroupSandbox(t *testing.T) {
+	sandboxDir := makeGroupSandbox(t, globals.SbTypeGroupSinglePrimary, 3)
+	gs, err := readGroupSandbox(sandboxDir)
+	require.NoError(t, err)
+	require.True(t, gs.singlePrimary)
+	require.Equal(t, []string{"node1", "node2", "node3"}, gs.nodes)
+	require.Len(t, gs.ports, 3)
+	require.NoError(t, gs.checkNode("node2"))
+	require.Error(t, gs.checkNode("node4"))
+
+	gs, err = readGroupSandbox(makeGroupSandbox(t, globals.SbTypeGroupMultiPrimary, 3))
+	require.NoError(t, err)
+	require.False(t, gs.singlePrimary)
+
+	_, err = readGroupSandbox(makeGroupSandbox(t, globals.MasterSlaveLabel, 2))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "Only 'group-multi-primary' or 'group-single-primary' sandboxes are supported")
+}
+
+func TestGroupCommandErrors(t *testing.T) {
+	multiPrimary := makeGroupSandbox(t, globals.SbTypeGroupMultiPrimary, 3)
+	singlePrimary := makeGroupSandbox(t, globals.SbTypeGroupSinglePrimary, 3)
+
+	err := GroupSetPrimary(multiPrimary, "node2")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "is multi-primary")
+
+	err = GroupSetPrimary(singlePrimary, "node9")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "node node9 not found")
+
+	_, err = GroupStatus(singlePrimary)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "none of the nodes")
+
+	err = GroupRejoin(multiPrimary, "node1")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "none of the nodes")
+}
+
+func TestFindMember(t *testing.T) {
+	members := []GroupMember{
+		{Node: "node1", Port: 5001, State: GroupStateOnline, Role: GroupRolePrimary},
+		{Node: "node2", Port: 5002, State: GroupStateOffline},
+	}
+	require.Equal(t, GroupRolePrimary, findMember(members, "node1").Role)
+	require.Equal(t, GroupStateOffline, findMember(members, "node2").State)
+	require.Equal(t, GroupStateUnreachable, findMember(members, "node3").State)
+}
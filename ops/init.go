@@ -205,7 +205,13 @@ func initDownloadTarball(options InitOptions) error {
 	fmt.Printf("# dbdeployer downloads get %s\n", tarball.Name)
 	if !(options.DryRun || options.SkipDownloads || options.SkipTarballDownload) {
 
-		err = rest.DownloadFile(tarball.Name, tarball.Url, true, globals.TenMB)
+		err = rest.DownloadWithOptions(rest.DownloadOptions{
+			Url:          tarball.Url,
+			FileName:     tarball.Name,
+			Progress:     true,
+			ProgressStep: globals.TenMB,
+			Checksum:     tarball.Checksum,
+		})
 		if err != nil {
 			return fmt.Errorf("error downloading file %s", tarball.Name)
 		}
@@ -0,0 +1,679 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2022 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ops
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/concurrent"
+	"github.com/datacharmer/dbdeployer/defaults"
+	"github.com/datacharmer/dbdeployer/globals"
+)
+
+// States of a job started by the API server
+const (
+	JobQueued  = "queued"
+	JobRunning = "running"
+	JobDone    = "done"
+	JobFailed  = "failed"
+)
+
+const (
+	// maxJobs is the number of jobs that the server remembers. The oldest finished jobs are removed first
+	maxJobs = 100
+	// maxRequestSize limits the body of a request
+	maxRequestSize = 1024 * 1024
+)
+
+// ServeOptions defines where the API server listens, and which sandboxes it manages
+type ServeOptions struct {
+	Listen        string // TCP address. Ignored when Socket is set
+	Socket        string // path of a unix socket
+	Token         string // every request needs the header "Authorization: Bearer <token>"
+	SandboxHome   string
+	SandboxBinary string
+	Executable    string // the dbdeployer binary that runs the jobs
+}
+
+// DeployRequest is the body of a deployment request.
+// Its fields have the same names as the corresponding deploy options
+type DeployRequest struct {
+	Topology      string   `json:"topology,omitempty"` // single (default), multiple, or a replication topology
+	Version       string   `json:"version"`
+	Flavor        string   `json:"flavor,omitempty"`
+	DirName       string   `json:"sandbox-directory,omitempty"`
+	Nodes         int      `json:"nodes,omitempty"`
+	Port          int      `json:"port,omitempty"`
+	BasePort      int      `json:"base-port,omitempty"`
+	Gtid          bool     `json:"gtid,omitempty"`
+	SemiSync      bool     `json:"semi-sync,omitempty"`
+	SinglePrimary bool     `json:"single-primary,omitempty"`
+	SkipStart     bool     `json:"skip-start,omitempty"`
+	DbUser        string   `json:"db-user,omitempty"`
+	DbPassword    string   `json:"db-password,omitempty"`
+	MyCnfOptions  []string `json:"my-cnf-options,omitempty"`
+	InitOptions   []string `json:"init-options,omitempty"`
+	Tags          []string `json:"tags,omitempty"`
+	Owner         string   `json:"owner,omitempty"`
+	Note          string   `json:"note,omitempty"`
+	Ttl           string   `json:"ttl,omitempty"`
+}
+
+// QueryRequest is the body of a query request. Node selects a server in a composite sandbox
+type QueryRequest struct {
+	Query     string `json:"query"`
+	Node      string `json:"node,omitempty"`
+	SuperUser bool   `json:"super-user,omitempty"`
+}
+
+// Job is a long-running operation started by the API server
+type Job struct {
+	Id        string     `json:"id"`
+	Operation string     `json:"operation"`
+	Sandbox   string     `json:"sandbox,omitempty"`
+	State     string     `json:"state"`
+	Command   []string   `json:"command"`
+	ExitCode  int        `json:"exit_code"`
+	Output    string     `json:"output,omitempty"`
+	Error     string     `json:"error,omitempty"`
+	Created   time.Time  `json:"created"`
+	Finished  *time.Time `json:"finished,omitempty"`
+}
+
+type apiServer struct {
+	options   ServeOptions
+	mutex     sync.Mutex // protects jobs and lastJobId
+	jobs      []*Job
+	lastJobId int
+	// Only loopback host names are accepted when the server listens on a local address,
+	// so that a web page cannot reach the API through DNS rebinding
+	localHostsOnly bool
+	// Jobs run one at a time, so that concurrent deployments don't claim the same ports
+	runLock sync.Mutex
+}
+
+type apiError struct {
+	Error string `json:"error"`
+}
+
+func writeJson(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(value)
+}
+
+func writeError(w http.ResponseWriter, status int, format string, args ...interface{}) {
+	writeJson(w, status, apiError{Error: fmt.Sprintf(format, args...)})
+}
+
+// NewToken returns a random token for the API server
+func NewToken() (string, error) {
+	buffer := make([]byte, 16)
+	_, err := rand.Read(buffer)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buffer), nil
+}
+
+// authorize rejects the requests without the expected token, the ones that come from a web page
+// (with an Origin header or, for a local server, a non-local host name), and the bodies that are not JSON
+func (s *apiServer) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Origin") != "" {
+			writeError(w, http.StatusForbidden, "cross-origin requests are not allowed")
+			return
+		}
+		if s.localHostsOnly && !isLoopbackHost(r.Host) {
+			writeError(w, http.StatusForbidden, "host '%s' is not allowed", r.Host)
+			return
+		}
+		token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !found || s.options.Token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(s.options.Token)) != 1 {
+			writeError(w, http.StatusUnauthorized, "missing or invalid token")
+			return
+		}
+		if r.ContentLength != 0 {
+			mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+			if err != nil || mediaType != "application/json" {
+				writeError(w, http.StatusUnsupportedMediaType, "the request body must be application/json")
+				return
+			}
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// sandboxPath returns the directory of the sandbox named in the request, if it exists
+func (s *apiServer) sandboxPath(w http.ResponseWriter, r *http.Request) (string, bool) {
+	name := r.PathValue("name")
+	if name == "" || name == "." || name == ".." || strings.Contains(name, "/") {
+		writeError(w, http.StatusBadRequest, "invalid sandbox name '%s'", name)
+		return "", false
+	}
+	sandboxPath := path.Join(s.options.SandboxHome, name)
+	if !common.FileExists(path.Join(sandboxPath, globals.SandboxDescriptionName)) {
+		writeError(w, http.StatusNotFound, "sandbox '%s' not found", name)
+		return "", false
+	}
+	return sandboxPath, true
+}
+
+// copyJob returns a snapshot of a job, safe to encode while the job runs
+func (s *apiServer) copyJob(job *Job) Job {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return *job
+}
+
+// maskedPassword replaces the passwords in the commands and output of jobs
+const maskedPassword = "********"
+
+// maskPasswords returns a copy of a command line where the values of password options
+// (such as --db-password=xxx or --rpl-password xxx) are hidden, and the list of hidden values
+func maskPasswords(command []string) ([]string, []string) {
+	var masked, secrets []string
+	maskNext := false
+	for _, arg := range command {
+		if maskNext {
+			masked = append(masked, maskedPassword)
+			secrets = append(secrets, arg)
+			maskNext = false
+			continue
+		}
+		name, value, hasValue := strings.Cut(arg, "=")
+		if strings.HasPrefix(name, "--") && strings.HasSuffix(name, "password") {
+			if !hasValue {
+				maskNext = true
+			} else if value != "" {
+				arg = name + "=" + maskedPassword
+				secrets = append(secrets, value)
+			}
+		}
+		masked = append(masked, arg)
+	}
+	return masked, secrets
+}
+
+// pruneJobs removes the oldest finished jobs, to make room for a new one.
+// It must be called with the mutex locked
+func (s *apiServer) pruneJobs() {
+	var kept []*Job
+	excess := len(s.jobs) - maxJobs + 1
+	for _, job := range s.jobs {
+		if excess > 0 && (job.State == JobDone || job.State == JobFailed) {
+			excess--
+			continue
+		}
+		kept = append(kept, job)
+	}
+	s.jobs = kept
+}
+
+// startJob records a job and runs its command in the background
+func (s *apiServer) startJob(operation, sandboxName string, ec concurrent.ExecCommand) (Job, error) {
+	command, secrets := maskPasswords(append([]string{ec.Cmd}, ec.Args...))
+	s.mutex.Lock()
+	s.pruneJobs()
+	if len(s.jobs) >= maxJobs {
+		s.mutex.Unlock()
+		return Job{}, fmt.Errorf("too many jobs in progress. Try again later")
+	}
+	s.lastJobId++
+	job := &Job{
+		Id:        fmt.Sprintf("%d", s.lastJobId),
+		Operation: operation,
+		Sandbox:   sandboxName,
+		State:     JobQueued,
+		Command:   command,
+		Created:   time.Now(),
+	}
+	s.jobs = append(s.jobs, job)
+	s.mutex.Unlock()
+
+	go func() {
+		s.runLock.Lock()
+		defer s.runLock.Unlock()
+		s.mutex.Lock()
+		job.State = JobRunning
+		s.mutex.Unlock()
+
+		result := concurrent.RunLimitedTasks(concurrent.ExecCommands{ec}, 1, 0)[0]
+
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		finished := time.Now()
+		job.Finished = &finished
+		job.ExitCode = result.ExitCode
+		job.Output = result.Stdout + result.Stderr
+		for _, secret := range secrets {
+			job.Output = strings.ReplaceAll(job.Output, secret, maskedPassword)
+		}
+		job.State = JobDone
+		if result.Err != nil {
+			job.Error = result.Err.Error()
+		}
+		if result.Err != nil || result.ExitCode != 0 {
+			job.State = JobFailed
+		}
+	}()
+	return s.copyJob(job), nil
+}
+
+// deployArgs translates a deployment request into the arguments of "dbdeployer deploy"
+func (s *apiServer) deployArgs(request DeployRequest) ([]string, error) {
+	if request.Version == "" {
+		return nil, fmt.Errorf("missing version")
+	}
+	if strings.HasPrefix(request.Version, "-") {
+		return nil, fmt.Errorf("invalid version '%s'", request.Version)
+	}
+	if request.Nodes < 0 || request.Port < 0 || request.BasePort < 0 {
+		return nil, fmt.Errorf("negative numeric values are not allowed")
+	}
+	if strings.ContainsAny(request.DirName, "/ ") || request.DirName == "." || request.DirName == ".." {
+		return nil, fmt.Errorf("invalid sandbox directory '%s'", request.DirName)
+	}
+	var args []string
+	switch request.Topology {
+	case "", globals.SbTypeSingle:
+		args = []string{"deploy", globals.SbTypeSingle, request.Version}
+	case globals.SbTypeMultiple:
+		args = []string{"deploy", globals.SbTypeMultiple, request.Version}
+	default:
+		if !slices.Contains(globals.AllowedTopologies, request.Topology) {
+			return nil, fmt.Errorf("unknown topology '%s'", request.Topology)
+		}
+		args = []string{"deploy", "replication", request.Version, "--" + globals.TopologyLabel + "=" + request.Topology}
+	}
+	args = append(args,
+		"--"+globals.SandboxHomeLabel+"="+s.options.SandboxHome,
+		"--"+globals.SandboxBinaryLabel+"="+s.options.SandboxBinary)
+
+	stringOptions := []struct {
+		label string
+		value string
+	}{
+		{globals.FlavorLabel, request.Flavor},
+		{globals.SandboxDirectoryLabel, request.DirName},
+		{globals.DbUserLabel, request.DbUser},
+		{globals.DbPasswordLabel, request.DbPassword},
+		{globals.OwnerLabel, request.Owner},
+		{globals.NoteLabel, request.Note},
+		{globals.TtlLabel, request.Ttl},
+	}
+	for _, option := range stringOptions {
+		if option.value != "" {
+			args = append(args, "--"+option.label+"="+option.value)
+		}
+	}
+	intOptions := []struct {
+		label string
+		value int
+	}{
+		{globals.NodesLabel, request.Nodes},
+		{globals.PortLabel, request.Port},
+		{globals.BasePortLabel, request.BasePort},
+	}
+	for _, option := range intOptions {
+		if option.value > 0 {
+			args = append(args, fmt.Sprintf("--%s=%d", option.label, option.value))
+		}
+	}
+	boolOptions := []struct {
+		label string
+		value bool
+	}{
+		{globals.GtidLabel, request.Gtid},
+		{globals.SemiSyncLabel, request.SemiSync},
+		{globals.SinglePrimaryLabel, request.SinglePrimary},
+		{globals.SkipStartLabel, request.SkipStart},
+	}
+	for _, option := range boolOptions {
+		if option.value {
+			args = append(args, "--"+option.label)
+		}
+	}
+	arrayOptions := []struct {
+		label  string
+		values []string
+	}{
+		{globals.MyCnfOptionsLabel, request.MyCnfOptions},
+		{globals.InitOptionsLabel, request.InitOptions},
+		{globals.TagLabel, request.Tags},
+	}
+	for _, option := range arrayOptions {
+		for _, value := range option.values {
+			args = append(args, "--"+option.label+"="+value)
+		}
+	}
+	return args, nil
+}
+
+func (s *apiServer) listVersions(w http.ResponseWriter, r *http.Request) {
+	type versionItem struct {
+		Version string `json:"version"`
+		Flavor  string `json:"flavor"`
+	}
+	versions := []versionItem{}
+	for _, info := range common.GetVersionInfoFromDir(s.options.SandboxBinary) {
+		versions = append(versions, versionItem{Version: info.Version, Flavor: info.Flavor})
+	}
+	writeJson(w, http.StatusOK, versions)
+}
+
+func (s *apiServer) listSandboxes(w http.ResponseWriter, r *http.Request) {
+	statusList := []SandboxStatus{}
+	if common.DirExists(s.options.SandboxHome) {
+		list, err := GetSandboxesStatus(s.options.SandboxHome, r.URL.Query().Get("query") == "true")
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "%s", err)
+			return
+		}
+		statusList = append(statusList, list...)
+	}
+	writeJson(w, http.StatusOK, statusList)
+}
+
+func (s *apiServer) getSandbox(w http.ResponseWriter, r *http.Request) {
+	sandboxPath, ok := s.sandboxPath(w, r)
+	if !ok {
+		return
+	}
+	status, err := GetSandboxStatus(sandboxPath, r.URL.Query().Get("query") == "true")
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "%s", err)
+		return
+	}
+	writeJson(w, http.StatusOK, status)
+}
+
+func (s *apiServer) deploySandbox(w http.ResponseWriter, r *http.Request) {
+	var request DeployRequest
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&request)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid deployment request: %s", err)
+		return
+	}
+	args, err := s.deployArgs(request)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid deployment request: %s", err)
+		return
+	}
+	if request.DirName != "" && common.DirExists(path.Join(s.options.SandboxHome, request.DirName)) {
+		writeError(w, http.StatusConflict, "sandbox '%s' already exists", request.DirName)
+		return
+	}
+	job, err := s.startJob("deploy", request.DirName, concurrent.ExecCommand{Cmd: s.options.Executable, Args: args})
+	if err != nil {
+		writeError(w, http.StatusServiceUnavailable, "%s", err)
+		return
+	}
+	writeJson(w, http.StatusAccepted, job)
+}
+
+// runSandboxScript starts a job that runs "dbdeployer global <operation>" on a sandbox, so that
+// the hooks of the operation are run as well. The sandbox must have the script of the operation
+func (s *apiServer) runSandboxScript(operation, script, scriptAll string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sandboxPath, ok := s.sandboxPath(w, r)
+		if !ok {
+			return
+		}
+		if !common.ExecExists(path.Join(sandboxPath, scriptAll)) && !common.ExecExists(path.Join(sandboxPath, script)) {
+			writeError(w, http.StatusInternalServerError, globals.ErrExecutableNotFound, path.Join(sandboxPath, script))
+			return
+		}
+		name := path.Base(sandboxPath)
+		job, err := s.startJob(operation, name, concurrent.ExecCommand{
+			Cmd: s.options.Executable,
+			Args: []string{"global", operation,
+				"--" + globals.SandboxHomeLabel + "=" + s.options.SandboxHome,
+				"--" + globals.NameLabel + "=" + name},
+		})
+		if err != nil {
+			writeError(w, http.StatusServiceUnavailable, "%s", err)
+			return
+		}
+		writeJson(w, http.StatusAccepted, job)
+	}
+}
+
+func (s *apiServer) deleteSandbox(w http.ResponseWriter, r *http.Request) {
+	sandboxPath, ok := s.sandboxPath(w, r)
+	if !ok {
+		return
+	}
+	name := path.Base(sandboxPath)
+	job, err := s.startJob("delete", name, concurrent.ExecCommand{
+		Cmd:  s.options.Executable,
+		Args: []string{"delete", name, "--" + globals.SandboxHomeLabel + "=" + s.options.SandboxHome},
+	})
+	if err != nil {
+		writeError(w, http.StatusServiceUnavailable, "%s", err)
+		return
+	}
+	writeJson(w, http.StatusAccepted, job)
+}
+
+func (s *apiServer) querySandbox(w http.ResponseWriter, r *http.Request) {
+	sandboxPath, ok := s.sandboxPath(w, r)
+	if !ok {
+		return
+	}
+	var request QueryRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil || request.Query == "" {
+		writeError(w, http.StatusBadRequest, "invalid query request: the body must contain a query")
+		return
+	}
+	if request.Node != "" {
+		if strings.Contains(request.Node, "..") {
+			writeError(w, http.StatusBadRequest, "invalid node '%s'", request.Node)
+			return
+		}
+		sandboxPath = path.Join(sandboxPath, request.Node)
+		if !common.DirExists(sandboxPath) {
+			writeError(w, http.StatusNotFound, "node '%s' not found", request.Node)
+			return
+		}
+	}
+	result, err := QuerySandbox(r.Context(), sandboxPath, request.Query, request.SuperUser)
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, "%s", err)
+		return
+	}
+	writeJson(w, http.StatusOK, result)
+}
+
+func (s *apiServer) getCatalog(w http.ResponseWriter, r *http.Request) {
+	catalog, err := defaults.ReadCatalog()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "%s", err)
+		return
+	}
+	if catalog == nil {
+		catalog = make(defaults.SandboxCatalog)
+	}
+	writeJson(w, http.StatusOK, catalog)
+}
+
+func (s *apiServer) listJobs(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	jobs := []Job{}
+	for _, job := range s.jobs {
+		jobs = append(jobs, *job)
+	}
+	s.mutex.Unlock()
+	writeJson(w, http.StatusOK, jobs)
+}
+
+func (s *apiServer) getJob(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	s.mutex.Lock()
+	var found *Job
+	for _, job := range s.jobs {
+		if job.Id == id {
+			found = job
+			break
+		}
+	}
+	s.mutex.Unlock()
+	if found == nil {
+		writeError(w, http.StatusNotFound, "job '%s' not found", id)
+		return
+	}
+	writeJson(w, http.StatusOK, s.copyJob(found))
+}
+
+// NewApiHandler returns the handler of the JSON API that manages the sandboxes
+func NewApiHandler(options ServeOptions) http.Handler {
+	s := &apiServer{
+		options:        options,
+		localHostsOnly: options.Socket != "" || options.Listen == "" || isLoopback(options.Listen),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /versions", s.listVersions)
+	mux.HandleFunc("GET /sandboxes", s.listSandboxes)
+	mux.HandleFunc("POST /sandboxes", s.deploySandbox)
+	mux.HandleFunc("GET /sandboxes/{name}", s.getSandbox)
+	mux.HandleFunc("DELETE /sandboxes/{name}", s.deleteSandbox)
+	mux.HandleFunc("POST /sandboxes/{name}/start", s.runSandboxScript("start", globals.ScriptStart, globals.ScriptStartAll))
+	mux.HandleFunc("POST /sandboxes/{name}/stop", s.runSandboxScript("stop", globals.ScriptStop, globals.ScriptStopAll))
+	mux.HandleFunc("POST /sandboxes/{name}/query", s.querySandbox)
+	mux.HandleFunc("GET /catalog", s.getCatalog)
+	mux.HandleFunc("GET /jobs", s.listJobs)
+	mux.HandleFunc("GET /jobs/{id}", s.getJob)
+	return s.authorize(mux)
+}
+
+// isLoopback tells whether a TCP address only accepts local connections
+func isLoopback(address string) bool {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+	return isLoopbackName(host)
+}
+
+// isLoopbackHost tells whether the Host header of a request, with or without a port, names the local host
+func isLoopbackHost(host string) bool {
+	name, _, err := net.SplitHostPort(host)
+	if err != nil {
+		name = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	}
+	return isLoopbackName(name)
+}
+
+func isLoopbackName(name string) bool {
+	if name == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(name)
+	return ip != nil && ip.IsLoopback()
+}
+
+func validateServeOptions(options ServeOptions) error {
+	if options.SandboxHome == "" || options.SandboxBinary == "" || options.Executable == "" {
+		return fmt.Errorf("serve options need non-empty SandboxHome, SandboxBinary, and Executable")
+	}
+	if options.Socket == "" && options.Listen == "" {
+		return fmt.Errorf("serve options need either a listening address or a socket")
+	}
+	if options.Token == "" {
+		return fmt.Errorf("serve options need a token")
+	}
+	return nil
+}
+
+// Serve runs the API server until it receives SIGINT or SIGTERM
+func Serve(options ServeOptions) error {
+	err := validateServeOptions(options)
+	if err != nil {
+		return err
+	}
+	var listener net.Listener
+	address := options.Listen
+	if options.Socket != "" {
+		address = options.Socket
+		info, err := os.Stat(options.Socket)
+		if err == nil {
+			// A socket left behind by a previous server is replaced. Anything else is kept
+			if info.Mode()&os.ModeSocket == 0 {
+				return fmt.Errorf("%s exists and it is not a socket", options.Socket)
+			}
+			err = os.Remove(options.Socket)
+			if err != nil {
+				return err
+			}
+		}
+		listener, err = net.Listen("unix", options.Socket)
+		if err != nil {
+			return err
+		}
+		err = os.Chmod(options.Socket, 0600)
+		if err != nil {
+			_ = listener.Close()
+			return err
+		}
+		defer os.Remove(options.Socket)
+	} else {
+		listener, err = net.Listen("tcp", options.Listen)
+		if err != nil {
+			return err
+		}
+	}
+
+	server := &http.Server{
+		Handler:           NewApiHandler(options),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}()
+	fmt.Printf("dbdeployer API listening on %s\n", address)
+	err = server.Serve(listener)
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,247 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2022 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ops
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/datacharmer/dbdeployer/concurrent"
+	"github.com/datacharmer/dbdeployer/globals"
+	"github.com/stretchr/testify/require"
+)
+
+// apiRequest sends a request to the API handler and decodes the response into result
+func apiRequest(t *testing.T, handler http.Handler, method, url, token, body string, result interface{}) int {
+	request := httptest.NewRequest(method, "http://localhost"+url, strings.NewReader(body))
+	if body != "" {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	if result != nil {
+		require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), result), recorder.Body.String())
+	}
+	return recorder.Code
+}
+
+// waitForJob polls a job until it is finished
+func waitForJob(t *testing.T, handler http.Handler, id string) Job {
+	var job Job
+	for i := 0; i < 100; i++ {
+		require.Equal(t, http.StatusOK, apiRequest(t, handler, "GET", "/jobs/"+id, "secret", "", &job))
+		if job.State == JobDone || job.State == JobFailed {
+			return job
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatalf("job %s did not finish", id)
+	return job
+}
+
+func TestApiHandler(t *testing.T) {
+	sandboxHome := t.TempDir()
+	sandboxBinary := t.TempDir()
+	require.NoError(t, os.MkdirAll(path.Join(sandboxBinary, "8.0.36", "bin"), 0700))
+	require.NoError(t, os.WriteFile(path.Join(sandboxBinary, "8.0.36", "bin", "mysqld"), []byte(""), 0700))
+	require.NoError(t, os.WriteFile(path.Join(sandboxBinary, "8.0.36", "FLAVOR"), []byte("mysql\n"), 0600))
+
+	single := path.Join(sandboxHome, "msb_8_0_36")
+	makeStatusServer(t, single, 8036)
+	require.NoError(t, os.WriteFile(path.Join(single, globals.ScriptStart), []byte("#!/bin/sh\necho started\n"), 0700))
+
+	// The fake executable shows the arguments of the jobs
+	executable := path.Join(t.TempDir(), "dbdeployer")
+	require.NoError(t, os.WriteFile(executable, []byte("#!/bin/sh\necho \"$@\"\n[ \"$1\" = deploy ] || [ \"$1\" = global ]\n"), 0700))
+
+	handler := NewApiHandler(ServeOptions{
+		Token:         "secret",
+		SandboxHome:   sandboxHome,
+		SandboxBinary: sandboxBinary,
+		Executable:    executable,
+	})
+
+	var apiErr apiError
+	require.Equal(t, http.StatusUnauthorized, apiRequest(t, handler, "GET", "/sandboxes", "", "", &apiErr))
+	require.Equal(t, http.StatusUnauthorized, apiRequest(t, handler, "GET", "/sandboxes", "wrong", "", &apiErr))
+
+	var versions []map[string]string
+	require.Equal(t, http.StatusOK, apiRequest(t, handler, "GET", "/versions", "secret", "", &versions))
+	require.Equal(t, []map[string]string{{"version": "8.0.36", "flavor": "mysql"}}, versions)
+
+	var statusList []SandboxStatus
+	require.Equal(t, http.StatusOK, apiRequest(t, handler, "GET", "/sandboxes", "secret", "", &statusList))
+	require.Len(t, statusList, 1)
+	require.Equal(t, "msb_8_0_36", statusList[0].Name)
+
+	var status SandboxStatus
+	require.Equal(t, http.StatusOK, apiRequest(t, handler, "GET", "/sandboxes/msb_8_0_36", "secret", "", &status))
+	require.Equal(t, ServerStopped, status.Servers[0].State)
+	require.Equal(t, http.StatusNotFound, apiRequest(t, handler, "GET", "/sandboxes/msb_5_7_44", "secret", "", &apiErr))
+
+	for _, body := range []string{
+		`{"topology": "circular", "version": "8.0.36"}`,
+		`{"topology": "single"}`,
+		`{"version": "--help"}`,
+		`{"version": "8.0.36", "no-such-option": true}`,
+		`{"version": "8.0.36", "sandbox-directory": "../elsewhere"}`,
+	} {
+		require.Equal(t, http.StatusBadRequest, apiRequest(t, handler, "POST", "/sandboxes", "secret", body, &apiErr), body)
+	}
+	require.Equal(t, http.StatusConflict, apiRequest(t, handler, "POST", "/sandboxes", "secret",
+		`{"version": "8.0.36", "sandbox-directory": "msb_8_0_36"}`, &apiErr))
+
+	var job Job
+	require.Equal(t, http.StatusAccepted, apiRequest(t, handler, "POST", "/sandboxes", "secret",
+		`{"topology": "group", "version": "8.0.36", "nodes": 5, "single-primary": true, "tags": ["team=qa"]}`, &job))
+	require.Equal(t, "deploy", job.Operation)
+	job = waitForJob(t, handler, job.Id)
+	require.Equal(t, JobDone, job.State)
+	require.Equal(t, "deploy replication 8.0.36 --topology=group --sandbox-home="+sandboxHome+
+		" --sandbox-binary="+sandboxBinary+" --nodes=5 --single-primary --tag=team=qa\n", job.Output)
+
+	// Passwords are not shown in the jobs
+	require.Equal(t, http.StatusAccepted, apiRequest(t, handler, "POST", "/sandboxes", "secret",
+		`{"version": "8.0.36", "db-password": "TopS3cret"}`, &job))
+	require.Contains(t, job.Command, "--db-password=********")
+	job = waitForJob(t, handler, job.Id)
+	require.Equal(t, JobDone, job.State)
+	require.NotContains(t, strings.Join(job.Command, " "), "TopS3cret")
+	require.NotContains(t, job.Output, "TopS3cret")
+	require.Contains(t, job.Output, "--db-password=********")
+
+	require.Equal(t, http.StatusAccepted, apiRequest(t, handler, "POST", "/sandboxes/msb_8_0_36/start", "secret", "", &job))
+	job = waitForJob(t, handler, job.Id)
+	require.Equal(t, JobDone, job.State)
+	require.Equal(t, "global start --sandbox-home="+sandboxHome+" --name=msb_8_0_36\n", job.Output)
+
+	require.Equal(t, http.StatusAccepted, apiRequest(t, handler, "DELETE", "/sandboxes/msb_8_0_36", "secret", "", &job))
+	job = waitForJob(t, handler, job.Id)
+	require.Equal(t, JobFailed, job.State)
+	require.Equal(t, 1, job.ExitCode)
+	require.Equal(t, "delete msb_8_0_36 --sandbox-home="+sandboxHome+"\n", job.Output)
+
+	var jobs []Job
+	require.Equal(t, http.StatusOK, apiRequest(t, handler, "GET", "/jobs", "secret", "", &jobs))
+	require.Len(t, jobs, 4)
+	require.Equal(t, http.StatusNotFound, apiRequest(t, handler, "GET", "/jobs/5", "secret", "", &apiErr))
+
+	require.Equal(t, http.StatusBadRequest, apiRequest(t, handler, "POST", "/sandboxes/msb_8_0_36/query", "secret", `{}`, &apiErr))
+}
+
+func TestApiRequestChecks(t *testing.T) {
+	handler := NewApiHandler(ServeOptions{
+		Listen:        "127.0.0.1:8091",
+		Token:         "secret",
+		SandboxHome:   t.TempDir(),
+		SandboxBinary: t.TempDir(),
+		Executable:    "/bin/false",
+	})
+	send := func(host string, headers map[string]string, body string) int {
+		request := httptest.NewRequest("POST", "http://"+host+"/sandboxes", strings.NewReader(body))
+		request.Header.Set("Authorization", "Bearer secret")
+		for name, value := range headers {
+			request.Header.Set(name, value)
+		}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		return recorder.Code
+	}
+	jsonType := map[string]string{"Content-Type": "application/json"}
+	body := `{"version": "--help"}`
+	require.Equal(t, http.StatusBadRequest, send("localhost:8091", jsonType, body))
+	require.Equal(t, http.StatusBadRequest, send("127.0.0.1:8091", jsonType, body))
+	require.Equal(t, http.StatusBadRequest, send("[::1]:8091", jsonType, body))
+	require.Equal(t, http.StatusForbidden, send("attacker.example.com:8091", jsonType, body))
+	require.Equal(t, http.StatusForbidden, send("localhost:8091",
+		map[string]string{"Content-Type": "application/json", "Origin": "http://attacker.example.com"}, body))
+	require.Equal(t, http.StatusUnsupportedMediaType, send("localhost:8091", map[string]string{"Content-Type": "text/plain"}, body))
+	require.Equal(t, http.StatusUnsupportedMediaType, send("localhost:8091", nil, body))
+	require.Equal(t, http.StatusBadRequest, send("localhost:8091", jsonType,
+		`{"version": "8.0.36", "note": "`+strings.Repeat("x", maxRequestSize)+`"}`))
+
+	// Without a token, no request is accepted
+	handler = NewApiHandler(ServeOptions{SandboxHome: t.TempDir(), SandboxBinary: t.TempDir(), Executable: "/bin/false"})
+	require.Equal(t, http.StatusUnauthorized, apiRequest(t, handler, "GET", "/sandboxes", "", "", nil))
+
+	// A server listening on a non-local address accepts its own host name
+	handler = NewApiHandler(ServeOptions{Listen: "0.0.0.0:8091", Token: "secret", SandboxHome: t.TempDir(), SandboxBinary: t.TempDir(), Executable: "/bin/false"})
+	require.Equal(t, http.StatusBadRequest, send("dbhost.example.com:8091", jsonType, body))
+}
+
+func TestPruneJobs(t *testing.T) {
+	s := &apiServer{}
+	for i := 1; i <= maxJobs; i++ {
+		state := JobDone
+		if i%2 == 0 {
+			state = JobRunning
+		}
+		s.jobs = append(s.jobs, &Job{Id: fmt.Sprintf("%d", i), State: state})
+	}
+	s.pruneJobs()
+	require.Len(t, s.jobs, maxJobs-1)
+	require.Equal(t, "2", s.jobs[0].Id)
+
+	// Unfinished jobs are never removed
+	for _, job := range s.jobs {
+		job.State = JobQueued
+	}
+	s.jobs = append(s.jobs, &Job{Id: "101", State: JobQueued})
+	s.pruneJobs()
+	require.Len(t, s.jobs, maxJobs)
+	_, err := s.startJob("deploy", "", concurrent.ExecCommand{Cmd: "/bin/false"})
+	require.Error(t, err)
+}
+
+func TestMaskPasswords(t *testing.T) {
+	masked, secrets := maskPasswords([]string{"dbdeployer", "deploy", "single", "8.0.36",
+		"--db-password=abc", "--rpl-password", "xyz", "--db-user=msandbox", "--password="})
+	require.Equal(t, []string{"dbdeployer", "deploy", "single", "8.0.36",
+		"--db-password=********", "--rpl-password", "********", "--db-user=msandbox", "--password="}, masked)
+	require.Equal(t, []string{"abc", "xyz"}, secrets)
+}
+
+func TestValidateServeOptions(t *testing.T) {
+	options := ServeOptions{Listen: "127.0.0.1:8091", SandboxHome: "/home", SandboxBinary: "/bin", Executable: "/dbdeployer"}
+	require.Error(t, validateServeOptions(options))
+	options.Token = "secret"
+	require.NoError(t, validateServeOptions(options))
+	options.Listen = "0.0.0.0:8091"
+	require.NoError(t, validateServeOptions(options))
+	options.Listen = ""
+	require.Error(t, validateServeOptions(options))
+	options.Socket = "/tmp/dbdeployer.sock"
+	require.NoError(t, validateServeOptions(options))
+}
+
+func TestNewToken(t *testing.T) {
+	token, err := NewToken()
+	require.NoError(t, err)
+	require.Len(t, token, 32)
+	other, err := NewToken()
+	require.NoError(t, err)
+	require.NotEqual(t, token, other)
+}
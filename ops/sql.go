@@ -16,6 +16,10 @@
 package ops
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"database/sql"
 	"encoding/json"
 	"fmt"
 	"os"
@@ -24,6 +28,7 @@ import (
 	"github.com/datacharmer/dbdeployer/common"
 	"github.com/datacharmer/dbdeployer/globals"
 	"github.com/datacharmer/dbdeployer/importing"
+	"github.com/go-sql-driver/mysql"
 )
 
 type sandboxConnection struct {
@@ -31,6 +36,9 @@ type sandboxConnection struct {
 	Port     int    `json:"master_port"`
 	User     string `json:"master_user"`
 	Password string `json:"master_password"`
+	SslCa    string `json:"ssl_ca,omitempty"`
+	SslCert  string `json:"ssl_cert,omitempty"`
+	SslKey   string `json:"ssl_key,omitempty"`
 }
 
 // getSandboxConnection finds the connection credentials in a given sandbox directory
@@ -55,19 +63,104 @@ func getSandboxConnection(sandboxPath string, asSuperUser bool) (sandboxConnecti
 	return sc, err
 }
 
-// RunSandboxQuery runs a SQL query in a given sandbox directory
-func RunSandboxQuery[T comparable](sandboxPath, query string, asSuperUser bool) (interface{}, error) {
+// registerSandboxTls registers a TLS configuration that uses the certificates of a sandbox
+// created with --tls, and returns its name
+func registerSandboxTls(sc sandboxConnection) (string, error) {
+	caText, err := os.ReadFile(sc.SslCa) // #nosec 304
+	if err != nil {
+		return "", err
+	}
+	rootCAs := x509.NewCertPool()
+	if !rootCAs.AppendCertsFromPEM(caText) {
+		return "", fmt.Errorf("no certificates found in %s", sc.SslCa)
+	}
+	certificate, err := tls.LoadX509KeyPair(sc.SslCert, sc.SslKey)
+	if err != nil {
+		return "", err
+	}
+	name := fmt.Sprintf("sandbox-%s-%d", sc.Host, sc.Port)
+	err = mysql.RegisterTLSConfig(name, &tls.Config{
+		RootCAs:      rootCAs,
+		Certificates: []tls.Certificate{certificate},
+		ServerName:   sc.Host,
+		MinVersion:   tls.VersionTLS12,
+	})
+	return name, err
+}
 
+// connectToSandbox opens a connection to the server in a given sandbox directory
+func connectToSandbox(sandboxPath string, asSuperUser bool) (*importing.DB, *mysql.Config, error) {
 	credentials, err := getSandboxConnection(sandboxPath, asSuperUser)
 	if err != nil {
-		return "", err
+		return nil, nil, err
 	}
 	config := importing.ParamsToConfig(credentials.Host, credentials.User, credentials.Password, credentials.Port)
+	if credentials.SslCa != "" {
+		config.TLSConfig, err = registerSandboxTls(credentials)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error loading TLS certificates for %s: %s", sandboxPath, err)
+		}
+	}
 	db, err := importing.Connect(config)
+	if err != nil {
+		return nil, nil, err
+	}
+	return db, config, nil
+}
+
+// RunSandboxQuery runs a SQL query in a given sandbox directory
+func RunSandboxQuery[T comparable](sandboxPath, query string, asSuperUser bool) (interface{}, error) {
+
+	db, config, err := connectToSandbox(sandboxPath, asSuperUser)
 	if err != nil {
 		return "", err
 	}
+	defer db.Close()
 	var result T
 	err = db.GetSingleResult(config, query, &result)
 	return result, err
 }
+
+// QueryResult is the outcome of a query that returns rows. NULL values are nil
+type QueryResult struct {
+	Columns []string    `json:"columns"`
+	Rows    [][]*string `json:"rows"`
+}
+
+// QuerySandbox runs a SQL query in a given sandbox directory, and returns all its rows
+func QuerySandbox(ctx context.Context, sandboxPath, query string, asSuperUser bool) (QueryResult, error) {
+	var result QueryResult
+	db, _, err := connectToSandbox(sandboxPath, asSuperUser)
+	if err != nil {
+		return result, err
+	}
+	defer db.Close()
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return result, err
+	}
+	defer rows.Close()
+	result.Columns, err = rows.Columns()
+	if err != nil {
+		return result, err
+	}
+	for rows.Next() {
+		values := make([]sql.NullString, len(result.Columns))
+		pointers := make([]interface{}, len(result.Columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		err = rows.Scan(pointers...)
+		if err != nil {
+			return result, err
+		}
+		row := make([]*string, len(values))
+		for i, value := range values {
+			if value.Valid {
+				row[i] = &values[i].String
+			}
+		}
+		result.Rows = append(result.Rows, row)
+	}
+	return result, rows.Err()
+}
@@ -0,0 +1,110 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2022 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ops
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/datacharmer/dbdeployer/globals"
+	"github.com/datacharmer/dbdeployer/mockserver"
+	"github.com/stretchr/testify/require"
+)
+
+// makeMockSandbox creates a server directory whose connection files point to a mock server
+func makeMockSandbox(t *testing.T, options mockserver.Options) (string, *mockserver.Server) {
+	options.Address = "127.0.0.1:0"
+	server, err := mockserver.New(options)
+	require.NoError(t, err)
+	go func() {
+		_ = server.Serve()
+	}()
+	t.Cleanup(func() { _ = server.Close() })
+
+	serverDir := t.TempDir()
+	pidFile := makeStatusServer(t, serverDir, server.Port())
+	// The mock server runs in this process
+	require.NoError(t, os.WriteFile(pidFile, []byte(fmt.Sprintf("%d\n", os.Getpid())), 0600))
+	connection := fmt.Sprintf(`{"master_host": "127.0.0.1", "master_port": %d, "master_user": "msandbox", "master_password": "msandbox"}`,
+		server.Port())
+	for _, fileName := range []string{globals.ScriptConnectionJson, globals.ScriptConnectionSuperJson} {
+		require.NoError(t, os.WriteFile(path.Join(serverDir, fileName), []byte(connection), 0600))
+	}
+	return serverDir, server
+}
+
+func TestRunSandboxQuery(t *testing.T) {
+	serverDir, _ := makeMockSandbox(t, mockserver.Options{Version: "8.0.98", ServerId: 100})
+
+	result, err := RunSandboxQuery[int](serverDir, "select @@server_id", false)
+	require.NoError(t, err)
+	require.Equal(t, 100, result)
+
+	result, err = RunSandboxQuery[string](serverDir, "select @@version", true)
+	require.NoError(t, err)
+	require.Equal(t, "8.0.98", result)
+
+	_, err = RunSandboxQuery[string](serverDir, "select * from no_such_table", false)
+	require.Error(t, err)
+}
+
+func TestQuerySandbox(t *testing.T) {
+	serverDir, _ := makeMockSandbox(t, mockserver.Options{
+		Queries: []mockserver.ScriptedQuery{
+			{Query: "select .* from test.t1", Columns: []string{"id", "name"},
+				Rows: [][]*string{{stringPointer("1"), stringPointer("one")}, {stringPointer("2"), nil}}},
+		},
+	})
+	result, err := QuerySandbox(context.Background(), serverDir, "select id, name from test.t1", false)
+	require.NoError(t, err)
+	require.Equal(t, []string{"id", "name"}, result.Columns)
+	require.Len(t, result.Rows, 2)
+	require.Equal(t, "one", *result.Rows[0][1])
+	require.Nil(t, result.Rows[1][1])
+}
+
+func TestGetSandboxStatusQuery(t *testing.T) {
+	serverDir, server := makeMockSandbox(t, mockserver.Options{Version: "8.0.98"})
+
+	sbStatus, err := GetSandboxStatus(serverDir, true)
+	require.NoError(t, err)
+	require.Len(t, sbStatus.Servers, 1)
+	status := sbStatus.Servers[0]
+	require.Equal(t, ServerRunning, status.State)
+	require.Equal(t, server.Port(), status.Port)
+	require.Equal(t, "8.0.98", status.Version)
+	require.Equal(t, RoleNone, status.Role)
+	require.Empty(t, status.Error)
+
+	// A replica with a running channel
+	_, err = QuerySandbox(context.Background(), serverDir, "change replication source to source_host='127.0.0.1', source_port=8036", true)
+	require.NoError(t, err)
+	_, err = QuerySandbox(context.Background(), serverDir, "start replica", true)
+	require.NoError(t, err)
+	sbStatus, err = GetSandboxStatus(serverDir, true)
+	require.NoError(t, err)
+	status = sbStatus.Servers[0]
+	require.Equal(t, RoleReplica, status.Role)
+	require.NotNil(t, status.Lag)
+	require.Equal(t, int64(0), *status.Lag)
+}
+
+func stringPointer(s string) *string {
+	return &s
+}
@@ -0,0 +1,329 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2022 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ops
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/globals"
+)
+
+// Live states of a sandbox server
+const (
+	ServerRunning = "running"
+	ServerStopped = "stopped"
+	ServerCrashed = "crashed" // the PID file was left by a process that is no longer running
+)
+
+// Replication roles of a sandbox server
+const (
+	RoleNone      = "none"
+	RoleSource    = "source"
+	RoleReplica   = "replica"
+	RoleRelay     = "relay" // both a replica and a source
+	RolePrimary   = "primary"
+	RoleSecondary = "secondary"
+)
+
+// ServerStatus is the live status of one server of a sandbox.
+// Version, role, and lag are only filled when the server was queried
+type ServerStatus struct {
+	Node    string `json:"node" yaml:"node"`
+	Port    int    `json:"port" yaml:"port"`
+	State   string `json:"state" yaml:"state"`
+	Pid     int    `json:"pid,omitempty" yaml:"pid,omitempty"`
+	Socket  bool   `json:"socket" yaml:"socket"`
+	Uptime  int64  `json:"uptime,omitempty" yaml:"uptime,omitempty"` // seconds
+	Version string `json:"version,omitempty" yaml:"version,omitempty"`
+	Role    string `json:"role,omitempty" yaml:"role,omitempty"`
+	Lag     *int64 `json:"lag,omitempty" yaml:"lag,omitempty"` // seconds behind the source, when replicating
+	Error   string `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+// SandboxStatus is the live status of all the servers of a sandbox
+type SandboxStatus struct {
+	Name    string         `json:"name" yaml:"name"`
+	Type    string         `json:"type" yaml:"type"`
+	Version string         `json:"version" yaml:"version"`
+	Servers []ServerStatus `json:"servers" yaml:"servers"`
+	Error   string         `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+// statusServerDirs returns the directories of the servers in a sandbox, relative to the sandbox.
+// A server directory has a sandbox description and a data directory
+func statusServerDirs(sandboxPath, relativeDir string, depth int) []string {
+	currentDir := path.Join(sandboxPath, relativeDir)
+	if common.FileExists(path.Join(currentDir, globals.SandboxDescriptionName)) &&
+		common.DirExists(path.Join(currentDir, globals.DataDirName)) {
+		return []string{relativeDir}
+	}
+	if depth == 0 {
+		return nil
+	}
+	entries, err := os.ReadDir(currentDir)
+	if err != nil {
+		return nil
+	}
+	var dirs []string
+	for _, entry := range entries {
+		if entry.IsDir() && entry.Name() != globals.DataDirName {
+			dirs = append(dirs, statusServerDirs(sandboxPath, path.Join(relativeDir, entry.Name()), depth-1)...)
+		}
+	}
+	return dirs
+}
+
+// configuredOption returns the first value of an option in the configuration file of a server
+func configuredOption(serverPath, option string) string {
+	lines, err := common.SlurpAsLines(path.Join(serverPath, globals.ScriptMySandboxCnf))
+	if err != nil {
+		return ""
+	}
+	for _, line := range lines {
+		name, value, found := strings.Cut(line, "=")
+		if found && strings.TrimSpace(name) == option {
+			return strings.TrimSpace(value)
+		}
+	}
+	return ""
+}
+
+// isProcessAlive reports whether a process exists, without affecting it
+func isProcessAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	err = process.Signal(syscall.Signal(0))
+	// EPERM means that the process exists, but belongs to another user
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+// processStatus fills the state of a server from its PID file and socket
+func processStatus(serverPath, flavor string, status *ServerStatus) {
+	var socket, pidFile string
+	if common.BaseFlavor(flavor) == common.PostgreSQLFlavor {
+		socket = path.Join(common.GlobalTempDir(), fmt.Sprintf(".s.PGSQL.%d", status.Port))
+		pidFile = path.Join(serverPath, globals.DataDirName, globals.FnPgPid)
+	} else {
+		socket = configuredOption(serverPath, "socket")
+		pidFile = configuredOption(serverPath, "pid-file")
+		if pidFile == "" {
+			pidFile = path.Join(serverPath, globals.DataDirName, fmt.Sprintf("mysql_sandbox%d.pid", status.Port))
+		}
+	}
+	status.Socket = socket != "" && common.FileExists(socket)
+	status.State = ServerStopped
+	info, err := os.Stat(pidFile)
+	if err != nil {
+		return
+	}
+	text, err := common.SlurpAsString(pidFile)
+	if err != nil {
+		status.State = ServerCrashed
+		return
+	}
+	// PostgreSQL writes more information after the PID, one item per line
+	pidText, _, _ := strings.Cut(strings.TrimSpace(text), "\n")
+	pid, err := strconv.Atoi(strings.TrimSpace(pidText))
+	if err != nil || !isProcessAlive(pid) {
+		status.State = ServerCrashed
+		return
+	}
+	status.State = ServerRunning
+	status.Pid = pid
+	status.Uptime = int64(time.Since(info.ModTime()).Seconds())
+}
+
+// queryReplicaStatus returns the columns of each row of SHOW REPLICA STATUS
+func queryReplicaStatus(db *sql.DB) ([]map[string]string, error) {
+	rows, err := db.Query("SHOW REPLICA STATUS")
+	if err != nil {
+		// Before 8.0.22
+		rows, err = db.Query("SHOW SLAVE STATUS")
+		if err != nil {
+			return nil, err
+		}
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	var result []map[string]string
+	for rows.Next() {
+		values := make([]sql.NullString, len(columns))
+		pointers := make([]interface{}, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		err = rows.Scan(pointers...)
+		if err != nil {
+			return nil, err
+		}
+		row := make(map[string]string)
+		for i, column := range columns {
+			if values[i].Valid {
+				row[column] = values[i].String
+			}
+		}
+		result = append(result, row)
+	}
+	return result, rows.Err()
+}
+
+// replicaRole sets role and lag of a server that is not in a group. The lag is the highest of its channels
+func replicaRole(db *sql.DB, status *ServerStatus) error {
+	channels, err := queryReplicaStatus(db)
+	if err != nil {
+		return err
+	}
+	var dumpThreads int
+	err = db.QueryRow("select count(*) from information_schema.processlist where command like 'Binlog Dump%'").Scan(&dumpThreads)
+	if err != nil {
+		return err
+	}
+	switch {
+	case len(channels) > 0 && dumpThreads > 0:
+		status.Role = RoleRelay
+	case len(channels) > 0:
+		status.Role = RoleReplica
+	case dumpThreads > 0:
+		status.Role = RoleSource
+	default:
+		status.Role = RoleNone
+	}
+	var errorMessages []string
+	for _, channel := range channels {
+		for _, column := range []string{"Last_IO_Error", "Last_SQL_Error"} {
+			if channel[column] != "" {
+				errorMessages = append(errorMessages, channel[column])
+			}
+		}
+		seconds, found := channel["Seconds_Behind_Source"]
+		if !found {
+			seconds = channel["Seconds_Behind_Master"]
+		}
+		// NULL when replication is not running
+		lag, err := strconv.ParseInt(seconds, 10, 64)
+		if err != nil {
+			continue
+		}
+		if status.Lag == nil || lag > *status.Lag {
+			status.Lag = &lag
+		}
+	}
+	status.Error = strings.Join(errorMessages, "; ")
+	return nil
+}
+
+// queryStatus fills version, uptime, and replication role of a running server
+func queryStatus(serverPath, sbType string, status *ServerStatus) error {
+	db, _, err := connectToSandbox(serverPath, true)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	var uptime string
+	err = db.QueryRow("select @@version, variable_value from performance_schema.global_status where variable_name = 'Uptime'").
+		Scan(&status.Version, &uptime)
+	if err != nil {
+		return err
+	}
+	status.Uptime, _ = strconv.ParseInt(uptime, 10, 64)
+	if sbType != globals.SbTypeGroupSinglePrimary && sbType != globals.SbTypeGroupMultiPrimary {
+		return replicaRole(db.DB, status)
+	}
+	var role, state string
+	err = db.QueryRow("select ifnull(member_role, ''), ifnull(member_state, '') from performance_schema.replication_group_members "+
+		"where member_id = @@server_uuid").Scan(&role, &state)
+	if errors.Is(err, sql.ErrNoRows) {
+		status.Role = RoleNone
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	status.Role = strings.ToLower(role)
+	if state != GroupStateOnline {
+		status.Error = "member state: " + state
+	}
+	return nil
+}
+
+// GetSandboxStatus returns the live status of the servers of a sandbox.
+// When runQuery is set, running servers are also asked for version, uptime, and replication role
+func GetSandboxStatus(sandboxPath string, runQuery bool) (SandboxStatus, error) {
+	sbStatus := SandboxStatus{Name: path.Base(sandboxPath)}
+	sbDesc, err := common.ReadSandboxDescription(sandboxPath)
+	if err != nil {
+		return sbStatus, err
+	}
+	sbStatus.Type = sbDesc.SBType
+	sbStatus.Version = sbDesc.Version
+	for _, server := range statusServerDirs(sandboxPath, "", 2) {
+		serverPath := path.Join(sandboxPath, server)
+		status := ServerStatus{Node: server}
+		serverDesc, err := common.ReadSandboxDescription(serverPath)
+		if err != nil {
+			return sbStatus, err
+		}
+		if len(serverDesc.Port) > 0 {
+			status.Port = serverDesc.Port[0]
+		}
+		processStatus(serverPath, serverDesc.Flavor, &status)
+		// The queries are MySQL specific
+		isPostgreSQL := common.BaseFlavor(serverDesc.Flavor) == common.PostgreSQLFlavor
+		if runQuery && status.State == ServerRunning && !isPostgreSQL {
+			err = queryStatus(serverPath, sbDesc.SBType, &status)
+			if err != nil {
+				status.Error = err.Error()
+			}
+		}
+		sbStatus.Servers = append(sbStatus.Servers, status)
+	}
+	return sbStatus, nil
+}
+
+// GetSandboxesStatus returns the live status of all the sandboxes in a sandbox home
+func GetSandboxesStatus(sandboxHome string, runQuery bool) ([]SandboxStatus, error) {
+	sandboxes, err := common.GetInstalledSandboxes(sandboxHome)
+	if err != nil {
+		return nil, err
+	}
+	var statusList []SandboxStatus
+	for _, sb := range sandboxes {
+		// A sandbox with a broken description is listed, with the reason why its status is unknown
+		sbStatus, err := GetSandboxStatus(path.Join(sandboxHome, sb.SandboxName), runQuery)
+		if err != nil {
+			sbStatus.Error = err.Error()
+		}
+		statusList = append(statusList, sbStatus)
+	}
+	return statusList, nil
+}
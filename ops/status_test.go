@@ -0,0 +1,123 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2022 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ops
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/globals"
+	"github.com/stretchr/testify/require"
+)
+
+// makeStatusServer creates a server directory with a configuration file and an empty data directory
+func makeStatusServer(t *testing.T, serverDir string, port int) string {
+	require.NoError(t, os.MkdirAll(path.Join(serverDir, globals.DataDirName), 0700))
+	require.NoError(t, common.WriteSandboxDescription(serverDir, common.SandboxDescription{
+		SBType: "single", Version: "8.0.36", Port: []int{port}}))
+	pidFile := path.Join(serverDir, globals.DataDirName, fmt.Sprintf("mysql_sandbox%d.pid", port))
+	cnf := fmt.Sprintf("[mysqld]\nport = %d\nsocket = %s\npid-file = %s\n",
+		port, path.Join(serverDir, "mysql.sock"), pidFile)
+	require.NoError(t, os.WriteFile(path.Join(serverDir, globals.ScriptMySandboxCnf), []byte(cnf), 0600))
+	return pidFile
+}
+
+func TestProcessStatus(t *testing.T) {
+	serverDir := t.TempDir()
+	pidFile := makeStatusServer(t, serverDir, 8036)
+	require.Equal(t, "8036", configuredOption(serverDir, "port"))
+	require.Equal(t, pidFile, configuredOption(serverDir, "pid-file"))
+	require.Equal(t, "", configuredOption(serverDir, "no-such-option"))
+
+	status := ServerStatus{Port: 8036}
+	processStatus(serverDir, common.MySQLFlavor, &status)
+	require.Equal(t, ServerStopped, status.State)
+	require.False(t, status.Socket)
+
+	require.NoError(t, os.WriteFile(pidFile, []byte(""), 0600))
+	status = ServerStatus{Port: 8036}
+	processStatus(serverDir, common.MySQLFlavor, &status)
+	require.Equal(t, ServerCrashed, status.State)
+
+	require.NoError(t, os.WriteFile(pidFile, []byte(fmt.Sprintf("%d\n", os.Getpid())), 0600))
+	require.NoError(t, os.WriteFile(path.Join(serverDir, "mysql.sock"), []byte(""), 0600))
+	status = ServerStatus{Port: 8036}
+	processStatus(serverDir, common.MySQLFlavor, &status)
+	require.Equal(t, ServerRunning, status.State)
+	require.Equal(t, os.Getpid(), status.Pid)
+	require.True(t, status.Socket)
+}
+
+func TestProcessStatusPostgreSQL(t *testing.T) {
+	serverDir := t.TempDir()
+	require.NoError(t, os.MkdirAll(path.Join(serverDir, globals.DataDirName), 0700))
+	pidFile := path.Join(serverDir, globals.DataDirName, globals.FnPgPid)
+
+	status := ServerStatus{Port: 16200}
+	processStatus(serverDir, common.PostgreSQLFlavor, &status)
+	require.Equal(t, ServerStopped, status.State)
+
+	// postmaster.pid has the PID in the first line, followed by the data directory, start time, and port
+	pidText := fmt.Sprintf("%d\n%s\n1700000000\n16200\n", os.Getpid(), path.Join(serverDir, globals.DataDirName))
+	require.NoError(t, os.WriteFile(pidFile, []byte(pidText), 0600))
+	status = ServerStatus{Port: 16200}
+	processStatus(serverDir, common.PostgreSQLFlavor, &status)
+	require.Equal(t, ServerRunning, status.State)
+	require.Equal(t, os.Getpid(), status.Pid)
+}
+
+func TestGetSandboxesStatus(t *testing.T) {
+	sandboxHome := t.TempDir()
+	single := path.Join(sandboxHome, "msb_8_0_36")
+	makeStatusServer(t, single, 8036)
+
+	replication := path.Join(sandboxHome, "rsandbox_8_0_36")
+	require.NoError(t, os.Mkdir(replication, 0700))
+	require.NoError(t, common.WriteSandboxDescription(replication, common.SandboxDescription{
+		SBType: globals.MasterSlaveLabel, Version: "8.0.36", Nodes: 2}))
+	for i, node := range []string{"master", "node1", "node2"} {
+		makeStatusServer(t, path.Join(replication, node), 19000+i)
+	}
+
+	broken := path.Join(sandboxHome, "msb_8_0_35")
+	require.NoError(t, os.Mkdir(broken, 0700))
+	require.NoError(t, os.WriteFile(path.Join(broken, globals.SandboxDescriptionName), []byte("{"), 0600))
+
+	statusList, err := GetSandboxesStatus(sandboxHome, false)
+	require.NoError(t, err)
+	require.Len(t, statusList, 3)
+	for _, sbStatus := range statusList {
+		switch sbStatus.Name {
+		case "msb_8_0_36":
+			require.Equal(t, "single", sbStatus.Type)
+			require.Len(t, sbStatus.Servers, 1)
+			require.Equal(t, "", sbStatus.Servers[0].Node)
+			require.Equal(t, 8036, sbStatus.Servers[0].Port)
+		case "rsandbox_8_0_36":
+			require.Len(t, sbStatus.Servers, 3)
+			require.Equal(t, "node2", sbStatus.Servers[2].Node)
+			require.Equal(t, 19002, sbStatus.Servers[2].Port)
+			require.Equal(t, ServerStopped, sbStatus.Servers[2].State)
+		case "msb_8_0_35":
+			require.NotEmpty(t, sbStatus.Error)
+		default:
+			t.Fatalf("unexpected sandbox %s", sbStatus.Name)
+		}
+	}
+}
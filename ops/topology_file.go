@@ -0,0 +1,151 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2022 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ops
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/globals"
+	"gopkg.in/yaml.v3"
+)
+
+// TopologySandbox describes one deployment inside a topology file
+type TopologySandbox struct {
+	Name          string   `json:"name" yaml:"name"`
+	Version       string   `json:"version" yaml:"version"`
+	Flavor        string   `json:"flavor,omitempty" yaml:"flavor,omitempty"`
+	Topology      string   `json:"topology,omitempty" yaml:"topology,omitempty"`
+	Nodes         int      `json:"nodes,omitempty" yaml:"nodes,omitempty"`
+	FanOut        string   `json:"fan-out,omitempty" yaml:"fan-out,omitempty"`
+	Port          int      `json:"port,omitempty" yaml:"port,omitempty"`
+	BasePort      int      `json:"base-port,omitempty" yaml:"base-port,omitempty"`
+	ServerId      int      `json:"server-id,omitempty" yaml:"server-id,omitempty"`
+	Gtid          bool     `json:"gtid,omitempty" yaml:"gtid,omitempty"`
+	SinglePrimary bool     `json:"single-primary,omitempty" yaml:"single-primary,omitempty"`
+	MyCnfOptions  []string `json:"my-cnf-options,omitempty" yaml:"my-cnf-options,omitempty"`
+}
+
+// TopologyLink describes a replication channel between two sandboxes of the same file
+type TopologyLink struct {
+	Source  string `json:"source" yaml:"source"`
+	Replica string `json:"replica" yaml:"replica"`
+}
+
+// TopologyFile is the declarative description of a set of sandboxes
+type TopologyFile struct {
+	Sandboxes   []TopologySandbox `json:"sandboxes" yaml:"sandboxes"`
+	Replication []TopologyLink    `json:"replication,omitempty" yaml:"replication,omitempty"`
+}
+
+// IsLinked returns true if the named sandbox is either a source or a replica in any link
+func (tf TopologyFile) IsLinked(name string) bool {
+	for _, link := range tf.Replication {
+		if link.Source == name || link.Replica == name {
+			return true
+		}
+	}
+	return false
+}
+
+// ReadTopologyFile reads and validates a topology file.
+// Files with extension '.json' are parsed as JSON. Anything else is parsed as YAML
+func ReadTopologyFile(fileName string) (TopologyFile, error) {
+	var tf TopologyFile
+	if !common.FileExists(fileName) {
+		return tf, fmt.Errorf(globals.ErrFileNotFound, fileName)
+	}
+	contents, err := os.ReadFile(fileName) // #nosec G304
+	if err != nil {
+		return tf, fmt.Errorf("error reading topology file %s: %s", fileName, err)
+	}
+	if strings.ToLower(path.Ext(fileName)) == ".json" {
+		err = json.Unmarshal(contents, &tf)
+	} else {
+		err = yaml.Unmarshal(contents, &tf)
+	}
+	if err != nil {
+		return tf, fmt.Errorf("error decoding topology file %s: %s", fileName, err)
+	}
+	err = ValidateTopologyFile(tf)
+	if err != nil {
+		return tf, fmt.Errorf("topology file %s: %s", fileName, err)
+	}
+	return tf, nil
+}
+
+// ValidateTopologyFile checks that sandbox names are unique and that
+// replication links refer to sandboxes defined in the same file
+func ValidateTopologyFile(tf TopologyFile) error {
+	if len(tf.Sandboxes) == 0 {
+		return fmt.Errorf("no sandboxes defined")
+	}
+	names := make(map[string]bool)
+	for i, sb := range tf.Sandboxes {
+		if sb.Name == "" {
+			return fmt.Errorf("sandbox #%d has no name", i+1)
+		}
+		if strings.ContainsAny(sb.Name, "/ ") {
+			return fmt.Errorf("sandbox name '%s' must not contain spaces or slashes", sb.Name)
+		}
+		if names[sb.Name] {
+			return fmt.Errorf("sandbox '%s' is defined more than once", sb.Name)
+		}
+		names[sb.Name] = true
+		if sb.Version == "" {
+			return fmt.Errorf("sandbox '%s' has no version", sb.Name)
+		}
+		if sb.Topology != "" && sb.Topology != globals.SbTypeSingle && sb.Topology != globals.SbTypeMultiple &&
+			!slices.Contains(globals.AllowedTopologies, sb.Topology) {
+			return fmt.Errorf("sandbox '%s' has unknown topology '%s'", sb.Name, sb.Topology)
+		}
+		if sb.FanOut != "" {
+			if sb.Topology != globals.TreeLabel {
+				return fmt.Errorf("sandbox '%s': fan-out can only be used with '%s' topology", sb.Name, globals.TreeLabel)
+			}
+			_, err := common.StringToIntSlice(sb.FanOut)
+			if err != nil {
+				return fmt.Errorf("sandbox '%s': %s", sb.Name, err)
+			}
+		}
+		if sb.Nodes < 0 || sb.Port < 0 || sb.BasePort < 0 || sb.ServerId < 0 {
+			return fmt.Errorf("sandbox '%s' has negative numeric values", sb.Name)
+		}
+	}
+	replicas := make(map[string]string)
+	for _, link := range tf.Replication {
+		if !names[link.Source] {
+			return fmt.Errorf("replication source '%s' is not a defined sandbox", link.Source)
+		}
+		if !names[link.Replica] {
+			return fmt.Errorf("replica '%s' is not a defined sandbox", link.Replica)
+		}
+		if link.Source == link.Replica {
+			return fmt.Errorf("sandbox '%s' cannot replicate from itself", link.Source)
+		}
+		existing, found := replicas[link.Replica]
+		if found {
+			return fmt.Errorf("replica '%s' already replicates from '%s'", link.Replica, existing)
+		}
+		replicas[link.Replica] = link.Source
+	}
+	return nil
+}
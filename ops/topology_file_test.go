@@ -0,0 +1,122 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2022 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ops
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadTopologyFile(t *testing.T) {
+	yamlContents := `
+sandboxes:
+  - name: source
+    version: 8.0.40
+    port: 9000
+    my-cnf-options:
+      - max_connections=100
+  - name: replica
+    version: 8.4.3
+    topology: group
+    nodes: 5
+    single-primary: true
+replication:
+  - source: source
+    replica: replica
+`
+	jsonContents := `{"sandboxes": [{"name": "one", "version": "5.7.44", "flavor": "percona", "gtid": true}]}`
+
+	dir := t.TempDir()
+	yamlFile := path.Join(dir, "topology.yaml")
+	jsonFile := path.Join(dir, "topology.json")
+	require.NoError(t, os.WriteFile(yamlFile, []byte(yamlContents), 0600))
+	require.NoError(t, os.WriteFile(jsonFile, []byte(jsonContents), 0600))
+
+	tf, err := ReadTopologyFile(yamlFile)
+	require.NoError(t, err)
+	require.Len(t, tf.Sandboxes, 2)
+	require.Equal(t, 9000, tf.Sandboxes[0].Port)
+	require.Equal(t, []string{"max_connections=100"}, tf.Sandboxes[0].MyCnfOptions)
+	require.Equal(t, "group", tf.Sandboxes[1].Topology)
+	require.Equal(t, 5, tf.Sandboxes[1].Nodes)
+	require.True(t, tf.Sandboxes[1].SinglePrimary)
+	require.True(t, tf.IsLinked("replica"))
+
+	tf, err = ReadTopologyFile(jsonFile)
+	require.NoError(t, err)
+	require.Len(t, tf.Sandboxes, 1)
+	require.Equal(t, "percona", tf.Sandboxes[0].Flavor)
+	require.True(t, tf.Sandboxes[0].Gtid)
+	require.False(t, tf.IsLinked("one"))
+
+	_, err = ReadTopologyFile(path.Join(dir, "missing.yaml"))
+	require.Error(t, err)
+}
+
+func TestValidateTopologyFile(t *testing.T) {
+	var testCases = []struct {
+		name      string
+		tf        TopologyFile
+		errorText string
+	}{
+		{"empty", TopologyFile{}, "no sandboxes defined"},
+		{"no-name", TopologyFile{Sandboxes: []TopologySandbox{{Version: "8.0.40"}}}, "has no name"},
+		{"bad-name", TopologyFile{Sandboxes: []TopologySandbox{{Name: "a/b", Version: "8.0.40"}}}, "must not contain"},
+		{"no-version", TopologyFile{Sandboxes: []TopologySandbox{{Name: "a"}}}, "has no version"},
+		{"duplicate", TopologyFile{Sandboxes: []TopologySandbox{
+			{Name: "a", Version: "8.0.40"},
+			{Name: "a", Version: "8.0.40"}}}, "more than once"},
+		{"bad-topology", TopologyFile{Sandboxes: []TopologySandbox{
+			{Name: "a", Version: "8.0.40", Topology: "star"}}}, "unknown topology"},
+		{"fan-out-topology", TopologyFile{Sandboxes: []TopologySandbox{
+			{Name: "a", Version: "8.0.40", Topology: "chain", FanOut: "2"}}}, "fan-out can only be used"},
+		{"fan-out-value", TopologyFile{Sandboxes: []TopologySandbox{
+			{Name: "a", Version: "8.0.40", Topology: "tree", FanOut: "2,x"}}}, "is not a number"},
+		{"self-link", TopologyFile{
+			Sandboxes:   []TopologySandbox{{Name: "a", Version: "8.0.40"}},
+			Replication: []TopologyLink{{Source: "a", Replica: "a"}}}, "from itself"},
+		{"unknown-source", TopologyFile{
+			Sandboxes:   []TopologySandbox{{Name: "a", Version: "8.0.40"}},
+			Replication: []TopologyLink{{Source: "b", Replica: "a"}}}, "not a defined sandbox"},
+		{"two-sources", TopologyFile{
+			Sandboxes: []TopologySandbox{
+				{Name: "a", Version: "8.0.40"},
+				{Name: "b", Version: "8.0.40"},
+				{Name: "c", Version: "8.0.40"}},
+			Replication: []TopologyLink{
+				{Source: "a", Replica: "c"},
+				{Source: "b", Replica: "c"}}}, "already replicates"},
+		{"ok", TopologyFile{
+			Sandboxes: []TopologySandbox{
+				{Name: "a", Version: "8.0.40", Topology: "multiple"},
+				{Name: "b", Version: "8.0.40", Topology: "single"}},
+			Replication: []TopologyLink{{Source: "a", Replica: "b"}}}, ""},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := ValidateTopologyFile(tc.tf)
+			if tc.errorText == "" {
+				require.NoError(t, err)
+			} else {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), tc.errorText)
+			}
+		})
+	}
+}
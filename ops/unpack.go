@@ -72,6 +72,15 @@ func UnpackTarball(options UnpackOptions) error {
 			return fmt.Errorf("no flavor detected in %s. Please use --%s", tarball, globals.FlavorLabel)
 		}
 	}
+	isPostgreSQL := common.BaseFlavor(flavor) == common.PostgreSQLFlavor
+	// PostgreSQL 10+ versions have only two components (e.g. 16.2), which we use as 16.2.0
+	if detectedVersion == "" && isPostgreSQL {
+		rePgVersion := regexp.MustCompile(`(\d+)\.(\d+)`)
+		pgVerList := rePgVersion.FindStringSubmatch(tarball)
+		if pgVerList != nil {
+			detectedVersion = fmt.Sprintf("%s.%s.0", pgVerList[1], pgVerList[2])
+		}
+	}
 	Version := options.Version
 	if Version == "" {
 		Version = detectedVersion
@@ -135,7 +144,11 @@ func UnpackTarball(options UnpackOptions) error {
 	default:
 		return fmt.Errorf("tarball extension must be either '%s' or '%s'", globals.TarGzExt, globals.TarXzExt)
 	}
-	err = unpack.VerifyTarFile(tarball)
+	var otherTopDirs []string
+	if isPostgreSQL {
+		otherTopDirs = []string{globals.PgTarballTopDir}
+	}
+	err = unpack.VerifyTarFile(tarball, otherTopDirs...)
 	if err != nil {
 		return fmt.Errorf("validation for %s failed: %s", tarball, err)
 	}
@@ -156,11 +169,17 @@ func UnpackTarball(options UnpackOptions) error {
 		return nil
 	}
 
+	// Some PostgreSQL binary tarballs have a generic top directory instead of one named after the tarball
+	pgTopDir := path.Join(Basedir, globals.PgTarballTopDir)
+	pgTopDirExisted := common.DirExists(pgTopDir)
 	err = extractFunc(tarball, Basedir, verbosity)
 	if err != nil {
 		return err
 	}
 	finalName := path.Join(Basedir, bareName)
+	if isPostgreSQL && !common.DirExists(finalName) && !pgTopDirExisted && common.DirExists(pgTopDir) {
+		finalName = pgTopDir
+	}
 	// If the directory was not created, it probably means that the tarball was not well organised
 	// and either lacked the top directory or the top directory had a different name
 	if !common.DirExists(finalName) {
@@ -0,0 +1,668 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2022 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rest
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/pkg/errors"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/globals"
+)
+
+// Code in this module downloads files in a way that survives unreliable connections.
+// The data goes into a ".part" file, which gets the final name only when the download
+// is complete, and its checksum matches. An interrupted download resumes from the data
+// already received, using HTTP Range requests, both in the retries and in a later run.
+// The state of the download (URL, remote file version, chunks) is kept in a ".part.json" file.
+
+const (
+	PartSuffix      = ".part"
+	PartStateSuffix = ".part.json"
+)
+
+var (
+	// RetryDelay is the pause before retrying a failed request
+	RetryDelay = time.Second
+
+	// Files with chunks smaller than this are not split
+	minChunkSize int64 = globals.MB
+
+	reContentRange = regexp.MustCompile(`^bytes (?:(\d+)-(\d+)|\*)/(\d+)$`)
+
+	// ErrChecksumMismatch is returned when the downloaded data does not match the expected checksum
+	ErrChecksumMismatch = fmt.Errorf("unmatched checksum")
+
+	errRemoteChanged = fmt.Errorf("the remote file has changed since the download started")
+	errNoRanges      = fmt.Errorf("the server does not support partial downloads")
+)
+
+// DownloadOptions define what to download, and how
+type DownloadOptions struct {
+	Url          string
+	FileName     string
+	Progress     bool
+	ProgressStep int64
+	Retries      int64  // How many times a failed request is repeated, resuming from the data already received
+	Parallel     int    // How many chunks are downloaded at once. Requires a server that supports Range requests
+	Checksum     string // "TYPE:CHECKSUM_STRING", such as "SHA256:abc123", verified while the data arrives
+}
+
+// downloadChunk is a range of the remote file, downloaded by a single request
+type downloadChunk struct {
+	Start int64 `json:"start"`
+	End   int64 `json:"end"`  // exclusive
+	Done  int64 `json:"done"` // bytes received from Start
+}
+
+// downloadState is what a later run needs to resume a download
+type downloadState struct {
+	Url       string          `json:"url"`
+	Validator string          `json:"validator,omitempty"` // ETag or Last-Modified, sent as If-Range
+	Size      int64           `json:"size,omitempty"`
+	Chunks    []downloadChunk `json:"chunks,omitempty"`
+}
+
+// contiguous returns how many bytes from the start of the file have been received
+func (state *downloadState) contiguous() int64 {
+	var received int64
+	for _, chunk := range state.Chunks {
+		received = chunk.Start + chunk.Done
+		if chunk.Start+chunk.Done < chunk.End {
+			break
+		}
+	}
+	return received
+}
+
+func (state *downloadState) received() int64 {
+	var received int64
+	for _, chunk := range state.Chunks {
+		received += chunk.Done
+	}
+	return received
+}
+
+func (state *downloadState) save(fileName string) error {
+	text, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(fileName, text, 0600)
+}
+
+// loadDownloadState reads the state of a previous download of the same URL. It returns nil when there is none
+func loadDownloadState(fileName, url string) *downloadState {
+	text, err := os.ReadFile(fileName) // #nosec G304
+	if err != nil {
+		return nil
+	}
+	var state downloadState
+	err = json.Unmarshal(text, &state)
+	if err != nil || state.Url != url {
+		return nil
+	}
+	return &state
+}
+
+// checksumVerifier computes the checksum of the data as it arrives. Without an expected checksum, it does nothing
+type checksumVerifier struct {
+	hasher   hash.Hash
+	crcType  string
+	expected string
+}
+
+func newChecksumVerifier(checksum string) (*checksumVerifier, error) {
+	verifier := &checksumVerifier{}
+	if checksum == "" {
+		return verifier, nil
+	}
+	crcType, crcText, err := common.ParseChecksum(checksum)
+	if err != nil {
+		return nil, err
+	}
+	hasher, err := common.NewChecksumHasher(crcType)
+	if err != nil {
+		return nil, err
+	}
+	return &checksumVerifier{hasher: hasher, crcType: crcType, expected: crcText}, nil
+}
+
+func (v *checksumVerifier) Write(p []byte) (int, error) {
+	if v.hasher != nil {
+		_, _ = v.hasher.Write(p)
+	}
+	return len(p), nil
+}
+
+func (v *checksumVerifier) reset() {
+	if v.hasher != nil {
+		v.hasher.Reset()
+	}
+}
+
+// addRange adds to the checksum a range of data already written
+func (v *checksumVerifier) addRange(r io.ReaderAt, from, to int64) error {
+	if v.hasher == nil || to <= from {
+		return nil
+	}
+	_, err := io.Copy(v.hasher, io.NewSectionReader(r, from, to-from))
+	return err
+}
+
+func (v *checksumVerifier) verify() error {
+	if v.hasher == nil {
+		return nil
+	}
+	found := hex.EncodeToString(v.hasher.Sum(nil))
+	if !strings.EqualFold(found, v.expected) {
+		return fmt.Errorf("%w: expected %s '%s' but found '%s'", ErrChecksumMismatch, v.crcType, v.expected, found)
+	}
+	return nil
+}
+
+// httpStatusError is an unexpected answer from the server
+type httpStatusError struct {
+	code int
+}
+
+func (e *httpStatusError) Error() string {
+	return fmt.Sprintf("received code %d", e.code)
+}
+
+// isRetryable tells whether a failed request is worth repeating
+func isRetryable(err error) bool {
+	var statusError *httpStatusError
+	if errors.As(err, &statusError) {
+		return statusError.code >= 500 || statusError.code == http.StatusTooManyRequests
+	}
+	return !errors.Is(err, errRemoteChanged) && !errors.Is(err, context.Canceled)
+}
+
+// parseContentRange returns start and total size from a Content-Range header.
+// The start is -1 for "bytes */size"
+func parseContentRange(header string) (start, size int64, err error) {
+	matches := reContentRange.FindStringSubmatch(header)
+	if matches == nil {
+		return 0, 0, fmt.Errorf("invalid Content-Range '%s'", header)
+	}
+	start = -1
+	if matches[1] != "" {
+		start, _ = strconv.ParseInt(matches[1], 10, 64)
+	}
+	size, _ = strconv.ParseInt(matches[3], 10, 64)
+	return start, size, nil
+}
+
+// remoteValidator returns what identifies the version of a remote file: a strong ETag, or the modification time
+func remoteValidator(resp *http.Response) string {
+	etag := resp.Header.Get("ETag")
+	if etag != "" && !strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+	return resp.Header.Get("Last-Modified")
+}
+
+// DownloadWithOptions downloads a URL into a local file. A failed download leaves a ".part" file,
+// which the next attempt resumes. With a checksum, a file that does not match is removed
+func DownloadWithOptions(options DownloadOptions) error {
+	partFile := options.FileName + PartSuffix
+	stateFile := options.FileName + PartStateSuffix
+	verifier, err := newChecksumVerifier(options.Checksum)
+	if err != nil {
+		return err
+	}
+	if options.ProgressStep <= 0 {
+		options.Progress = false
+	}
+	retries := options.Retries
+	if retries < 0 {
+		retries = 0
+	}
+	maxAttempts := int(retries) + 1
+
+	state := loadDownloadState(stateFile, options.Url)
+	if state == nil {
+		// Partial data of unknown origin can't be trusted
+		_ = os.Remove(partFile)
+		state = &downloadState{Url: options.Url}
+	}
+	if state.Chunks == nil && options.Parallel > 1 && !common.FileExists(partFile) {
+		err = splitDownload(options, state, stateFile)
+		if err != nil && !errors.Is(err, errNoRanges) {
+			return err
+		}
+	}
+	if state.Chunks != nil && options.Parallel <= 1 {
+		// A chunked download resumed as a single stream keeps only the data at the start of the file
+		err = os.Truncate(partFile, state.contiguous())
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		state.Chunks = nil
+		err = state.save(stateFile)
+		if err != nil {
+			return err
+		}
+	}
+	if state.Chunks != nil {
+		err = downloadChunks(options, partFile, stateFile, state, verifier, maxAttempts)
+	} else {
+		err = downloadStream(options, partFile, stateFile, state, verifier, maxAttempts)
+	}
+	if err != nil {
+		if errors.Is(err, errRemoteChanged) || state.Chunks == nil && isEmptyFile(partFile) ||
+			state.Chunks != nil && state.received() == 0 {
+			// Nothing that a later run could resume
+			_ = os.Remove(partFile)
+			_ = os.Remove(stateFile)
+		}
+		return err
+	}
+	err = verifier.verify()
+	if err != nil {
+		// A corrupted file can't be resumed
+		_ = os.Remove(partFile)
+		_ = os.Remove(stateFile)
+		return fmt.Errorf("error verifying %s: %w", options.FileName, err)
+	}
+	_ = os.Remove(stateFile)
+	return os.Rename(partFile, options.FileName)
+}
+
+// isEmptyFile tells whether a file is missing or has no data
+func isEmptyFile(fileName string) bool {
+	info, err := os.Stat(fileName)
+	return err != nil || info.Size() == 0
+}
+
+// partData is the ".part" file of a stream download. It is created when the first data arrives
+type partData struct {
+	name string
+	*os.File
+}
+
+func (p *partData) open() error {
+	if p.File != nil {
+		return nil
+	}
+	out, err := os.OpenFile(p.name, os.O_CREATE|os.O_RDWR, 0644) // #nosec G302 G304
+	if err != nil {
+		return fmt.Errorf("error creating file %s: %s", p.name, err)
+	}
+	p.File = out
+	return nil
+}
+
+func (p *partData) close() {
+	if p.File != nil {
+		_ = p.File.Close()
+	}
+}
+
+// downloadStream downloads the file with a single request, resuming from the end of the ".part" file
+func downloadStream(options DownloadOptions, partFile, stateFile string, state *downloadState, verifier *checksumVerifier, maxAttempts int) error {
+	out := &partData{name: partFile}
+	defer out.close()
+	var offset int64
+	if common.FileExists(partFile) {
+		err := out.open()
+		if err != nil {
+			return err
+		}
+		offset, err = out.Seek(0, io.SeekEnd)
+		if err != nil {
+			return err
+		}
+	}
+	if offset > 0 {
+		if options.Progress {
+			fmt.Printf("Resuming download from %s\n", humanize.Bytes(uint64(offset)))
+		}
+		err := verifier.addRange(out, 0, offset)
+		if err != nil {
+			return err
+		}
+	}
+	progress := &PassThru{
+		total:           offset,
+		maxBytesPerDot:  options.ProgressStep,
+		maxBytesPerMark: options.ProgressStep * 10,
+		showProgress:    options.Progress,
+	}
+	for attempts := 1; ; attempts++ {
+		var err error
+		offset, err = fetchStream(options.Url, out, offset, state, stateFile, verifier, progress)
+		if err == nil {
+			return nil
+		}
+		if attempts >= maxAttempts || !isRetryable(err) {
+			return fmt.Errorf("error getting %s (attempts: %d): %w", options.Url, attempts, err)
+		}
+		time.Sleep(RetryDelay)
+	}
+}
+
+// fetchStream requests the data from a given offset to the end of the file, and returns the new offset
+func fetchStream(url string, out *partData, offset int64, state *downloadState, stateFile string,
+	verifier *checksumVerifier, progress *PassThru) (int64, error) {
+	restart := func() (int64, error) {
+		verifier.reset()
+		progress.total = 0
+		if out.File == nil {
+			return 0, nil
+		}
+		err := out.Truncate(0)
+		if err != nil {
+			return offset, err
+		}
+		return out.Seek(0, io.SeekStart)
+	}
+	request, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return offset, err
+	}
+	if offset > 0 {
+		request.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		if state.Validator != "" {
+			// If the file has changed, the server sends all of it
+			request.Header.Set("If-Range", state.Validator)
+		}
+	}
+	resp, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return offset, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		start, _, err := parseContentRange(resp.Header.Get("Content-Range"))
+		if err != nil || start != offset {
+			offset, _ = restart()
+			return offset, fmt.Errorf("unexpected partial content '%s' for offset %d", resp.Header.Get("Content-Range"), offset)
+		}
+	case http.StatusOK:
+		if offset > 0 {
+			// The server does not support ranges, or the file has changed: the download starts again
+			offset, err = restart()
+			if err != nil {
+				return offset, err
+			}
+		}
+		state.Validator = remoteValidator(resp)
+		err = state.save(stateFile)
+		if err != nil {
+			return offset, err
+		}
+	case http.StatusRequestedRangeNotSatisfiable:
+		_, size, err := parseContentRange(resp.Header.Get("Content-Range"))
+		if err == nil && offset > 0 && size == offset {
+			// All the data was already there
+			return offset, nil
+		}
+		offset, _ = restart()
+		return offset, &httpStatusError{resp.StatusCode}
+	default:
+		return offset, &httpStatusError{resp.StatusCode}
+	}
+	err = out.open()
+	if err != nil {
+		return offset, err
+	}
+	progress.Reader = resp.Body
+	written, err := io.Copy(io.MultiWriter(out, verifier), progress)
+	return offset + written, err
+}
+
+// splitDownload divides the remote file into chunks, when the server supports Range requests
+// and the file is large enough
+func splitDownload(options DownloadOptions, state *downloadState, stateFile string) error {
+	request, err := http.NewRequest(http.MethodGet, options.Url, nil)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Range", "bytes=0-0")
+	resp, err := http.DefaultClient.Do(request)
+	if err != nil {
+		// The stream download will retry, and report the error
+		return errNoRanges
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent {
+		return errNoRanges
+	}
+	_, size, err := parseContentRange(resp.Header.Get("Content-Range"))
+	if err != nil {
+		return errNoRanges
+	}
+	numChunks := int64(options.Parallel)
+	if size/numChunks < minChunkSize {
+		numChunks = size / minChunkSize
+	}
+	if numChunks < 2 {
+		return errNoRanges
+	}
+	chunkSize := size / numChunks
+	var chunks []downloadChunk
+	for start := int64(0); start < size; start += chunkSize {
+		end := start + chunkSize
+		if end > size || int64(len(chunks)) == numChunks-1 {
+			end = size
+		}
+		chunks = append(chunks, downloadChunk{Start: start, End: end})
+		if end == size {
+			break
+		}
+	}
+	state.Validator = remoteValidator(resp)
+	state.Size = size
+	state.Chunks = chunks
+	return state.save(stateFile)
+}
+
+// chunkWriter writes the data of a chunk at its position in the file, and records the progress
+type chunkWriter struct {
+	out      *os.File
+	offset   int64
+	chunk    *downloadChunk
+	mutex    *sync.Mutex
+	progress *PassThru
+	notify   chan struct{}
+}
+
+func (w *chunkWriter) Write(p []byte) (int, error) {
+	n, err := w.out.WriteAt(p, w.offset)
+	w.offset += int64(n)
+	w.mutex.Lock()
+	w.chunk.Done += int64(n)
+	w.progress.count(n, nil)
+	w.mutex.Unlock()
+	select {
+	case w.notify <- struct{}{}:
+	default:
+	}
+	return n, err
+}
+
+// downloadChunks downloads the missing chunks at once. The checksum is computed
+// while the data at the start of the file becomes complete
+func downloadChunks(options DownloadOptions, partFile, stateFile string, state *downloadState, verifier *checksumVerifier, maxAttempts int) error {
+	out, err := os.OpenFile(partFile, os.O_CREATE|os.O_RDWR, 0644) // #nosec G302 G304
+	if err != nil {
+		return fmt.Errorf("error creating file %s: %s", partFile, err)
+	}
+	defer out.Close() // #nosec G307
+	err = out.Truncate(state.Size)
+	if err != nil {
+		return err
+	}
+	var mutex sync.Mutex
+	received := state.received()
+	if options.Progress {
+		if received > 0 {
+			fmt.Printf("Resuming download from %s\n", humanize.Bytes(uint64(received)))
+		}
+		fmt.Printf("Downloading %s in %d chunks\n", humanize.Bytes(uint64(state.Size)), len(state.Chunks))
+	}
+	progress := &PassThru{
+		total:           received,
+		maxBytesPerDot:  options.ProgressStep,
+		maxBytesPerMark: options.ProgressStep * 10,
+		showProgress:    options.Progress,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	notify := make(chan struct{}, 1)
+	errorChannel := make(chan error, len(state.Chunks))
+	var wg sync.WaitGroup
+	for i := range state.Chunks {
+		wg.Add(1)
+		go func(chunk *downloadChunk) {
+			defer wg.Done()
+			writer := &chunkWriter{out: out, chunk: chunk, mutex: &mutex, progress: progress, notify: notify}
+			err := fetchChunk(ctx, options.Url, state.Validator, writer, maxAttempts)
+			if err != nil {
+				errorChannel <- err
+				// One failed chunk stops the others. The data received so far is kept
+				cancel()
+			}
+		}(&state.Chunks[i])
+	}
+	finished := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(finished)
+	}()
+
+	var hashed int64
+	hashReceived := func() error {
+		mutex.Lock()
+		contiguous := state.contiguous()
+		mutex.Unlock()
+		err := verifier.addRange(out, hashed, contiguous)
+		hashed = contiguous
+		return err
+	}
+	lastSave := time.Now()
+	var hashError error
+	waiting := true
+	for waiting {
+		select {
+		case <-notify:
+		case <-finished:
+			waiting = false
+		}
+		if hashError == nil {
+			hashError = hashReceived()
+		}
+		if time.Since(lastSave) > time.Second {
+			// The saved state allows a later run to resume, even if this one is killed
+			mutex.Lock()
+			_ = state.save(stateFile)
+			mutex.Unlock()
+			lastSave = time.Now()
+		}
+	}
+	close(errorChannel)
+	err = <-errorChannel
+	if err != nil {
+		_ = state.save(stateFile)
+		return err
+	}
+	if hashError != nil {
+		return hashError
+	}
+	progress.count(0, io.EOF)
+	return nil
+}
+
+// fetchChunk downloads the rest of a chunk, retrying after a failure
+func fetchChunk(ctx context.Context, url, validator string, writer *chunkWriter, maxAttempts int) error {
+	for attempts := 1; ; attempts++ {
+		err := fetchChunkRange(ctx, url, validator, writer)
+		if err == nil {
+			return nil
+		}
+		if attempts >= maxAttempts || !isRetryable(err) || ctx.Err() != nil {
+			return fmt.Errorf("error getting bytes %d-%d of %s (attempts: %d): %w",
+				writer.chunk.Start, writer.chunk.End-1, url, attempts, err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(RetryDelay):
+		}
+	}
+}
+
+func fetchChunkRange(ctx context.Context, url, validator string, writer *chunkWriter) error {
+	writer.mutex.Lock()
+	start := writer.chunk.Start + writer.chunk.Done
+	end := writer.chunk.End
+	writer.mutex.Unlock()
+	if start >= end {
+		return nil
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end-1))
+	if validator != "" {
+		request.Header.Set("If-Range", validator)
+	}
+	resp, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusOK:
+		return errRemoteChanged
+	default:
+		return &httpStatusError{resp.StatusCode}
+	}
+	rangeStart, _, err := parseContentRange(resp.Header.Get("Content-Range"))
+	if err != nil {
+		return err
+	}
+	if rangeStart != start {
+		return fmt.Errorf("unexpected partial content '%s' for offset %d", resp.Header.Get("Content-Range"), start)
+	}
+	writer.offset = start
+	written, err := io.Copy(writer, io.LimitReader(resp.Body, end-start))
+	if err == nil && written < end-start {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,244 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2022 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rest
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/stretchr/testify/require"
+)
+
+// flakyServer serves a file, dropping the connection of the first requests after a given amount of data
+type flakyServer struct {
+	data         []byte
+	dropAfter    int  // bytes sent before dropping a connection
+	failures     int  // how many requests are dropped
+	ignoreRanges bool // behave like a server without Range support
+	mutex        sync.Mutex
+	ranges       []string
+}
+
+// droppingWriter aborts the response after a given amount of data
+type droppingWriter struct {
+	http.ResponseWriter
+	remaining int
+}
+
+func (w *droppingWriter) Write(p []byte) (int, error) {
+	if len(p) > w.remaining {
+		n, _ := w.ResponseWriter.Write(p[:w.remaining])
+		w.remaining -= n
+		w.ResponseWriter.(http.Flusher).Flush()
+		panic(http.ErrAbortHandler)
+	}
+	w.remaining -= len(p)
+	return w.ResponseWriter.Write(p)
+}
+
+func (s *flakyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/file.tar.xz" {
+		http.NotFound(w, r)
+		return
+	}
+	if s.ignoreRanges {
+		r.Header.Del("Range")
+	}
+	s.mutex.Lock()
+	s.ranges = append(s.ranges, r.Header.Get("Range"))
+	drop := r.Header.Get("Range") != "bytes=0-0" && s.failures > 0
+	if drop {
+		s.failures--
+	}
+	s.mutex.Unlock()
+	if drop {
+		w = &droppingWriter{ResponseWriter: w, remaining: s.dropAfter}
+	}
+	http.ServeContent(w, r, "file.tar.xz", time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC), bytes.NewReader(s.data))
+}
+
+func (s *flakyServer) requestedRanges() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]string{}, s.ranges...)
+}
+
+func makeFlakyServer(t *testing.T, size int) (*flakyServer, string) {
+	data := make([]byte, size)
+	_, _ = rand.New(rand.NewSource(1)).Read(data) // #nosec G404
+	server := &flakyServer{data: data}
+	testServer := httptest.NewServer(server)
+	t.Cleanup(testServer.Close)
+	savedRetryDelay := RetryDelay
+	RetryDelay = 10 * time.Millisecond
+	t.Cleanup(func() { RetryDelay = savedRetryDelay })
+	return server, testServer.URL + "/file.tar.xz"
+}
+
+func dataChecksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "SHA256:" + hex.EncodeToString(sum[:])
+}
+
+func requireDownloaded(t *testing.T, fileName string, data []byte) {
+	contents, err := os.ReadFile(fileName)
+	require.NoError(t, err)
+	require.True(t, bytes.Equal(data, contents), "downloaded data differs from the original")
+	require.False(t, common.FileExists(fileName+PartSuffix))
+	require.False(t, common.FileExists(fileName+PartStateSuffix))
+}
+
+func TestDownloadResumeWithRetries(t *testing.T) {
+	server, url := makeFlakyServer(t, 300000)
+	server.dropAfter = 100000
+	server.failures = 2
+	fileName := path.Join(t.TempDir(), "file.tar.xz")
+
+	err := DownloadWithOptions(DownloadOptions{Url: url, FileName: fileName, Retries: 3, Checksum: dataChecksum(server.data)})
+	require.NoError(t, err)
+	requireDownloaded(t, fileName, server.data)
+	// Each retry continues from where the previous one stopped
+	require.Equal(t, []string{"", "bytes=100000-", "bytes=200000-"}, server.requestedRanges())
+}
+
+func TestDownloadResumeAcrossCalls(t *testing.T) {
+	server, url := makeFlakyServer(t, 300000)
+	server.dropAfter = 120000
+	server.failures = 1
+	fileName := path.Join(t.TempDir(), "file.tar.xz")
+	options := DownloadOptions{Url: url, FileName: fileName, Checksum: dataChecksum(server.data)}
+
+	err := DownloadWithOptions(options)
+	require.Error(t, err)
+	require.False(t, common.FileExists(fileName))
+	info, err := os.Stat(fileName + PartSuffix)
+	require.NoError(t, err)
+	require.Equal(t, int64(120000), info.Size())
+
+	err = DownloadWithOptions(options)
+	require.NoError(t, err)
+	requireDownloaded(t, fileName, server.data)
+	require.Equal(t, []string{"", "bytes=120000-"}, server.requestedRanges())
+
+	// Partial data from a different URL is not used
+	require.NoError(t, os.WriteFile(fileName+PartSuffix, []byte("stale data"), 0600))
+	require.NoError(t, os.Remove(fileName))
+	err = DownloadWithOptions(options)
+	require.NoError(t, err)
+	requireDownloaded(t, fileName, server.data)
+}
+
+func TestDownloadChecksumMismatch(t *testing.T) {
+	_, url := makeFlakyServer(t, 100000)
+	fileName := path.Join(t.TempDir(), "file.tar.xz")
+
+	err := DownloadWithOptions(DownloadOptions{Url: url, FileName: fileName, Checksum: dataChecksum([]byte("something else"))})
+	require.ErrorIs(t, err, ErrChecksumMismatch)
+	require.Contains(t, err.Error(), "expected SHA256")
+	require.False(t, common.FileExists(fileName))
+	require.False(t, common.FileExists(fileName+PartSuffix))
+	require.False(t, common.FileExists(fileName+PartStateSuffix))
+
+	err = DownloadWithOptions(DownloadOptions{Url: url, FileName: fileName, Checksum: "SHA256"})
+	require.Error(t, err)
+}
+
+func TestDownloadFailedRequest(t *testing.T) {
+	_, url := makeFlakyServer(t, 100000)
+	dir := t.TempDir()
+	fileName := path.Join(dir, "file.tar.xz")
+
+	for _, wrongUrl := range []string{url + "-missing", "http://127.0.0.1:1/file.tar.xz"} {
+		err := DownloadWithOptions(DownloadOptions{Url: wrongUrl, FileName: fileName, Retries: 1})
+		require.Error(t, err)
+		// A request without data leaves nothing behind
+		files, err := os.ReadDir(dir)
+		require.NoError(t, err)
+		require.Empty(t, files)
+	}
+}
+
+func TestDownloadWithoutRangeSupport(t *testing.T) {
+	server, url := makeFlakyServer(t, 300000)
+	server.dropAfter = 100000
+	server.failures = 1
+	server.ignoreRanges = true
+	fileName := path.Join(t.TempDir(), "file.tar.xz")
+
+	err := DownloadWithOptions(DownloadOptions{Url: url, FileName: fileName, Retries: 1, Parallel: 4, Checksum: dataChecksum(server.data)})
+	require.NoError(t, err)
+	requireDownloaded(t, fileName, server.data)
+}
+
+func TestDownloadParallel(t *testing.T) {
+	savedChunkSize := minChunkSize
+	minChunkSize = 50000
+	t.Cleanup(func() { minChunkSize = savedChunkSize })
+
+	server, url := makeFlakyServer(t, 400000)
+	server.dropAfter = 20000
+	server.failures = 3
+	fileName := path.Join(t.TempDir(), "file.tar.xz")
+
+	err := DownloadWithOptions(DownloadOptions{Url: url, FileName: fileName, Retries: 3, Parallel: 4, Checksum: dataChecksum(server.data)})
+	require.NoError(t, err)
+	requireDownloaded(t, fileName, server.data)
+	ranges := server.requestedRanges()
+	require.Equal(t, "bytes=0-0", ranges[0])
+	require.Contains(t, ranges, "bytes=300000-399999")
+	// The dropped chunks were resumed, not restarted
+	require.Len(t, ranges, 1+4+3)
+
+	// A file too small to be split is downloaded as a single stream
+	server.failures = 0
+	smallFile := path.Join(t.TempDir(), "small.tar.xz")
+	minChunkSize = 300000
+	err = DownloadWithOptions(DownloadOptions{Url: url, FileName: smallFile, Parallel: 4, Checksum: dataChecksum(server.data)})
+	require.NoError(t, err)
+	requireDownloaded(t, smallFile, server.data)
+}
+
+func TestDownloadParallelResumeAcrossCalls(t *testing.T) {
+	savedChunkSize := minChunkSize
+	minChunkSize = 50000
+	t.Cleanup(func() { minChunkSize = savedChunkSize })
+
+	server, url := makeFlakyServer(t, 200000)
+	server.dropAfter = 30000
+	server.failures = 4
+	fileName := path.Join(t.TempDir(), "file.tar.xz")
+	options := DownloadOptions{Url: url, FileName: fileName, Parallel: 2, Checksum: dataChecksum(server.data)}
+
+	err := DownloadWithOptions(options)
+	require.Error(t, err)
+	require.True(t, common.FileExists(fileName+PartStateSuffix))
+
+	// The chunks that were interrupted continue from the data received
+	server.failures = 0
+	err = DownloadWithOptions(options)
+	require.NoError(t, err)
+	requireDownloaded(t, fileName, server.data)
+}
@@ -21,7 +21,6 @@ import (
 	"io"
 	"net/http"
 	"os"
-	"time"
 
 	"github.com/dustin/go-humanize"
 	"github.com/pkg/errors"
@@ -108,6 +107,12 @@ type PassThru struct {
 // use it to keep track of byte counts and then forward the call.
 func (pt *PassThru) Read(p []byte) (int, error) {
 	n, err := pt.Reader.Read(p)
+	pt.count(n, err)
+	return n, err
+}
+
+// count updates the byte counts, and shows the progress
+func (pt *PassThru) count(n int, err error) {
 	pt.total += int64(n)
 	pt.stepProgress += int64(n)
 	pt.markProgress += int64(n)
@@ -126,7 +131,6 @@ func (pt *PassThru) Read(p []byte) (int, error) {
 	if pt.showProgress && err == io.EOF {
 		fmt.Println(" ", humanize.Bytes(uint64(pt.total)))
 	}
-	return n, err
 }
 
 // DownloadFile will download a url to a local file. It's efficient because it will
@@ -137,59 +141,24 @@ func DownloadFile(filepath string, url string, progress bool, progressStep int64
 
 // DownloadFileWithRetry will download a url to a local file. It's efficient because it will
 // write as it downloads and not load the whole file into memory.
+// As in previous versions, retriesOnFailure is the total number of attempts (at most 10),
+// with one second between them. Unlike previous versions, a failure during the transfer,
+// or a server error (5xx), also causes a new attempt, which resumes from the data already received.
 func DownloadFileWithRetry(filepath string, url string, progress bool, progressStep, retriesOnFailure int64) error {
-
-	// Get the data
-	var resp *http.Response
-	var err error
-	var attempts int64 = 1
-	if retriesOnFailure > 10 {
-		retriesOnFailure = 10
-	}
-	resp, err = http.Get(url) // #nosec G107
-	for err != nil && attempts < retriesOnFailure {
-		time.Sleep(time.Second)
-		resp, err = http.Get(url) // #nosec G107
-		attempts++
-	}
+	retries := retriesOnFailure - 1
+	if retries > 9 {
+		retries = 9
+	}
+	err := DownloadWithOptions(DownloadOptions{
+		Url:          url,
+		FileName:     filepath,
+		Progress:     progress,
+		ProgressStep: progressStep,
+		Retries:      retries,
+	})
 	if err != nil {
-		return fmt.Errorf("[DownloadFileWithRetry] error getting %s (attempts: %d): %s", url, attempts, err)
+		return fmt.Errorf("[DownloadFileWithRetry] %s", err)
 	}
-	defer func(Body io.ReadCloser) {
-		err := Body.Close()
-		if err != nil {
-			fmt.Printf("[DownloadFileWithRetry] error closing response body: %s", err)
-		}
-	}(resp.Body)
-
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("[DownloadFileWithRetry] received code %d ", resp.StatusCode)
-	}
-
-	// Create the file
-	out, err := os.Create(filepath) // #nosec G304
-	if err != nil {
-		return fmt.Errorf("[DownloadFileWithRetry] error creating file %s", filepath)
-	}
-	defer out.Close() // #nosec G307
-
-	progressMark := progressStep * 10
-	if progressStep <= 0 {
-		progress = false
-	}
-	newBody := &PassThru{
-		Reader:          resp.Body,
-		maxBytesPerDot:  progressStep,
-		maxBytesPerMark: progressMark,
-		showProgress:    progress,
-	}
-
-	// Write the body to file
-	_, err = io.Copy(out, newBody)
-	if err != nil {
-		return fmt.Errorf("[DownloadFileWithRetry] error during data writing to file %s: %s", filepath, err)
-	}
-
 	return nil
 }
 
@@ -0,0 +1,98 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2022 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sandbox
+
+import (
+	"fmt"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/globals"
+)
+
+// chainSources returns the replication sources for a chain of nodes:
+// every node replicates from the previous one
+func chainSources(nodes int) []int {
+	var sources []int
+	for node := 2; node <= nodes; node++ {
+		sources = append(sources, node-1)
+	}
+	return sources
+}
+
+// treeSources returns the replication sources for a tree of nodes, filled level by level.
+// fanOut[L] is the number of replicas for each node at level L. When the tree is deeper
+// than the fan-out list, the last value is used for the remaining levels
+func treeSources(nodes int, fanOut []int) ([]int, error) {
+	if len(fanOut) == 0 {
+		return nil, fmt.Errorf("empty fan-out list")
+	}
+	for _, f := range fanOut {
+		if f < 1 {
+			return nil, fmt.Errorf("fan-out values must be greater than zero (%v)", fanOut)
+		}
+	}
+	var sources []int
+	currentLevel := []int{1}
+	level := 0
+	nextNode := 2
+	for nextNode <= nodes {
+		levelFanOut := fanOut[len(fanOut)-1]
+		if level < len(fanOut) {
+			levelFanOut = fanOut[level]
+		}
+		var nextLevel []int
+		for _, source := range currentLevel {
+			for n := 0; n < levelFanOut && nextNode <= nodes; n++ {
+				sources = append(sources, source)
+				nextLevel = append(nextLevel, nextNode)
+				nextNode++
+			}
+		}
+		currentLevel = nextLevel
+		level++
+	}
+	return sources, nil
+}
+
+// TreeNodes returns the number of nodes in a complete tree with the given fan-out per level
+func TreeNodes(fanOut []int) int {
+	total := 1
+	levelNodes := 1
+	for _, f := range fanOut {
+		levelNodes *= f
+		total += levelNodes
+	}
+	return total
+}
+
+// CreateChainReplication deploys a cascading replication (master -> node1 -> node2 -> ...)
+func CreateChainReplication(sandboxDef SandboxDef, origin string, nodes int, masterIp string) error {
+	return createMasterSlaveReplication(sandboxDef, origin, nodes, masterIp, globals.ChainLabel, chainSources(nodes))
+}
+
+// CreateTreeReplication deploys a master with replicas that are in turn sources for other replicas.
+// fanOut is a comma separated list with the number of replicas for each node at every level
+func CreateTreeReplication(sandboxDef SandboxDef, origin string, nodes int, masterIp, fanOut string) error {
+	fanOutList, err := common.StringToIntSlice(fanOut)
+	if err != nil {
+		return err
+	}
+	sources, err := treeSources(nodes, fanOutList)
+	if err != nil {
+		return err
+	}
+	return createMasterSlaveReplication(sandboxDef, origin, nodes, masterIp, globals.TreeLabel, sources)
+}
@@ -0,0 +1,64 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2022 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sandbox
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/datacharmer/dbdeployer/compare"
+)
+
+func TestChainSources(t *testing.T) {
+	compare.OkEqualIntSlices(t, chainSources(2), []int{1})
+	compare.OkEqualIntSlices(t, chainSources(5), []int{1, 2, 3, 4})
+}
+
+func TestTreeSources(t *testing.T) {
+	var testCases = []struct {
+		nodes    int
+		fanOut   []int
+		expected []int
+	}{
+		// master with 2 replicas, each with 2 replicas
+		{7, []int{2, 2}, []int{1, 1, 2, 2, 3, 3}},
+		// master with 2 replicas, each with 3 replicas
+		{9, []int{2, 3}, []int{1, 1, 2, 2, 2, 3, 3, 3}},
+		// incomplete last level
+		{5, []int{2, 2}, []int{1, 1, 2, 2}},
+		// the last fan-out value is used for deeper levels
+		{7, []int{1}, []int{1, 2, 3, 4, 5, 6}},
+		// a wide fan-out is a plain master-slave
+		{4, []int{5}, []int{1, 1, 1}},
+	}
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("%d-%v", tc.nodes, tc.fanOut), func(t *testing.T) {
+			sources, err := treeSources(tc.nodes, tc.fanOut)
+			compare.OkIsNil("tree sources", err, t)
+			compare.OkEqualIntSlices(t, sources, tc.expected)
+		})
+	}
+	_, err := treeSources(3, []int{})
+	compare.OkIsNotNil("empty fan-out", err, t)
+	_, err = treeSources(3, []int{2, 0})
+	compare.OkIsNotNil("zero fan-out", err, t)
+}
+
+func TestTreeNodes(t *testing.T) {
+	compare.OkEqualInt("nodes for 2,2", TreeNodes([]int{2, 2}), 7, t)
+	compare.OkEqualInt("nodes for 2,3", TreeNodes([]int{2, 3}), 9, t)
+	compare.OkEqualInt("nodes for 4", TreeNodes([]int{4}), 5, t)
+}
@@ -39,6 +39,21 @@ var (
 	//go:embed templates/cluster/check_nodes_cluster.gotxt
 	checkClusterNodesTemplate string
 
+	//go:embed templates/cluster/router_bootstrap.gotxt
+	routerBootstrapTemplate string
+
+	//go:embed templates/cluster/router_start.gotxt
+	routerStartTemplate string
+
+	//go:embed templates/cluster/router_stop.gotxt
+	routerStopTemplate string
+
+	//go:embed templates/cluster/router_status.gotxt
+	routerStatusTemplate string
+
+	//go:embed templates/cluster/router_connection_json.gotxt
+	routerConnectionJsonTemplate string
+
 	ClusterTemplates = TemplateCollection{
 		globals.TmplClusterOptions: TemplateDesc{
 			Description: "Set the correct my.cnf configurations",
@@ -60,5 +75,30 @@ var (
 			Notes:       "",
 			Contents:    checkClusterNodesTemplate,
 		},
+		globals.TmplRouterBootstrap: TemplateDesc{
+			Description: "Bootstraps MySQL Router against the InnoDB Cluster",
+			Notes:       "",
+			Contents:    routerBootstrapTemplate,
+		},
+		globals.TmplRouterStart: TemplateDesc{
+			Description: "Starts MySQL Router, bootstrapping it if needed",
+			Notes:       "",
+			Contents:    routerStartTemplate,
+		},
+		globals.TmplRouterStop: TemplateDesc{
+			Description: "Stops MySQL Router",
+			Notes:       "",
+			Contents:    routerStopTemplate,
+		},
+		globals.TmplRouterStatus: TemplateDesc{
+			Description: "Shows the status of MySQL Router",
+			Notes:       "",
+			Contents:    routerStatusTemplate,
+		},
+		globals.TmplRouterConnectionJson: TemplateDesc{
+			Description: "Connection info for MySQL Router read-write and read-only ports",
+			Notes:       "",
+			Contents:    routerConnectionJsonTemplate,
+		},
 	}
 )
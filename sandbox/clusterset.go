@@ -0,0 +1,295 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2022 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sandbox
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"time"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/defaults"
+	"github.com/datacharmer/dbdeployer/globals"
+	"github.com/pkg/errors"
+)
+
+// ClusterSetData describes the clusters of a clusterset deployment
+type ClusterSetData struct {
+	Clusters    int    // How many clusters will be deployed
+	Nodes       int    // How many nodes in each cluster
+	ClusterType string // group or innodb-cluster
+	MasterIp    string // IP used by the replication channels
+}
+
+// clusterSetChannelStatements returns the statements that create and start the channel
+// from the primary cluster in a replica cluster
+func clusterSetChannelStatements(sandboxDef SandboxDef, masterIp string, sourcePort int, logger *defaults.Logger) ([]string, error) {
+	legacySyntax := usesChangeMasterSyntax(sandboxDef.Flavor, sandboxDef.Version)
+	masterAutoPosition := ", SOURCE_AUTO_POSITION=1"
+	publicKeyOpt := "GET_SOURCE_PUBLIC_KEY=1"
+	if legacySyntax {
+		masterAutoPosition = ", MASTER_AUTO_POSITION=1"
+		publicKeyOpt = "GET_MASTER_PUBLIC_KEY=1"
+	}
+	changeMasterOptions := sandboxDef.ChangeMasterOptions
+	isMinimumNativeAuthPlugin, err := common.HasCapability(sandboxDef.Flavor, common.NativeAuth, sandboxDef.Version)
+	if err != nil {
+		return nil, err
+	}
+	if isMinimumNativeAuthPlugin && !sandboxDef.NativeAuthPlugin {
+		changeMasterOptions = append(changeMasterOptions, publicKeyOpt)
+	}
+	if sandboxDef.EnableTls {
+		tlsOpt := tlsChangeMasterOption(sandboxDef.Flavor, sandboxDef.Version)
+		changeMasterOptions = append(changeMasterOptions, tlsOpt)
+	}
+	data := common.StringMap{
+		"MasterIp":           masterIp,
+		"MasterPort":         sourcePort,
+		"RplUser":            sandboxDef.RplUser,
+		"RplPassword":        sandboxDef.RplPassword,
+		"MasterAutoPosition": masterAutoPosition,
+		"ChangeMasterExtra":  setChangeMasterProperties("", changeMasterOptions, logger),
+	}
+	var statements []string
+	for _, statement := range startReplicationStatements(data, "", legacySyntax) {
+		statements = append(statements, fmt.Sprintf("%s FOR CHANNEL '%s'", statement, globals.ClusterSetChannel))
+	}
+	return statements, nil
+}
+
+// CreateClusterSet deploys several group replication (or InnoDB Cluster) sandboxes
+// inside one directory. The first cluster is the primary one. The node1 of every other
+// cluster replicates from the node1 of the primary cluster through an asynchronous channel.
+func CreateClusterSet(sandboxDef SandboxDef, origin string, csData ClusterSetData) error {
+	if !common.IsIPV4(csData.MasterIp) {
+		return fmt.Errorf("IP %s is not a valid IPV4", csData.MasterIp)
+	}
+	if !common.DirExists(sandboxDef.Basedir) {
+		return fmt.Errorf(globals.ErrBaseDirectoryNotFound, sandboxDef.Basedir)
+	}
+	if csData.Clusters < 2 {
+		return fmt.Errorf("a clusterset needs at least 2 clusters")
+	}
+	var createCluster func(SandboxDef, string, int, string) error
+	checkScript := globals.ScriptCheckNodes
+	switch csData.ClusterType {
+	case globals.GroupLabel:
+		isMinimumGroupRepl, err := common.HasCapability(sandboxDef.Flavor, common.GroupReplication, sandboxDef.Version)
+		if err != nil {
+			return err
+		}
+		if !isMinimumGroupRepl {
+			return unsupportedError(globals.ErrFeatureRequiresVersion, "group replication", common.IntSliceToDottedString(globals.MinimumGroupReplVersion))
+		}
+		createCluster = CreateGroupReplication
+	case globals.InnoDBClusterLabel:
+		isMinimumInnodbCluster, err := common.HasCapability(sandboxDef.Flavor, common.InnoDBCluster, sandboxDef.Version)
+		if err != nil {
+			return err
+		}
+		if !isMinimumInnodbCluster {
+			return unsupportedError(globals.ErrFeatureRequiresCapability, "InnoDB Cluster", common.MySQLFlavor,
+				common.IntSliceToDottedString(globals.MinimumInnoDBCluster))
+		}
+		createCluster = CreateInnoDBClusterReplication
+		checkScript = globals.ScriptCheckNodesCluster
+	default:
+		return fmt.Errorf("unrecognized cluster type '%s'. Accepted: '%s', '%s'",
+			csData.ClusterType, globals.GroupLabel, globals.InnoDBClusterLabel)
+	}
+
+	if sandboxDef.DirName == "" {
+		sandboxDef.SandboxDir = path.Join(sandboxDef.SandboxDir, defaults.Defaults().ClusterSetPrefix+common.VersionToName(origin))
+	} else {
+		sandboxDef.SandboxDir = path.Join(sandboxDef.SandboxDir, sandboxDef.DirName)
+	}
+	sandboxDef.DirName = ""
+	var err error
+	if common.DirExists(sandboxDef.SandboxDir) {
+		sandboxDef, err = checkDirectory(sandboxDef)
+		if err != nil {
+			return err
+		}
+	}
+	if sandboxDef.HistoryDir == "REPL_DIR" {
+		sandboxDef.HistoryDir = sandboxDef.SandboxDir
+	}
+
+	logger, fileName, err := defaults.NewLogger(common.LogDirName(), "clusterset")
+	if err != nil {
+		return err
+	}
+	sandboxDef.LogFileName = common.ReplaceLiteralHome(fileName)
+	sandboxDef.Logger = logger
+
+	err = os.Mkdir(sandboxDef.SandboxDir, globals.PublicDirectoryAttr)
+	if err != nil {
+		return err
+	}
+	common.AddToCleanupStack(common.RmdirAll, "RmdirAll", sandboxDef.SandboxDir)
+	logger.Printf("Creating directory %s\n", sandboxDef.SandboxDir)
+
+	sbDesc := common.SandboxDescription{
+		Basedir: sandboxDef.Basedir,
+		SBType:  globals.SbTypeClusterSet,
+		Version: sandboxDef.Version,
+		Flavor:  sandboxDef.Flavor,
+		Port:    []int{},
+		Nodes:   csData.Clusters,
+		NodeNum: 0,
+		LogFile: sandboxDef.LogFileName,
+		Owner:   sandboxDef.Owner,
+		Tags:    sandboxDef.Tags,
+		Note:    sandboxDef.Note,
+		Expires: sandboxDef.Expires,
+	}
+	sbItem := defaults.SandboxItem{
+		Origin:       sbDesc.Basedir,
+		SBType:       sbDesc.SBType,
+		Version:      sandboxDef.Version,
+		Flavor:       sandboxDef.Flavor,
+		Port:         []int{},
+		Nodes:        []string{},
+		Destination:  sandboxDef.SandboxDir,
+		LogDirectory: common.DirName(sandboxDef.LogFileName),
+		Owner:        sandboxDef.Owner,
+		Tags:         sandboxDef.Tags,
+		Note:         sandboxDef.Note,
+		Expires:      sandboxDef.Expires,
+	}
+
+	nodeLabel := defaults.Defaults().NodePrefix
+	installedPorts := sandboxDef.InstalledPorts
+	baseServerId := sandboxDef.BaseServerId
+	sourcePort := 0
+	var clusters []common.StringMap
+	var replicaClusters []common.StringMap
+	for c := 1; c <= csData.Clusters; c++ {
+		clusterName := fmt.Sprintf("%s%d", globals.ClusterLabel, c)
+		clusterDir := path.Join(sandboxDef.SandboxDir, clusterName)
+		clusterDef := sandboxDef
+		clusterDef.SandboxDir = clusterDir
+		clusterDef.InstalledPorts = installedPorts
+		// Server IDs must be unique across clusters, or the channels would skip events
+		clusterDef.BaseServerId = baseServerId + c*100
+		common.CondPrintf("Creating %s\n", clusterName)
+		logger.Printf("Creating %s cluster %s\n", csData.ClusterType, clusterDir)
+		err = createCluster(clusterDef, origin, csData.Nodes, csData.MasterIp)
+		if err != nil {
+			return errors.Wrapf(err, "error creating %s", clusterName)
+		}
+		// The clusterset is recorded as one catalog entry
+		err = defaults.DeleteFromCatalog(clusterDir)
+		if err != nil {
+			return errors.Wrapf(err, "unable to update catalog")
+		}
+		clusterDesc, err := common.ReadSandboxDescription(clusterDir)
+		if err != nil {
+			return err
+		}
+		installedPorts = append(installedPorts, clusterDesc.Port...)
+		sbDesc.Port = append(sbDesc.Port, clusterDesc.Port...)
+		sbItem.Port = append(sbItem.Port, clusterDesc.Port...)
+		sbItem.Nodes = append(sbItem.Nodes, clusterName)
+		clusters = append(clusters, common.StringMap{"ClusterName": clusterName})
+
+		node1Desc, err := common.ReadSandboxDescription(path.Join(clusterDir, nodeLabel+"1"))
+		if err != nil {
+			return err
+		}
+		if len(node1Desc.Port) == 0 {
+			return fmt.Errorf("no ports found in %s", path.Join(clusterDir, nodeLabel+"1"))
+		}
+		if c == 1 {
+			sourcePort = node1Desc.Port[0]
+			continue
+		}
+		statements, err := clusterSetChannelStatements(sandboxDef, csData.MasterIp, sourcePort, logger)
+		if err != nil {
+			return err
+		}
+		replicaClusters = append(replicaClusters, common.StringMap{
+			"ClusterName": clusterName,
+			"Statements":  statements,
+		})
+	}
+
+	logger.Printf("Writing sandbox description in %s\n", sandboxDef.SandboxDir)
+	err = common.WriteSandboxDescription(sandboxDef.SandboxDir, sbDesc)
+	if err != nil {
+		return errors.Wrapf(err, "unable to write sandbox description")
+	}
+	err = defaults.UpdateCatalog(sandboxDef.SandboxDir, sbItem)
+	if err != nil {
+		return errors.Wrapf(err, "unable to update catalog")
+	}
+
+	legacySyntax := usesChangeMasterSyntax(sandboxDef.Flavor, sandboxDef.Version)
+	statusStatement := "SHOW REPLICA STATUS"
+	if legacySyntax {
+		statusStatement = "SHOW SLAVE STATUS"
+	}
+	data := common.StringMap{
+		"ShellPath":       sandboxDef.ShellPath,
+		"Copyright":       globals.ShellScriptCopyright,
+		"AppVersion":      common.VersionDef,
+		"DateTime":        time.Now().Format(time.UnixDate),
+		"SandboxDir":      sandboxDef.SandboxDir,
+		"NodeLabel":       nodeLabel,
+		"ChannelName":     globals.ClusterSetChannel,
+		"CheckScript":     checkScript,
+		"StatusStatement": statusStatement,
+		"SourceCluster":   clusters[0]["ClusterName"],
+		"SourcePort":      sourcePort,
+		"Clusters":        clusters,
+		"ReplicaClusters": replicaClusters,
+	}
+	logger.Printf("Writing clusterset scripts\n")
+	for _, script := range []string{globals.ScriptStartAll, globals.ScriptStopAll, globals.ScriptRestartAll,
+		globals.ScriptStatusAll, globals.ScriptSendKillAll, globals.ScriptUseAll} {
+		data["ScriptName"] = script
+		err = writeScript(logger, GroupTemplates, script, globals.TmplClusterSetAll, sandboxDef.SandboxDir, data, true)
+		if err != nil {
+			return err
+		}
+	}
+	err = writeScripts(ScriptBatch{
+		tc:         GroupTemplates,
+		logger:     logger,
+		data:       data,
+		sandboxDir: sandboxDef.SandboxDir,
+		scripts: []ScriptDef{
+			{globals.ScriptInitializeClusterSet, globals.TmplClusterSetInitialize, true},
+			{globals.ScriptCheckClusterSet, globals.TmplClusterSetCheck, true},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	if !sandboxDef.SkipStart {
+		common.CondPrintln(path.Join(common.ReplaceLiteralHome(sandboxDef.SandboxDir), globals.ScriptInitializeClusterSet))
+		logger.Printf("Running clusterset initialization script\n")
+		_, err = common.RunCmd(path.Join(sandboxDef.SandboxDir, globals.ScriptInitializeClusterSet))
+		if err != nil {
+			return fmt.Errorf("error initializing clusterset replication: %s", err)
+		}
+	}
+	common.CondPrintf("ClusterSet directory installed in %s\n", common.ReplaceLiteralHome(sandboxDef.SandboxDir))
+	return defaults.RunHook(defaults.HookPostDeploy, sandboxDef.SandboxDir)
+}
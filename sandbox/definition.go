@@ -0,0 +1,154 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2022 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sandbox
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/globals"
+)
+
+// DetectFlavor returns the flavor of the binaries in basedir.
+// The FLAVOR file has precedence over the contents of the directory, and must agree with userDefinedFlavor, if given
+func DetectFlavor(userDefinedFlavor, basedir string) (string, error) {
+	flavorOrigin := ""
+	flavor := userDefinedFlavor
+	if userDefinedFlavor != "" {
+		flavorOrigin = "flag"
+	}
+	flavorFile := path.Join(basedir, globals.FlavorFileName)
+	if common.FileExists(flavorFile) {
+		flavorText, err := common.SlurpAsString(flavorFile)
+		if err != nil {
+			return "", fmt.Errorf("error reading flavor file %s: %s", flavorFile, err)
+		}
+		flavorText = strings.TrimSpace(flavorText)
+		if userDefinedFlavor != "" && userDefinedFlavor != flavorText {
+			return "", fmt.Errorf("user defined flavor %s doesn't match found flavor %s", userDefinedFlavor, flavorText)
+		}
+		flavor = flavorText
+		flavorOrigin = "FLAVOR file"
+	}
+	// Flavor detection based on tarball contents
+	if flavor == "" {
+		flavor = common.DetectBinaryFlavor(basedir)
+		flavorOrigin = "Binary examination"
+	}
+	err := common.CheckFlavorSupport(flavor)
+	if err != nil {
+		return "", WithKind(ErrUnsupported, fmt.Errorf("flavor detected from %s unsupported: %s", flavorOrigin, err))
+	}
+	return flavor, nil
+}
+
+// findTemplateGroup returns the group and the full name of a template, which can be given without the "_template" suffix
+func findTemplateGroup(templateName string) (string, string) {
+	for groupName, group := range AllTemplates {
+		for _, name := range []string{templateName, templateName + "_template"} {
+			if _, ok := group[name]; ok {
+				return groupName, name
+			}
+		}
+	}
+	return "", ""
+}
+
+// UseFlavorTemplates replaces the templates that the descriptor of a custom flavor overrides.
+// It does nothing for built-in flavors
+func UseFlavorTemplates(flavor string) error {
+	descriptor, ok := common.CustomFlavors[flavor]
+	if !ok {
+		return nil
+	}
+	for templateName, fileName := range descriptor.Templates {
+		groupName, name := findTemplateGroup(templateName)
+		if groupName == "" {
+			return fmt.Errorf("template '%s' of flavor %s not found", templateName, flavor)
+		}
+		contents, err := common.SlurpAsString(fileName)
+		if err != nil {
+			return fmt.Errorf("error reading template %s for flavor %s: %s", fileName, flavor, err)
+		}
+		template := AllTemplates[groupName][name]
+		template.Contents = contents
+		template.TemplateInFile = true
+		AllTemplates[groupName][name] = template
+	}
+	return nil
+}
+
+// SetMasterOptions makes a sandbox replication ready
+func SetMasterOptions(sd *SandboxDef) {
+	sd.ReplOptions = SingleTemplates[globals.TmplReplicationOptions].Contents
+	if sd.ServerId == 0 {
+		sd.PortAsServerId = true
+	} else {
+		sd.PortAsServerId = false
+	}
+}
+
+// SetGtidOptions enables GTID in a sandbox definition, using the templates suitable for its version
+func SetGtidOptions(sd *SandboxDef) error {
+	if common.BaseFlavor(sd.Flavor) == common.MariaDbFlavor {
+		return setMariaDbGtidOptions(sd)
+	}
+	templateName := globals.TmplGtidOptions56
+	// 5.7.0
+	// isEnhancedGtid, err := common.GreaterOrEqualVersion(sd.Version, globals.MinimumEnhancedGtidVersion)
+	isEnhancedGtid, err := common.HasCapability(sd.Flavor, common.EnhancedGTID, sd.Version)
+	if err != nil {
+		return fmt.Errorf("%s: %s", globals.ErrWhileComparingVersions, err)
+	}
+	if isEnhancedGtid {
+		templateName = globals.TmplGtidOptions57
+	}
+	// 5.6.9
+	//isMinimumGtid, err := common.GreaterOrEqualVersion(sd.Version, globals.MinimumGtidVersion)
+	isMinimumGtid, err := common.HasCapability(sd.Flavor, common.GTID, sd.Version)
+	if err != nil {
+		return fmt.Errorf("%s: %s", globals.ErrWhileComparingVersions, err)
+	}
+	if !isMinimumGtid {
+		return unsupportedError(globals.ErrOptionRequiresVersion, globals.GtidLabel, common.IntSliceToDottedString(globals.MinimumGtidVersion))
+	}
+	sd.GtidOptions = SingleTemplates[templateName].Contents
+	sd.ReplCrashSafeOptions = SingleTemplates[globals.TmplReplCrashSafeOptions84].Contents
+	if strings.HasPrefix(sd.Version, "5") || strings.HasPrefix(sd.Version, "8.0") {
+		sd.ReplCrashSafeOptions = SingleTemplates[globals.TmplReplCrashSafeOptions].Contents
+	}
+	SetMasterOptions(sd)
+	return nil
+}
+
+// setMariaDbGtidOptions enables MariaDB GTID. Unlike MySQL, MariaDB GTID is always
+// active, and the slave position is kept in a crash-safe table: we only need
+// strict mode and binary logs in all nodes
+func setMariaDbGtidOptions(sd *SandboxDef) error {
+	isMinimumGtid, err := common.HasCapability(sd.Flavor, common.MariaDbGTID, sd.Version)
+	if err != nil {
+		return fmt.Errorf("%s: %s", globals.ErrWhileComparingVersions, err)
+	}
+	if !isMinimumGtid {
+		return unsupportedError(globals.ErrFeatureRequiresCapability, "--"+globals.GtidLabel, common.MariaDbFlavor,
+			common.IntSliceToDottedString(globals.MariaDbMinimumGtidVersion))
+	}
+	sd.GtidOptions = SingleTemplates[globals.TmplGtidOptionsMariaDb].Contents
+	SetMasterOptions(sd)
+	return nil
+}
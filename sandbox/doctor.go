@@ -0,0 +1,262 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2022 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sandbox
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/defaults"
+)
+
+// Categories of the issues found by DiagnoseSandboxes
+const (
+	IssueStaleEntry        = "stale-entry"        // catalog entry for a sandbox that no longer exists
+	IssueMissingEntry      = "missing-entry"      // sandbox without a catalog entry
+	IssueMismatchedEntry   = "mismatched-entry"   // catalog entry that disagrees with the sandbox description
+	IssueBrokenDescription = "broken-description" // sandbox whose description can't be read
+	IssueDuplicatePort     = "duplicate-port"     // port used by more than one sandbox
+	IssueMissingBasedir    = "missing-basedir"    // binaries used by a sandbox were removed
+)
+
+// DoctorIssue is an inconsistency between the catalog and the deployed sandboxes.
+// Only the issues in the catalog can be fixed
+type DoctorIssue struct {
+	Category string
+	Sandbox  string
+	Message  string
+	Fixable  bool
+	entry    *defaults.SandboxItem // the entry that replaces the current one. When nil, the entry is removed
+}
+
+func (issue DoctorIssue) String() string {
+	return fmt.Sprintf("[%s] %s: %s", issue.Category, issue.Sandbox, issue.Message)
+}
+
+// catalogEntryFromSandbox builds the catalog entry of a sandbox from its description.
+// The fields that can't be found in the sandbox are taken from the current entry, if any
+func catalogEntryFromSandbox(sandboxPath string, sbDesc common.SandboxDescription, current defaults.SandboxItem, found bool) (defaults.SandboxItem, error) {
+	ports, err := common.GetInstalledPorts(sandboxPath)
+	if err != nil {
+		return defaults.SandboxItem{}, err
+	}
+	servers, err := sandboxServerDirs(sandboxPath, "", 2)
+	if err != nil {
+		return defaults.SandboxItem{}, err
+	}
+	entry := current
+	if !found {
+		entry = defaults.SandboxItem{
+			DbDeployerVersion: sbDesc.DbDeployerVersion,
+			Timestamp:         sbDesc.Timestamp,
+			CommandLine:       sbDesc.CommandLine,
+			Owner:             sbDesc.Owner,
+			Tags:              sbDesc.Tags,
+			Note:              sbDesc.Note,
+			Expires:           sbDesc.Expires,
+		}
+		if sbDesc.LogFile != "" {
+			entry.LogDirectory = common.DirName(sbDesc.LogFile)
+		}
+	}
+	entry.Origin = sbDesc.Basedir
+	entry.SBType = sbDesc.SBType
+	entry.Version = sbDesc.Version
+	entry.Flavor = sbDesc.Flavor
+	entry.Host = sbDesc.Host
+	entry.Port = ports
+	entry.Destination = sandboxPath
+	entry.Nodes = nil
+	for _, server := range servers {
+		if server != "" {
+			entry.Nodes = append(entry.Nodes, server)
+		}
+	}
+	return entry, nil
+}
+
+// sameCatalogEntry tells whether two entries describe the same sandbox, regardless of port order
+func sameCatalogEntry(a, b defaults.SandboxItem) bool {
+	if a.SBType != b.SBType || a.Version != b.Version || a.Origin != b.Origin || a.Destination != b.Destination {
+		return false
+	}
+	aPorts := append([]int{}, a.Port...)
+	bPorts := append([]int{}, b.Port...)
+	sort.Ints(aPorts)
+	sort.Ints(bPorts)
+	return fmt.Sprintf("%v", aPorts) == fmt.Sprintf("%v", bPorts)
+}
+
+// diagnose cross-checks the sandboxes in sandboxHome, and the ones in the catalog, with the catalog itself
+func diagnose(sandboxHome string, catalog defaults.SandboxCatalog, useCatalog bool) ([]DoctorIssue, error) {
+	var issues []DoctorIssue
+	var sandboxPaths []string
+	if common.DirExists(sandboxHome) {
+		installed, err := common.GetInstalledSandboxes(sandboxHome)
+		if err != nil {
+			return nil, err
+		}
+		for _, sb := range installed {
+			sandboxPaths = append(sandboxPaths, path.Join(sandboxHome, sb.SandboxName))
+		}
+	}
+	if useCatalog {
+		var names []string
+		for name := range catalog {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if !common.DirExists(name) {
+				issues = append(issues, DoctorIssue{
+					Category: IssueStaleEntry,
+					Sandbox:  name,
+					Message:  "the sandbox directory no longer exists",
+					Fixable:  true,
+				})
+				continue
+			}
+			// Sandboxes deployed outside of sandboxHome are only known through the catalog
+			if path.Dir(name) != path.Clean(sandboxHome) {
+				sandboxPaths = append(sandboxPaths, name)
+			}
+		}
+	}
+	sort.Strings(sandboxPaths)
+
+	portUsers := make(map[int][]string)
+	for _, sandboxPath := range sandboxPaths {
+		sbDesc, err := common.ReadSandboxDescription(sandboxPath)
+		if err != nil {
+			issues = append(issues, DoctorIssue{
+				Category: IssueBrokenDescription,
+				Sandbox:  sandboxPath,
+				Message:  err.Error(),
+			})
+			continue
+		}
+		if !common.DirExists(sbDesc.Basedir) {
+			issues = append(issues, DoctorIssue{
+				Category: IssueMissingBasedir,
+				Sandbox:  sandboxPath,
+				Message:  fmt.Sprintf("binaries directory %s not found", sbDesc.Basedir),
+			})
+		}
+		if sbDesc.ClientBasedir != "" && sbDesc.ClientBasedir != sbDesc.Basedir && !common.DirExists(sbDesc.ClientBasedir) {
+			issues = append(issues, DoctorIssue{
+				Category: IssueMissingBasedir,
+				Sandbox:  sandboxPath,
+				Message:  fmt.Sprintf("client binaries directory %s not found", sbDesc.ClientBasedir),
+			})
+		}
+		var current defaults.SandboxItem
+		var found bool
+		if catalog != nil {
+			current, found = catalog[sandboxPath]
+		}
+		entry, err := catalogEntryFromSandbox(sandboxPath, sbDesc, current, found)
+		if err != nil {
+			issues = append(issues, DoctorIssue{
+				Category: IssueBrokenDescription,
+				Sandbox:  sandboxPath,
+				Message:  err.Error(),
+			})
+			continue
+		}
+		for _, port := range entry.Port {
+			portUsers[port] = append(portUsers[port], sandboxPath)
+		}
+		if !useCatalog {
+			continue
+		}
+		switch {
+		case !found:
+			issues = append(issues, DoctorIssue{
+				Category: IssueMissingEntry,
+				Sandbox:  sandboxPath,
+				Message:  "the sandbox is not in the catalog",
+				Fixable:  true,
+				entry:    &entry,
+			})
+		case !sameCatalogEntry(current, entry):
+			issues = append(issues, DoctorIssue{
+				Category: IssueMismatchedEntry,
+				Sandbox:  sandboxPath,
+				Message: fmt.Sprintf("catalog has %s %s %v, sandbox has %s %s %v",
+					current.SBType, current.Version, current.Port, entry.SBType, entry.Version, entry.Port),
+				Fixable: true,
+				entry:   &entry,
+			})
+		}
+	}
+
+	var ports []int
+	for port := range portUsers {
+		ports = append(ports, port)
+	}
+	sort.Ints(ports)
+	for _, port := range ports {
+		users := portUsers[port]
+		if len(users) > 1 {
+			issues = append(issues, DoctorIssue{
+				Category: IssueDuplicatePort,
+				Sandbox:  users[0],
+				Message:  fmt.Sprintf("port %d is also used by %s", port, strings.Join(users[1:], ", ")),
+			})
+		}
+	}
+	return issues, nil
+}
+
+// DiagnoseSandboxes finds the inconsistencies between the catalog, the sandboxes
+// deployed in sandboxHome, their ports, and the binaries they use
+func DiagnoseSandboxes(sandboxHome string) ([]DoctorIssue, error) {
+	catalog, err := defaults.ReadCatalog()
+	if err != nil {
+		return nil, err
+	}
+	return diagnose(sandboxHome, catalog, defaults.IsCatalogEnabled())
+}
+
+// FixIssues rebuilds or removes the catalog entries of the fixable issues, under the catalog lock.
+// It returns the issues that were fixed
+func FixIssues(issues []DoctorIssue) ([]DoctorIssue, error) {
+	var fixed []DoctorIssue
+	var staleEntries []string
+	entries := make(defaults.SandboxCatalog)
+	for _, issue := range issues {
+		if !issue.Fixable {
+			continue
+		}
+		if issue.entry == nil {
+			staleEntries = append(staleEntries, issue.Sandbox)
+		} else {
+			entries[issue.Sandbox] = *issue.entry
+		}
+		fixed = append(fixed, issue)
+	}
+	if len(fixed) == 0 {
+		return nil, nil
+	}
+	err := defaults.RepairCatalog(entries, staleEntries)
+	if err != nil {
+		return nil, err
+	}
+	return fixed, nil
+}
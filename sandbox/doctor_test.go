@@ -0,0 +1,117 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2022 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sandbox
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/compare"
+	"github.com/datacharmer/dbdeployer/defaults"
+	"github.com/datacharmer/dbdeployer/globals"
+)
+
+func makeDoctorSandbox(t *testing.T, sandboxPath, basedir string, servers map[string]int) {
+	var ports []int
+	for server, port := range servers {
+		serverPath := path.Join(sandboxPath, server)
+		err := os.MkdirAll(path.Join(serverPath, globals.DataDirName), 0755)
+		compare.OkIsNil("data directory", err, t)
+		err = common.WriteSandboxDescription(serverPath, common.SandboxDescription{
+			SBType: "node", Version: "8.0.36", Basedir: basedir, Port: []int{port}})
+		compare.OkIsNil("server description", err, t)
+		ports = append(ports, port)
+	}
+	sbType := globals.MasterSlaveLabel
+	if len(servers) == 1 {
+		sbType = globals.SbTypeSingle
+	}
+	err := common.WriteSandboxDescription(sandboxPath, common.SandboxDescription{
+		SBType: sbType, Version: "8.0.36", Basedir: basedir, Port: ports, Nodes: len(servers) - 1})
+	compare.OkIsNil("sandbox description", err, t)
+}
+
+func countIssues(issues []DoctorIssue, category string) int {
+	count := 0
+	for _, issue := range issues {
+		if issue.Category == category {
+			count++
+		}
+	}
+	return count
+}
+
+func TestDiagnose(t *testing.T) {
+	sandboxHome := t.TempDir()
+	basedir := t.TempDir()
+	single := path.Join(sandboxHome, "msb_8_0_36")
+	replication := path.Join(sandboxHome, "rsandbox_8_0_36")
+	outside := path.Join(t.TempDir(), "msb_8_0_35")
+	makeDoctorSandbox(t, single, basedir, map[string]int{"": 8036})
+	makeDoctorSandbox(t, replication, basedir, map[string]int{"master": 19001, "node1": 19002})
+	makeDoctorSandbox(t, outside, path.Join(basedir, "missing"), map[string]int{"": 8036})
+
+	catalog := defaults.SandboxCatalog{
+		single: {SBType: globals.SbTypeSingle, Version: "8.0.36", Origin: basedir, Destination: single,
+			Port: []int{8036}, CommandLine: "dbdeployer deploy single 8.0.36"},
+		outside:                              {SBType: globals.SbTypeSingle, Version: "8.0.35", Origin: basedir, Destination: outside, Port: []int{8036}},
+		path.Join(sandboxHome, "msb_8_0_34"): {SBType: globals.SbTypeSingle, Version: "8.0.34"},
+	}
+	issues, err := diagnose(sandboxHome, catalog, true)
+	compare.OkIsNil("diagnose", err, t)
+	for _, issue := range issues {
+		t.Logf("%s", issue)
+	}
+	compare.OkEqualInt("stale entries", countIssues(issues, IssueStaleEntry), 1, t)
+	compare.OkEqualInt("missing entries", countIssues(issues, IssueMissingEntry), 1, t)
+	compare.OkEqualInt("mismatched entries", countIssues(issues, IssueMismatchedEntry), 1, t)
+	compare.OkEqualInt("missing basedir", countIssues(issues, IssueMissingBasedir), 1, t)
+	compare.OkEqualInt("duplicate ports", countIssues(issues, IssueDuplicatePort), 1, t)
+	compare.OkEqualInt("broken descriptions", countIssues(issues, IssueBrokenDescription), 0, t)
+
+	for _, issue := range issues {
+		switch issue.Category {
+		case IssueStaleEntry:
+			compare.OkEqualString("stale entry", issue.Sandbox, path.Join(sandboxHome, "msb_8_0_34"), t)
+			compare.OkEqualBool("stale entry fixable", issue.Fixable, true, t)
+		case IssueMissingEntry:
+			compare.OkEqualString("missing entry", issue.Sandbox, replication, t)
+			compare.OkEqualString("rebuilt type", issue.entry.SBType, globals.MasterSlaveLabel, t)
+			compare.OkEqualStringSlices(t, issue.entry.Nodes, []string{"master", "node1"})
+			compare.OkEqualInt("rebuilt ports", len(issue.entry.Port), 2, t)
+		case IssueMismatchedEntry:
+			compare.OkEqualString("mismatched entry", issue.Sandbox, outside, t)
+			compare.OkEqualString("rebuilt origin", issue.entry.Origin, path.Join(basedir, "missing"), t)
+		case IssueDuplicatePort, IssueMissingBasedir:
+			compare.OkEqualBool(issue.Category+" fixable", issue.Fixable, false, t)
+		}
+	}
+
+	// Without a catalog, only the sandboxes themselves are checked
+	issues, err = diagnose(sandboxHome, nil, false)
+	compare.OkIsNil("diagnose without catalog", err, t)
+	compare.OkEqualInt("issues without catalog", len(issues), 0, t)
+
+	err = os.Remove(path.Join(single, globals.SandboxDescriptionName))
+	compare.OkIsNil("remove description", err, t)
+	err = os.WriteFile(path.Join(single, "start"), []byte(""), 0755)
+	compare.OkIsNil("start script", err, t)
+	issues, err = diagnose(sandboxHome, nil, false)
+	compare.OkIsNil("diagnose broken description", err, t)
+	compare.OkEqualInt("broken descriptions", countIssues(issues, IssueBrokenDescription), 1, t)
+}
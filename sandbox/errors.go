@@ -0,0 +1,69 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2022 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Categories of the errors returned by the deployment functions.
+// They can be detected with errors.Is
+var (
+	ErrPortConflict    = errors.New("port conflict")
+	ErrMissingBinaries = errors.New("missing binaries")
+	ErrUnsupported     = errors.New("unsupported capability")
+	ErrStartTimeout    = errors.New("start timeout")
+	ErrSandboxExists   = errors.New("sandbox already exists")
+)
+
+// kindError adds a category to an error, without changing its message
+type kindError struct {
+	kind error
+	err  error
+}
+
+func (e kindError) Error() string {
+	return e.err.Error()
+}
+
+func (e kindError) Unwrap() []error {
+	return []error{e.kind, e.err}
+}
+
+// WithKind marks an error as belonging to one of the categories above, without changing its message
+func WithKind(kind, err error) error {
+	if err == nil {
+		return nil
+	}
+	return kindError{kind: kind, err: err}
+}
+
+// StartError categorizes the failure of a start script, given its output. The script gives up
+// waiting for the server after a fixed time, and so does a cancelled context
+func StartError(output string, err error) error {
+	if strings.Contains(output, "not started yet") || errors.Is(err, context.DeadlineExceeded) {
+		return WithKind(ErrStartTimeout, err)
+	}
+	return err
+}
+
+// unsupportedError formats one of the messages about a capability that the server lacks
+func unsupportedError(format string, args ...interface{}) error {
+	return WithKind(ErrUnsupported, fmt.Errorf(format, args...))
+}
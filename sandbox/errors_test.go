@@ -0,0 +1,63 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2022 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/datacharmer/dbdeployer/compare"
+	"github.com/datacharmer/dbdeployer/globals"
+)
+
+func TestErrorKinds(t *testing.T) {
+	err := WithKind(ErrPortConflict, fmt.Errorf("port 8036 is already used"))
+	compare.OkEqualString("message", err.Error(), "port 8036 is already used", t)
+	compare.OkEqualBool("is port conflict", errors.Is(err, ErrPortConflict), true, t)
+	compare.OkEqualBool("is unsupported", errors.Is(err, ErrUnsupported), false, t)
+	compare.OkIsNil("no error", WithKind(ErrPortConflict, nil), t)
+
+	err = checkPortAvailability("test", globals.SbTypeSingle, []int{8036}, 8036)
+	compare.OkEqualBool("installed port", errors.Is(err, ErrPortConflict), true, t)
+
+	err = unsupportedError(globals.ErrOptionRequiresVersion, globals.GtidLabel, "5.6.9")
+	compare.OkEqualBool("unsupported", errors.Is(err, ErrUnsupported), true, t)
+
+	failure := fmt.Errorf("exit status 1")
+	compare.OkEqualBool("start timeout", errors.Is(StartError(". sandbox server not started yet", failure), ErrStartTimeout), true, t)
+	compare.OkEqualBool("context deadline", errors.Is(StartError("", context.DeadlineExceeded), ErrStartTimeout), true, t)
+	compare.OkEqualBool("other failure", errors.Is(StartError("mysqld_safe has errors", failure), ErrStartTimeout), false, t)
+}
+
+func TestDetectFlavor(t *testing.T) {
+	basedir := t.TempDir()
+	err := os.WriteFile(path.Join(basedir, globals.FlavorFileName), []byte("percona\n"), 0600)
+	compare.OkIsNil("flavor file", err, t)
+	flavor, err := DetectFlavor("", basedir)
+	compare.OkIsNil("detect flavor", err, t)
+	compare.OkEqualString("flavor", flavor, "percona", t)
+	_, err = DetectFlavor("mariadb", basedir)
+	compare.OkEqualBool("conflicting flavor", err != nil, true, t)
+
+	err = os.WriteFile(path.Join(basedir, globals.FlavorFileName), []byte("no-such-flavor\n"), 0600)
+	compare.OkIsNil("flavor file", err, t)
+	_, err = DetectFlavor("", basedir)
+	compare.OkEqualBool("unsupported flavor", errors.Is(err, ErrUnsupported), true, t)
+}
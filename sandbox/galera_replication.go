@@ -0,0 +1,408 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2021 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package sandbox
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"time"
+
+	"github.com/dustin/go-humanize/english"
+	"github.com/pkg/errors"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/concurrent"
+	"github.com/datacharmer/dbdeployer/defaults"
+	"github.com/datacharmer/dbdeployer/globals"
+)
+
+// findGaleraProvider returns the path of the wsrep provider library in a MariaDB basedir,
+// or an empty string if the library was not found
+func findGaleraProvider(basedir string) string {
+	for _, dir := range globals.GaleraProviderDirs {
+		for _, lib := range []string{globals.FnLibGaleraSmmSo, globals.FnLibGaleraSmmDylib} {
+			fileName := path.Join(basedir, dir, lib)
+			if common.FileExists(fileName) {
+				return fileName
+			}
+		}
+	}
+	return ""
+}
+
+func CreateGaleraReplication(sandboxDef SandboxDef, origin string, nodes int, masterIp string) error {
+	var execLists []concurrent.ExecutionList
+
+	err := common.CheckPrerequisites("Galera", globals.NeededGaleraExecutables)
+	if err != nil {
+		return err
+	}
+	wsrepProvider := findGaleraProvider(sandboxDef.Basedir)
+	if wsrepProvider == "" {
+		return WithKind(ErrMissingBinaries, fmt.Errorf("wsrep provider library (%s) not found in %s. Looked in %v",
+			globals.FnLibGaleraSmmSo, sandboxDef.Basedir, globals.GaleraProviderDirs))
+	}
+	var logger *defaults.Logger
+	if sandboxDef.Logger != nil {
+		logger = sandboxDef.Logger
+	} else {
+		var fileName string
+		var err error
+		logger, fileName, err = defaults.NewLogger(common.LogDirName(), "galera-replication")
+		if err != nil {
+			return err
+		}
+		sandboxDef.LogFileName = common.ReplaceLiteralHome(fileName)
+	}
+	logger.Printf("Using wsrep provider %s\n", wsrepProvider)
+
+	readOnlyOptions, err := checkReadOnlyFlags(sandboxDef)
+	if err != nil {
+		return err
+	}
+	if readOnlyOptions != "" {
+		return fmt.Errorf("options --read-only and --super-read-only can't be used for Galera topology")
+	}
+
+	vList, err := common.VersionToList(sandboxDef.Version)
+	if err != nil {
+		return err
+	}
+	rev := vList[2]
+
+	basePort := computeBaseport(sandboxDef.Port + defaults.Defaults().GaleraBasePort + (rev * 100))
+	if sandboxDef.BasePort > 0 {
+		basePort = sandboxDef.BasePort
+	}
+
+	if nodes < 3 {
+		return fmt.Errorf("can't run Galera replication with less than 3 nodes")
+	}
+	if common.DirExists(sandboxDef.SandboxDir) {
+		sandboxDef, err = checkDirectory(sandboxDef)
+		if err != nil {
+			return err
+		}
+	}
+	// FindFreePort returns the first free port, but base_port will be used
+	// with a counter. Thus the availability will be checked using
+	// "base_port + 1"
+	firstGroupPort, err := common.FindFreePort(basePort+1, sandboxDef.InstalledPorts, nodes)
+	if err != nil {
+		return errors.Wrapf(err, "error retrieving free port for replication")
+	}
+	groupPortDelta := defaults.Defaults().GroupPortDelta
+	rsyncPortDelta := groupPortDelta + nodes + 10
+	basePort = firstGroupPort - 1
+	baseGroupPort := basePort + groupPortDelta
+	baseRsyncPort := basePort + rsyncPortDelta
+
+	firstGroupPort, err = common.FindFreePort(baseGroupPort+1, sandboxDef.InstalledPorts, nodes*2)
+	if err != nil {
+		return errors.Wrapf(err, "error retrieving Galera replication free port")
+	}
+	baseGroupPort = firstGroupPort - 1
+	for checkPort := basePort + 1; checkPort < basePort+nodes+1; checkPort++ {
+		err = checkPortAvailability("CreateGaleraReplication", sandboxDef.SandboxDir, sandboxDef.InstalledPorts, checkPort)
+		if err != nil {
+			return err
+		}
+	}
+	for checkPort := baseGroupPort + 1; checkPort < baseGroupPort+(nodes*2)+1; checkPort++ {
+		err = checkPortAvailability("CreateGaleraReplication-group", sandboxDef.SandboxDir, sandboxDef.InstalledPorts, checkPort)
+		if err != nil {
+			return err
+		}
+	}
+
+	firstGroupPort, err = common.FindFreePort(baseRsyncPort+1, sandboxDef.InstalledPorts, nodes)
+	if err != nil {
+		return errors.Wrapf(err, "error retrieving Galera replication free port")
+	}
+	baseRsyncPort = firstGroupPort - 1
+	for checkPort := baseRsyncPort + 1; checkPort < baseRsyncPort+nodes+1; checkPort++ {
+		err = checkPortAvailability("CreateGaleraReplication-rsync", sandboxDef.SandboxDir, sandboxDef.InstalledPorts, checkPort)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = os.Mkdir(sandboxDef.SandboxDir, globals.PublicDirectoryAttr)
+	if err != nil {
+		return err
+	}
+	common.AddToCleanupStack(common.RmdirAll, "RmdirAll", sandboxDef.SandboxDir)
+	logger.Printf("Creating directory %s\n", sandboxDef.SandboxDir)
+	timestamp := time.Now()
+	slaveLabel := defaults.Defaults().SlavePrefix
+	slaveAbbr := defaults.Defaults().SlaveAbbr
+	masterAbbr := defaults.Defaults().MasterAbbr
+	masterLabel := defaults.Defaults().MasterName
+	masterList := makeNodesList(nodes)
+	slaveList := masterList
+	changeMasterExtra := setChangeMasterProperties("", sandboxDef.ChangeMasterOptions, logger)
+
+	stopNodeList := ""
+	for i := nodes; i > 0; i-- {
+		stopNodeList += fmt.Sprintf(" %d", i)
+	}
+	nodeLabel := defaults.Defaults().NodePrefix
+	var data = common.StringMap{
+		"ShellPath":         sandboxDef.ShellPath,
+		"Copyright":         globals.ShellScriptCopyright,
+		"AppVersion":        common.VersionDef,
+		"DateTime":          timestamp.Format(time.UnixDate),
+		"SandboxDir":        sandboxDef.SandboxDir,
+		"MasterIp":          masterIp,
+		"MasterList":        masterList,
+		"NodeLabel":         nodeLabel,
+		"SlaveList":         slaveList,
+		"RplUser":           sandboxDef.RplUser,
+		"RplPassword":       sandboxDef.RplPassword,
+		"SlaveLabel":        slaveLabel,
+		"SlaveAbbr":         slaveAbbr,
+		"ChangeMasterExtra": changeMasterExtra,
+		"MasterLabel":       masterLabel,
+		"MasterAbbr":        masterAbbr,
+		"StopNodeList":      stopNodeList,
+		"Nodes":             []common.StringMap{},
+	}
+	// Connection ports are 1, 3, 5, etc
+	// IST ports are 2, 4, 6, etc
+	groupPorts := []int{0}
+	for i := 1; i <= nodes*2; i++ {
+		if i%2 == 0 {
+			groupPorts = append(groupPorts, baseGroupPort+i)
+		}
+	}
+
+	sbType := "MariaDB-Galera-Cluster"
+
+	sbDesc := common.SandboxDescription{
+		Basedir: sandboxDef.Basedir,
+		SBType:  sbType,
+		Version: sandboxDef.Version,
+		Flavor:  sandboxDef.Flavor,
+		Port:    []int{},
+		Nodes:   nodes,
+		NodeNum: 0,
+		LogFile: sandboxDef.LogFileName,
+		Owner:   sandboxDef.Owner,
+		Tags:    sandboxDef.Tags,
+		Note:    sandboxDef.Note,
+		Expires: sandboxDef.Expires,
+	}
+
+	sbItem := defaults.SandboxItem{
+		Origin:      sbDesc.Basedir,
+		SBType:      sbDesc.SBType,
+		Version:     sandboxDef.Version,
+		Flavor:      sandboxDef.Flavor,
+		Port:        []int{},
+		Nodes:       []string{},
+		Destination: sandboxDef.SandboxDir,
+		Owner:       sandboxDef.Owner,
+		Tags:        sandboxDef.Tags,
+		Note:        sandboxDef.Note,
+		Expires:     sandboxDef.Expires,
+	}
+
+	if sandboxDef.LogFileName != "" {
+		sbItem.LogDirectory = common.DirName(sandboxDef.LogFileName)
+	}
+
+	baseReplicationOptions := sandboxDef.ReplOptions
+	groupCommunication := "gcomm://"
+	for i := 1; i <= nodes; i++ {
+		groupCommunication += fmt.Sprintf("%s:%d", masterIp, groupPorts[i])
+		if i < nodes {
+			groupCommunication += ","
+		}
+	}
+	logger.Printf("Creating cluster address %s\n", groupCommunication)
+	clusterName := common.BaseName(sandboxDef.SandboxDir)
+
+	for i := 1; i <= nodes; i++ {
+		groupPort := groupPorts[i]
+		rsyncPort := baseRsyncPort + i
+		sandboxDef.Port = basePort + i
+		data["Nodes"] = append(data["Nodes"].([]common.StringMap), common.StringMap{
+			"ShellPath":         sandboxDef.ShellPath,
+			"Copyright":         globals.ShellScriptCopyright,
+			"AppVersion":        common.VersionDef,
+			"DateTime":          timestamp.Format(time.UnixDate),
+			"Node":              i,
+			"NodePort":          sandboxDef.Port,
+			"MasterIp":          masterIp,
+			"NodeLabel":         nodeLabel,
+			"SlaveLabel":        slaveLabel,
+			"SlaveAbbr":         slaveAbbr,
+			"ChangeMasterExtra": changeMasterExtra,
+			"MasterLabel":       masterLabel,
+			"MasterAbbr":        masterAbbr,
+			"StopNodeList":      stopNodeList,
+			"SandboxDir":        sandboxDef.SandboxDir,
+			"RplUser":           sandboxDef.RplUser,
+			"RplPassword":       sandboxDef.RplPassword,
+		})
+
+		sandboxDef.DirName = fmt.Sprintf("%s%d", nodeLabel, i)
+		sandboxDef.MorePorts = []int{
+			groupPort,
+			groupPort + 1, // IST port
+			rsyncPort}
+		sandboxDef.ServerId = setServerId(sandboxDef, i)
+		sbItem.Nodes = append(sbItem.Nodes, sandboxDef.DirName)
+
+		sbItem.Port = append(sbItem.Port, sandboxDef.Port, groupPort, groupPort+1, rsyncPort)
+		sbDesc.Port = append(sbDesc.Port, sandboxDef.Port, groupPort, groupPort+1, rsyncPort)
+
+		if !sandboxDef.RunConcurrently {
+			installationMessage := "Installing and starting %s %d\n"
+			if sandboxDef.SkipStart {
+				installationMessage = "Installing %s %d\n"
+			}
+			common.CondPrintf(installationMessage, nodeLabel, i)
+			logger.Printf(installationMessage, nodeLabel, i)
+		}
+
+		galeraReplicationText := GaleraTemplates[globals.TmplGaleraReplication].Contents
+
+		galeraReplicationData := common.StringMap{
+			"NodeIp":             masterIp,
+			"NodeName":           sandboxDef.DirName,
+			"ClusterName":        clusterName,
+			"GroupCommunication": groupCommunication,
+			"WsrepProvider":      wsrepProvider,
+			"RsyncPort":          rsyncPort,
+			"GroupPort":          groupPort,
+			"SstMethod":          "rsync",
+		}
+		galeraFilledTemplate, err := common.SafeTemplateFill(globals.TmplGaleraReplication, galeraReplicationText, galeraReplicationData)
+		if err != nil {
+			return fmt.Errorf("error filling galera replication template %s", err)
+		}
+
+		sandboxDef.ReplOptions = baseReplicationOptions + fmt.Sprintf("\n%s\n", galeraFilledTemplate)
+		sandboxDef.Multi = true
+		if i == 1 {
+			sandboxDef.StartArgs = []string{"--wsrep-new-cluster"}
+			sandboxDef.LoadGrants = true
+		} else {
+			sandboxDef.StartArgs = []string{}
+			sandboxDef.LoadGrants = false
+		}
+		sandboxDef.Prompt = fmt.Sprintf("%s%d", nodeLabel, i)
+		sandboxDef.SBType = "galera-node"
+		sandboxDef.NodeNum = i
+		logger.Printf("Create single sandbox for node %d\n", i)
+		execList, err := CreateChildSandbox(sandboxDef)
+		if err != nil {
+			return fmt.Errorf(globals.ErrCreatingSandbox, err)
+		}
+		execLists = append(execLists, execList...)
+		var dataNode = common.StringMap{
+			"ShellPath":         sandboxDef.ShellPath,
+			"Copyright":         globals.ShellScriptCopyright,
+			"AppVersion":        common.VersionDef,
+			"DateTime":          timestamp.Format(time.UnixDate),
+			"Node":              i,
+			"NodePort":          sandboxDef.Port,
+			"NodeLabel":         nodeLabel,
+			"MasterLabel":       masterLabel,
+			"MasterAbbr":        masterAbbr,
+			"ChangeMasterExtra": changeMasterExtra,
+			"SlaveLabel":        slaveLabel,
+			"SlaveAbbr":         slaveAbbr,
+			"SandboxDir":        sandboxDef.SandboxDir,
+		}
+		logger.Printf("Create node script for node %d\n", i)
+		err = writeScript(logger, MultipleTemplates, fmt.Sprintf("n%d", i), globals.TmplNode, sandboxDef.SandboxDir, dataNode, true)
+		if err != nil {
+			return err
+		}
+	}
+	logger.Printf("Writing sandbox description in %s\n", sandboxDef.SandboxDir)
+	err = common.WriteSandboxDescription(sandboxDef.SandboxDir, sbDesc)
+	if err != nil {
+		return errors.Wrapf(err, "unable to write sandbox description")
+	}
+	err = defaults.UpdateCatalog(sandboxDef.SandboxDir, sbItem)
+	if err != nil {
+		return errors.Wrapf(err, "unable to update catalog")
+	}
+
+	logger.Printf("Writing Galera replication scripts\n")
+	sbMultiple := ScriptBatch{
+		tc:         MultipleTemplates,
+		logger:     logger,
+		data:       data,
+		sandboxDir: sandboxDef.SandboxDir,
+		scripts: []ScriptDef{
+			{globals.ScriptRestartAll, globals.TmplRestartMulti, true},
+			{globals.ScriptStatusAll, globals.TmplStatusMulti, true},
+			{globals.ScriptTestSbAll, globals.TmplTestSbMulti, true},
+			{globals.ScriptStopAll, globals.TmplStopMulti, true},
+			{globals.ScriptClearAll, globals.TmplClearMulti, true},
+			{globals.ScriptSendKillAll, globals.TmplSendKillMulti, true},
+			{globals.ScriptUseAll, globals.TmplUseMulti, true},
+			{globals.ScriptMetadataAll, globals.TmplMetadataMulti, true},
+			{globals.ScriptSysbench, globals.TmplSysbenchMulti, true},
+			{globals.ScriptSysbenchReady, globals.TmplSysbenchReadyMulti, true},
+		},
+	}
+
+	slavePlural := english.PluralWord(2, slaveLabel, "")
+	masterPlural := english.PluralWord(2, masterLabel, "")
+	useAllMasters := "use_all_" + masterPlural
+	useAllSlaves := "use_all_" + slavePlural
+
+	sbRepl := ScriptBatch{
+		tc:         ReplicationTemplates,
+		logger:     logger,
+		data:       data,
+		sandboxDir: sandboxDef.SandboxDir,
+		scripts: []ScriptDef{
+			{useAllSlaves, globals.TmplMultiSourceUseSlaves, true},
+			{useAllMasters, globals.TmplMultiSourceUseMasters, true},
+			{globals.ScriptTestReplication, globals.TmplMultiSourceTest, true},
+		},
+	}
+	sbGalera := ScriptBatch{
+		tc:         GaleraTemplates,
+		logger:     logger,
+		data:       data,
+		sandboxDir: sandboxDef.SandboxDir,
+		scripts: []ScriptDef{
+			{globals.ScriptStartAll, globals.TmplGaleraStart, true},
+			{globals.ScriptCheckNodes, globals.TmplGaleraCheckNodes, true},
+		},
+	}
+
+	for _, sb := range []ScriptBatch{sbMultiple, sbRepl, sbGalera} {
+		err := writeScripts(sb)
+		if err != nil {
+			return err
+		}
+	}
+
+	logger.Printf("Running parallel tasks\n")
+	concurrent.RunParallelTasksByPriority(execLists)
+
+	common.CondPrintf("Replication directory installed in %s\n", common.ReplaceLiteralHome(sandboxDef.SandboxDir))
+	common.CondPrintf("run 'dbdeployer usage multiple' for basic instructions'\n")
+	return nil
+}
@@ -0,0 +1,118 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2022 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sandbox
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/compare"
+	"github.com/datacharmer/dbdeployer/globals"
+)
+
+func TestFindGaleraProvider(t *testing.T) {
+	var testCases = []struct {
+		name     string
+		libDir   string
+		expected bool
+	}{
+		{"galera-4", "lib/galera-4", true},
+		{"galera", "lib/galera", true},
+		{"lib", "lib", true},
+		{"missing", "", false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			basedir := t.TempDir()
+			expected := ""
+			if tc.libDir != "" {
+				err := os.MkdirAll(path.Join(basedir, tc.libDir), globals.PublicDirectoryAttr)
+				compare.OkIsNil("creating lib directory", err, t)
+				expected = path.Join(basedir, tc.libDir, globals.FnLibGaleraSmmSo)
+				err = os.WriteFile(expected, []byte{}, 0600)
+				compare.OkIsNil("creating provider", err, t)
+			}
+			provider := findGaleraProvider(basedir)
+			compare.OkEqualBool("provider found", provider != "", tc.expected, t)
+			compare.OkEqualString("provider", provider, expected, t)
+		})
+	}
+}
+
+func TestUsesChangeMasterSyntax(t *testing.T) {
+	var testCases = []struct {
+		flavor       string
+		shortVersion string
+		expected     bool
+	}{
+		{common.MySQLFlavor, "5.7", true},
+		{common.MySQLFlavor, "8.0", true},
+		{common.MySQLFlavor, "8.4", false},
+		{common.PerconaServerFlavor, "8.4", false},
+		{common.MariaDbFlavor, "10.11", true},
+		{common.MariaDbFlavor, "11.4", true},
+		{common.MySQLFlavor, "4.1.22", true},
+		{common.MySQLFlavor, "8.0.36", true},
+		{common.MySQLFlavor, "8.4.0", false},
+		{common.MySQLFlavor, "9.1.0", false},
+		{common.MariaDbFlavor, "11.4.2", true},
+	}
+	for _, tc := range testCases {
+		compare.OkEqualBool(tc.flavor+" "+tc.shortVersion,
+			usesChangeMasterSyntax(tc.flavor, tc.shortVersion), tc.expected, t)
+	}
+}
+
+func TestMariaDbGtidOptions(t *testing.T) {
+	sd := SandboxDef{Flavor: common.MariaDbFlavor, Version: "10.11.6"}
+	err := SetGtidOptions(&sd)
+	compare.OkIsNil("setting MariaDB GTID", err, t)
+	compare.OkMatchesString("strict mode", sd.GtidOptions, `gtid_strict_mode=ON`, t)
+	compare.OkEqualString("crash safe options", sd.ReplCrashSafeOptions, "", t)
+	compare.OkMatchesString("domain", mariaDbGtidDomainOptions(sd.GtidOptions, 3), `(?m)^gtid_domain_id=3$`, t)
+	// The options of a deployed node, as add-node and switchover read them
+	nodeOptions := []string{"log-slave-updates", "gtid_strict_mode=ON", "gtid_domain_id=3"}
+	compare.OkEqualBool("MariaDB GTID node", isMariaDbGtid(common.MariaDbFlavor, nodeOptions), true, t)
+	compare.OkEqualBool("MySQL node", isMariaDbGtid(common.MySQLFlavor, nodeOptions), false, t)
+	compare.OkEqualBool("MariaDB node without GTID", isMariaDbGtid(common.MariaDbFlavor, nodeOptions[:1]), false, t)
+
+	sd = SandboxDef{Flavor: common.MariaDbFlavor, Version: "5.5.60"}
+	err = SetGtidOptions(&sd)
+	compare.OkIsNotNil("MariaDB GTID on old version", err, t)
+}
+
+func TestUsesBinaryLogStatusSyntax(t *testing.T) {
+	var testCases = []struct {
+		flavor   string
+		version  string
+		expected bool
+	}{
+		{common.MySQLFlavor, "5.7.44", false},
+		{common.MySQLFlavor, "8.0.36", false},
+		{common.MySQLFlavor, "8.1.0", false},
+		{common.MySQLFlavor, "8.2.0", true},
+		{common.MySQLFlavor, "8.4", true},
+		{common.MySQLFlavor, "9.1.0", true},
+		{common.PerconaServerFlavor, "8.1.0", false},
+		{common.MariaDbFlavor, "11.4.2", false},
+	}
+	for _, tc := range testCases {
+		compare.OkEqualBool(tc.flavor+" "+tc.version,
+			usesBinaryLogStatusSyntax(tc.flavor, tc.version), tc.expected, t)
+	}
+}
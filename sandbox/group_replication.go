@@ -70,7 +70,7 @@ func getBaseAdminPort(basePort int, sdef SandboxDef, nodes int) (int, error) {
 		return 0, err
 	}
 	if !isMinimumAdminAddress {
-		return 0, fmt.Errorf(globals.ErrFeatureRequiresCapability,
+		return 0, unsupportedError(globals.ErrFeatureRequiresCapability,
 			globals.EnableAdminAddressLabel,
 			common.MySQLFlavor,
 			common.IntSliceToDottedString(globals.MinimumAdminAddressVersion))
@@ -250,10 +250,10 @@ func CreateGroupReplication(sandboxDef SandboxDef, origin string, nodes int, mas
 	}
 	logger.Printf("Creating connection string %s\n", connectionString)
 
-	sbType := "group-multi-primary"
+	sbType := globals.SbTypeGroupMultiPrimary
 	singlePrimaryMode := "off"
 	if sandboxDef.SinglePrimary {
-		sbType = "group-single-primary"
+		sbType = globals.SbTypeGroupSinglePrimary
 		singlePrimaryMode = "on"
 	}
 	logger.Printf("Defining group type %s\n", sbType)
@@ -267,6 +267,10 @@ func CreateGroupReplication(sandboxDef SandboxDef, origin string, nodes int, mas
 		Nodes:   nodes,
 		NodeNum: 0,
 		LogFile: sandboxDef.LogFileName,
+		Owner:   sandboxDef.Owner,
+		Tags:    sandboxDef.Tags,
+		Note:    sandboxDef.Note,
+		Expires: sandboxDef.Expires,
 	}
 
 	sbItem := defaults.SandboxItem{
@@ -277,6 +281,10 @@ func CreateGroupReplication(sandboxDef SandboxDef, origin string, nodes int, mas
 		Port:        []int{},
 		Nodes:       []string{},
 		Destination: sandboxDef.SandboxDir,
+		Owner:       sandboxDef.Owner,
+		Tags:        sandboxDef.Tags,
+		Note:        sandboxDef.Note,
+		Expires:     sandboxDef.Expires,
 	}
 
 	if sandboxDef.LogFileName != "" {
@@ -353,6 +361,10 @@ func CreateGroupReplication(sandboxDef SandboxDef, origin string, nodes int, mas
 			tmplKey = globals.TmplReplCrashSafeOptions
 		}
 		sandboxDef.ReplOptions += fmt.Sprintf("\n%s\n", SingleTemplates[tmplKey].Contents)
+		if sandboxDef.EnableTls {
+			// Distributed recovery uses the server certificates
+			sandboxDef.ReplOptions += "\nloose-group_replication_recovery_use_ssl=ON\n"
+		}
 		// 8.0.11
 		isMinimumMySQLXDefault, err := common.HasCapability(sandboxDef.Flavor, common.MySQLXDefault, sandboxDef.Version)
 		if err != nil {
@@ -502,7 +514,7 @@ func CreateGroupReplication(sandboxDef SandboxDef, origin string, nodes int, mas
 	if !sandboxDef.SkipStart {
 		common.CondPrintln(path.Join(common.ReplaceLiteralHome(sandboxDef.SandboxDir), globals.ScriptInitializeNodes))
 		logger.Printf("Running group replication initialization script\n")
-		_, err := common.RunCmd(path.Join(sandboxDef.SandboxDir, globals.ScriptInitializeNodes))
+		_, err := sandboxDef.runCmd(path.Join(sandboxDef.SandboxDir, globals.ScriptInitializeNodes), nil, false)
 		if err != nil {
 			return fmt.Errorf("error initializing group replication: %s", err)
 		}
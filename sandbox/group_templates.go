@@ -42,6 +42,15 @@ var (
 	//go:embed templates/group/group_repl_options84.gotxt
 	groupReplOptions84Template string
 
+	//go:embed templates/group/clusterset_all.gotxt
+	clusterSetAllTemplate string
+
+	//go:embed templates/group/clusterset_initialize.gotxt
+	clusterSetInitializeTemplate string
+
+	//go:embed templates/group/clusterset_check.gotxt
+	clusterSetCheckTemplate string
+
 	GroupTemplates = TemplateCollection{
 		globals.TmplInitNodes: TemplateDesc{
 			Description: "Initialize group replication after deployment",
@@ -68,5 +77,20 @@ var (
 			Notes:       "",
 			Contents:    groupReplOptions84Template,
 		},
+		globals.TmplClusterSetAll: TemplateDesc{
+			Description: "Runs the same script in every cluster of a clusterset",
+			Notes:       "",
+			Contents:    clusterSetAllTemplate,
+		},
+		globals.TmplClusterSetInitialize: TemplateDesc{
+			Description: "Creates the replication channels between the clusters of a clusterset",
+			Notes:       "",
+			Contents:    clusterSetInitializeTemplate,
+		},
+		globals.TmplClusterSetCheck: TemplateDesc{
+			Description: "Checks the status of every cluster and channel in a clusterset",
+			Notes:       "",
+			Contents:    clusterSetCheckTemplate,
+		},
 	}
 )
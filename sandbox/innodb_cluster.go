@@ -64,6 +64,11 @@ func CreateInnoDBClusterReplication(sandboxDef SandboxDef, origin string, nodes
 	if nodes < 3 {
 		return fmt.Errorf("can't run group replication with less than 3 nodes")
 	}
+	routerPath := path.Join(sandboxDef.Basedir, "bin", "mysqlrouter")
+	if sandboxDef.WithRouter && !common.ExecExists(routerPath) {
+		return fmt.Errorf("option '--%s' requires MySQL Router: "+globals.ErrExecutableNotFound,
+			globals.WithRouterLabel, routerPath)
+	}
 	if common.DirExists(sandboxDef.SandboxDir) {
 		sandboxDef, err = checkDirectory(sandboxDef)
 		if err != nil {
@@ -189,6 +194,10 @@ func CreateInnoDBClusterReplication(sandboxDef SandboxDef, origin string, nodes
 		Nodes:   nodes,
 		NodeNum: 0,
 		LogFile: sandboxDef.LogFileName,
+		Owner:   sandboxDef.Owner,
+		Tags:    sandboxDef.Tags,
+		Note:    sandboxDef.Note,
+		Expires: sandboxDef.Expires,
 	}
 
 	sbItem := defaults.SandboxItem{
@@ -199,6 +208,10 @@ func CreateInnoDBClusterReplication(sandboxDef SandboxDef, origin string, nodes
 		Port:        []int{},
 		Nodes:       []string{},
 		Destination: sandboxDef.SandboxDir,
+		Owner:       sandboxDef.Owner,
+		Tags:        sandboxDef.Tags,
+		Note:        sandboxDef.Note,
+		Expires:     sandboxDef.Expires,
 	}
 
 	if sandboxDef.LogFileName != "" {
@@ -336,6 +349,28 @@ func CreateInnoDBClusterReplication(sandboxDef SandboxDef, origin string, nodes
 
 		}
 	}
+	var routerData common.StringMap
+	if sandboxDef.WithRouter {
+		usedPorts := append(append([]int{}, sandboxDef.InstalledPorts...), sbDesc.Port...)
+		routerData, err = routerPorts(sandboxDef, usedPorts)
+		if err != nil {
+			return err
+		}
+		for _, key := range []string{"RwPort", "RoPort", "XRwPort", "XRoPort"} {
+			sbItem.RouterPorts = append(sbItem.RouterPorts, routerData[key].(int))
+		}
+		sbDesc.Port = append(sbDesc.Port, routerData["Ports"].([]int)...)
+		sbItem.Port = append(sbItem.Port, routerData["Ports"].([]int)...)
+		routerData["ShellPath"] = sandboxDef.ShellPath
+		routerData["Copyright"] = globals.ShellScriptCopyright
+		routerData["AppVersion"] = common.VersionDef
+		routerData["DateTime"] = timestamp.Format(time.UnixDate)
+		routerData["RouterDir"] = path.Join(sandboxDef.SandboxDir, globals.RouterDirName)
+		routerData["RouterPath"] = routerPath
+		routerData["BootstrapPort"] = basePort + 1
+		routerData["DbUser"] = sandboxDef.DbUser
+		routerData["DbPassword"] = sandboxDef.DbPassword
+	}
 	logger.Printf("Writing sandbox description in %s\n", sandboxDef.SandboxDir)
 	err = common.WriteSandboxDescription(sandboxDef.SandboxDir, sbDesc)
 	if err != nil {
@@ -416,6 +451,31 @@ func CreateInnoDBClusterReplication(sandboxDef SandboxDef, origin string, nodes
 		}
 	}
 
+	if sandboxDef.WithRouter {
+		routerDir := routerData["RouterDir"].(string)
+		logger.Printf("Creating MySQL Router directory %s\n", routerDir)
+		err = os.Mkdir(routerDir, globals.PublicDirectoryAttr)
+		if err != nil {
+			return err
+		}
+		err = writeScripts(ScriptBatch{
+			tc:         ClusterTemplates,
+			logger:     logger,
+			data:       routerData,
+			sandboxDir: routerDir,
+			scripts: []ScriptDef{
+				{globals.ScriptRouterBootstrap, globals.TmplRouterBootstrap, true},
+				{globals.ScriptStart, globals.TmplRouterStart, true},
+				{globals.ScriptStop, globals.TmplRouterStop, true},
+				{globals.ScriptStatus, globals.TmplRouterStatus, true},
+				{globals.ScriptConnectionJson, globals.TmplRouterConnectionJson, false},
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+
 	logger.Printf("Running parallel tasks\n")
 	concurrent.RunParallelTasksByPriority(execLists)
 	if !sandboxDef.SkipStart {
@@ -425,8 +485,52 @@ func CreateInnoDBClusterReplication(sandboxDef SandboxDef, origin string, nodes
 		if err != nil {
 			return fmt.Errorf("error initializing group replication: %s", err)
 		}
+		if sandboxDef.WithRouter {
+			routerStart := path.Join(routerData["RouterDir"].(string), globals.ScriptStart)
+			common.CondPrintln(common.ReplaceLiteralHome(routerStart))
+			logger.Printf("Bootstrapping and starting MySQL Router\n")
+			_, err = common.RunCmd(routerStart)
+			if err != nil {
+				return fmt.Errorf("error starting MySQL Router: %s", err)
+			}
+		}
 	}
 	common.CondPrintf("Group Replication directory installed in %s\n", common.ReplaceLiteralHome(sandboxDef.SandboxDir))
+	if sandboxDef.WithRouter {
+		common.CondPrintf("MySQL Router installed in %s (read-write port %d, read-only port %d)\n",
+			common.ReplaceLiteralHome(routerData["RouterDir"].(string)), routerData["RwPort"], routerData["RoPort"])
+	}
 	common.CondPrintf("run 'dbdeployer usage multiple' for basic instructions'\n")
 	return nil
 }
+
+// routerPorts reserves the ports for MySQL Router. Bootstrapping with --conf-base-port
+// uses consecutive ports for classic read-write, read-only, X protocol read-write and
+// read-only, and read-write splitting (8.2+). The last port is for the REST API.
+func routerPorts(sandboxDef SandboxDef, usedPorts []int) (common.StringMap, error) {
+	const numRouterPorts = 6
+	firstPort, err := common.FindFreePort(globals.RouterBasePortValue, usedPorts, numRouterPorts)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error retrieving free ports for MySQL Router")
+	}
+	var ports []int
+	for port := firstPort; port < firstPort+numRouterPorts; port++ {
+		ports = append(ports, port)
+	}
+	httpsPort := 0
+	hasHttpsPort, err := common.GreaterOrEqualVersion(sandboxDef.Version, globals.MinimumRouterHttpsPort)
+	if err != nil {
+		return nil, err
+	}
+	if hasHttpsPort {
+		httpsPort = ports[5]
+	}
+	return common.StringMap{
+		"Ports":     ports,
+		"RwPort":    ports[0],
+		"RoPort":    ports[1],
+		"XRwPort":   ports[2],
+		"XRoPort":   ports[3],
+		"HttpsPort": httpsPort,
+	}, nil
+}
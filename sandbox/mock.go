@@ -149,6 +149,17 @@ func CreateCustomMockVersion(version string, fileSet []MockFileSet) error {
 }
 
 func MySQLMockSet(debug bool) []MockFileSet {
+	return mysqlMockSet(debug, globals.TmplMysqldSafeMock)
+}
+
+// MySQLServerMockSet is like MySQLMockSet, but its mysqld_safe runs the dbdeployer mock server,
+// so that the sandboxes answer queries. The command "dbdeployer" must be in $PATH,
+// or its path in $DBDEPLOYER_MOCK_SERVER
+func MySQLServerMockSet(debug bool) []MockFileSet {
+	return mysqlMockSet(debug, globals.TmplMysqldSafeServerMock)
+}
+
+func mysqlMockSet(debug bool, mysqldSafeTemplate string) []MockFileSet {
 	currentOs := runtime.GOOS
 	extension := ""
 	switch currentOs {
@@ -177,7 +188,7 @@ func MySQLMockSet(debug bool) []MockFileSet {
 		[]ScriptDef{
 			{mysqld, noOpMockTemplateName, true},
 			{globals.FnMysql, noOpMockTemplateName, true},
-			{globals.FnMysqldSafe, globals.TmplMysqldSafeMock, true},
+			{globals.FnMysqldSafe, mysqldSafeTemplate, true},
 		},
 	}
 	scriptsFileSet := MockFileSet{
@@ -197,6 +208,11 @@ func CreateMockVersion(version string) error {
 	return CreateCustomMockVersion(version, fileSet)
 }
 
+// CreateMockServerVersion creates a mock version whose servers answer queries
+func CreateMockServerVersion(version string) error {
+	return CreateCustomMockVersion(version, MySQLServerMockSet(false))
+}
+
 func init() {
 	saveSandboxBinary = os.Getenv("SANDBOX_BINARY")
 	saveSandboxHome = os.Getenv("SANDBOX_HOME")
@@ -181,6 +181,10 @@ func CreateAllMastersReplication(sandboxDef SandboxDef, origin string, nodes int
 	data["RplUser"] = sandboxDef.RplUser
 	data["RplPassword"] = sandboxDef.RplPassword
 	data["NodeLabel"] = defaults.Defaults().NodePrefix
+	if sandboxDef.EnableTls {
+		tlsOpt := tlsChangeMasterOption(sandboxDef.Flavor, sandboxDef.Version)
+		sandboxDef.ChangeMasterOptions = append(sandboxDef.ChangeMasterOptions, tlsOpt)
+	}
 	data["ChangeMasterExtra"] = setChangeMasterProperties("", sandboxDef.ChangeMasterOptions, logger)
 	logger.Printf("Writing master and slave scripts in %s\n", sandboxDef.SandboxDir)
 	for _, node := range slaveList {
@@ -409,6 +413,10 @@ func CreateFanInReplication(sandboxDef SandboxDef, origin string, nodes int, mas
 	data["RplUser"] = sandboxDef.RplUser
 	data["RplPassword"] = sandboxDef.RplPassword
 	data["NodeLabel"] = defaults.Defaults().NodePrefix
+	if sandboxDef.EnableTls {
+		tlsOpt := tlsChangeMasterOption(sandboxDef.Flavor, sandboxDef.Version)
+		sandboxDef.ChangeMasterOptions = append(sandboxDef.ChangeMasterOptions, tlsOpt)
+	}
 	data["ChangeMasterExtra"] = setChangeMasterProperties("", sandboxDef.ChangeMasterOptions, logger)
 	data["MasterIp"] = masterIp
 	logger.Printf("Writing master and slave scripts in %s\n", sandboxDef.SandboxDir)
@@ -40,6 +40,9 @@ func CreateMultipleSandbox(sandboxDef SandboxDef, origin string, nodes int) (com
 	var execLists []concurrent.ExecutionList
 	var emptyStringMap = common.StringMap{}
 
+	if isPostgreSQL(sandboxDef) {
+		return emptyStringMap, unsupportedError("multiple sandboxes are not supported for flavor '%s'", sandboxDef.Flavor)
+	}
 	sbType := sandboxDef.SBType
 	if sbType == "" {
 		sbType = "multiple"
@@ -144,6 +147,10 @@ func CreateMultipleSandbox(sandboxDef SandboxDef, origin string, nodes int) (com
 		Nodes:   nodes,
 		NodeNum: 0,
 		LogFile: sandboxDef.LogFileName,
+		Owner:   sandboxDef.Owner,
+		Tags:    sandboxDef.Tags,
+		Note:    sandboxDef.Note,
+		Expires: sandboxDef.Expires,
 	}
 
 	sbItem := defaults.SandboxItem{
@@ -154,6 +161,10 @@ func CreateMultipleSandbox(sandboxDef SandboxDef, origin string, nodes int) (com
 		Port:        []int{},
 		Nodes:       []string{},
 		Destination: sandboxDef.SandboxDir,
+		Owner:       sandboxDef.Owner,
+		Tags:        sandboxDef.Tags,
+		Note:        sandboxDef.Note,
+		Expires:     sandboxDef.Expires,
 	}
 
 	if sandboxDef.LogFileName != "" {
@@ -227,18 +238,10 @@ func CreateMultipleSandbox(sandboxDef SandboxDef, origin string, nodes int) (com
 		}
 		logger.Printf("Creating node script for node %d\n", i)
 		logger.Printf("Defining multiple sandbox node inner data: %v\n", stringMapToJson(dataNode))
-		err = writeScript(logger, MultipleTemplates, fmt.Sprintf("n%d", i), globals.TmplNode, sandboxDef.SandboxDir, dataNode, true)
+		err = writeNodeScripts(logger, sandboxDef.SandboxDir, dataNode, i, sandboxDef.EnableAdminAddress)
 		if err != nil {
 			return data, err
 		}
-		if sandboxDef.EnableAdminAddress {
-			logger.Printf("Creating admin script for node %d\n", i)
-			err = writeScript(logger, MultipleTemplates, fmt.Sprintf("na%d", i),
-				globals.TmplNodeAdmin, sandboxDef.SandboxDir, dataNode, true)
-			if err != nil {
-				return data, err
-			}
-		}
 	}
 	logger.Printf("Write sandbox description\n")
 	err = common.WriteSandboxDescription(sandboxDef.SandboxDir, sbDesc)
@@ -251,10 +254,42 @@ func CreateMultipleSandbox(sandboxDef SandboxDef, origin string, nodes int) (com
 	}
 
 	logger.Printf("Write multiple sandbox scripts\n")
+	err = writeMultipleScripts(logger, sandboxDef.SandboxDir, data, sandboxDef.EnableAdminAddress)
+	if err != nil {
+		return data, err
+	}
+	logger.Printf("Run concurrent tasks\n")
+	concurrent.RunParallelTasksByPriority(execLists)
+
+	common.CondPrintf("%s directory installed in %s\n", sbType, common.ReplaceLiteralHome(sandboxDef.SandboxDir))
+	common.CondPrintf("run 'dbdeployer usage multiple' for basic instructions'\n")
+	// Multi-source topologies are built on this sandbox, and run the hook when they are complete
+	if sbType == globals.SbTypeMultiple {
+		err = defaults.RunHook(defaults.HookPostDeploy, sandboxDef.SandboxDir)
+	}
+	return data, err
+}
+
+// writeNodeScripts writes the shortcut scripts for a node of a multiple sandbox (n1 and na1)
+func writeNodeScripts(logger *defaults.Logger, sandboxDir string, dataNode common.StringMap, node int, adminAddress bool) error {
+	err := writeScript(logger, MultipleTemplates, fmt.Sprintf("n%d", node), globals.TmplNode, sandboxDir, dataNode, true)
+	if err != nil {
+		return err
+	}
+	if adminAddress {
+		logger.Printf("Creating admin script for node %d\n", node)
+		err = writeScript(logger, MultipleTemplates, fmt.Sprintf("na%d", node),
+			globals.TmplNodeAdmin, sandboxDir, dataNode, true)
+	}
+	return err
+}
+
+// writeMultipleScripts writes the scripts that operate on all the nodes of a multiple sandbox
+func writeMultipleScripts(logger *defaults.Logger, sandboxDir string, data common.StringMap, adminAddress bool) error {
 	sbMultiple := ScriptBatch{
 		tc:         MultipleTemplates,
 		logger:     logger,
-		sandboxDir: sandboxDef.SandboxDir,
+		sandboxDir: sandboxDir,
 		data:       data,
 		scripts: []ScriptDef{
 			{globals.ScriptStartAll, globals.TmplStartMulti, true},
@@ -273,22 +308,14 @@ func CreateMultipleSandbox(sandboxDef SandboxDef, origin string, nodes int) (com
 		},
 	}
 
-	err = writeScripts(sbMultiple)
+	err := writeScripts(sbMultiple)
 	if err != nil {
-		return data, err
+		return err
 	}
-	if sandboxDef.EnableAdminAddress {
+	if adminAddress {
 		logger.Printf("Creating admin script for all nodes\n")
 		err = writeScript(logger, MultipleTemplates, globals.ScriptUseAllAdmin,
-			globals.TmplUseMultiAdmin, sandboxDef.SandboxDir, data, true)
-		if err != nil {
-			return data, err
-		}
+			globals.TmplUseMultiAdmin, sandboxDir, data, true)
 	}
-	logger.Printf("Run concurrent tasks\n")
-	concurrent.RunParallelTasksByPriority(execLists)
-
-	common.CondPrintf("%s directory installed in %s\n", sbType, common.ReplaceLiteralHome(sandboxDef.SandboxDir))
-	common.CondPrintf("run 'dbdeployer usage multiple' for basic instructions'\n")
-	return data, nil
+	return err
 }
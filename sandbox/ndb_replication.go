@@ -182,6 +182,10 @@ func CreateNdbReplication(sandboxDef SandboxDef, origin string, nodes int, ndbNo
 		Nodes:   nodes,
 		NodeNum: 0,
 		LogFile: sandboxDef.LogFileName,
+		Owner:   sandboxDef.Owner,
+		Tags:    sandboxDef.Tags,
+		Note:    sandboxDef.Note,
+		Expires: sandboxDef.Expires,
 	}
 
 	sbItem := defaults.SandboxItem{
@@ -192,6 +196,10 @@ func CreateNdbReplication(sandboxDef SandboxDef, origin string, nodes int, ndbNo
 		Port:        []int{ndbClusterPort},
 		Nodes:       []string{},
 		Destination: sandboxDef.SandboxDir,
+		Owner:       sandboxDef.Owner,
+		Tags:        sandboxDef.Tags,
+		Note:        sandboxDef.Note,
+		Expires:     sandboxDef.Expires,
 	}
 
 	if sandboxDef.LogFileName != "" {
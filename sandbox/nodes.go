@@ -0,0 +1,910 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2022 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sandbox
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/concurrent"
+	"github.com/datacharmer/dbdeployer/defaults"
+	"github.com/datacharmer/dbdeployer/globals"
+	"github.com/pkg/errors"
+)
+
+// nodeLayout describes the servers of an existing master/slave or multiple sandbox
+type nodeLayout struct {
+	sandboxDir string
+	sbDesc     common.SandboxDescription
+	isMultiple bool
+	masterDir  string // empty for multiple sandboxes
+	nodes      []int  // numbers of the 'nodeN' directories, in ascending order
+}
+
+// Options in my.sandbox.cnf that are generated for each server, and must not be copied to a new node
+var nodeSpecificOptions = []string{
+	"user", "port", "socket", "basedir", "datadir", "tmpdir", "pid-file", "bind-address",
+	"report-host", "report-port", "log-error", "server-id",
+	"mysqlx", "mysqlx-port", "mysqlx-socket", "admin-port", "admin-address",
+	"default-authentication-plugin", "ssl-ca", "ssl-cert", "ssl-key", "require-secure-transport",
+	"gtid-domain-id",
+}
+
+func (nl nodeLayout) nodeDir(node int) string {
+	return fmt.Sprintf("%s%d", defaults.Defaults().NodePrefix, node)
+}
+
+// slaveDirs returns the directories of the servers that are not the master, or all
+// the nodes of a multiple sandbox. After a switchover, the original master directory is the first slave
+func (nl nodeLayout) slaveDirs() []string {
+	var dirs []string
+	if nl.masterDir != "" && nl.masterDir != defaults.Defaults().MasterName {
+		dirs = append(dirs, defaults.Defaults().MasterName)
+	}
+	for _, n := range nl.nodes {
+		if nl.nodeDir(n) != nl.masterDir {
+			dirs = append(dirs, nl.nodeDir(n))
+		}
+	}
+	return dirs
+}
+
+// members returns the directories of all the servers, starting with the master
+func (nl nodeLayout) members() []string {
+	var dirs []string
+	if nl.masterDir != "" {
+		dirs = append(dirs, nl.masterDir)
+	}
+	return append(dirs, nl.slaveDirs()...)
+}
+
+func readNodeLayout(sandboxDir string) (nodeLayout, error) {
+	nl := nodeLayout{sandboxDir: sandboxDir}
+	if !common.DirExists(sandboxDir) {
+		return nl, fmt.Errorf(globals.ErrDirectoryNotFound, sandboxDir)
+	}
+	sbDesc, err := common.ReadSandboxDescription(sandboxDir)
+	if err != nil {
+		return nl, err
+	}
+	nl.sbDesc = sbDesc
+	switch sbDesc.SBType {
+	case globals.MasterSlaveLabel:
+		nl.masterDir = defaults.Defaults().MasterName
+		if sbDesc.Master != "" {
+			nl.masterDir = sbDesc.Master
+		}
+	case globals.SbTypeMultiple:
+		nl.isMultiple = true
+	default:
+		return nl, fmt.Errorf("sandbox %s has type '%s'. Nodes can only be added to or removed from '%s' or '%s' sandboxes",
+			sandboxDir, sbDesc.SBType, globals.MasterSlaveLabel, globals.SbTypeMultiple)
+	}
+	entries, err := os.ReadDir(sandboxDir)
+	if err != nil {
+		return nl, err
+	}
+	reNode := regexp.MustCompile(`^` + regexp.QuoteMeta(defaults.Defaults().NodePrefix) + `(\d+)$`)
+	for _, entry := range entries {
+		matches := reNode.FindStringSubmatch(entry.Name())
+		if !entry.IsDir() || matches == nil {
+			continue
+		}
+		n, _ := strconv.Atoi(matches[1])
+		nl.nodes = append(nl.nodes, n)
+	}
+	sort.Ints(nl.nodes)
+	if nl.masterDir != "" && !common.DirExists(path.Join(sandboxDir, nl.masterDir)) {
+		return nl, fmt.Errorf(globals.ErrDirectoryNotFound, path.Join(sandboxDir, nl.masterDir))
+	}
+	if len(nl.nodes) == 0 {
+		return nl, fmt.Errorf("no nodes found in %s", sandboxDir)
+	}
+	return nl, nil
+}
+
+// readMysqldOptions returns the lines of the [mysqld] section of a configuration file
+func readMysqldOptions(fileName string) ([]string, error) {
+	lines, err := common.SlurpAsLines(fileName)
+	if err != nil {
+		return nil, err
+	}
+	var options []string
+	reHeader := regexp.MustCompile(`^\s*\[\s*(\S+)\s*\]`)
+	inMysqld := false
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		header := reHeader.FindStringSubmatch(line)
+		if header != nil {
+			inMysqld = header[1] == "mysqld"
+			continue
+		}
+		if inMysqld {
+			options = append(options, line)
+		}
+	}
+	return options, nil
+}
+
+// optionName returns the name of a server option, with dashes instead of underscores
+func optionName(option string) string {
+	name := strings.SplitN(option, "=", 2)[0]
+	return strings.ReplaceAll(strings.TrimSpace(name), "_", "-")
+}
+
+func optionValue(options []string, name string) string {
+	for _, option := range options {
+		if optionName(option) == name {
+			parts := strings.SplitN(option, "=", 2)
+			if len(parts) == 2 {
+				return strings.TrimSpace(parts[1])
+			}
+			return ""
+		}
+	}
+	return ""
+}
+
+func hasOption(options []string, name string) bool {
+	for _, option := range options {
+		if optionName(option) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// readConnectionInfo returns the user and password of a connection file
+func readConnectionInfo(fileName string) (string, string, error) {
+	var connection struct {
+		User     string `json:"master_user"`
+		Password string `json:"master_password"`
+	}
+	contents, err := common.SlurpAsBytes(fileName)
+	if err != nil {
+		return "", "", err
+	}
+	err = json.Unmarshal(contents, &connection)
+	if err != nil {
+		return "", "", errors.Wrapf(err, "error decoding %s", fileName)
+	}
+	return connection.User, connection.Password, nil
+}
+
+// nextServerId returns a server ID higher than the ones used in the sandbox,
+// following the progression of the existing IDs (100, 200, 300 or 101, 102, 103)
+func nextServerId(sandboxDir string, members []string) (int, error) {
+	maxId := 0
+	for _, member := range members {
+		options, err := readMysqldOptions(path.Join(sandboxDir, member, globals.ScriptMySandboxCnf))
+		if err != nil {
+			return 0, err
+		}
+		id, err := strconv.Atoi(optionValue(options, "server-id"))
+		if err == nil && id > maxId {
+			maxId = id
+		}
+	}
+	if maxId > 0 && maxId%100 == 0 {
+		return maxId + 100, nil
+	}
+	return maxId + 1, nil
+}
+
+// replicationData rebuilds the template data of an existing master/slave sandbox
+func (nl nodeLayout) replicationData(logger *defaults.Logger, masterOptions []string) (common.StringMap, error) {
+	masterPath := path.Join(nl.sandboxDir, nl.masterDir)
+	masterDesc, err := common.ReadSandboxDescription(masterPath)
+	if err != nil {
+		return nil, err
+	}
+	if len(masterDesc.Port) == 0 {
+		return nil, fmt.Errorf("no ports found in %s", masterPath)
+	}
+	rplUser, rplPassword, err := readConnectionInfo(path.Join(masterPath, globals.ScriptConnectionJson))
+	if err != nil {
+		return nil, err
+	}
+	legacySyntax := usesChangeMasterSyntax(nl.sbDesc.Flavor, nl.sbDesc.Version)
+	masterAutoPosition := ""
+	switch {
+	case isMariaDbGtid(nl.sbDesc.Flavor, masterOptions):
+		masterAutoPosition = ", MASTER_USE_GTID=slave_pos"
+	case strings.EqualFold(optionValue(masterOptions, "gtid-mode"), "ON"):
+		masterAutoPosition = ", SOURCE_AUTO_POSITION=1"
+		if legacySyntax {
+			masterAutoPosition = ", MASTER_AUTO_POSITION=1"
+		}
+	}
+	changeMasterExtra := ""
+	isMinimumNativeAuthPlugin, err := common.HasCapability(nl.sbDesc.Flavor, common.NativeAuth, nl.sbDesc.Version)
+	if err != nil {
+		return nil, err
+	}
+	if isMinimumNativeAuthPlugin && !hasOption(masterOptions, "default-authentication-plugin") {
+		publicKeyOpt := "GET_SOURCE_PUBLIC_KEY=1"
+		if legacySyntax {
+			publicKeyOpt = "GET_MASTER_PUBLIC_KEY=1"
+		}
+		changeMasterExtra = setChangeMasterProperties(changeMasterExtra, []string{publicKeyOpt}, logger)
+	}
+	if hasOption(masterOptions, "ssl-ca") {
+		tlsOpt := tlsChangeMasterOption(nl.sbDesc.Flavor, nl.sbDesc.Version)
+		changeMasterExtra = setChangeMasterProperties(changeMasterExtra, []string{tlsOpt}, logger)
+	}
+	masterIp := optionValue(masterOptions, "bind-address")
+	if masterIp == "" || masterIp == "0.0.0.0" {
+		masterIp = globals.MasterIpValue
+	}
+	data := common.StringMap{
+		"ShellPath":          defaults.Defaults().ShellPath,
+		"Copyright":          globals.ShellScriptCopyright,
+		"AppVersion":         common.VersionDef,
+		"DateTime":           time.Now().Format(time.UnixDate),
+		"SandboxDir":         nl.sandboxDir,
+		"MasterLabel":        nl.masterDir,
+		"MasterPort":         masterDesc.Port[0],
+		"SlaveLabel":         defaults.Defaults().SlavePrefix,
+		"NodeLabel":          defaults.Defaults().NodePrefix,
+		"MasterAbbr":         defaults.Defaults().MasterAbbr,
+		"MasterIp":           masterIp,
+		"RplUser":            rplUser,
+		"RplPassword":        rplPassword,
+		"SlaveAbbr":          defaults.Defaults().SlaveAbbr,
+		"ChangeMasterExtra":  changeMasterExtra,
+		"MasterAutoPosition": masterAutoPosition,
+		"Slaves":             []common.StringMap{},
+	}
+	for i, dir := range nl.slaveDirs() {
+		nodeDesc, err := common.ReadSandboxDescription(path.Join(nl.sandboxDir, dir))
+		if err != nil {
+			return nil, err
+		}
+		if len(nodeDesc.Port) == 0 {
+			return nil, fmt.Errorf("no ports found in %s", path.Join(nl.sandboxDir, dir))
+		}
+		slave := slaveData(data, i+1, nodeDesc.Port[0], masterDesc.Port[0], 1, defaults.Defaults().MasterName, nl.masterDir, false)
+		// The templates find the slave directory as NodeLabel + Node
+		if dir == defaults.Defaults().MasterName {
+			slave["NodeLabel"] = dir
+			slave["SlaveLabel"] = dir
+			slave["Node"] = ""
+		} else {
+			slave["Node"], _ = strconv.Atoi(strings.TrimPrefix(dir, defaults.Defaults().NodePrefix))
+		}
+		data["Slaves"] = append(data["Slaves"].([]common.StringMap), slave)
+	}
+	return data, nil
+}
+
+// multipleData rebuilds the template data of an existing multiple sandbox
+func (nl nodeLayout) multipleData() (common.StringMap, error) {
+	stopNodeList := ""
+	for i := len(nl.nodes) - 1; i >= 0; i-- {
+		stopNodeList += fmt.Sprintf(" %d", nl.nodes[i])
+	}
+	data := common.StringMap{
+		"ShellPath":    defaults.Defaults().ShellPath,
+		"Copyright":    globals.ShellScriptCopyright,
+		"AppVersion":   common.VersionDef,
+		"DateTime":     time.Now().Format(time.UnixDate),
+		"SandboxDir":   nl.sandboxDir,
+		"StopNodeList": stopNodeList,
+		"NodeLabel":    defaults.Defaults().NodePrefix,
+		"Nodes":        []common.StringMap{},
+	}
+	for _, n := range nl.nodes {
+		nodeDesc, err := common.ReadSandboxDescription(path.Join(nl.sandboxDir, nl.nodeDir(n)))
+		if err != nil {
+			return nil, err
+		}
+		if len(nodeDesc.Port) == 0 {
+			return nil, fmt.Errorf("no ports found in %s", path.Join(nl.sandboxDir, nl.nodeDir(n)))
+		}
+		data["Nodes"] = append(data["Nodes"].([]common.StringMap), common.StringMap{
+			"ShellPath":    data["ShellPath"],
+			"Copyright":    globals.ShellScriptCopyright,
+			"AppVersion":   common.VersionDef,
+			"DateTime":     data["DateTime"],
+			"Node":         n,
+			"NodePort":     nodeDesc.Port[0],
+			"NodeLabel":    data["NodeLabel"],
+			"SandboxDir":   nl.sandboxDir,
+			"StopNodeList": stopNodeList,
+		})
+	}
+	return data, nil
+}
+
+// refreshNodeScripts writes again the scripts that operate on all nodes, and updates
+// the sandbox description and the catalog with the current list of nodes and ports
+func (nl nodeLayout) refreshNodeScripts(logger *defaults.Logger) error {
+	referenceOptions, err := readMysqldOptions(path.Join(nl.sandboxDir, nl.members()[0], globals.ScriptMySandboxCnf))
+	if err != nil {
+		return err
+	}
+	adminAddress := hasOption(referenceOptions, "admin-port")
+	var data common.StringMap
+	if nl.isMultiple {
+		data, err = nl.multipleData()
+		if err != nil {
+			return err
+		}
+		for _, node := range data["Nodes"].([]common.StringMap) {
+			err = writeNodeScripts(logger, nl.sandboxDir, node, node["Node"].(int), adminAddress)
+			if err != nil {
+				return err
+			}
+		}
+		err = writeMultipleScripts(logger, nl.sandboxDir, data, adminAddress)
+	} else {
+		data, err = nl.replicationData(logger, referenceOptions)
+		if err != nil {
+			return err
+		}
+		for i, slave := range data["Slaves"].([]common.StringMap) {
+			err = writeSlaveScripts(logger, nl.sandboxDir, slave, i+1, adminAddress)
+			if err != nil {
+				return err
+			}
+		}
+		vList, err := common.VersionToList(nl.sbDesc.Version)
+		if err != nil {
+			return err
+		}
+		shortVersion := fmt.Sprintf("%d.%d", vList[0], vList[1])
+		// The semi-synchronous post-initialization script is removed after its first run,
+		// and it is not recreated here
+		err = writeScripts(replicationScripts(logger, nl.sandboxDir, data, nl.sbDesc.SBType,
+			usesChangeMasterSyntax(nl.sbDesc.Flavor, shortVersion), false, adminAddress))
+	}
+	if err != nil {
+		return err
+	}
+
+	var ports []int
+	for _, member := range nl.members() {
+		memberDesc, err := common.ReadSandboxDescription(path.Join(nl.sandboxDir, member))
+		if err != nil {
+			return err
+		}
+		ports = append(ports, memberDesc.Port...)
+	}
+	nl.sbDesc.Port = ports
+	nl.sbDesc.Nodes = len(nl.slaveDirs())
+	nl.sbDesc.Master = ""
+	if !nl.isMultiple && nl.masterDir != defaults.Defaults().MasterName {
+		nl.sbDesc.Master = nl.masterDir
+	}
+	err = common.WriteSandboxDescription(nl.sandboxDir, nl.sbDesc)
+	if err != nil {
+		return errors.Wrapf(err, "unable to write sandbox description")
+	}
+
+	catalog, err := defaults.ReadCatalog()
+	if err != nil {
+		return err
+	}
+	sbItem, found := catalog[nl.sandboxDir]
+	if !found {
+		sbItem = defaults.SandboxItem{
+			Origin:      nl.sbDesc.Basedir,
+			SBType:      nl.sbDesc.SBType,
+			Version:     nl.sbDesc.Version,
+			Flavor:      nl.sbDesc.Flavor,
+			Destination: nl.sandboxDir,
+		}
+	}
+	sbItem.Nodes = nl.members()
+	sbItem.Port = ports
+	err = defaults.UpdateCatalog(nl.sandboxDir, sbItem)
+	if err != nil {
+		return errors.Wrapf(err, "unable to update catalog")
+	}
+	return nil
+}
+
+// newNodeDefinition builds the definition of a new node, using the configuration of an existing one
+func (nl nodeLayout) newNodeDefinition(node int, installedPorts []int) (SandboxDef, error) {
+	var sd SandboxDef
+	referenceDir := path.Join(nl.sandboxDir, nl.nodeDir(nl.nodes[len(nl.nodes)-1]))
+	referenceDesc, err := common.ReadSandboxDescription(referenceDir)
+	if err != nil {
+		return sd, err
+	}
+	referenceCnf := path.Join(referenceDir, globals.ScriptMySandboxCnf)
+	options, err := readMysqldOptions(referenceCnf)
+	if err != nil {
+		return sd, err
+	}
+	config, err := common.ParseConfigFile(referenceCnf)
+	if err != nil {
+		return sd, err
+	}
+	for _, kv := range config["client"] {
+		switch kv.Key {
+		case "user":
+			sd.DbUser = kv.Value
+		case "password":
+			sd.DbPassword = kv.Value
+		}
+	}
+	connectionSource := nl.nodeDir(nl.nodes[0])
+	if nl.masterDir != "" {
+		connectionSource = nl.masterDir
+	}
+	sd.RplUser, sd.RplPassword, err = readConnectionInfo(path.Join(nl.sandboxDir, connectionSource, globals.ScriptConnectionJson))
+	if err != nil {
+		return sd, err
+	}
+
+	maxPort := 0
+	for _, member := range nl.members() {
+		memberDesc, err := common.ReadSandboxDescription(path.Join(nl.sandboxDir, member))
+		if err != nil {
+			return sd, err
+		}
+		if len(memberDesc.Port) > 0 && memberDesc.Port[0] > maxPort {
+			maxPort = memberDesc.Port[0]
+		}
+	}
+	sd.Port, err = common.FindFreePort(maxPort+1, installedPorts, 1)
+	if err != nil {
+		return sd, errors.Wrapf(err, "error detecting free port for new node")
+	}
+	sd.ServerId, err = nextServerId(nl.sandboxDir, nl.members())
+	if err != nil {
+		return sd, err
+	}
+
+	sd.Version = nl.sbDesc.Version
+	sd.Flavor = nl.sbDesc.Flavor
+	sd.Basedir = referenceDesc.Basedir
+	sd.ClientBasedir = referenceDesc.ClientBasedir
+	sd.SbHost = referenceDesc.Host
+	sd.SBType = referenceDesc.SBType
+	sd.SandboxDir = nl.sandboxDir
+	sd.DirName = nl.nodeDir(node)
+	sd.InstalledPorts = installedPorts
+	sd.ShellPath = defaults.Defaults().ShellPath
+	sd.MysqlshPath = defaults.Defaults().MysqlshPath
+	sd.RemoteAccess = globals.RemoteAccessValue
+	sd.BindAddress = optionValue(options, "bind-address")
+	if sd.BindAddress == "" {
+		sd.BindAddress = globals.BindAddressValue
+	}
+	sd.SocketInDatadir = strings.HasPrefix(optionValue(options, "socket"), path.Join(referenceDir, globals.DataDirName))
+	sd.SkipReportHost = !hasOption(options, "report-host")
+	sd.SkipReportPort = !hasOption(options, "report-port")
+	sd.DisableMysqlX = strings.EqualFold(optionValue(options, "mysqlx"), "OFF")
+	sd.EnableMysqlX = hasOption(options, "mysqlx-port")
+	sd.EnableAdminAddress = hasOption(options, "admin-port")
+	sd.NativeAuthPlugin = hasOption(options, "default-authentication-plugin")
+	if hasOption(options, "ssl-ca") {
+		// The new node gets certificates signed by the CA of the sandbox home
+		sd.EnableTls = true
+		sd.TlsCaDir = path.Join(path.Dir(nl.sandboxDir), globals.TlsCaDirName)
+		sd.SecureTransportOnly = strings.EqualFold(optionValue(options, "require-secure-transport"), "ON")
+	}
+	sd.LoadGrants = true
+	sd.Multi = true
+	if nl.isMultiple {
+		sd.NodeNum = node
+		sd.Prompt = sd.DirName
+	} else {
+		sd.NodeNum = node + 1
+		sd.Prompt = fmt.Sprintf("%s%d", defaults.Defaults().SlavePrefix, node)
+	}
+	// The replication, GTID, and custom options of the reference node are copied as they are
+	for _, option := range options {
+		name := optionName(option)
+		isSpecific := false
+		for _, specific := range nodeSpecificOptions {
+			if name == specific {
+				isSpecific = true
+				break
+			}
+		}
+		// The X plugin is loaded again when EnableMysqlX is set
+		if name == "plugin-load-add" && strings.HasPrefix(optionValue([]string{option}, name), "mysqlx") {
+			isSpecific = true
+		}
+		if !isSpecific {
+			sd.MyCnfOptions = append(sd.MyCnfOptions, option)
+		}
+	}
+	if hasOption(options, "gtid-domain-id") {
+		// MariaDB GTID: each node writes in its own domain, as in the initial deployment
+		sd.MyCnfOptions = append(sd.MyCnfOptions, fmt.Sprintf("gtid_domain_id=%d", sd.NodeNum))
+	}
+	return sd, nil
+}
+
+// runInNode runs a SQL statement in one server of the sandbox, as root
+func runInNode(nodePath, statement string) (string, error) {
+	return common.RunCmdCtrlWithArgs(path.Join(nodePath, globals.ScriptUse), []string{"-u", "root", "-BN", "-e", statement}, true)
+}
+
+// provisionSlave copies the master data into a new slave, and starts replication
+func (nl nodeLayout) provisionSlave(logger *defaults.Logger, data common.StringMap, node int, useDump bool) error {
+	masterPath := path.Join(nl.sandboxDir, nl.masterDir)
+	nodePath := path.Join(nl.sandboxDir, nl.nodeDir(node))
+	legacySyntax := usesChangeMasterSyntax(nl.sbDesc.Flavor, nl.sbDesc.Version)
+	usingGtid := data["MasterAutoPosition"] != ""
+	canClone, err := common.HasCapability(nl.sbDesc.Flavor, common.CloneServer, nl.sbDesc.Version)
+	if err != nil {
+		return err
+	}
+	coordinates := ""
+	if canClone && !useDump {
+		common.CondPrintf("Provisioning %s from %s using clone\n", nl.nodeDir(node), nl.masterDir)
+		logger.Printf("Cloning %s from %s\n", nodePath, masterPath)
+		_ = os.Setenv("SANDBOX_HOME", common.DirName(nl.sandboxDir))
+		out, err := common.RunCmdWithArgs(path.Join(nodePath, globals.ScriptCloneFrom),
+			[]string{path.Join(common.BaseName(nl.sandboxDir), nl.masterDir)})
+		if err != nil {
+			return fmt.Errorf("error cloning %s from %s: %s\n%s", nodePath, masterPath, err, out)
+		}
+		cloneReplication := path.Join(nodePath, "clone_replication.sql")
+		if common.FileExists(cloneReplication) {
+			coordinates, err = common.SlurpAsString(cloneReplication)
+			if err != nil {
+				return err
+			}
+			_ = os.Remove(cloneReplication)
+		}
+	} else {
+		common.CondPrintf("Provisioning %s from %s using a dump\n", nl.nodeDir(node), nl.masterDir)
+		out, err := runInNode(masterPath, "select schema_name from information_schema.schemata "+
+			"where schema_name not in ('mysql', 'sys', 'information_schema', 'performance_schema')")
+		if err != nil {
+			return fmt.Errorf("error getting the list of schemas from %s: %s", masterPath, err)
+		}
+		coordinates, err = nl.loadDump(logger, masterPath, nodePath, strings.Fields(out), usingGtid)
+		if err != nil {
+			return err
+		}
+	}
+	if !legacySyntax {
+		coordinates = strings.ReplaceAll(coordinates, "MASTER_LOG", "SOURCE_LOG")
+	}
+	if coordinates != "" && !usingGtid {
+		coordinates = ", " + strings.TrimSpace(coordinates)
+	} else {
+		coordinates = ""
+	}
+
+	logger.Printf("Starting replication in %s\n", nodePath)
+	for _, statement := range startReplicationStatements(data, coordinates, legacySyntax) {
+		_, err = runInNode(nodePath, statement)
+		if err != nil {
+			return fmt.Errorf("error starting replication in %s: %s", nodePath, err)
+		}
+	}
+	return nil
+}
+
+// startReplicationStatements returns the statements that point a server to the master in data, and start replication.
+// The coordinates, when not empty, must start with a comma
+func startReplicationStatements(data common.StringMap, coordinates string, legacySyntax bool) []string {
+	if legacySyntax {
+		return []string{
+			fmt.Sprintf(`CHANGE MASTER TO master_host="%s", master_port=%d, master_user="%s", master_password="%s" %s %s %s`,
+				data["MasterIp"], data["MasterPort"], data["RplUser"], data["RplPassword"],
+				data["MasterAutoPosition"], coordinates, data["ChangeMasterExtra"]),
+			"START SLAVE",
+		}
+	}
+	return []string{
+		fmt.Sprintf(`CHANGE REPLICATION SOURCE TO SOURCE_HOST="%s", SOURCE_PORT=%d, SOURCE_USER="%s", SOURCE_PASSWORD="%s" %s %s %s`,
+			data["MasterIp"], data["MasterPort"], data["RplUser"], data["RplPassword"],
+			data["MasterAutoPosition"], coordinates, data["ChangeMasterExtra"]),
+		"START REPLICA",
+	}
+}
+
+// Replication coordinates that mysqldump writes as comments with --source-data=2 or --master-data=2
+var (
+	reDumpCoordinates = regexp.MustCompile(`^-- CHANGE (?:MASTER|REPLICATION SOURCE) TO (?:MASTER|SOURCE)_LOG_FILE='([^']+)',\s*(?:MASTER|SOURCE)_LOG_POS=(\d+)`)
+	reDumpGtidPos     = regexp.MustCompile(`^-- SET GLOBAL gtid_slave_pos='([^']*)'`)
+)
+
+// dumpCoordinates reads the replication coordinates from the header of a dump.
+// It returns binary log file and position, and the MariaDB GTID position
+func dumpCoordinates(dumpFile string) (string, string, string, error) {
+	file, err := os.Open(dumpFile) // #nosec G304
+	if err != nil {
+		return "", "", "", err
+	}
+	defer file.Close() // #nosec G307
+	reader := bufio.NewReader(file)
+	var logFile, logPos, gtidPos string
+	// The coordinates come before any data
+	for i := 0; i < 100; i++ {
+		line, err := reader.ReadString('\n')
+		if matches := reDumpCoordinates.FindStringSubmatch(line); matches != nil {
+			logFile, logPos = matches[1], matches[2]
+		}
+		if matches := reDumpGtidPos.FindStringSubmatch(line); matches != nil {
+			gtidPos = matches[1]
+		}
+		if err != nil || strings.HasPrefix(line, "CREATE DATABASE") {
+			break
+		}
+	}
+	if logFile == "" {
+		return "", "", "", fmt.Errorf("replication coordinates not found in %s", dumpFile)
+	}
+	return logFile, logPos, gtidPos, nil
+}
+
+// loadDump copies the given schemas from the master to a new slave, and returns the
+// replication coordinates of the dump. They are empty when using GTID, as the dump sets the
+// GTID position of the new slave. When there are no schemas to copy, the dump only has the coordinates
+func (nl nodeLayout) loadDump(logger *defaults.Logger, masterPath, nodePath string, schemas []string, usingGtid bool) (string, error) {
+	nodeDesc, err := common.ReadSandboxDescription(nodePath)
+	if err != nil {
+		return "", err
+	}
+	clientBasedir := nodeDesc.ClientBasedir
+	if clientBasedir == "" {
+		clientBasedir = nodeDesc.Basedir
+	}
+	mysqldump := path.Join(clientBasedir, "bin", "mysqldump")
+	if !common.ExecExists(mysqldump) {
+		return "", fmt.Errorf(globals.ErrExecutableNotFound, mysqldump)
+	}
+	if usingGtid {
+		// The new node has its own transactions (the grants) that must be
+		// removed before loading the GTID set of the master
+		resetStatement := "RESET MASTER"
+		if usesBinaryLogStatusSyntax(nl.sbDesc.Flavor, nl.sbDesc.Version) {
+			resetStatement = "RESET BINARY LOGS AND GTIDS"
+		}
+		_, err = runInNode(nodePath, resetStatement)
+		if err != nil {
+			return "", fmt.Errorf("error resetting binary logs in %s: %s", nodePath, err)
+		}
+	}
+	isMariaDb := common.BaseFlavor(nl.sbDesc.Flavor) == common.MariaDbFlavor
+	// The coordinates are taken by mysqldump in the same consistent snapshot as the data
+	sourceDataOption := "--master-data=2"
+	if !isMariaDb {
+		hasSourceData, err := common.GreaterOrEqualVersion(nl.sbDesc.Version, globals.MinimumMysqldumpSourceDataVersion)
+		if err != nil {
+			return "", err
+		}
+		if hasSourceData {
+			sourceDataOption = "--source-data=2"
+		}
+	}
+	dumpFile := path.Join(nodePath, "tmp", "provisioning.sql")
+	args := []string{
+		"--defaults-file=" + path.Join(masterPath, globals.ScriptMySandboxCnf),
+		"--single-transaction", sourceDataOption,
+		"--result-file=" + dumpFile}
+	if isMariaDb && usingGtid {
+		args = append(args, "--gtid")
+	}
+	if len(schemas) > 0 {
+		args = append(args, "--routines", "--triggers", "--events", "--databases")
+		args = append(args, schemas...)
+	} else {
+		args = append(args, "--no-data", "--no-create-info", "--skip-triggers", "mysql")
+	}
+	logger.Printf("Dumping %v from %s\n", schemas, masterPath)
+	out, err := common.RunCmdCtrlWithArgs(mysqldump, args, true)
+	if err != nil {
+		return "", fmt.Errorf("error dumping data from %s: %s\n%s", masterPath, err, out)
+	}
+	defer os.Remove(dumpFile) // #nosec G307
+	logFile, logPos, gtidPos, err := dumpCoordinates(dumpFile)
+	if err != nil {
+		return "", err
+	}
+	logger.Printf("Loading %s into %s\n", dumpFile, nodePath)
+	_, err = runInNode(nodePath, "source "+dumpFile)
+	if err != nil {
+		return "", fmt.Errorf("error loading data into %s: %s", nodePath, err)
+	}
+	if !usingGtid {
+		return fmt.Sprintf(`MASTER_LOG_FILE="%s", MASTER_LOG_POS=%s`, logFile, logPos), nil
+	}
+	if isMariaDb {
+		// MariaDB writes the GTID position as a comment, as it does with the coordinates
+		_, err = runInNode(nodePath, fmt.Sprintf("SET GLOBAL gtid_slave_pos='%s'", gtidPos))
+		if err != nil {
+			return "", fmt.Errorf("error setting the GTID position in %s: %s", nodePath, err)
+		}
+	}
+	return "", nil
+}
+
+// AddNode adds a node to an existing master/slave or multiple sandbox.
+// In master/slave sandboxes, the new slave is provisioned from the master using clone,
+// when the version supports it and useDump is false, or a dump otherwise.
+// Returns the name of the directory of the new node
+func AddNode(sandboxDir string, useDump bool) (string, error) {
+	nl, err := readNodeLayout(sandboxDir)
+	if err != nil {
+		return "", err
+	}
+	if isLocked(sandboxDir) {
+		return "", fmt.Errorf("sandbox %s is locked", sandboxDir)
+	}
+	logger, logFileName, err := defaults.NewLogger(common.LogDirName(), "add-node")
+	if err != nil {
+		return "", err
+	}
+	installedPorts, err := common.GetInstalledPorts(common.DirName(sandboxDir))
+	if err != nil {
+		return "", err
+	}
+	installedPorts = append(installedPorts, defaults.Defaults().ReservedPorts...)
+
+	node := nl.nodes[len(nl.nodes)-1] + 1
+	sd, err := nl.newNodeDefinition(node, installedPorts)
+	if err != nil {
+		return "", err
+	}
+	sd.Logger = logger
+	sd.LogFileName = common.ReplaceLiteralHome(logFileName)
+	common.CondPrintf("Installing and starting %s (port %d - server-id %d)\n", sd.DirName, sd.Port, sd.ServerId)
+	logger.Printf("Creating single sandbox for %s\n", sd.DirName)
+	previousNodes := append([]int{}, nl.nodes...)
+	nl.nodes = append(nl.nodes, node)
+	execList, err := CreateChildSandbox(sd)
+	if err != nil {
+		return "", nl.abortAddNode(logger, node, previousNodes, fmt.Errorf(globals.ErrCreatingSandbox, err))
+	}
+	concurrent.RunParallelTasksByPriority(execList)
+
+	if !nl.isMultiple {
+		masterOptions, err := readMysqldOptions(path.Join(sandboxDir, nl.masterDir, globals.ScriptMySandboxCnf))
+		if err != nil {
+			return "", nl.abortAddNode(logger, node, previousNodes, err)
+		}
+		data, err := nl.replicationData(logger, masterOptions)
+		if err != nil {
+			return "", nl.abortAddNode(logger, node, previousNodes, err)
+		}
+		err = nl.provisionSlave(logger, data, node, useDump)
+		if err != nil {
+			return "", nl.abortAddNode(logger, node, previousNodes, err)
+		}
+	}
+	err = nl.refreshNodeScripts(logger)
+	if err != nil {
+		return "", nl.abortAddNode(logger, node, previousNodes, err)
+	}
+	return sd.DirName, nil
+}
+
+// abortAddNode removes a node that could not be added, so that the sandbox, its scripts,
+// and its catalog entry are as they were before. It returns the original error
+func (nl nodeLayout) abortAddNode(logger *defaults.Logger, node int, previousNodes []int, originalErr error) error {
+	nodePath := path.Join(nl.sandboxDir, nl.nodeDir(node))
+	logger.Printf("Removing %s after error: %s\n", nodePath, originalErr)
+	common.CondPrintf("Removing %s after a failed addition\n", nl.nodeDir(node))
+	stop := path.Join(nodePath, globals.ScriptSendKill)
+	if common.ExecExists(stop) {
+		_, _ = common.RunCmdWithArgs(stop, []string{"destroy"})
+	}
+	err := os.RemoveAll(nodePath)
+	if err != nil {
+		return fmt.Errorf("%s (%s could not be removed: %s)", originalErr, nodePath, err)
+	}
+	for _, script := range nl.lastNodeScripts(node) {
+		_ = os.Remove(path.Join(nl.sandboxDir, script))
+	}
+	nl.nodes = previousNodes
+	err = nl.refreshNodeScripts(logger)
+	if err != nil {
+		return fmt.Errorf("%s (error restoring the scripts of %s: %s)", originalErr, nl.sandboxDir, err)
+	}
+	return originalErr
+}
+
+// lastNodeScripts returns the scripts that exist only for the node with the highest number.
+// Slave scripts are numbered by position, and the last set is the one that
+// appears or disappears when a node is added or removed
+func (nl nodeLayout) lastNodeScripts(node int) []string {
+	if nl.isMultiple {
+		return []string{fmt.Sprintf("n%d", node), fmt.Sprintf("na%d", node)}
+	}
+	last := len(nl.slaveDirs())
+	slaveAbbr := defaults.Defaults().SlaveAbbr
+	return []string{
+		fmt.Sprintf("%s%d", slaveAbbr, last), fmt.Sprintf("n%d", last+1),
+		fmt.Sprintf("%sa%d", slaveAbbr, last), fmt.Sprintf("na%d", last+1),
+	}
+}
+
+// RemoveNode stops and removes a node from a master/slave or multiple sandbox.
+// When nodeName is empty, the node with the highest number is removed.
+// The master and the only remaining node cannot be removed
+func RemoveNode(sandboxDir, nodeName string) (string, error) {
+	nl, err := readNodeLayout(sandboxDir)
+	if err != nil {
+		return "", err
+	}
+	if isLocked(sandboxDir) {
+		return "", fmt.Errorf("sandbox %s is locked", sandboxDir)
+	}
+	slaveDirs := nl.slaveDirs()
+	if nodeName == "" {
+		nodeName = slaveDirs[len(slaveDirs)-1]
+	}
+	if nodeName == nl.masterDir {
+		return "", fmt.Errorf("the master of %s cannot be removed", sandboxDir)
+	}
+	index := -1
+	for i, n := range nl.nodes {
+		if nl.nodeDir(n) == nodeName {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		return "", fmt.Errorf("node %s not found in %s", nodeName, sandboxDir)
+	}
+	minNodes := 1
+	if nl.isMultiple {
+		minNodes = 2
+	}
+	if len(slaveDirs) <= minNodes {
+		return "", fmt.Errorf("sandbox %s needs at least %d nodes. Node %s cannot be removed", sandboxDir, minNodes, nodeName)
+	}
+	logger, _, err := defaults.NewLogger(common.LogDirName(), "remove-node")
+	if err != nil {
+		return "", err
+	}
+	nodePath := path.Join(sandboxDir, nodeName)
+	stop := path.Join(nodePath, globals.ScriptSendKill)
+	common.CondPrintf("Stopping %s\n", nodeName)
+	_, err = common.RunCmdWithArgs(stop, []string{"destroy"})
+	if err != nil {
+		return "", fmt.Errorf(globals.ErrWhileStoppingSandbox, nodePath)
+	}
+	logger.Printf("Removing %s\n", nodePath)
+	err = os.RemoveAll(nodePath)
+	if err != nil {
+		return "", fmt.Errorf(globals.ErrWhileDeletingSandbox, nodePath)
+	}
+	// The refresh below rewrites the scripts of the remaining nodes
+	for _, script := range nl.lastNodeScripts(nl.nodes[index]) {
+		_ = os.Remove(path.Join(sandboxDir, script))
+	}
+
+	nl.nodes = append(nl.nodes[:index], nl.nodes[index+1:]...)
+	err = nl.refreshNodeScripts(logger)
+	if err != nil {
+		return "", err
+	}
+	return nodeName, nil
+}
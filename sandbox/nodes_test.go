@@ -0,0 +1,129 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2022 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sandbox
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/datacharmer/dbdeployer/compare"
+	"github.com/datacharmer/dbdeployer/globals"
+)
+
+func TestReadMysqldOptions(t *testing.T) {
+	cnf := `
+[client]
+user = msandbox
+port = 5000
+
+[mysqld]
+port               = 5000
+server-id=300
+# basic replication options
+log-bin=mysql-bin
+gtid_mode=ON
+enforce-gtid-consistency
+
+[mysqldump]
+quick
+`
+	fileName := path.Join(t.TempDir(), globals.ScriptMySandboxCnf)
+	err := os.WriteFile(fileName, []byte(cnf), 0600)
+	compare.OkIsNil("writing my.cnf", err, t)
+
+	options, err := readMysqldOptions(fileName)
+	compare.OkIsNil("reading options", err, t)
+	compare.OkEqualStringSlices(t, options, []string{
+		"port               = 5000",
+		"server-id=300",
+		"log-bin=mysql-bin",
+		"gtid_mode=ON",
+		"enforce-gtid-consistency",
+	})
+	compare.OkEqualString("port", optionValue(options, "port"), "5000", t)
+	compare.OkEqualString("gtid mode", optionValue(options, "gtid-mode"), "ON", t)
+	compare.OkEqualString("option without value", optionValue(options, "enforce-gtid-consistency"), "", t)
+	compare.OkEqualBool("has option", hasOption(options, "enforce-gtid-consistency"), true, t)
+	compare.OkEqualBool("missing option", hasOption(options, "quick"), false, t)
+}
+
+func TestNextServerId(t *testing.T) {
+	var testCases = []struct {
+		name     string
+		ids      []string
+		expected int
+	}{
+		{"default", []string{"100", "200", "300"}, 400},
+		{"base-server-id", []string{"1001", "1002"}, 1003},
+		{"no-id", []string{""}, 1},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			var members []string
+			for i, id := range tc.ids {
+				member := string(rune('a' + i))
+				members = append(members, member)
+				err := os.Mkdir(path.Join(dir, member), 0700)
+				compare.OkIsNil("creating member", err, t)
+				cnf := "[mysqld]\n"
+				if id != "" {
+					cnf += "server-id=" + id + "\n"
+				}
+				err = os.WriteFile(path.Join(dir, member, globals.ScriptMySandboxCnf), []byte(cnf), 0600)
+				compare.OkIsNil("writing my.cnf", err, t)
+			}
+			serverId, err := nextServerId(dir, members)
+			compare.OkIsNil("next server id", err, t)
+			compare.OkEqualInt("server id", serverId, tc.expected, t)
+		})
+	}
+}
+
+func TestDumpCoordinates(t *testing.T) {
+	var testCases = []struct {
+		name    string
+		header  string
+		logFile string
+		logPos  string
+		gtidPos string
+	}{
+		{"source-data", "-- MySQL dump 10.13\n--\n-- Position to start replication or point-in-time recovery from\n--\n\n" +
+			"-- CHANGE REPLICATION SOURCE TO SOURCE_LOG_FILE='binlog.000002', SOURCE_LOG_POS=157;\n\n" +
+			"CREATE DATABASE /*!32312 IF NOT EXISTS*/ `test`;\n", "binlog.000002", "157", ""},
+		{"master-data", "-- MySQL dump 10.13\n-- CHANGE MASTER TO MASTER_LOG_FILE='mysql-bin.000001', MASTER_LOG_POS=154;\n",
+			"mysql-bin.000001", "154", ""},
+		{"MariaDB GTID", "-- MariaDB dump 10.19\n-- Preferably use GTID to start replication from GTID position:\n\n" +
+			"-- CHANGE MASTER TO MASTER_USE_GTID=slave_pos;\n-- SET GLOBAL gtid_slave_pos='1-100-12';\n\n" +
+			"-- CHANGE MASTER TO MASTER_LOG_FILE='mysql-bin.000001', MASTER_LOG_POS=3407;\n", "mysql-bin.000001", "3407", "1-100-12"},
+	}
+	for _, tc := range testCases {
+		dumpFile := path.Join(t.TempDir(), "dump.sql")
+		err := os.WriteFile(dumpFile, []byte(tc.header), 0600)
+		compare.OkIsNil("writing dump", err, t)
+		logFile, logPos, gtidPos, err := dumpCoordinates(dumpFile)
+		compare.OkIsNil(tc.name, err, t)
+		compare.OkEqualString(tc.name+" file", logFile, tc.logFile, t)
+		compare.OkEqualString(tc.name+" position", logPos, tc.logPos, t)
+		compare.OkEqualString(tc.name+" GTID position", gtidPos, tc.gtidPos, t)
+	}
+	dumpFile := path.Join(t.TempDir(), "dump.sql")
+	err := os.WriteFile(dumpFile, []byte("-- MySQL dump 10.13\nCREATE DATABASE `test`;\n"), 0600)
+	compare.OkIsNil("writing dump", err, t)
+	_, _, _, err = dumpCoordinates(dumpFile)
+	compare.OkIsNotNil("dump without coordinates", err, t)
+}
@@ -0,0 +1,537 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2022 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sandbox
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/concurrent"
+	"github.com/datacharmer/dbdeployer/defaults"
+	"github.com/datacharmer/dbdeployer/globals"
+	"github.com/dustin/go-humanize/english"
+	"github.com/pkg/errors"
+)
+
+// isPostgreSQL tells whether a sandbox is deployed with PostgreSQL binaries
+func isPostgreSQL(sandboxDef SandboxDef) bool {
+	return common.BaseFlavor(sandboxDef.Flavor) == common.PostgreSQLFlavor
+}
+
+// checkPostgresBinaries makes sure that the programs used by the PostgreSQL scripts are in basedir
+func checkPostgresBinaries(sandboxDef SandboxDef) error {
+	executables := []string{globals.FnPostgres, globals.FnInitdb, globals.FnPgCtl, globals.FnPsql}
+	if sandboxDef.StandbyOfPort > 0 {
+		executables = append(executables, globals.FnPgBasebackup)
+	}
+	for _, executable := range executables {
+		fullName := path.Join(sandboxDef.Basedir, "bin", executable)
+		if !common.ExecExists(fullName) {
+			return WithKind(ErrMissingBinaries, fmt.Errorf("file %s not found or not executable", fullName))
+		}
+	}
+	return nil
+}
+
+// createPostgresSandbox deploys a PostgreSQL server, using PostgresqlTemplates.
+// When sandboxDef.StandbyOfPort is set, the server is a standby, copied from a running primary
+func createPostgresSandbox(sandboxDef SandboxDef) (execList []concurrent.ExecutionList, err error) {
+	logName := sandboxDef.SBType
+	if sandboxDef.NodeNum > 0 {
+		logName = fmt.Sprintf("%s-%d", logName, sandboxDef.NodeNum)
+	}
+	logger := sandboxDef.Logger
+	if logger == nil {
+		var fileName string
+		logger, fileName, err = defaults.NewLogger(common.LogDirName(), logName)
+		if err != nil {
+			return emptyExecutionList, sbError("logger", "%s", err)
+		}
+		sandboxDef.LogFileName = common.ReplaceLiteralHome(fileName)
+		sandboxDef.Logger = logger
+	}
+	logger.Printf("PostgreSQL Sandbox Definition: %s\n", sandboxDefToJson(sandboxDef))
+	if !common.DirExists(sandboxDef.Basedir) {
+		return emptyExecutionList, WithKind(ErrMissingBinaries, fmt.Errorf(globals.ErrBaseDirectoryNotFound, sandboxDef.Basedir))
+	}
+	err = checkPostgresBinaries(sandboxDef)
+	if err != nil {
+		return emptyExecutionList, err
+	}
+	if sandboxDef.Port <= 1024 {
+		return emptyExecutionList, fmt.Errorf("port for sandbox must be > 1024 (given:%d)", sandboxDef.Port)
+	}
+	isScramAuth, err := common.HasCapability(sandboxDef.Flavor, common.PgScramAuth, sandboxDef.Version)
+	if err != nil {
+		return emptyExecutionList, err
+	}
+	isWalLevelReplica, err := common.HasCapability(sandboxDef.Flavor, common.PgWalLevelReplica, sandboxDef.Version)
+	if err != nil {
+		return emptyExecutionList, err
+	}
+	isReplicationSlots, err := common.HasCapability(sandboxDef.Flavor, common.PgReplicationSlots, sandboxDef.Version)
+	if err != nil {
+		return emptyExecutionList, err
+	}
+	authMethod := "md5"
+	if isScramAuth {
+		authMethod = "scram-sha-256"
+	}
+	walLevel := "hot_standby"
+	if isWalLevelReplica {
+		walLevel = "replica"
+	}
+
+	if sandboxDef.Prompt == "" || sandboxDef.Prompt == globals.PromptValue {
+		sandboxDef.Prompt = "postgres"
+	}
+	if sandboxDef.FlavorInPrompt {
+		sandboxDef.Prompt = sandboxDef.Flavor + "-" + sandboxDef.Prompt
+	}
+	if sandboxDef.DirName == "" {
+		if sandboxDef.Version != sandboxDef.BasedirName {
+			sandboxDef.DirName = defaults.Defaults().SandboxPrefix + sandboxDef.BasedirName
+		} else {
+			sandboxDef.DirName = defaults.Defaults().SandboxPrefix + common.VersionToName(sandboxDef.Version)
+		}
+	}
+	if sandboxDef.DirName == globals.ForbiddenDirName {
+		return emptyExecutionList, fmt.Errorf("the name %s cannot be used for a sandbox", sandboxDef.DirName)
+	}
+	sandboxDir := path.Join(sandboxDef.SandboxDir, sandboxDef.DirName)
+	sandboxDef.SandboxDir = sandboxDir
+	logger.Printf("PostgreSQL Sandbox directory defined as %s\n", sandboxDef.SandboxDir)
+	tmpDir := path.Join(sandboxDir, "tmp")
+	socketDir := common.GlobalTempDir()
+	if !common.DirExists(socketDir) {
+		return emptyExecutionList, fmt.Errorf("TMP directory %s does not exist", socketDir)
+	}
+	if sandboxDef.NodeNum == 0 && !sandboxDef.Force {
+		sandboxDef.Port, err = common.FindFreePort(sandboxDef.Port, sandboxDef.InstalledPorts, 1)
+		if err != nil {
+			return emptyExecutionList, errors.Wrapf(err, "error detecting free port for single sandbox")
+		}
+		logger.Printf("Port defined as %d using FindFreePort \n", sandboxDef.Port)
+	}
+	verList, err := common.VersionToList(sandboxDef.Version)
+	if err != nil {
+		return emptyExecutionList, errors.Wrapf(err, "")
+	}
+	if sandboxDef.SbHost == "" {
+		sandboxDef.SbHost = "127.0.0.1"
+	}
+	if sandboxDef.BindAddress == "" {
+		sandboxDef.BindAddress = sandboxDef.SbHost
+	}
+	isStandby := sandboxDef.StandbyOfPort > 0
+	initTemplate := globals.TmplPgInitDb
+	if isStandby {
+		initTemplate = globals.TmplPgStandbyInit
+	}
+	initFlags := ""
+	if len(sandboxDef.InitOptions) > 0 {
+		initFlags = strings.Join(sandboxDef.InitOptions, " ")
+	}
+
+	timestamp := time.Now()
+	var data = common.StringMap{
+		"ShellPath":      sandboxDef.ShellPath,
+		"Copyright":      globals.ShellScriptCopyright,
+		"AppVersion":     common.VersionDef,
+		"DateTime":       timestamp.Format(time.UnixDate),
+		"SandboxDir":     sandboxDir,
+		"SbHost":         sandboxDef.SbHost,
+		"BindAddress":    sandboxDef.BindAddress,
+		"Basedir":        sandboxDef.Basedir,
+		"Port":           sandboxDef.Port,
+		"Prompt":         sandboxDef.Prompt,
+		"Version":        sandboxDef.Version,
+		"VersionMajor":   verList[0],
+		"VersionMinor":   verList[1],
+		"Flavor":         sandboxDef.Flavor,
+		"SandboxType":    sandboxDef.SBType,
+		"SocketDir":      socketDir,
+		"DbUser":         sandboxDef.DbUser,
+		"DbPassword":     sandboxDef.DbPassword,
+		"RplUser":        sandboxDef.RplUser,
+		"RplPassword":    sandboxDef.RplPassword,
+		"AuthMethod":     authMethod,
+		"WalLevel":       walLevel,
+		"UseSlot":        isReplicationSlots,
+		"SlotName":       strings.ReplaceAll(sandboxDef.DirName, "-", "_"),
+		"IsStandby":      isStandby,
+		"MasterIp":       sandboxDef.StandbyOfHost,
+		"MasterPort":     sandboxDef.StandbyOfPort,
+		"ConfName":       globals.ScriptPgSandboxConf,
+		"ExtraInitFlags": initFlags,
+		"ExtraOptions":   sliceToText(sandboxDef.MyCnfOptions),
+		"HistoryDir":     sandboxDef.HistoryDir,
+	}
+
+	if common.DirExists(sandboxDir) {
+		sandboxDef, err = checkDirectory(sandboxDef)
+		if err != nil {
+			return emptyExecutionList, sbError("check directory", "%s", err)
+		}
+	}
+	logger.Printf("Checking port %d using checkPortAvailability\n", sandboxDef.Port)
+	err = checkPortAvailability("createPostgresSandbox", sandboxDef.SBType, sandboxDef.InstalledPorts, sandboxDef.Port)
+	if err != nil {
+		return emptyExecutionList, sbError("check port", "%s", err)
+	}
+	err = os.Mkdir(sandboxDir, globals.PublicDirectoryAttr)
+	if err != nil {
+		return emptyExecutionList, sbError("sandbox dir creation", "%s", err)
+	}
+	logger.Printf("Created directory %s\n", sandboxDef.SandboxDir)
+	logger.Printf("PostgreSQL Sandbox template data: %s\n", stringMapToJson(data))
+	// The data directory is created by initdb or pg_basebackup, which require it to be missing or empty
+	err = os.Mkdir(tmpDir, globals.PublicDirectoryAttr)
+	if err != nil {
+		return emptyExecutionList, sbError("tmp dir creation", "%s", err)
+	}
+	logger.Printf("Created directory %s\n", tmpDir)
+
+	logger.Printf("Writing PostgreSQL sandbox scripts\n")
+	err = writeScripts(ScriptBatch{
+		tc:         PostgresqlTemplates,
+		logger:     logger,
+		sandboxDir: sandboxDir,
+		data:       data,
+		scripts: []ScriptDef{
+			{globals.ScriptSbInclude, globals.TmplPgSbInclude, false},
+			{globals.ScriptInitDb, initTemplate, true},
+			{globals.ScriptPgSandboxConf, globals.TmplPgConf, false},
+			{globals.ScriptPgPass, globals.TmplPgPass, false},
+			{globals.ScriptStart, globals.TmplPgStart, true},
+			{globals.ScriptStop, globals.TmplPgStop, true},
+			{globals.ScriptStatus, globals.TmplPgStatus, true},
+			{globals.ScriptRestart, globals.TmplPgRestart, true},
+			{globals.ScriptSendKill, globals.TmplPgSendKill, true},
+			{globals.ScriptUse, globals.TmplPgUse, true},
+			{globals.ScriptClear, globals.TmplPgClear, true},
+			{globals.ScriptShowLog, globals.TmplPgShowLog, true},
+			{globals.ScriptTestSb, globals.TmplPgTestSb, true},
+			{globals.ScriptAfterStart, globals.TmplPgAfterStart, true},
+		},
+	})
+	if err != nil {
+		return emptyExecutionList, err
+	}
+	// The PostgreSQL client ignores a password file that other users can read
+	err = os.Chmod(path.Join(sandboxDir, globals.ScriptPgPass), 0600)
+	if err != nil {
+		return emptyExecutionList, err
+	}
+
+	initDbScript := path.Join(sandboxDir, globals.ScriptInitDb)
+	if sandboxDef.RunConcurrently {
+		logger.Printf("Added init_db script to execution list\n")
+		execList = append(execList, concurrent.ExecutionList{Logger: logger, Priority: 0,
+			Command: concurrent.ExecCommand{Cmd: initDbScript, Args: []string{}}})
+	} else {
+		logger.Printf("Running init_db script \n")
+		initOutput, err := sandboxDef.runCmd(initDbScript, nil, true)
+		if err != nil {
+			common.CondPrintf("%s output: %s\n", globals.ScriptInitDb, initOutput)
+			return emptyExecutionList, fmt.Errorf("%s failure: %s", globals.ScriptInitDb, err)
+		}
+		if !sandboxDef.Multi && globals.UsingDbDeployer {
+			common.CondPrintf("Database installed in %s\n", common.ReplaceLiteralHome(sandboxDir))
+			common.CondPrintf("run 'dbdeployer usage single' for basic instructions'\n")
+		}
+	}
+
+	sbItem := defaults.SandboxItem{
+		Origin:      sandboxDef.Basedir,
+		SBType:      sandboxDef.SBType,
+		Version:     sandboxDef.Version,
+		Flavor:      sandboxDef.Flavor,
+		Host:        sandboxDef.SbHost,
+		Port:        []int{sandboxDef.Port},
+		Nodes:       []string{},
+		Destination: sandboxDir,
+		Owner:       sandboxDef.Owner,
+		Tags:        sandboxDef.Tags,
+		Note:        sandboxDef.Note,
+		Expires:     sandboxDef.Expires,
+	}
+	if sandboxDef.LogFileName != "" {
+		sbItem.LogDirectory = common.DirName(sandboxDef.LogFileName)
+	}
+	sbDesc := common.SandboxDescription{
+		Basedir:       sandboxDef.Basedir,
+		ClientBasedir: sandboxDef.Basedir,
+		SBType:        sandboxDef.SBType,
+		Version:       sandboxDef.Version,
+		Flavor:        sandboxDef.Flavor,
+		Host:          sandboxDef.SbHost,
+		Port:          []int{sandboxDef.Port},
+		Nodes:         0,
+		NodeNum:       sandboxDef.NodeNum,
+		LogFile:       sandboxDef.LogFileName,
+		Owner:         sandboxDef.Owner,
+		Tags:          sandboxDef.Tags,
+		Note:          sandboxDef.Note,
+		Expires:       sandboxDef.Expires,
+	}
+	logger.Printf("Writing PostgreSQL sandbox description\n")
+	err = common.WriteSandboxDescription(sandboxDir, sbDesc)
+	if err != nil {
+		return emptyExecutionList, errors.Wrapf(err, "unable to write sandbox description")
+	}
+	if sandboxDef.SBType == globals.SbTypeSingle {
+		err = defaults.UpdateCatalog(sandboxDir, sbItem)
+		if err != nil {
+			return emptyExecutionList, errors.Wrapf(err, "error updating catalog")
+		}
+	}
+
+	if sandboxDef.SkipStart {
+		return execList, nil
+	}
+	if sandboxDef.RunConcurrently {
+		logger.Printf("Adding start command to execution list\n")
+		execList = append(execList, concurrent.ExecutionList{Logger: logger, Priority: 2,
+			Command: concurrent.ExecCommand{Cmd: path.Join(sandboxDir, globals.ScriptStart), Args: sandboxDef.StartArgs}})
+		logger.Printf("Adding after start command to execution list\n")
+		execList = append(execList, concurrent.ExecutionList{Logger: logger, Priority: 3,
+			Command: concurrent.ExecCommand{Cmd: path.Join(sandboxDir, globals.ScriptAfterStart), Args: []string{}}})
+		return execList, nil
+	}
+	logger.Printf("Running start script\n")
+	startOutput, err := sandboxDef.runCmd(path.Join(sandboxDir, globals.ScriptStart), sandboxDef.StartArgs, false)
+	if err != nil {
+		return emptyExecutionList, StartError(startOutput, err)
+	}
+	logger.Printf("Running after start script\n")
+	_, err = sandboxDef.runCmd(path.Join(sandboxDir, globals.ScriptAfterStart), nil, false)
+	if err != nil {
+		return emptyExecutionList, err
+	}
+	if sandboxDef.SBType == globals.SbTypeSingle {
+		err = defaults.RunHook(defaults.HookPostDeploy, sandboxDir)
+	}
+	return execList, err
+}
+
+// createPostgresReplication deploys a primary and nodes-1 standbys using streaming replication.
+// The standbys are copies of the running primary, and thus the nodes are always deployed sequentially
+func createPostgresReplication(sandboxDef SandboxDef, origin string, nodes int, masterIp string) error {
+	var logger *defaults.Logger
+	if sandboxDef.Logger != nil {
+		logger = sandboxDef.Logger
+	} else {
+		var fileName string
+		var err error
+		logger, fileName, err = defaults.NewLogger(common.LogDirName(), globals.MasterSlaveLabel+"-replication")
+		if err != nil {
+			return err
+		}
+		sandboxDef.LogFileName = common.ReplaceLiteralHome(fileName)
+	}
+	isStreamingReplication, err := common.HasCapability(sandboxDef.Flavor, common.PgStreamingReplication, sandboxDef.Version)
+	if err != nil {
+		return err
+	}
+	if !isStreamingReplication {
+		return unsupportedError(globals.ErrFeatureRequiresCapability, "streaming replication", common.PostgreSQLFlavor,
+			common.IntSliceToDottedString(globals.MinimumPgStreamingReplication))
+	}
+	if sandboxDef.SkipStart {
+		return unsupportedError("option --%s can't be used with PostgreSQL replication, as the standbys are copied from the running primary",
+			globals.SkipStartLabel)
+	}
+	if nodes < 2 {
+		return fmt.Errorf("can't run replication with less than 2 nodes")
+	}
+	vList, err := common.VersionToList(sandboxDef.Version)
+	if err != nil {
+		return err
+	}
+	basePort := computeBaseport(sandboxDef.Port + defaults.Defaults().MasterSlaveBasePort + (vList[2] * 100))
+	if sandboxDef.BasePort > 0 {
+		basePort = sandboxDef.BasePort
+	}
+	// FindFreePort returns the first free port, but base_port will be used
+	// with a counter. Thus the availability will be checked using
+	// "base_port + 1"
+	firstPort, err := common.FindFreePort(basePort+1, sandboxDef.InstalledPorts, nodes)
+	if err != nil {
+		return errors.Wrapf(err, "error detecting free port for replication")
+	}
+	basePort = firstPort - 1
+	for checkPort := basePort + 1; checkPort < basePort+nodes+1; checkPort++ {
+		err := checkPortAvailability("createPostgresReplication", sandboxDef.SandboxDir, sandboxDef.InstalledPorts, checkPort)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = os.Mkdir(sandboxDef.SandboxDir, globals.PublicDirectoryAttr)
+	if err != nil {
+		return err
+	}
+	logger.Printf("Created directory %s\n", sandboxDef.SandboxDir)
+	logger.Printf("PostgreSQL Replication Sandbox Definition: %s\n", sandboxDefToJson(sandboxDef))
+	common.AddToCleanupStack(common.RmdirAll, "RmdirAll", sandboxDef.SandboxDir)
+
+	slaves := nodes - 1
+	masterAbbr := defaults.Defaults().MasterAbbr
+	masterLabel := defaults.Defaults().MasterName
+	slaveLabel := defaults.Defaults().SlavePrefix
+	nodeLabel := defaults.Defaults().NodePrefix
+	timestamp := time.Now()
+	var data = common.StringMap{
+		"ShellPath":   sandboxDef.ShellPath,
+		"Copyright":   globals.ShellScriptCopyright,
+		"AppVersion":  common.VersionDef,
+		"DateTime":    timestamp.Format(time.UnixDate),
+		"SandboxDir":  sandboxDef.SandboxDir,
+		"MasterLabel": masterLabel,
+		"MasterPort":  basePort + 1,
+		"SlaveLabel":  slaveLabel,
+		"NodeLabel":   nodeLabel,
+		"MasterAbbr":  masterAbbr,
+		"MasterIp":    masterIp,
+		"RplUser":     sandboxDef.RplUser,
+		"RplPassword": sandboxDef.RplPassword,
+		"SlaveAbbr":   defaults.Defaults().SlaveAbbr,
+		"Slaves":      []common.StringMap{},
+	}
+	logger.Printf("Defining replication data: %v\n", stringMapToJson(data))
+
+	sandboxDef.RunConcurrently = false
+	sandboxDef.Multi = true
+	sandboxDef.SBType = "replication-node"
+	sandboxDef.Logger = logger
+
+	primaryDef := sandboxDef
+	primaryDef.DirName = masterLabel
+	primaryDef.Port = basePort + 1
+	primaryDef.NodeNum = 1
+	primaryDef.Prompt = masterLabel
+	common.CondPrintf("Installing and starting %s\n", masterLabel)
+	logger.Printf("Creating PostgreSQL sandbox for primary\n")
+	_, err = CreateChildSandbox(primaryDef)
+	if err != nil {
+		return fmt.Errorf(globals.ErrCreatingSandbox, err)
+	}
+
+	sbDesc := common.SandboxDescription{
+		Basedir: sandboxDef.Basedir,
+		SBType:  globals.MasterSlaveLabel,
+		Version: sandboxDef.Version,
+		Flavor:  sandboxDef.Flavor,
+		Port:    []int{primaryDef.Port},
+		Nodes:   slaves,
+		NodeNum: 0,
+		LogFile: sandboxDef.LogFileName,
+		Owner:   sandboxDef.Owner,
+		Tags:    sandboxDef.Tags,
+		Note:    sandboxDef.Note,
+		Expires: sandboxDef.Expires,
+	}
+	sbItem := defaults.SandboxItem{
+		Origin:      sbDesc.Basedir,
+		SBType:      sbDesc.SBType,
+		Version:     sandboxDef.Version,
+		Flavor:      sandboxDef.Flavor,
+		Port:        []int{primaryDef.Port},
+		Nodes:       []string{masterLabel},
+		Destination: sandboxDef.SandboxDir,
+		Owner:       sandboxDef.Owner,
+		Tags:        sandboxDef.Tags,
+		Note:        sandboxDef.Note,
+		Expires:     sandboxDef.Expires,
+	}
+	if sandboxDef.LogFileName != "" {
+		sbItem.LogDirectory = common.DirName(sandboxDef.LogFileName)
+	}
+
+	for i := 1; i <= slaves; i++ {
+		standbyDef := sandboxDef
+		standbyDef.Port = basePort + i + 1
+		standbyDef.DirName = fmt.Sprintf("%s%d", nodeLabel, i)
+		standbyDef.Prompt = fmt.Sprintf("%s%d", slaveLabel, i)
+		standbyDef.NodeNum = i + 1
+		standbyDef.StandbyOfHost = masterIp
+		standbyDef.StandbyOfPort = primaryDef.Port
+		slave := slaveData(data, i, standbyDef.Port, primaryDef.Port, 1, masterLabel, masterLabel, false)
+		data["Slaves"] = append(data["Slaves"].([]common.StringMap), slave)
+		sbItem.Nodes = append(sbItem.Nodes, standbyDef.DirName)
+		sbItem.Port = append(sbItem.Port, standbyDef.Port)
+		sbDesc.Port = append(sbDesc.Port, standbyDef.Port)
+		common.CondPrintf("Installing and starting %s%d\n", slaveLabel, i)
+		logger.Printf("Creating PostgreSQL sandbox for standby %d\n", i)
+		_, err = CreateChildSandbox(standbyDef)
+		if err != nil {
+			return fmt.Errorf(globals.ErrCreatingSandbox, err)
+		}
+		logger.Printf("Create slave script %d\n", i)
+		err = writeSlaveScripts(logger, sandboxDef.SandboxDir, slave, i, false)
+		if err != nil {
+			return err
+		}
+	}
+	err = common.WriteSandboxDescription(sandboxDef.SandboxDir, sbDesc)
+	if err != nil {
+		return errors.Wrapf(err, "unable to write sandbox description")
+	}
+	logger.Printf("Create sandbox description\n")
+	err = defaults.UpdateCatalog(sandboxDef.SandboxDir, sbItem)
+	if err != nil {
+		return errors.Wrapf(err, "unable to update catalog")
+	}
+
+	slavePlural := english.PluralWord(2, slaveLabel, "")
+	masterPlural := english.PluralWord(2, masterLabel, "")
+	logger.Printf("Create replication scripts\n")
+	// The scripts that only call the ones of each node are the same used for MySQL
+	err = writeScripts(ScriptBatch{ReplicationTemplates, logger, sandboxDef.SandboxDir, data,
+		[]ScriptDef{
+			{globals.ScriptStopAll, globals.TmplStopAll, true},
+			{globals.ScriptRestartAll, globals.TmplRestartAll, true},
+			{globals.ScriptSendKillAll, globals.TmplSendKillAll, true},
+			{globals.ScriptTestSbAll, globals.TmplTestSbAll, true},
+			{globals.ScriptUseAll, globals.TmplUseAll, true},
+			{"use_all_" + slavePlural, globals.TmplUseAllSlaves, true},
+			{"use_all_" + masterPlural, globals.TmplUseAllMasters, true},
+			{masterAbbr, globals.TmplMaster, true},
+			{"n1", globals.TmplMaster, true},
+		}})
+	if err != nil {
+		return err
+	}
+	err = writeScripts(ScriptBatch{PostgresqlTemplates, logger, sandboxDef.SandboxDir, data,
+		[]ScriptDef{
+			{globals.ScriptStartAll, globals.TmplPgStartAll, true},
+			{globals.ScriptStatusAll, globals.TmplPgStatusAll, true},
+			{globals.ScriptClearAll, globals.TmplPgClearAll, true},
+			{"check_" + slavePlural, globals.TmplPgCheckStandbys, true},
+			{"test_replication", globals.TmplPgTestReplication, true},
+		}})
+	if err != nil {
+		return err
+	}
+	common.CondPrintf("Replication directory installed in %s\n", common.ReplaceLiteralHome(sandboxDef.SandboxDir))
+	common.CondPrintf("run 'dbdeployer usage multiple' for basic instructions'\n")
+	return nil
+}
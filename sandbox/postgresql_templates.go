@@ -0,0 +1,187 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2022 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sandbox
+
+import (
+	_ "embed"
+	"fmt"
+	"os"
+	"regexp"
+
+	"github.com/datacharmer/dbdeployer/globals"
+)
+
+var (
+	//go:embed templates/postgresql/sb_include.gotxt
+	pgSbIncludeTemplate string
+	//go:embed templates/postgresql/init_db.gotxt
+	pgInitDbTemplate string
+	//go:embed templates/postgresql/standby_init.gotxt
+	pgStandbyInitTemplate string
+	//go:embed templates/postgresql/conf.gotxt
+	pgConfTemplate string
+	//go:embed templates/postgresql/pgpass.gotxt
+	pgPassTemplate string
+	//go:embed templates/postgresql/start.gotxt
+	pgStartTemplate string
+	//go:embed templates/postgresql/stop.gotxt
+	pgStopTemplate string
+	//go:embed templates/postgresql/status.gotxt
+	pgStatusTemplate string
+	//go:embed templates/postgresql/restart.gotxt
+	pgRestartTemplate string
+	//go:embed templates/postgresql/send_kill.gotxt
+	pgSendKillTemplate string
+	//go:embed templates/postgresql/use.gotxt
+	pgUseTemplate string
+	//go:embed templates/postgresql/clear.gotxt
+	pgClearTemplate string
+	//go:embed templates/postgresql/show_log.gotxt
+	pgShowLogTemplate string
+	//go:embed templates/postgresql/test_sb.gotxt
+	pgTestSbTemplate string
+	//go:embed templates/postgresql/after_start.gotxt
+	pgAfterStartTemplate string
+	//go:embed templates/postgresql/start_all.gotxt
+	pgStartAllTemplate string
+	//go:embed templates/postgresql/status_all.gotxt
+	pgStatusAllTemplate string
+	//go:embed templates/postgresql/clear_all.gotxt
+	pgClearAllTemplate string
+	//go:embed templates/postgresql/check_slaves.gotxt
+	pgCheckStandbysTemplate string
+	//go:embed templates/postgresql/test_replication.gotxt
+	pgTestReplicationTemplate string
+)
+
+const pgPrefix = "pg_"
+
+// PostgresqlTemplates are used instead of SingleTemplates and ReplicationTemplates
+// when the flavor is "postgresql"
+var PostgresqlTemplates = TemplateCollection{
+	globals.TmplPgSbInclude: TemplateDesc{
+		Description: "Common variables and routines for PostgreSQL sandboxes scripts",
+		Notes:       "",
+		Contents:    pgSbIncludeTemplate,
+	},
+	globals.TmplPgInitDb: TemplateDesc{
+		Description: "Initialization template for the PostgreSQL server",
+		Notes:       "This should normally run only once",
+		Contents:    pgInitDbTemplate,
+	},
+	globals.TmplPgStandbyInit: TemplateDesc{
+		Description: "Initialization template for a PostgreSQL standby",
+		Notes:       "Copies the primary using pg_basebackup",
+		Contents:    pgStandbyInitTemplate,
+	},
+	globals.TmplPgConf: TemplateDesc{
+		Description: "Options for a PostgreSQL sandbox",
+		Notes:       "Included by the postgresql.conf of the data directory",
+		Contents:    pgConfTemplate,
+	},
+	globals.TmplPgPass: TemplateDesc{
+		Description: "Password file for the PostgreSQL client",
+		Notes:       "",
+		Contents:    pgPassTemplate,
+	},
+	globals.TmplPgStart: TemplateDesc{
+		Description: "Starts a database in a single PostgreSQL sandbox",
+		Notes:       "",
+		Contents:    pgStartTemplate,
+	},
+	globals.TmplPgStop: TemplateDesc{
+		Description: "Stops a database in a single PostgreSQL sandbox",
+		Notes:       "",
+		Contents:    pgStopTemplate,
+	},
+	globals.TmplPgStatus: TemplateDesc{
+		Description: "Shows the status of a single PostgreSQL sandbox",
+		Notes:       "",
+		Contents:    pgStatusTemplate,
+	},
+	globals.TmplPgRestart: TemplateDesc{
+		Description: "Restarts the database in a single PostgreSQL sandbox",
+		Notes:       "",
+		Contents:    pgRestartTemplate,
+	},
+	globals.TmplPgSendKill: TemplateDesc{
+		Description: "Sends a kill signal to the PostgreSQL database",
+		Notes:       "",
+		Contents:    pgSendKillTemplate,
+	},
+	globals.TmplPgUse: TemplateDesc{
+		Description: "Invokes psql with the right connection parameters",
+		Notes:       "",
+		Contents:    pgUseTemplate,
+	},
+	globals.TmplPgClear: TemplateDesc{
+		Description: "Removes all data from a PostgreSQL sandbox and initializes it again",
+		Notes:       "",
+		Contents:    pgClearTemplate,
+	},
+	globals.TmplPgShowLog: TemplateDesc{
+		Description: "Shows the log of a PostgreSQL sandbox",
+		Notes:       "",
+		Contents:    pgShowLogTemplate,
+	},
+	globals.TmplPgTestSb: TemplateDesc{
+		Description: "Tests basic PostgreSQL sandbox functionality",
+		Notes:       "",
+		Contents:    pgTestSbTemplate,
+	},
+	globals.TmplPgAfterStart: TemplateDesc{
+		Description: "Commands to run after the database started",
+		Notes:       "Creates the replication user in the primary",
+		Contents:    pgAfterStartTemplate,
+	},
+	globals.TmplPgStartAll: TemplateDesc{
+		Description: "Starts the primary and all the standbys",
+		Notes:       "",
+		Contents:    pgStartAllTemplate,
+	},
+	globals.TmplPgStatusAll: TemplateDesc{
+		Description: "Shows the status of all the nodes of a PostgreSQL replication",
+		Notes:       "",
+		Contents:    pgStatusAllTemplate,
+	},
+	globals.TmplPgClearAll: TemplateDesc{
+		Description: "Removes data from all the nodes of a PostgreSQL replication",
+		Notes:       "The primary is cleared before the standbys",
+		Contents:    pgClearAllTemplate,
+	},
+	globals.TmplPgCheckStandbys: TemplateDesc{
+		Description: "Shows the replication status of the primary and of the standbys",
+		Notes:       "",
+		Contents:    pgCheckStandbysTemplate,
+	},
+	globals.TmplPgTestReplication: TemplateDesc{
+		Description: "Tests streaming replication",
+		Notes:       "",
+		Contents:    pgTestReplicationTemplate,
+	},
+}
+
+func init() {
+	// Makes sure that all template names in PostgresqlTemplates start with 'pg_',
+	// so that they can't be confused with the ones they replace
+	re := regexp.MustCompile(`^` + pgPrefix)
+	for name := range PostgresqlTemplates {
+		if !re.MatchString(name) {
+			fmt.Printf("found template name '%s' that does not start with '%s'\n", name, pgPrefix)
+			os.Exit(1)
+		}
+	}
+}
@@ -207,6 +207,10 @@ func CreatePxcReplication(sandboxDef SandboxDef, origin string, nodes int, maste
 		Nodes:   nodes,
 		NodeNum: 0,
 		LogFile: sandboxDef.LogFileName,
+		Owner:   sandboxDef.Owner,
+		Tags:    sandboxDef.Tags,
+		Note:    sandboxDef.Note,
+		Expires: sandboxDef.Expires,
 	}
 
 	sbItem := defaults.SandboxItem{
@@ -217,6 +221,10 @@ func CreatePxcReplication(sandboxDef SandboxDef, origin string, nodes int, maste
 		Port:        []int{},
 		Nodes:       []string{},
 		Destination: sandboxDef.SandboxDir,
+		Owner:       sandboxDef.Owner,
+		Tags:        sandboxDef.Tags,
+		Note:        sandboxDef.Note,
+		Expires:     sandboxDef.Expires,
 	}
 
 	if sandboxDef.LogFileName != "" {
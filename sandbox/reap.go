@@ -0,0 +1,125 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2022 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sandbox
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/defaults"
+	"github.com/pkg/errors"
+)
+
+// ExpiredSandbox is a sandbox whose time to live has passed
+type ExpiredSandbox struct {
+	Name    string
+	Expires time.Time
+	Locked  bool  // locked sandboxes are never reaped
+	Size    int64 // disk space used by the sandbox and its snapshots
+	Ports   []int
+	flavor  string
+}
+
+// diskUsage returns the total size of the files under a directory
+func diskUsage(dir string) (int64, error) {
+	var size int64
+	if !common.DirExists(dir) {
+		return 0, nil
+	}
+	err := filepath.WalkDir(dir, func(fileName string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.Type().IsRegular() {
+			info, err := entry.Info()
+			if err != nil {
+				return err
+			}
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
+// FindExpiredSandboxes returns the sandboxes in sandboxHome that expired before the given time.
+// Sandboxes deployed without a time to live never expire.
+// Sandboxes with an invalid expiry are skipped with a warning
+func FindExpiredSandboxes(sandboxHome string, now time.Time) ([]ExpiredSandbox, error) {
+	installed, err := common.GetInstalledSandboxes(sandboxHome)
+	if err != nil {
+		return nil, err
+	}
+	var expired []ExpiredSandbox
+	for _, sb := range installed {
+		if sb.SandboxDesc.Expires == "" {
+			continue
+		}
+		expires, err := time.Parse(time.RFC3339, sb.SandboxDesc.Expires)
+		if err != nil {
+			// A broken description must not prevent the removal of the other sandboxes
+			_, _ = fmt.Fprintf(os.Stderr, "# WARNING: skipping sandbox %s: invalid expiry '%s': %s\n",
+				sb.SandboxName, sb.SandboxDesc.Expires, err)
+			continue
+		}
+		if expires.After(now) {
+			continue
+		}
+		sandboxPath := path.Join(sandboxHome, sb.SandboxName)
+		size, err := diskUsage(sandboxPath)
+		if err != nil {
+			return nil, err
+		}
+		snapshotsSize, err := diskUsage(SnapshotsDir(sandboxHome, sb.SandboxName))
+		if err != nil {
+			return nil, err
+		}
+		ports, err := common.GetInstalledPorts(sandboxPath)
+		if err != nil {
+			return nil, err
+		}
+		expired = append(expired, ExpiredSandbox{
+			Name:    sb.SandboxName,
+			Expires: expires,
+			Locked:  sb.Locked,
+			Size:    size + snapshotsSize,
+			Ports:   ports,
+			flavor:  sb.SandboxDesc.Flavor,
+		})
+	}
+	return expired, nil
+}
+
+// ReapSandbox stops and removes an expired sandbox, and deletes it from the catalog
+func ReapSandbox(sandboxHome string, sb ExpiredSandbox) error {
+	if sb.Locked {
+		return errors.Errorf("sandbox %s is locked", sb.Name)
+	}
+	_, err := RemoveCustomSandbox(sandboxHome, sb.Name, false, UseStopForFlavor(sb.flavor, false))
+	if err != nil {
+		return err
+	}
+	err = defaults.DeleteFromCatalog(path.Join(sandboxHome, sb.Name))
+	if err != nil {
+		return errors.Wrapf(err, "unable to update catalog")
+	}
+	return nil
+}
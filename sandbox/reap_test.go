@@ -0,0 +1,107 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2022 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sandbox
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/compare"
+	"github.com/datacharmer/dbdeployer/globals"
+)
+
+func TestFindExpiredSandboxes(t *testing.T) {
+	sandboxHome := t.TempDir()
+	now := time.Now()
+	for name, expires := range map[string]string{
+		"msb_8_0_34": "",
+		"msb_8_0_35": now.Add(time.Hour).Format(time.RFC3339),
+		"msb_8_0_36": now.Add(-time.Hour).Format(time.RFC3339),
+		"msb_8_0_37": now.Add(-time.Minute).Format(time.RFC3339),
+	} {
+		sandboxPath := path.Join(sandboxHome, name)
+		err := os.MkdirAll(path.Join(sandboxPath, globals.DataDirName), 0755)
+		compare.OkIsNil("sandbox directory", err, t)
+		err = common.WriteSandboxDescription(sandboxPath, common.SandboxDescription{
+			SBType: globals.SbTypeSingle, Version: "8.0.36", Port: []int{8036}, Expires: expires})
+		compare.OkIsNil("sandbox description", err, t)
+		err = os.WriteFile(path.Join(sandboxPath, globals.DataDirName, "ibdata1"), make([]byte, 1000), 0644)
+		compare.OkIsNil("data file", err, t)
+	}
+	err := os.WriteFile(path.Join(sandboxHome, "msb_8_0_37", globals.ScriptNoClear), []byte(""), 0755)
+	compare.OkIsNil("lock", err, t)
+
+	expired, err := FindExpiredSandboxes(sandboxHome, now)
+	compare.OkIsNil("find expired", err, t)
+	compare.OkEqualInt("expired sandboxes", len(expired), 2, t)
+	for _, sb := range expired {
+		compare.OkEqualBool(sb.Name+" locked", sb.Locked, sb.Name == "msb_8_0_37", t)
+		compare.OkEqualBool(sb.Name+" size", sb.Size > 1000, true, t)
+		compare.OkEqualInt(sb.Name+" ports", len(sb.Ports), 1, t)
+	}
+	err = ReapSandbox(sandboxHome, expired[1])
+	compare.OkIsNotNil("reaping locked sandbox", err, t)
+
+	expired, err = FindExpiredSandboxes(sandboxHome, now.Add(2*time.Hour))
+	compare.OkIsNil("find expired later", err, t)
+	compare.OkEqualInt("expired sandboxes later", len(expired), 3, t)
+}
+
+func TestFindExpiredSandboxesInvalidExpiry(t *testing.T) {
+	sandboxHome := t.TempDir()
+	for name, expires := range map[string]string{
+		"msb_8_0_35": "next tuesday",
+		"msb_8_0_36": time.Now().Add(-time.Hour).Format(time.RFC3339),
+	} {
+		sandboxPath := path.Join(sandboxHome, name)
+		err := os.MkdirAll(sandboxPath, 0755)
+		compare.OkIsNil("sandbox directory", err, t)
+		err = common.WriteSandboxDescription(sandboxPath, common.SandboxDescription{
+			SBType: globals.SbTypeSingle, Version: "8.0.36", Port: []int{8036}, Expires: expires})
+		compare.OkIsNil("sandbox description", err, t)
+	}
+
+	// The sandbox with an invalid expiry is skipped, and the expired one is still found
+	expired, err := FindExpiredSandboxes(sandboxHome, time.Now())
+	compare.OkIsNil("find expired", err, t)
+	compare.OkEqualInt("expired sandboxes", len(expired), 1, t)
+	if len(expired) == 1 {
+		compare.OkEqualString("expired sandbox", expired[0].Name, "msb_8_0_36", t)
+	}
+}
+
+func TestUseStopForFlavor(t *testing.T) {
+	var testCases = []struct {
+		flavor    string
+		requested bool
+		expected  bool
+	}{
+		{common.MySQLFlavor, false, false},
+		{common.MySQLFlavor, true, true},
+		{common.MariaDbFlavor, false, false},
+		{common.NdbFlavor, false, true},
+		{common.PxcFlavor, false, true},
+		{"", false, true},
+	}
+	for _, tc := range testCases {
+		compare.OkEqualBool(fmt.Sprintf("flavor '%s' (requested: %v)", tc.flavor, tc.requested),
+			UseStopForFlavor(tc.flavor, tc.requested), tc.expected, t)
+	}
+}
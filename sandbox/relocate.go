@@ -0,0 +1,649 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2022 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sandbox
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/defaults"
+	"github.com/datacharmer/dbdeployer/globals"
+)
+
+// Files larger than this are not considered when rewriting the text of a sandbox
+const maxRelocatedTextSize = 1024 * 1024
+
+// Sandbox types that cannot be copied: they keep ports and paths in their metadata tables,
+// or, as groups do, their name, which the copy would share with the source
+var notCopyableSandboxes = []string{
+	globals.SbTypeGroupSinglePrimary,
+	globals.SbTypeGroupMultiPrimary,
+	globals.InnoDBClusterLabel,
+	"ndb",
+	globals.SbTypeClusterSet,
+}
+
+// relocation describes how the text files of a sandbox change when the sandbox is copied or moved
+type relocation struct {
+	oldPath string
+	newPath string
+	ports   map[int]int       // old port -> new port. Empty when moving
+	uuids   map[string]string // old server UUID -> new server UUID. Empty when moving
+}
+
+// rewriter returns a function that replaces old paths, ports, and UUIDs in a text
+func (r relocation) rewriter() func(string) string {
+	// The old path must not be followed by a character that would make it a different name,
+	// such as 'msb_8_0_36' when relocating 'msb_8_0_3'
+	alternatives := []string{regexp.QuoteMeta(r.oldPath) + `(?:[^\w.-]|$)`}
+	var uuids []string
+	for uuid := range r.uuids {
+		uuids = append(uuids, regexp.QuoteMeta(uuid))
+	}
+	sort.Strings(uuids)
+	alternatives = append(alternatives, uuids...)
+	if len(r.ports) > 0 {
+		// Ports are replaced only when they are a whole number, not part of a longer one
+		alternatives = append(alternatives, `\d+`)
+	}
+	re := regexp.MustCompile(strings.Join(alternatives, "|"))
+	return func(text string) string {
+		return re.ReplaceAllStringFunc(text, func(found string) string {
+			if strings.HasPrefix(found, r.oldPath) {
+				return r.newPath + strings.TrimPrefix(found, r.oldPath)
+			}
+			if uuid, ok := r.uuids[found]; ok {
+				return uuid
+			}
+			number, err := strconv.Atoi(found)
+			if err == nil {
+				if port, ok := r.ports[number]; ok {
+					return strconv.Itoa(port)
+				}
+			}
+			return found
+		})
+	}
+}
+
+// rewriteTree applies the relocation to the text files of a sandbox.
+// Data and TLS directories are skipped, and so are the files listed in skipFiles
+func (r relocation) rewriteTree(sandboxPath string, skipFiles ...string) error {
+	rewrite := r.rewriter()
+	return filepath.WalkDir(sandboxPath, func(fileName string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if fileName != sandboxPath && (entry.Name() == globals.DataDirName || entry.Name() == globals.TlsDirName) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !entry.Type().IsRegular() || slices.Contains(skipFiles, entry.Name()) {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		if info.Size() > maxRelocatedTextSize {
+			return nil
+		}
+		contents, err := os.ReadFile(fileName) // #nosec G304
+		if err != nil {
+			return err
+		}
+		// Binary files are left alone
+		if bytes.IndexByte(contents, 0) >= 0 {
+			return nil
+		}
+		text := rewrite(string(contents))
+		if text == string(contents) {
+			return nil
+		}
+		return os.WriteFile(fileName, []byte(text), info.Mode().Perm())
+	})
+}
+
+// copyTree copies a sandbox directory. Sockets and PID files are not copied
+func copyTree(source, destination string) error {
+	return filepath.WalkDir(source, func(fileName string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		relative, err := filepath.Rel(source, fileName)
+		if err != nil {
+			return err
+		}
+		target := path.Join(destination, relative)
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		switch {
+		case entry.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm())
+		case entry.Type()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(fileName)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case !entry.Type().IsRegular() || strings.HasSuffix(entry.Name(), ".pid"):
+			return nil
+		}
+		return common.CopyFile(fileName, target)
+	})
+}
+
+// RelocationTarget returns the full path of the destination of a copy or a move.
+// A relative destination is a sandbox name in the sandbox home
+func RelocationTarget(sandboxHome, destination string) string {
+	if path.IsAbs(destination) {
+		return path.Clean(destination)
+	}
+	return path.Join(sandboxHome, destination)
+}
+
+// checkRelocation makes sure that the source is a sandbox and that the destination is available
+func checkRelocation(sourcePath, destinationPath string) (common.SandboxDescription, []string, error) {
+	if !common.DirExists(sourcePath) {
+		return common.SandboxDescription{}, nil, fmt.Errorf(globals.ErrDirectoryNotFound, sourcePath)
+	}
+	sbDesc, err := common.ReadSandboxDescription(sourcePath)
+	if err != nil {
+		return sbDesc, nil, err
+	}
+	if destinationPath == sourcePath || strings.HasPrefix(destinationPath, sourcePath+"/") {
+		return sbDesc, nil, fmt.Errorf("destination %s cannot be inside the source sandbox %s", destinationPath, sourcePath)
+	}
+	if common.DirExists(destinationPath) || common.FileExists(destinationPath) {
+		return sbDesc, nil, fmt.Errorf(globals.ErrNamedDirectoryAlreadyExists, "destination", destinationPath)
+	}
+	if !common.DirExists(path.Dir(destinationPath)) {
+		return sbDesc, nil, fmt.Errorf(globals.ErrDirectoryNotFound, path.Dir(destinationPath))
+	}
+	servers, err := sandboxServerDirs(sourcePath, "", 2)
+	if err != nil {
+		return sbDesc, nil, err
+	}
+	if len(servers) == 0 {
+		return sbDesc, nil, fmt.Errorf("no data directories found in %s", sourcePath)
+	}
+	return sbDesc, servers, nil
+}
+
+// relocateCatalog registers the relocated sandbox in the catalog, using the entries of the source.
+// When the sandbox was moved, the old entries are removed
+func relocateCatalog(r relocation, isMove bool) error {
+	catalog, err := defaults.ReadCatalog()
+	if err != nil {
+		return err
+	}
+	for name, item := range catalog {
+		if name != r.oldPath && !strings.HasPrefix(name, r.oldPath+"/") {
+			continue
+		}
+		newName := r.newPath + strings.TrimPrefix(name, r.oldPath)
+		item.Destination = newName
+		if !isMove {
+			item.Port = mapPorts(item.Port, r.ports)
+			// The copy must not share the log directory of the source,
+			// or removing the copy would remove the source logs
+			item.LogDirectory = ""
+		}
+		err = defaults.UpdateCatalog(newName, item)
+		if err != nil {
+			return errors.Wrapf(err, "unable to update catalog")
+		}
+		if isMove {
+			err = defaults.DeleteFromCatalog(name)
+			if err != nil {
+				return errors.Wrapf(err, "unable to update catalog")
+			}
+		}
+	}
+	return nil
+}
+
+// refreshTopologyScripts regenerates from templates the scripts of the sandboxes that support it
+func refreshTopologyScripts(sandboxPath, sbType, label string) error {
+	if sbType != globals.MasterSlaveLabel && sbType != globals.SbTypeMultiple {
+		return nil
+	}
+	nl, err := readNodeLayout(sandboxPath)
+	if err != nil {
+		return err
+	}
+	logger, _, err := defaults.NewLogger(common.LogDirName(), label)
+	if err != nil {
+		return err
+	}
+	return nl.refreshNodeScripts(logger)
+}
+
+// removeDefaultMarker removes the marker of the default sandbox from a relocated sandbox,
+// and reports whether it was there
+func removeDefaultMarker(sandboxPath string) (bool, error) {
+	marker := path.Join(sandboxPath, "is_default")
+	if !common.FileExists(marker) {
+		return false, nil
+	}
+	return true, os.Remove(marker)
+}
+
+func mapPorts(ports []int, portMap map[int]int) []int {
+	var mapped []int
+	for _, port := range ports {
+		if newPort, ok := portMap[port]; ok {
+			port = newPort
+		}
+		mapped = append(mapped, port)
+	}
+	return mapped
+}
+
+// allocatePorts assigns a free port to every port used by a sandbox
+func allocatePorts(sandboxHome string, descriptions []common.SandboxDescription) (map[int]int, error) {
+	installedPorts, err := common.GetInstalledPorts(sandboxHome)
+	if err != nil {
+		return nil, err
+	}
+	installedPorts = append(installedPorts, defaults.Defaults().ReservedPorts...)
+	var oldPorts []int
+	for _, sbDesc := range descriptions {
+		oldPorts = append(oldPorts, sbDesc.Port...)
+	}
+	sort.Ints(oldPorts)
+	ports := make(map[int]int)
+	for _, port := range oldPorts {
+		if _, ok := ports[port]; ok {
+			continue
+		}
+		newPort, err := common.FindFreePort(port, installedPorts, 1)
+		if err != nil {
+			return nil, err
+		}
+		ports[port] = newPort
+		installedPorts = append(installedPorts, newPort)
+	}
+	return ports, nil
+}
+
+var (
+	serverUuidRe = regexp.MustCompile(`server-uuid\s*=\s*(\S+)`)
+	serverIdRe   = regexp.MustCompile(`(server[-_]id\s*=\s*)\d+`)
+)
+
+// renewServerUuid writes a new auto.cnf for a copied server, and returns the old and new UUIDs.
+// Servers without auto.cnf are left alone
+func renewServerUuid(serverPath string, sbDesc common.SandboxDescription, newPort int) (string, string, error) {
+	uuidDef, uuidFile, err := fixServerUuid(SandboxDef{
+		Version:    sbDesc.Version,
+		Flavor:     sbDesc.Flavor,
+		Port:       newPort,
+		NodeNum:    sbDesc.NodeNum,
+		SandboxDir: serverPath,
+	})
+	if err != nil || uuidDef == "" || !common.FileExists(uuidFile) {
+		return "", "", err
+	}
+	text, err := common.SlurpAsString(uuidFile)
+	if err != nil {
+		return "", "", err
+	}
+	matches := serverUuidRe.FindStringSubmatch(text)
+	if matches == nil {
+		return "", "", fmt.Errorf("no server UUID found in %s", uuidFile)
+	}
+	err = common.WriteString(fmt.Sprintf("[auto]\n%s\n", uuidDef), uuidFile)
+	if err != nil {
+		return "", "", err
+	}
+	return matches[1], strings.TrimPrefix(uuidDef, "server-uuid="), nil
+}
+
+// changeServerId changes the server ID in the configuration of a copied server, if it has one
+func changeServerId(serverPath string, serverId int) error {
+	for _, fileName := range []string{globals.ScriptMySandboxCnf, globals.ScriptSbInclude} {
+		fullName := path.Join(serverPath, fileName)
+		if !common.FileExists(fullName) {
+			continue
+		}
+		text, err := common.SlurpAsString(fullName)
+		if err != nil {
+			return err
+		}
+		newText := serverIdRe.ReplaceAllString(text, fmt.Sprintf("${1}%d", serverId))
+		if newText != text {
+			err = common.WriteString(newText, fullName)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// parseReplicaStatus returns the rows of the vertical output of SHOW REPLICA STATUS
+func parseReplicaStatus(text string) []map[string]string {
+	var rows []map[string]string
+	var current map[string]string
+	for _, line := range strings.Split(text, "\n") {
+		if strings.HasPrefix(line, "***") {
+			current = make(map[string]string)
+			rows = append(rows, current)
+			continue
+		}
+		key, value, found := strings.Cut(strings.TrimSpace(line), ":")
+		if !found || current == nil {
+			continue
+		}
+		current[key] = strings.TrimSpace(value)
+	}
+	return rows
+}
+
+// statusValue returns the value of the first of the given columns that is found in a status row
+func statusValue(row map[string]string, columns ...string) string {
+	for _, column := range columns {
+		if value, ok := row[column]; ok {
+			return value
+		}
+	}
+	return ""
+}
+
+// repointReplication changes the replication channels of a copied server that point to
+// a server of the source sandbox, so that they use the corresponding server of the copy
+func repointReplication(serverPath, flavor, version string, ports map[int]int) error {
+	legacySyntax := usesChangeMasterSyntax(flavor, version)
+	syntax := replicationSyntax(flavor, version)
+	statusStatement := "SHOW REPLICA STATUS"
+	changeSource := "CHANGE REPLICATION SOURCE TO SOURCE_PORT=%d"
+	if legacySyntax {
+		statusStatement = "SHOW SLAVE STATUS"
+		changeSource = "CHANGE MASTER TO MASTER_PORT=%d"
+	}
+	out, err := common.RunCmdCtrlWithArgs(path.Join(serverPath, globals.ScriptUse),
+		[]string{"-u", "root", "-e", statusStatement + `\G`}, true)
+	if err != nil {
+		return fmt.Errorf("error reading replication status in %s: %s", serverPath, err)
+	}
+	for _, row := range parseReplicaStatus(out) {
+		oldPort, _ := strconv.Atoi(statusValue(row, "Source_Port", "Master_Port"))
+		newPort, ok := ports[oldPort]
+		if !ok {
+			continue
+		}
+		forChannel := ""
+		if channel := row["Channel_Name"]; channel != "" {
+			forChannel = fmt.Sprintf(" FOR CHANNEL '%s'", channel)
+		}
+		// Changing the port resets the coordinates, which must be given again
+		// unless the channel uses auto-position (MariaDB: Using_Gtid)
+		change := fmt.Sprintf(changeSource, newPort)
+		usingGtid := row["Auto_Position"] == "1" || (row["Using_Gtid"] != "" && !strings.EqualFold(row["Using_Gtid"], "No"))
+		if !usingGtid {
+			change += fmt.Sprintf(syntax.coordinateFormat,
+				statusValue(row, "Relay_Source_Log_File", "Relay_Master_Log_File"),
+				statusValue(row, "Exec_Source_Log_Pos", "Exec_Master_Log_Pos"))
+		}
+		err = runStatements(serverPath, []string{
+			syntax.stopReplica + forChannel,
+			change + forChannel,
+			syntax.startReplica + forChannel,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CopySandbox duplicates a stopped copy of a sandbox, with new ports, server IDs, and server UUIDs.
+// Replication channels between the servers of the copy are pointed to the new ports.
+// The copy ends up running if the source was running. Returns the path of the copy.
+// A running source is restarted also when the copy fails
+func CopySandbox(sandboxHome, source, destination string) (copyPath string, err error) {
+	sourcePath := path.Join(sandboxHome, source)
+	destinationPath := RelocationTarget(sandboxHome, destination)
+	sbDesc, servers, err := checkRelocation(sourcePath, destinationPath)
+	if err != nil {
+		return "", err
+	}
+	if slices.Contains(notCopyableSandboxes, sbDesc.SBType) {
+		return "", fmt.Errorf("sandboxes of type '%s' cannot be copied", sbDesc.SBType)
+	}
+	descriptions := []common.SandboxDescription{sbDesc}
+	for _, server := range servers {
+		if server == "" {
+			continue
+		}
+		serverDesc, err := common.ReadSandboxDescription(path.Join(sourcePath, server))
+		if err != nil {
+			return "", err
+		}
+		descriptions = append(descriptions, serverDesc)
+	}
+	ports, err := allocatePorts(sandboxHome, descriptions)
+	if err != nil {
+		return "", err
+	}
+
+	wasRunning, err := stopSandboxServers(sourcePath, servers)
+	if err != nil {
+		return "", err
+	}
+	if wasRunning {
+		defer func() {
+			err = restartSandboxServers(sourcePath, err)
+		}()
+	}
+	common.CondPrintf("Copying %s to %s\n", common.ReplaceLiteralHome(sourcePath), common.ReplaceLiteralHome(destinationPath))
+	err = copyTree(sourcePath, destinationPath)
+	if err != nil {
+		_ = os.RemoveAll(destinationPath)
+		return "", fmt.Errorf("error copying %s: %s", sourcePath, err)
+	}
+	err = completeCopy(sourcePath, destinationPath, servers, ports, wasRunning)
+	return destinationPath, err
+}
+
+// restartSandboxServers starts again the servers of a sandbox that a relocation has stopped,
+// and returns the error of the relocation, if any, or else the one of the restart
+func restartSandboxServers(sandboxPath string, err error) error {
+	startErr := startSandboxServers(sandboxPath)
+	if err == nil {
+		return startErr
+	}
+	if startErr != nil {
+		return fmt.Errorf("%s - %s", err, startErr)
+	}
+	return err
+}
+
+// completeCopy gives a new identity to the servers of a copied sandbox
+func completeCopy(sourcePath, destinationPath string, servers []string, ports map[int]int, wasRunning bool) error {
+	r := relocation{oldPath: sourcePath, newPath: destinationPath, ports: ports, uuids: make(map[string]string)}
+	// A single sandbox is its own only server, and has only one description
+	var descriptionDirs []string
+	if len(servers) > 1 || servers[0] != "" {
+		descriptionDirs = append(descriptionDirs, "")
+	}
+	descriptionDirs = append(descriptionDirs, servers...)
+	for _, dir := range descriptionDirs {
+		dirPath := path.Join(destinationPath, dir)
+		sbDesc, err := common.ReadSandboxDescription(dirPath)
+		if err != nil {
+			return err
+		}
+		sbDesc.Port = mapPorts(sbDesc.Port, ports)
+		sbDesc.LogFile = ""
+		err = common.WriteSandboxDescription(dirPath, sbDesc)
+		if err != nil {
+			return errors.Wrapf(err, "unable to write sandbox description")
+		}
+		if !common.DirExists(path.Join(dirPath, globals.DataDirName)) || len(sbDesc.Port) == 0 {
+			continue
+		}
+		oldUuid, newUuid, err := renewServerUuid(dirPath, sbDesc, sbDesc.Port[0])
+		if err != nil {
+			return err
+		}
+		if oldUuid != "" {
+			r.uuids[oldUuid] = newUuid
+		}
+	}
+	err := r.rewriteTree(destinationPath, globals.SandboxDescriptionName)
+	if err != nil {
+		return err
+	}
+	for _, server := range servers {
+		serverPath := path.Join(destinationPath, server)
+		serverDesc, err := common.ReadSandboxDescription(serverPath)
+		if err != nil {
+			return err
+		}
+		// The new port is unique among the installed sandboxes, and so is the server ID based on it
+		err = changeServerId(serverPath, serverDesc.Port[0])
+		if err != nil {
+			return err
+		}
+	}
+	_, err = removeDefaultMarker(destinationPath)
+	if err != nil {
+		return err
+	}
+	err = relocateCatalog(r, false)
+	if err != nil {
+		return err
+	}
+	sbDesc, err := common.ReadSandboxDescription(destinationPath)
+	if err != nil {
+		return err
+	}
+	err = refreshTopologyScripts(destinationPath, sbDesc.SBType, "copy")
+	if err != nil {
+		return err
+	}
+
+	if len(servers) < 2 && !wasRunning {
+		return nil
+	}
+	err = startSandboxServers(destinationPath)
+	if err != nil {
+		return err
+	}
+	for _, server := range servers {
+		err = repointReplication(path.Join(destinationPath, server), sbDesc.Flavor, sbDesc.Version, ports)
+		if err != nil {
+			return err
+		}
+	}
+	if !wasRunning {
+		_, err = stopSandboxServers(destinationPath, servers)
+	}
+	return err
+}
+
+// MoveSandbox relocates or renames a sandbox, rewriting the paths in its scripts,
+// its description, and the catalog. The sandbox is restarted if it was running,
+// also when the move fails. Returns the new path of the sandbox
+func MoveSandbox(sandboxHome, source, destination string) (newPath string, err error) {
+	sourcePath := path.Join(sandboxHome, source)
+	destinationPath := RelocationTarget(sandboxHome, destination)
+	_, servers, err := checkRelocation(sourcePath, destinationPath)
+	if err != nil {
+		return "", err
+	}
+	if isLocked(sourcePath) {
+		return "", fmt.Errorf("sandbox %s is locked. Unlock it with 'dbdeployer admin unlock' before moving", source)
+	}
+	wasRunning, err := stopSandboxServers(sourcePath, servers)
+	if err != nil {
+		return "", err
+	}
+	// The sandbox to restart is the source until its files are in the destination
+	currentPath := sourcePath
+	if wasRunning {
+		defer func() {
+			err = restartSandboxServers(currentPath, err)
+		}()
+	}
+	common.CondPrintf("Moving %s to %s\n", common.ReplaceLiteralHome(sourcePath), common.ReplaceLiteralHome(destinationPath))
+	err = os.Rename(sourcePath, destinationPath)
+	if err != nil {
+		// Renaming fails across file systems
+		err = copyTree(sourcePath, destinationPath)
+		if err != nil {
+			_ = os.RemoveAll(destinationPath)
+			return "", fmt.Errorf("error moving %s: %s", sourcePath, err)
+		}
+		currentPath = destinationPath
+		err = os.RemoveAll(sourcePath)
+		if err != nil {
+			return "", err
+		}
+	}
+	currentPath = destinationPath
+	r := relocation{oldPath: sourcePath, newPath: destinationPath}
+	err = r.rewriteTree(destinationPath)
+	if err != nil {
+		return destinationPath, err
+	}
+	err = relocateCatalog(r, true)
+	if err != nil {
+		return destinationPath, err
+	}
+	snapshotsDir := SnapshotsDir(path.Dir(sourcePath), path.Base(sourcePath))
+	if common.DirExists(snapshotsDir) {
+		newSnapshotsDir := SnapshotsDir(path.Dir(destinationPath), path.Base(destinationPath))
+		err = os.MkdirAll(path.Dir(newSnapshotsDir), globals.PublicDirectoryAttr)
+		if err == nil {
+			err = os.Rename(snapshotsDir, newSnapshotsDir)
+		}
+		if err != nil {
+			return destinationPath, fmt.Errorf("error moving the snapshots of %s: %s", source, err)
+		}
+	}
+	wasDefault, err := removeDefaultMarker(destinationPath)
+	if err != nil {
+		return destinationPath, err
+	}
+	if wasDefault {
+		common.CondPrintf("%s was the default sandbox. Use 'dbdeployer admin set-default' to make it default again\n", source)
+	}
+	sbDesc, err := common.ReadSandboxDescription(destinationPath)
+	if err != nil {
+		return destinationPath, err
+	}
+	err = refreshTopologyScripts(destinationPath, sbDesc.SBType, "move")
+	return destinationPath, err
+}
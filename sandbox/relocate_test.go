@@ -0,0 +1,215 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2022 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sandbox
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/compare"
+	"github.com/datacharmer/dbdeployer/globals"
+)
+
+func TestRelocationRewriter(t *testing.T) {
+	r := relocation{
+		oldPath: "/home/test/sandboxes/rsandbox_8_0_3",
+		newPath: "/home/test/sandboxes/copy",
+		ports:   map[int]int{8036: 8040, 8037: 8041},
+		uuids:   map[string]string{"00008036-0000-0000-0000-000000008036": "00008040-0000-0000-0000-000000008040"},
+	}
+	rewrite := r.rewriter()
+	type rewriteCase struct {
+		text     string
+		expected string
+	}
+	for _, c := range []rewriteCase{
+		{`export SBDIR="/home/test/sandboxes/rsandbox_8_0_3"`, `export SBDIR="/home/test/sandboxes/copy"`},
+		{`$HOME/x /home/test/sandboxes/rsandbox_8_0_3/node1/use`, `$HOME/x /home/test/sandboxes/copy/node1/use`},
+		// A different sandbox with the same prefix is not changed
+		{`/home/test/sandboxes/rsandbox_8_0_36/use`, `/home/test/sandboxes/rsandbox_8_0_36/use`},
+		{`port=8036`, `port=8040`},
+		{`socket=/tmp/mysql_sandbox8037.sock`, `socket=/tmp/mysql_sandbox8041.sock`},
+		{`group_seeds="127.0.0.1:8036,127.0.0.1:8037"`, `group_seeds="127.0.0.1:8040,127.0.0.1:8041"`},
+		// Numbers that only contain a port are not changed
+		{`port=18036 port=80367`, `port=18036 port=80367`},
+		{`server-uuid=00008036-0000-0000-0000-000000008036`, `server-uuid=00008040-0000-0000-0000-000000008040`},
+	} {
+		compare.OkEqualString(c.text, rewrite(c.text), c.expected, t)
+	}
+
+	// When moving, only the paths change
+	r = relocation{oldPath: "/home/test/sandboxes/msb_8_0_36", newPath: "/opt/msb"}
+	rewrite = r.rewriter()
+	compare.OkEqualString("move", rewrite(`/home/test/sandboxes/msb_8_0_36/data port=8036`), `/opt/msb/data port=8036`, t)
+}
+
+func TestRelocationRewriteTree(t *testing.T) {
+	sandboxPath := path.Join(t.TempDir(), "msb_8_0_36")
+	dataDir := path.Join(sandboxPath, globals.DataDirName)
+	err := os.MkdirAll(dataDir, 0755)
+	compare.OkIsNil("data directory", err, t)
+	files := map[string]string{
+		path.Join(sandboxPath, "start"):              "#!/bin/bash\n" + sandboxPath + "/sb_include\nport=8036\n",
+		path.Join(sandboxPath, "binary"):             "port=8036\x00",
+		path.Join(dataDir, "relay.info"):             "port=8036\n",
+		path.Join(sandboxPath, "sbdescription.json"): "port=8036\n",
+	}
+	for fileName, text := range files {
+		err = os.WriteFile(fileName, []byte(text), 0644)
+		compare.OkIsNil(fileName, err, t)
+	}
+	r := relocation{oldPath: sandboxPath, newPath: "/opt/msb", ports: map[int]int{8036: 9036}}
+	err = r.rewriteTree(sandboxPath, globals.SandboxDescriptionName)
+	compare.OkIsNil("rewrite tree", err, t)
+
+	text, err := common.SlurpAsString(path.Join(sandboxPath, "start"))
+	compare.OkIsNil("reading script", err, t)
+	compare.OkEqualString("script", text, "#!/bin/bash\n/opt/msb/sb_include\nport=9036\n", t)
+	info, err := os.Stat(path.Join(sandboxPath, "start"))
+	compare.OkIsNil("script stat", err, t)
+	compare.OkEqualInt("script mode", int(info.Mode().Perm()), 0644, t)
+	// Binary files, data directories, and skipped files are left alone
+	for _, fileName := range []string{"binary", "data/relay.info", "sbdescription.json"} {
+		fullName := path.Join(sandboxPath, fileName)
+		contents, err := os.ReadFile(fullName)
+		compare.OkIsNil(fileName, err, t)
+		compare.OkEqualString(fileName, string(contents), files[fullName], t)
+	}
+}
+
+func TestCopyTree(t *testing.T) {
+	source := path.Join(t.TempDir(), "msb_8_0_36")
+	destination := path.Join(t.TempDir(), "copy")
+	dataDir := path.Join(source, globals.DataDirName)
+	err := os.MkdirAll(path.Join(dataDir, "test"), 0755)
+	compare.OkIsNil("data directory", err, t)
+	err = os.WriteFile(path.Join(source, "start"), []byte("start"), 0744)
+	compare.OkIsNil("script", err, t)
+	err = os.WriteFile(path.Join(dataDir, "test", "t1.ibd"), []byte("data"), 0640)
+	compare.OkIsNil("data file", err, t)
+	err = os.WriteFile(path.Join(dataDir, "mysql_sandbox8036.pid"), []byte("1234"), 0640)
+	compare.OkIsNil("pid file", err, t)
+	err = os.Symlink("start", path.Join(source, "begin"))
+	compare.OkIsNil("symlink", err, t)
+
+	err = copyTree(source, destination)
+	compare.OkIsNil("copy tree", err, t)
+	compare.OkEqualBool("script copied", common.ExecExists(path.Join(destination, "start")), true, t)
+	compare.OkEqualBool("data copied", common.FileExists(path.Join(destination, globals.DataDirName, "test", "t1.ibd")), true, t)
+	compare.OkEqualBool("pid file skipped", common.FileExists(path.Join(destination, globals.DataDirName, "mysql_sandbox8036.pid")), false, t)
+	link, err := os.Readlink(path.Join(destination, "begin"))
+	compare.OkIsNil("symlink copied", err, t)
+	compare.OkEqualString("symlink target", link, "start", t)
+}
+
+func TestChangeServerId(t *testing.T) {
+	serverPath := t.TempDir()
+	err := os.WriteFile(path.Join(serverPath, globals.ScriptMySandboxCnf), []byte("[mysqld]\nport=8040\nserver-id=100\n"), 0644)
+	compare.OkIsNil("configuration file", err, t)
+	err = os.WriteFile(path.Join(serverPath, globals.ScriptSbInclude), []byte("export SERVER_ID=server-id=100\n"), 0644)
+	compare.OkIsNil("include file", err, t)
+
+	err = changeServerId(serverPath, 8040)
+	compare.OkIsNil("change server ID", err, t)
+	text, err := common.SlurpAsString(path.Join(serverPath, globals.ScriptMySandboxCnf))
+	compare.OkIsNil("reading configuration", err, t)
+	compare.OkEqualString("configuration", text, "[mysqld]\nport=8040\nserver-id=8040\n", t)
+	text, err = common.SlurpAsString(path.Join(serverPath, globals.ScriptSbInclude))
+	compare.OkIsNil("reading include file", err, t)
+	compare.OkEqualString("include file", text, "export SERVER_ID=server-id=8040\n", t)
+}
+
+func TestParseReplicaStatus(t *testing.T) {
+	status := `*************************** 1. row ***************************
+             Replica_IO_State: Waiting for source to send event
+                  Source_Host: 127.0.0.1
+                  Source_Port: 8036
+        Relay_Source_Log_File: mysql-bin.000001
+          Exec_Source_Log_Pos: 157
+                Auto_Position: 0
+                 Channel_Name: node1
+*************************** 2. row ***************************
+                  Source_Port: 8037
+                Auto_Position: 1
+                 Channel_Name:
+`
+	rows := parseReplicaStatus(status)
+	compare.OkEqualInt("rows", len(rows), 2, t)
+	compare.OkEqualString("port", statusValue(rows[0], "Source_Port", "Master_Port"), "8036", t)
+	compare.OkEqualString("log file", statusValue(rows[0], "Relay_Source_Log_File", "Relay_Master_Log_File"), "mysql-bin.000001", t)
+	compare.OkEqualString("state with colons", rows[0]["Replica_IO_State"], "Waiting for source to send event", t)
+	compare.OkEqualString("channel", rows[0]["Channel_Name"], "node1", t)
+	compare.OkEqualString("empty channel", rows[1]["Channel_Name"], "", t)
+	compare.OkEqualString("auto position", rows[1]["Auto_Position"], "1", t)
+	compare.OkEqualInt("no status", len(parseReplicaStatus("")), 0, t)
+}
+
+func TestRelocationTarget(t *testing.T) {
+	compare.OkEqualString("name", RelocationTarget("/home/test/sandboxes", "copy"), "/home/test/sandboxes/copy", t)
+	compare.OkEqualString("path", RelocationTarget("/home/test/sandboxes", "/opt/sb/copy/"), "/opt/sb/copy", t)
+}
+
+func TestRestartSandboxServers(t *testing.T) {
+	sandboxPath := t.TempDir()
+	marker := path.Join(sandboxPath, "started")
+	err := common.WriteSandboxDescription(sandboxPath, common.SandboxDescription{SBType: globals.SbTypeSingle, Version: "8.0.36"})
+	compare.OkIsNil("sandbox description", err, t)
+	err = os.WriteFile(path.Join(sandboxPath, globals.ScriptStart), []byte("#!/bin/sh\ntouch "+marker+"\n"), 0755) // #nosec G306
+	compare.OkIsNil("writing start script", err, t)
+
+	err = restartSandboxServers(sandboxPath, fmt.Errorf("copy failed"))
+	compare.OkIsNotNil("relocation error", err, t)
+	if err != nil {
+		compare.OkEqualString("relocation error kept", err.Error(), "copy failed", t)
+	}
+	compare.OkEqualBool("restarted after a failure", common.FileExists(marker), true, t)
+
+	err = os.Remove(marker)
+	compare.OkIsNil("removing marker", err, t)
+	err = restartSandboxServers(sandboxPath, nil)
+	compare.OkIsNil("restart", err, t)
+	compare.OkEqualBool("restarted", common.FileExists(marker), true, t)
+
+	// A failed restart is reported together with the relocation error
+	err = os.Remove(path.Join(sandboxPath, globals.ScriptStart))
+	compare.OkIsNil("removing start script", err, t)
+	err = restartSandboxServers(sandboxPath, nil)
+	compare.OkIsNotNil("restart without script", err, t)
+	err = restartSandboxServers(sandboxPath, fmt.Errorf("copy failed"))
+	compare.OkIsNotNil("both errors", err, t)
+	if err != nil {
+		compare.OkMatchesString("both errors", err.Error(), "^copy failed - ", t)
+	}
+}
+
+func TestCopyGroupSandbox(t *testing.T) {
+	sandboxHome := t.TempDir()
+	for _, sbType := range []string{globals.SbTypeGroupSinglePrimary, globals.SbTypeGroupMultiPrimary, globals.InnoDBClusterLabel} {
+		sandboxName := "group_" + sbType
+		makeSnapshotSandbox(t, sandboxHome, sandboxName, []string{"node1", "node2"})
+		err := common.WriteSandboxDescription(path.Join(sandboxHome, sandboxName), common.SandboxDescription{SBType: sbType, Version: "8.0.36"})
+		compare.OkIsNil("sandbox description", err, t)
+		_, err = CopySandbox(sandboxHome, sandboxName, sandboxName+"_copy")
+		compare.OkIsNotNil("copy of "+sbType, err, t)
+		if err != nil {
+			compare.OkMatchesString("copy of "+sbType, err.Error(), "cannot be copied", t)
+		}
+		compare.OkEqualBool("no copy of "+sbType, common.DirExists(path.Join(sandboxHome, sandboxName+"_copy")), false, t)
+	}
+}
@@ -129,6 +129,15 @@ var (
 	//go:embed templates/replication/repl_sysbench.gotxt
 	sysbenchReplTemplate string
 
+	//go:embed templates/replication/check_slaves_tree.gotxt
+	checkSlavesTreeTemplate string
+
+	//go:embed templates/replication/use_all_tree.gotxt
+	useAllTreeTemplate string
+
+	//go:embed templates/replication/test_replication_tree.gotxt
+	testReplicationTreeTemplate string
+
 	//go:embed templates/replication/repl_sysbench_ready.gotxt
 	sysbenchReadyReplTemplate string
 
@@ -308,5 +317,20 @@ var (
 			Notes:       "",
 			Contents:    sysbenchReadyReplTemplate,
 		},
+		globals.TmplCheckSlavesTree: TemplateDesc{
+			Description: "Checks replication status in every node, showing its source and level",
+			Notes:       "chain and tree topologies",
+			Contents:    checkSlavesTreeTemplate,
+		},
+		globals.TmplUseAllTree: TemplateDesc{
+			Description: "Execute a query for all nodes, or for the nodes of a given level",
+			Notes:       "chain and tree topologies",
+			Contents:    useAllTreeTemplate,
+		},
+		globals.TmplTestReplicationTree: TemplateDesc{
+			Description: "Tests replication flow across the hierarchy",
+			Notes:       "chain and tree topologies",
+			Contents:    testReplicationTreeTemplate,
+		},
 	}
 )
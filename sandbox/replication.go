@@ -19,6 +19,7 @@ import (
 	"fmt"
 	"os"
 	"path"
+	"strconv"
 	"strings"
 	"time"
 
@@ -45,6 +46,7 @@ type ReplicationData struct {
 	NdbNodes   int
 	MasterList string
 	SlaveList  string
+	FanOut     string
 }
 
 func setChangeMasterProperties(currentProperties string, moreProperties []string, logger *defaults.Logger) string {
@@ -67,7 +69,7 @@ func checkReadOnlyFlags(sandboxDef SandboxDef) (string, error) {
 			return "", err
 		}
 		if !readOnlyAllowed {
-			return "", fmt.Errorf(globals.ErrOptionRequiresVersion,
+			return "", unsupportedError(globals.ErrOptionRequiresVersion,
 				globals.SuperReadOnlyLabel, common.IntSliceToDottedString(globals.MinimumSuperReadOnly))
 		}
 		readOnlyOption = "super_read_only=on"
@@ -79,7 +81,7 @@ func checkReadOnlyFlags(sandboxDef SandboxDef) (string, error) {
 				return "", err
 			}
 			if !readOnlyAllowed {
-				return "", fmt.Errorf(globals.ErrOptionRequiresVersion,
+				return "", unsupportedError(globals.ErrOptionRequiresVersion,
 					globals.ReadOnlyLabel, common.IntSliceToDottedString(globals.MinimumDynVariablesVersion))
 			}
 			readOnlyOption = "read_only=on"
@@ -104,6 +106,13 @@ func computeBaseport(proposed int) int {
 }
 
 func CreateMasterSlaveReplication(sandboxDef SandboxDef, origin string, nodes int, masterIp string) error {
+	return createMasterSlaveReplication(sandboxDef, origin, nodes, masterIp, globals.MasterSlaveLabel, nil)
+}
+
+// createMasterSlaveReplication deploys a master and nodes-1 slaves.
+// sources[i-1] is the node number (1 = master, N = slave N-1) that slave i replicates from.
+// When sources is nil, all slaves replicate from the master.
+func createMasterSlaveReplication(sandboxDef SandboxDef, origin string, nodes int, masterIp, topology string, sources []int) error {
 
 	var execLists []concurrent.ExecutionList
 
@@ -113,7 +122,7 @@ func CreateMasterSlaveReplication(sandboxDef SandboxDef, origin string, nodes in
 	} else {
 		var fileName string
 		var err error
-		logger, fileName, err = defaults.NewLogger(common.LogDirName(), "master-slave-replication")
+		logger, fileName, err = defaults.NewLogger(common.LogDirName(), topology+"-replication")
 		if err != nil {
 			return err
 		}
@@ -127,6 +136,7 @@ func CreateMasterSlaveReplication(sandboxDef SandboxDef, origin string, nodes in
 	}
 	rev := vList[2]
 	shortVersion := fmt.Sprintf("%d.%d", vList[0], vList[1])
+	legacySyntax := usesChangeMasterSyntax(sandboxDef.Flavor, shortVersion)
 	basePort := computeBaseport(sandboxDef.Port + defaults.Defaults().MasterSlaveBasePort + (rev * 100))
 	if sandboxDef.BasePort > 0 {
 		basePort = sandboxDef.BasePort
@@ -158,6 +168,25 @@ func CreateMasterSlaveReplication(sandboxDef SandboxDef, origin string, nodes in
 	if nodes < 2 {
 		return fmt.Errorf("can't run replication with less than 2 nodes")
 	}
+	if sources == nil {
+		for i := 1; i < nodes; i++ {
+			sources = append(sources, 1)
+		}
+	}
+	if len(sources) != nodes-1 {
+		return fmt.Errorf("replication sources defined for %d nodes. Expected: %d", len(sources)+1, nodes)
+	}
+	levels := []int{0}
+	isRelay := make(map[int]bool)
+	for i, source := range sources {
+		if source < 1 || source > i+1 {
+			return fmt.Errorf("invalid source %d for node %d", source, i+2)
+		}
+		levels = append(levels, levels[source-1]+1)
+		if source > 1 {
+			isRelay[source] = true
+		}
+	}
 
 	readOnlyOptions, err := checkReadOnlyFlags(sandboxDef)
 	if err != nil {
@@ -175,14 +204,23 @@ func CreateMasterSlaveReplication(sandboxDef SandboxDef, origin string, nodes in
 	//sandboxDef.ServerId = (baseServerId + 1) * 100
 	sandboxDef.ServerId = setServerId(sandboxDef, 1)
 	sandboxDef.LoadGrants = false
-	masterPort := sandboxDef.Port
 	changeMasterExtra := ""
 	masterAutoPosition := ""
+	// MariaDB GTID: every node writes its own transactions in a separate domain,
+	// so that writes to a slave don't break the strict ordering of the master's domain
+	isMariaDbGtid := sandboxDef.GtidOptions != "" && common.BaseFlavor(sandboxDef.Flavor) == common.MariaDbFlavor
+	gtidOptions := sandboxDef.GtidOptions
+	if isMariaDbGtid {
+		sandboxDef.GtidOptions = mariaDbGtidDomainOptions(gtidOptions, 1)
+	}
 	if sandboxDef.GtidOptions != "" {
 		var autoPosOpt string = "SOURCE_AUTO_POSITION=1"
-		if strings.HasPrefix(shortVersion, "5") || strings.HasPrefix(shortVersion, "8.0") {
+		if legacySyntax {
 			autoPosOpt = "MASTER_AUTO_POSITION=1"
 		}
+		if isMariaDbGtid {
+			autoPosOpt = "MASTER_USE_GTID=slave_pos"
+		}
 		masterAutoPosition += ", " + autoPosOpt
 		logger.Printf("Adding %s to slaves setup\n", autoPosOpt)
 	}
@@ -201,6 +239,10 @@ func CreateMasterSlaveReplication(sandboxDef SandboxDef, origin string, nodes in
 			sandboxDef.ChangeMasterOptions = append(sandboxDef.ChangeMasterOptions, publicKeyOpt)
 		}
 	}
+	if sandboxDef.EnableTls {
+		tlsOpt := tlsChangeMasterOption(sandboxDef.Flavor, sandboxDef.Version)
+		sandboxDef.ChangeMasterOptions = append(sandboxDef.ChangeMasterOptions, tlsOpt)
+	}
 	slaves := nodes - 1
 	masterAbbr := defaults.Defaults().MasterAbbr
 	masterLabel := defaults.Defaults().MasterName
@@ -218,6 +260,7 @@ func CreateMasterSlaveReplication(sandboxDef SandboxDef, origin string, nodes in
 		"MasterLabel":        masterLabel,
 		"MasterPort":         sandboxDef.Port,
 		"SlaveLabel":         slaveLabel,
+		"NodeLabel":          defaults.Defaults().NodePrefix,
 		"MasterAbbr":         masterAbbr,
 		"MasterIp":           masterIp,
 		"RplUser":            sandboxDef.RplUser,
@@ -252,13 +295,17 @@ func CreateMasterSlaveReplication(sandboxDef SandboxDef, origin string, nodes in
 
 	sbDesc := common.SandboxDescription{
 		Basedir: sandboxDef.Basedir,
-		SBType:  globals.MasterSlaveLabel,
+		SBType:  topology,
 		Version: sandboxDef.Version,
 		Flavor:  sandboxDef.Flavor,
 		Port:    []int{sandboxDef.Port},
 		Nodes:   slaves,
 		NodeNum: 0,
 		LogFile: sandboxDef.LogFileName,
+		Owner:   sandboxDef.Owner,
+		Tags:    sandboxDef.Tags,
+		Note:    sandboxDef.Note,
+		Expires: sandboxDef.Expires,
 	}
 
 	sbItem := defaults.SandboxItem{
@@ -269,6 +316,10 @@ func CreateMasterSlaveReplication(sandboxDef SandboxDef, origin string, nodes in
 		Port:        []int{sandboxDef.Port},
 		Nodes:       []string{defaults.Defaults().MasterName},
 		Destination: sandboxDef.SandboxDir,
+		Owner:       sandboxDef.Owner,
+		Tags:        sandboxDef.Tags,
+		Note:        sandboxDef.Note,
+		Expires:     sandboxDef.Expires,
 	}
 
 	if sandboxDef.LogFileName != "" {
@@ -299,26 +350,29 @@ func CreateMasterSlaveReplication(sandboxDef SandboxDef, origin string, nodes in
 
 	sandboxDef.ReadOnlyOptions = readOnlyOptions
 	nodeLabel := defaults.Defaults().NodePrefix
+	// Intermediate nodes must write to their binary log the events received from their source
+	logReplicaUpdates := "log-replica-updates=ON"
+	if legacySyntax {
+		logReplicaUpdates = "log-slave-updates=ON"
+	}
+	myCnfOptions := sandboxDef.MyCnfOptions
 	for i := 1; i <= slaves; i++ {
 		sandboxDef.Port = basePort + i + 1
-		data["Slaves"] = append(data["Slaves"].([]common.StringMap), common.StringMap{
-			"ShellPath":          sandboxDef.ShellPath,
-			"Copyright":          globals.ShellScriptCopyright,
-			"AppVersion":         common.VersionDef,
-			"DateTime":           timestamp.Format(time.UnixDate),
-			"Node":               i,
-			"NodeLabel":          nodeLabel,
-			"NodePort":           sandboxDef.Port,
-			"SlaveLabel":         slaveLabel,
-			"MasterAbbr":         masterAbbr,
-			"SlaveAbbr":          slaveAbbr,
-			"SandboxDir":         sandboxDef.SandboxDir,
-			"MasterPort":         masterPort,
-			"MasterIp":           masterIp,
-			"ChangeMasterExtra":  changeMasterExtra,
-			"MasterAutoPosition": masterAutoPosition,
-			"RplUser":            sandboxDef.RplUser,
-			"RplPassword":        sandboxDef.RplPassword})
+		source := sources[i-1]
+		sourcePort := basePort + source
+		sourceLabel := masterLabel
+		sourceDir := defaults.Defaults().MasterName
+		if source > 1 {
+			sourceLabel = fmt.Sprintf("%s%d", slaveLabel, source-1)
+			sourceDir = fmt.Sprintf("%s%d", nodeLabel, source-1)
+		}
+		sandboxDef.MyCnfOptions = myCnfOptions
+		if isRelay[i+1] {
+			sandboxDef.MyCnfOptions = append(append([]string{}, myCnfOptions...), logReplicaUpdates)
+			logger.Printf("Adding %s to relay %s%d\n", logReplicaUpdates, slaveLabel, i)
+		}
+		slave := slaveData(data, i, sandboxDef.Port, sourcePort, levels[i], sourceLabel, sourceDir, isRelay[i+1])
+		data["Slaves"] = append(data["Slaves"].([]common.StringMap), slave)
 		sandboxDef.LoadGrants = false
 		sandboxDef.Prompt = fmt.Sprintf("%s%d", slaveLabel, i)
 		sandboxDef.DirName = fmt.Sprintf("%s%d", nodeLabel, i)
@@ -359,48 +413,21 @@ func CreateMasterSlaveReplication(sandboxDef SandboxDef, origin string, nodes in
 		if sandboxDef.SemiSyncOptions != "" {
 			sandboxDef.SemiSyncOptions = SingleTemplates[globals.TmplSemisyncSlaveOptions].Contents
 		}
+		if isMariaDbGtid {
+			sandboxDef.GtidOptions = mariaDbGtidDomainOptions(gtidOptions, i+1)
+		}
 		logger.Printf("Creating single sandbox for slave %d\n", i)
 		execListNode, err := CreateChildSandbox(sandboxDef)
 		if err != nil {
 			return fmt.Errorf(globals.ErrCreatingSandbox, err)
 		}
 		execLists = append(execLists, execListNode...)
-		var dataSlave = common.StringMap{
-			"ShellPath":          sandboxDef.ShellPath,
-			"Copyright":          globals.ShellScriptCopyright,
-			"AppVersion":         common.VersionDef,
-			"DateTime":           timestamp.Format(time.UnixDate),
-			"Node":               i,
-			"NodeLabel":          nodeLabel,
-			"NodePort":           sandboxDef.Port,
-			"SlaveLabel":         slaveLabel,
-			"MasterAbbr":         masterAbbr,
-			"ChangeMasterExtra":  changeMasterExtra,
-			"MasterAutoPosition": masterAutoPosition,
-			"SlaveAbbr":          slaveAbbr,
-			"SandboxDir":         sandboxDef.SandboxDir,
-		}
-		logger.Printf("Defining replication node data: %v\n", stringMapToJson(dataSlave))
+		logger.Printf("Defining replication node data: %v\n", stringMapToJson(slave))
 		logger.Printf("Create slave script %d\n", i)
-		err = writeScripts(ScriptBatch{ReplicationTemplates, logger, sandboxDef.SandboxDir, dataSlave,
-			[]ScriptDef{
-				{fmt.Sprintf("%s%d", slaveAbbr, i), globals.TmplSlave, true},
-				{fmt.Sprintf("n%d", i+1), globals.TmplSlave, true},
-			}})
+		err = writeSlaveScripts(logger, sandboxDef.SandboxDir, slave, i, sandboxDef.EnableAdminAddress)
 		if err != nil {
 			return err
 		}
-		if sandboxDef.EnableAdminAddress {
-			logger.Printf("Create slave admin script %d\n", i)
-			err = writeScripts(ScriptBatch{ReplicationTemplates, logger, sandboxDef.SandboxDir, dataSlave,
-				[]ScriptDef{
-					{fmt.Sprintf("%sa%d", slaveAbbr, i), globals.TmplSlaveAdmin, true},
-					{fmt.Sprintf("na%d", i+1), globals.TmplSlaveAdmin, true},
-				}})
-			if err != nil {
-				return err
-			}
-		}
 	}
 	err = common.WriteSandboxDescription(sandboxDef.SandboxDir, sbDesc)
 	if err != nil {
@@ -412,6 +439,127 @@ func CreateMasterSlaveReplication(sandboxDef SandboxDef, origin string, nodes in
 		return errors.Wrapf(err, "unable to update catalog")
 	}
 
+	initializeSlaves := "initialize_" + english.PluralWord(2, slaveLabel, "")
+	sb := replicationScripts(logger, sandboxDef.SandboxDir, data, topology, legacySyntax,
+		sandboxDef.SemiSyncOptions != "", sandboxDef.EnableAdminAddress)
+	logger.Printf("Create replication scripts\n")
+	err = writeScripts(sb)
+	if err != nil {
+		return err
+	}
+	logger.Printf("Run concurrent sandbox scripts \n")
+	concurrent.RunParallelTasksByPriority(execLists)
+	if !sandboxDef.SkipStart {
+		common.CondPrintln(path.Join(common.ReplaceLiteralHome(sandboxDef.SandboxDir), initializeSlaves))
+		logger.Printf("Run replication initialization script \n")
+		out, err := sandboxDef.runCmd(path.Join(sandboxDef.SandboxDir, initializeSlaves), nil, false)
+		if err != nil {
+			fmt.Printf("error initializing cluster: %s\n:%s", out, err)
+			return err
+		}
+	}
+	common.CondPrintf("Replication directory installed in %s\n", common.ReplaceLiteralHome(sandboxDef.SandboxDir))
+	common.CondPrintf("run 'dbdeployer usage multiple' for basic instructions'\n")
+	return nil
+}
+
+// usesChangeMasterSyntax tells whether replication uses CHANGE MASTER TO and START SLAVE
+// rather than CHANGE REPLICATION SOURCE TO and START REPLICA. MariaDB only supports the former.
+// The version can be either short ("8.0") or full ("8.0.36")
+func usesChangeMasterSyntax(flavor, version string) bool {
+	if common.BaseFlavor(flavor) == common.MariaDbFlavor {
+		return true
+	}
+	major, minor := majorMinor(version)
+	return major < 8 || (major == 8 && minor == 0)
+}
+
+// usesBinaryLogStatusSyntax tells whether the server has SHOW BINARY LOG STATUS and
+// RESET BINARY LOGS AND GTIDS, which replaced SHOW MASTER STATUS and RESET MASTER in 8.2.
+// The version can be either short ("8.2") or full ("8.2.0")
+func usesBinaryLogStatusSyntax(flavor, version string) bool {
+	if common.BaseFlavor(flavor) == common.MariaDbFlavor {
+		return false
+	}
+	major, minor := majorMinor(version)
+	return major > 8 || (major == 8 && minor >= 2)
+}
+
+// majorMinor returns the first two components of a short or full version
+func majorMinor(version string) (int, int) {
+	parts := strings.Split(version, ".")
+	major, _ := strconv.Atoi(parts[0])
+	minor := 0
+	if len(parts) > 1 {
+		minor, _ = strconv.Atoi(parts[1])
+	}
+	return major, minor
+}
+
+// isMariaDbGtid tells whether the options of a MariaDB server enable the GTID setup of dbdeployer
+func isMariaDbGtid(flavor string, options []string) bool {
+	return common.BaseFlavor(flavor) == common.MariaDbFlavor && strings.EqualFold(optionValue(options, "gtid-strict-mode"), "ON")
+}
+
+// mariaDbGtidDomainOptions adds the GTID domain of a MariaDB node to the GTID options
+func mariaDbGtidDomainOptions(gtidOptions string, domain int) string {
+	return fmt.Sprintf("%s\ngtid_domain_id=%d\n", gtidOptions, domain)
+}
+
+// slaveData returns the template data for one slave. The values that are
+// common to all nodes are taken from the replication data.
+func slaveData(data common.StringMap, node, nodePort, sourcePort, level int, sourceLabel, sourceDir string, isRelay bool) common.StringMap {
+	return common.StringMap{
+		"ShellPath":          data["ShellPath"],
+		"Copyright":          globals.ShellScriptCopyright,
+		"AppVersion":         common.VersionDef,
+		"DateTime":           data["DateTime"],
+		"Node":               node,
+		"NodeLabel":          data["NodeLabel"],
+		"NodePort":           nodePort,
+		"SlaveLabel":         data["SlaveLabel"],
+		"MasterAbbr":         data["MasterAbbr"],
+		"SlaveAbbr":          data["SlaveAbbr"],
+		"SandboxDir":         data["SandboxDir"],
+		"MasterPort":         sourcePort,
+		"SourceLabel":        sourceLabel,
+		"SourceDir":          sourceDir,
+		"Level":              level,
+		"IsRelay":            isRelay,
+		"MasterIp":           data["MasterIp"],
+		"ChangeMasterExtra":  data["ChangeMasterExtra"],
+		"MasterAutoPosition": data["MasterAutoPosition"],
+		"RplUser":            data["RplUser"],
+		"RplPassword":        data["RplPassword"]}
+}
+
+// writeSlaveScripts writes the shortcut scripts for a slave (s1, n2, and their admin counterparts)
+func writeSlaveScripts(logger *defaults.Logger, sandboxDir string, dataSlave common.StringMap, node int, adminAddress bool) error {
+	slaveAbbr := defaults.Defaults().SlaveAbbr
+	err := writeScripts(ScriptBatch{ReplicationTemplates, logger, sandboxDir, dataSlave,
+		[]ScriptDef{
+			{fmt.Sprintf("%s%d", slaveAbbr, node), globals.TmplSlave, true},
+			{fmt.Sprintf("n%d", node+1), globals.TmplSlave, true},
+		}})
+	if err != nil {
+		return err
+	}
+	if adminAddress {
+		logger.Printf("Create slave admin script %d\n", node)
+		err = writeScripts(ScriptBatch{ReplicationTemplates, logger, sandboxDir, dataSlave,
+			[]ScriptDef{
+				{fmt.Sprintf("%sa%d", slaveAbbr, node), globals.TmplSlaveAdmin, true},
+				{fmt.Sprintf("na%d", node+1), globals.TmplSlaveAdmin, true},
+			}})
+	}
+	return err
+}
+
+// replicationScripts returns the scripts that operate on all the nodes of a master/slave deployment
+func replicationScripts(logger *defaults.Logger, sandboxDir string, data common.StringMap, topology string, legacySyntax, semiSync, adminAddress bool) ScriptBatch {
+	slaveLabel := defaults.Defaults().SlavePrefix
+	masterLabel := defaults.Defaults().MasterName
+	masterAbbr := defaults.Defaults().MasterAbbr
 	slavePlural := english.PluralWord(2, slaveLabel, "")
 	masterPlural := english.PluralWord(2, masterLabel, "")
 	initializeSlaves := "initialize_" + slavePlural
@@ -424,7 +572,7 @@ func CreateMasterSlaveReplication(sandboxDef SandboxDef, origin string, nodes in
 	sb := ScriptBatch{
 		tc:         ReplicationTemplates,
 		logger:     logger,
-		sandboxDir: sandboxDef.SandboxDir,
+		sandboxDir: sandboxDir,
 		data:       data,
 		scripts: []ScriptDef{
 			{globals.ScriptStartAll, globals.TmplStartAll, true},
@@ -452,37 +600,31 @@ func CreateMasterSlaveReplication(sandboxDef SandboxDef, origin string, nodes in
 		},
 	}
 	tmpl := globals.TmplInitSlaves84
-	if strings.HasPrefix(shortVersion, "5") || strings.HasPrefix(shortVersion, "8.0") {
+	if legacySyntax {
 		tmpl = globals.TmplInitSlaves
 	}
 	sb.scripts = append(sb.scripts, ScriptDef{initializeSlaves, tmpl, true})
-	if sandboxDef.SemiSyncOptions != "" {
+	if semiSync {
 		sb.scripts = append(sb.scripts, ScriptDef{"post_initialization", globals.TmplSemiSyncStart, true})
 	}
-	if sandboxDef.EnableAdminAddress {
+	if adminAddress {
 		sb.scripts = append(sb.scripts, ScriptDef{masterAbbr + "a", globals.TmplMasterAdmin, true})
 		sb.scripts = append(sb.scripts, ScriptDef{"na1", globals.TmplMasterAdmin, true})
 		sb.scripts = append(sb.scripts, ScriptDef{globals.ScriptUseAllAdmin, globals.TmplUseAllAdmin, true})
 	}
-	logger.Printf("Create replication scripts\n")
-	err = writeScripts(sb)
-	if err != nil {
-		return err
-	}
-	logger.Printf("Run concurrent sandbox scripts \n")
-	concurrent.RunParallelTasksByPriority(execLists)
-	if !sandboxDef.SkipStart {
-		common.CondPrintln(path.Join(common.ReplaceLiteralHome(sandboxDef.SandboxDir), initializeSlaves))
-		logger.Printf("Run replication initialization script \n")
-		out, err := common.RunCmd(path.Join(sandboxDef.SandboxDir, initializeSlaves))
-		if err != nil {
-			fmt.Printf("error initializing cluster: %s\n:%s", out, err)
-			return err
+	if topology != globals.MasterSlaveLabel {
+		for i, script := range sb.scripts {
+			switch script.templateName {
+			case globals.TmplCheckSlaves:
+				sb.scripts[i].templateName = globals.TmplCheckSlavesTree
+			case globals.TmplUseAll:
+				sb.scripts[i].templateName = globals.TmplUseAllTree
+			case globals.TmplTestReplication:
+				sb.scripts[i].templateName = globals.TmplTestReplicationTree
+			}
 		}
 	}
-	common.CondPrintf("Replication directory installed in %s\n", common.ReplaceLiteralHome(sandboxDef.SandboxDir))
-	common.CondPrintf("run 'dbdeployer usage multiple' for basic instructions'\n")
-	return nil
+	return sb
 }
 
 // func CreateReplicationSandbox(sdef SandboxDef, origin string, topology string, nodes int, masterIp, masterList, slaveList string) error {
@@ -497,9 +639,17 @@ func CreateReplicationSandbox(sdef SandboxDef, origin string, replData Replicati
 	}
 
 	sandboxDir := sdef.SandboxDir
+	if isPostgreSQL(sdef) && replData.Topology != globals.MasterSlaveLabel {
+		return unsupportedError("topology '%s' is not supported for flavor '%s'. Use '%s'",
+			replData.Topology, sdef.Flavor, globals.MasterSlaveLabel)
+	}
 	switch replData.Topology {
 	case globals.MasterSlaveLabel:
 		sdef.SandboxDir = path.Join(sdef.SandboxDir, defaults.Defaults().MasterSlavePrefix+common.VersionToName(origin))
+	case globals.ChainLabel:
+		sdef.SandboxDir = path.Join(sdef.SandboxDir, defaults.Defaults().ChainPrefix+common.VersionToName(origin))
+	case globals.TreeLabel:
+		sdef.SandboxDir = path.Join(sdef.SandboxDir, defaults.Defaults().TreePrefix+common.VersionToName(origin))
 	case globals.GroupLabel:
 		if sdef.SinglePrimary {
 			sdef.SandboxDir = path.Join(sdef.SandboxDir, defaults.Defaults().GroupSpPrefix+common.VersionToName(origin))
@@ -513,7 +663,7 @@ func CreateReplicationSandbox(sdef SandboxDef, origin string, replData Replicati
 			return err
 		}
 		if !isMinimumGroupRepl {
-			return fmt.Errorf(globals.ErrFeatureRequiresVersion, "group replication", common.IntSliceToDottedString(globals.MinimumGroupReplVersion))
+			return unsupportedError(globals.ErrFeatureRequiresVersion, "group replication", common.IntSliceToDottedString(globals.MinimumGroupReplVersion))
 		}
 	case globals.FanInLabel:
 		// 5.7.9
@@ -523,7 +673,7 @@ func CreateReplicationSandbox(sdef SandboxDef, origin string, replData Replicati
 			return err
 		}
 		if !isMinimumMultiSource {
-			return fmt.Errorf(globals.ErrFeatureRequiresVersion, "multi-source replication", common.IntSliceToDottedString(globals.MinimumMultiSourceReplVersion))
+			return unsupportedError(globals.ErrFeatureRequiresVersion, "multi-source replication", common.IntSliceToDottedString(globals.MinimumMultiSourceReplVersion))
 		}
 		sdef.SandboxDir = path.Join(sdef.SandboxDir, defaults.Defaults().FanInPrefix+common.VersionToName(origin))
 	case globals.AllMastersLabel:
@@ -535,7 +685,7 @@ func CreateReplicationSandbox(sdef SandboxDef, origin string, replData Replicati
 			return err
 		}
 		if !isMinimumMultiSource {
-			return fmt.Errorf(globals.ErrFeatureRequiresVersion, "multi-source replication", common.IntSliceToDottedString(globals.MinimumMultiSourceReplVersion))
+			return unsupportedError(globals.ErrFeatureRequiresVersion, "multi-source replication", common.IntSliceToDottedString(globals.MinimumMultiSourceReplVersion))
 		}
 		sdef.SandboxDir = path.Join(sdef.SandboxDir, defaults.Defaults().AllMastersPrefix+common.VersionToName(origin))
 	case globals.PxcLabel:
@@ -544,16 +694,26 @@ func CreateReplicationSandbox(sdef SandboxDef, origin string, replData Replicati
 			return err
 		}
 		if !isMinimumPxc {
-			return fmt.Errorf(globals.ErrFeatureRequiresCapability, "Xtradb Cluster", common.PxcFlavor, common.IntSliceToDottedString(globals.MinimumXtradbClusterVersion))
+			return unsupportedError(globals.ErrFeatureRequiresCapability, "Xtradb Cluster", common.PxcFlavor, common.IntSliceToDottedString(globals.MinimumXtradbClusterVersion))
 		}
 		sdef.SandboxDir = path.Join(sdef.SandboxDir, defaults.Defaults().PxcPrefix+common.VersionToName(origin))
+	case globals.GaleraLabel:
+		isMinimumGalera, err := common.HasCapability(sdef.Flavor, common.GaleraCluster, sdef.Version)
+		if err != nil {
+			return err
+		}
+		if !isMinimumGalera {
+			return unsupportedError(globals.ErrFeatureRequiresCapability, "Galera Cluster", common.MariaDbFlavor,
+				common.IntSliceToDottedString(globals.MariaDbMinimumGaleraVersion))
+		}
+		sdef.SandboxDir = path.Join(sdef.SandboxDir, defaults.Defaults().GaleraPrefix+common.VersionToName(origin))
 	case globals.NdbLabel:
 		isMinimumNdb, err := common.HasCapability(sdef.Flavor, common.NdbCluster, sdef.Version)
 		if err != nil {
 			return err
 		}
 		if !isMinimumNdb {
-			return fmt.Errorf(globals.ErrFeatureRequiresCapability, "NDB Cluster", common.NdbFlavor,
+			return unsupportedError(globals.ErrFeatureRequiresCapability, "NDB Cluster", common.NdbFlavor,
 				common.IntSliceToDottedString(globals.MinimumNdbClusterVersion))
 		}
 		sdef.SandboxDir = path.Join(sdef.SandboxDir, defaults.Defaults().NdbPrefix+common.VersionToName(origin))
@@ -563,7 +723,7 @@ func CreateReplicationSandbox(sdef SandboxDef, origin string, replData Replicati
 			return err
 		}
 		if !isMinimumInnodbCluster {
-			return fmt.Errorf(globals.ErrFeatureRequiresCapability, "InnoDB Cluster", common.MySQLFlavor,
+			return unsupportedError(globals.ErrFeatureRequiresCapability, "InnoDB Cluster", common.MySQLFlavor,
 				common.IntSliceToDottedString(globals.MinimumInnoDBCluster))
 		}
 		sdef.SandboxDir = path.Join(sdef.SandboxDir, defaults.Defaults().InnoDBClusterPrefix+common.VersionToName(origin))
@@ -588,7 +748,19 @@ func CreateReplicationSandbox(sdef SandboxDef, origin string, replData Replicati
 	var err error
 	switch replData.Topology {
 	case globals.MasterSlaveLabel:
-		err = CreateMasterSlaveReplication(sdef, origin, replData.Nodes, replData.MasterIp)
+		if isPostgreSQL(sdef) {
+			err = createPostgresReplication(sdef, origin, replData.Nodes, replData.MasterIp)
+		} else {
+			err = CreateMasterSlaveReplication(sdef, origin, replData.Nodes, replData.MasterIp)
+		}
+	case globals.ChainLabel:
+		err = CreateChainReplication(sdef, origin, replData.Nodes, replData.MasterIp)
+	case globals.TreeLabel:
+		fanOut := replData.FanOut
+		if fanOut == "" {
+			fanOut = globals.FanOutValue
+		}
+		err = CreateTreeReplication(sdef, origin, replData.Nodes, replData.MasterIp, fanOut)
 	case globals.GroupLabel:
 		err = CreateGroupReplication(sdef, origin, replData.Nodes, replData.MasterIp)
 	case globals.FanInLabel:
@@ -597,10 +769,15 @@ func CreateReplicationSandbox(sdef SandboxDef, origin string, replData Replicati
 		err = CreateAllMastersReplication(sdef, origin, replData.Nodes, replData.MasterIp)
 	case globals.PxcLabel:
 		err = CreatePxcReplication(sdef, origin, replData.Nodes, replData.MasterIp)
+	case globals.GaleraLabel:
+		err = CreateGaleraReplication(sdef, origin, replData.Nodes, replData.MasterIp)
 	case globals.NdbLabel:
 		err = CreateNdbReplication(sdef, origin, replData.Nodes, replData.NdbNodes, replData.MasterIp)
 	case globals.InnoDBClusterLabel:
 		err = CreateInnoDBClusterReplication(sdef, origin, replData.Nodes, replData.MasterIp)
 	}
-	return err
+	if err != nil {
+		return err
+	}
+	return defaults.RunHook(defaults.HookPostDeploy, sdef.SandboxDir)
 }
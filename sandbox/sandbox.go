@@ -16,6 +16,7 @@
 package sandbox
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"os"
@@ -34,81 +35,92 @@ import (
 )
 
 type SandboxDef struct {
-	DirName              string           // Name of the directory containing the sandbox
-	SBType               string           // Type of sandbox (single, multiple, replication-node, group-node)
-	Multi                bool             // CoalesceString single or part of a multiple sandbox
-	NodeNum              int              // In multiple sandboxes, which node is this
-	Version              string           // MySQL version
-	Basedir              string           // Where to get binaries from (e.g. $HOME/opt/mysql/8.0.11)
-	SbHost               string           // The host for this sandbox (default 127.0.0.1)
-	Imported             bool             // The server is being imported
-	ClientBasedir        string           // Where to get client binaries from (e.g. $HOME/opt/mysql/8.0.15)
-	BasedirName          string           // The bare name of the directory containing the binaries (e.g. 8.0.11)
-	SandboxDir           string           // Target directory for sandboxes
-	ShellPath            string           // The Bash interpreter to use for generated scripts
-	StartArgs            []string         // Arguments passed to the 'start' command
-	LoadGrants           bool             // Should we load grants?
-	SkipReportHost       bool             // Do not add report-host to my.sandbox.cnf
-	SkipReportPort       bool             // Do not add report-port to my.sandbox.cnf
-	SkipStart            bool             // Do not start the server after deployment
-	InstalledPorts       []int            // Which ports should be skipped in port assignment for this SB
-	Port                 int              // Port assigned to this sandbox
-	MysqlXPort           int              // XPlugin port for this sandbox
-	AdminPort            int              // Admin port for this sandbox (8.0.14+)
-	UserPort             int              // Custom port provided by user
-	BasePort             int              // Base port for calculating more ports in multiple SB
-	MorePorts            []int            // Additional ports that belong to this sandbox
-	Prompt               string           // Prompt to use in "mysql" client
-	DbUser               string           // Database user name
-	RplUser              string           // Replication user name
-	DbPassword           string           // Database password
-	RplPassword          string           // Replication password
-	DefaultRole          string           // Role assigned to default user
-	CustomRoleName       string           // Custom role name
-	CustomRolePrivileges string           // Custom role privileges (such as 'SELECT, INSERT')
-	CustomRoleTarget     string           // Custom role target (such as 'dbName.*', or '*.*')
-	CustomRoleExtra      string           // Custom role extra (such as 'with grant option')
-	TaskUser             string           // Additional user to be created on demand
-	TaskUserRole         string           // Role to be assigned to task user
-	RemoteAccess         string           // What access have the users created for this SB (127.%)
-	BindAddress          string           // Bind address for this sandbox (127.0.0.1)
-	CustomMysqld         string           // Use an alternative mysqld executable
-	ServerId             int              // Server ID (for single sandbox)
-	BaseServerId         int              // Base Server ID (for multiple sandboxes)
-	ReplOptions          string           // Replication options, as string to append to my.sandbox.cnf
-	GtidOptions          string           // Options needed for GTID
-	ReplCrashSafeOptions string           // Options needed for Replication crash safe
-	SemiSyncOptions      string           // Options for semi-synchronous replication
-	ReadOnlyOptions      string           // Options for read-only passed to child sandboxes
-	InitOptions          []string         // Options to be added to the initialization command
-	MyCnfOptions         []string         // Options to be added to my.sandbox.cnf
-	ChangeMasterOptions  []string         // Options to be added to CHANGE MASTER TO
-	PreGrantsSql         []string         // SQL statements to execute before grants assignment
-	PreGrantsSqlFile     string           // SQL file to load before grants assignment
-	PostGrantsSql        []string         // SQL statements to run after grants assignment
-	PostGrantsSqlFile    string           // SQL file to load after grants assignment
-	MyCnfFile            string           // options file to merge with the SB my.sandbox.cnf
-	HistoryDir           string           // Where to store the MySQL client history
-	LogFileName          string           // Where to log operations for this sandbox
-	Flavor               string           // The flavor of the binaries (MySQL, Percona, NDB, etc)
-	PortAsServerId       bool             // Whether we use the port number as server ID
-	FlavorInPrompt       bool             // Add flavor to prompt
-	SocketInDatadir      bool             // Whether we want the socket in the data directory
-	SlavesReadOnly       bool             // Whether slaves will set the read_only flag
-	SlavesSuperReadOnly  bool             // Whether slaves will set the super_read_only flag
-	Logger               *defaults.Logger // Carries a logger across sandboxes
-	InitGeneralLog       bool             // Enable general log during server initialization
-	EnableGeneralLog     bool             // Enable general log for regular usage
-	NativeAuthPlugin     bool             // Use the native password plugin for MySQL 8.0.4+
-	DisableMysqlX        bool             // Disable Xplugin (MySQL 8.0.11+)
-	EnableMysqlX         bool             // Enable Xplugin (MySQL 5.7.12+)
-	EnableAdminAddress   bool             // Enable Admin address (MySQL 8.0.14+)
-	KeepUuid             bool             // Do not change UUID
-	SinglePrimary        bool             // Use single primary for group replication
-	Force                bool             // Overwrite an existing sandbox with same target
-	ExposeDdTables       bool             // Show hidden data dictionary tables (MySQL 8.0.0+)
-	RunConcurrently      bool             // Run multiple sandbox creation concurrently
-	MysqlshPath          string           // Path to mysqlsh executable
+	DirName              string            // Name of the directory containing the sandbox
+	SBType               string            // Type of sandbox (single, multiple, replication-node, group-node)
+	Multi                bool              // CoalesceString single or part of a multiple sandbox
+	NodeNum              int               // In multiple sandboxes, which node is this
+	Version              string            // MySQL version
+	Basedir              string            // Where to get binaries from (e.g. $HOME/opt/mysql/8.0.11)
+	SbHost               string            // The host for this sandbox (default 127.0.0.1)
+	Imported             bool              // The server is being imported
+	ClientBasedir        string            // Where to get client binaries from (e.g. $HOME/opt/mysql/8.0.15)
+	BasedirName          string            // The bare name of the directory containing the binaries (e.g. 8.0.11)
+	SandboxDir           string            // Target directory for sandboxes
+	ShellPath            string            // The Bash interpreter to use for generated scripts
+	StartArgs            []string          // Arguments passed to the 'start' command
+	LoadGrants           bool              // Should we load grants?
+	SkipReportHost       bool              // Do not add report-host to my.sandbox.cnf
+	SkipReportPort       bool              // Do not add report-port to my.sandbox.cnf
+	SkipStart            bool              // Do not start the server after deployment
+	InstalledPorts       []int             // Which ports should be skipped in port assignment for this SB
+	Port                 int               // Port assigned to this sandbox
+	MysqlXPort           int               // XPlugin port for this sandbox
+	AdminPort            int               // Admin port for this sandbox (8.0.14+)
+	UserPort             int               // Custom port provided by user
+	BasePort             int               // Base port for calculating more ports in multiple SB
+	MorePorts            []int             // Additional ports that belong to this sandbox
+	Prompt               string            // Prompt to use in "mysql" client
+	DbUser               string            // Database user name
+	RplUser              string            // Replication user name
+	DbPassword           string            // Database password
+	RplPassword          string            // Replication password
+	DefaultRole          string            // Role assigned to default user
+	CustomRoleName       string            // Custom role name
+	CustomRolePrivileges string            // Custom role privileges (such as 'SELECT, INSERT')
+	CustomRoleTarget     string            // Custom role target (such as 'dbName.*', or '*.*')
+	CustomRoleExtra      string            // Custom role extra (such as 'with grant option')
+	TaskUser             string            // Additional user to be created on demand
+	TaskUserRole         string            // Role to be assigned to task user
+	RemoteAccess         string            // What access have the users created for this SB (127.%)
+	BindAddress          string            // Bind address for this sandbox (127.0.0.1)
+	CustomMysqld         string            // Use an alternative mysqld executable
+	ServerId             int               // Server ID (for single sandbox)
+	BaseServerId         int               // Base Server ID (for multiple sandboxes)
+	ReplOptions          string            // Replication options, as string to append to my.sandbox.cnf
+	GtidOptions          string            // Options needed for GTID
+	ReplCrashSafeOptions string            // Options needed for Replication crash safe
+	SemiSyncOptions      string            // Options for semi-synchronous replication
+	ReadOnlyOptions      string            // Options for read-only passed to child sandboxes
+	InitOptions          []string          // Options to be added to the initialization command
+	MyCnfOptions         []string          // Options to be added to my.sandbox.cnf
+	ChangeMasterOptions  []string          // Options to be added to CHANGE MASTER TO
+	PreGrantsSql         []string          // SQL statements to execute before grants assignment
+	PreGrantsSqlFile     string            // SQL file to load before grants assignment
+	PostGrantsSql        []string          // SQL statements to run after grants assignment
+	PostGrantsSqlFile    string            // SQL file to load after grants assignment
+	MyCnfFile            string            // options file to merge with the SB my.sandbox.cnf
+	HistoryDir           string            // Where to store the MySQL client history
+	LogFileName          string            // Where to log operations for this sandbox
+	Flavor               string            // The flavor of the binaries (MySQL, Percona, NDB, etc)
+	PortAsServerId       bool              // Whether we use the port number as server ID
+	FlavorInPrompt       bool              // Add flavor to prompt
+	SocketInDatadir      bool              // Whether we want the socket in the data directory
+	SlavesReadOnly       bool              // Whether slaves will set the read_only flag
+	SlavesSuperReadOnly  bool              // Whether slaves will set the super_read_only flag
+	Logger               *defaults.Logger  // Carries a logger across sandboxes
+	InitGeneralLog       bool              // Enable general log during server initialization
+	EnableGeneralLog     bool              // Enable general log for regular usage
+	NativeAuthPlugin     bool              // Use the native password plugin for MySQL 8.0.4+
+	DisableMysqlX        bool              // Disable Xplugin (MySQL 8.0.11+)
+	EnableMysqlX         bool              // Enable Xplugin (MySQL 5.7.12+)
+	EnableAdminAddress   bool              // Enable Admin address (MySQL 8.0.14+)
+	KeepUuid             bool              // Do not change UUID
+	SinglePrimary        bool              // Use single primary for group replication
+	Force                bool              // Overwrite an existing sandbox with same target
+	ExposeDdTables       bool              // Show hidden data dictionary tables (MySQL 8.0.0+)
+	RunConcurrently      bool              // Run multiple sandbox creation concurrently
+	MysqlshPath          string            // Path to mysqlsh executable
+	WithRouter           bool              // Deploy MySQL Router with InnoDB Cluster
+	EnableTls            bool              // Generate certificates and enable TLS
+	TlsCaDir             string            // Where the certificate authority for TLS sandboxes is stored
+	SecureTransportOnly  bool              // Refuse TCP connections without TLS (MySQL 5.7.8+)
+	Owner                string            // Who deployed the sandbox
+	Tags                 map[string]string // Labels used to filter sandboxes
+	Note                 string            // Free-form description of the sandbox
+	Expires              string            // When the sandbox can be reaped (RFC3339)
+	StandbyOfHost        string            // Host of the primary that a PostgreSQL standby is copied from
+	StandbyOfPort        int               // Port of the primary that a PostgreSQL standby is copied from
+	Context              context.Context   `json:"-"` // When cancelled, stops the commands run during the deployment
 }
 
 type ScriptDef struct {
@@ -235,7 +247,7 @@ func checkDirectory(sandboxDef SandboxDef) (SandboxDef, error) {
 				sandboxDef.InstalledPorts = newInstalledPorts
 			}
 		} else {
-			return sandboxDef, fmt.Errorf("directory %s already exists. Use --force to override", sandboxDir)
+			return sandboxDef, WithKind(ErrSandboxExists, fmt.Errorf("directory %s already exists. Use --force to override", sandboxDir))
 		}
 	}
 	return sandboxDef, nil
@@ -252,11 +264,11 @@ func checkPortAvailability(caller string, sandboxType string, installedPorts []i
 		}
 	}
 	if conflict > 0 {
-		return fmt.Errorf("port conflict detected for %s (%s). Port %d is already used", sandboxType, caller, conflict)
+		return WithKind(ErrPortConflict, fmt.Errorf("port conflict detected for %s (%s). Port %d is already used", sandboxType, caller, conflict))
 	}
 	// Also check if the port is actually available on the system
 	if !common.IsPortAvailable(port) {
-		return fmt.Errorf("port conflict detected for %s (%s). Port %d is already in use by another process", sandboxType, caller, port)
+		return WithKind(ErrPortConflict, fmt.Errorf("port conflict detected for %s (%s). Port %d is already in use by another process", sandboxType, caller, port))
 	}
 	return nil
 }
@@ -307,7 +319,7 @@ func setMysqlxProperties(sandboxDef SandboxDef, socketDir string) (SandboxDef, e
 	}
 	// Check if the MySQL X port is actually available on the system
 	if !common.IsPortAvailable(mysqlxPort) {
-		return SandboxDef{}, fmt.Errorf("MySQL X port %d is already in use by another process", mysqlxPort)
+		return SandboxDef{}, WithKind(ErrPortConflict, fmt.Errorf("MySQL X port %d is already in use by another process", mysqlxPort))
 	}
 	sandboxDef.MyCnfOptions = append(sandboxDef.MyCnfOptions, fmt.Sprintf("mysqlx-port=%d", mysqlxPort))
 	sandboxDef.MyCnfOptions = append(sandboxDef.MyCnfOptions, fmt.Sprintf("mysqlx-socket=%s/mysqlx-%d.sock", socketDir, mysqlxPort))
@@ -328,7 +340,7 @@ func setAdminPortProperties(sandboxDef SandboxDef) (SandboxDef, error) {
 		return sandboxDef, err
 	}
 	if !isMinimumAdminAddress {
-		return sandboxDef, fmt.Errorf(globals.ErrOptionRequiresVersion, globals.EnableAdminAddressLabel,
+		return sandboxDef, unsupportedError(globals.ErrOptionRequiresVersion, globals.EnableAdminAddressLabel,
 			common.IntSliceToDottedString(globals.MinimumAdminAddressVersion))
 	}
 	adminPort := sandboxDef.AdminPort
@@ -341,7 +353,7 @@ func setAdminPortProperties(sandboxDef SandboxDef) (SandboxDef, error) {
 	}
 	// Check if the admin port is actually available on the system
 	if !common.IsPortAvailable(adminPort) {
-		return SandboxDef{}, fmt.Errorf("admin port %d is already in use by another process", adminPort)
+		return SandboxDef{}, WithKind(ErrPortConflict, fmt.Errorf("admin port %d is already in use by another process", adminPort))
 	}
 	sandboxDef.MyCnfOptions = append(sandboxDef.MyCnfOptions, fmt.Sprintf("admin-port=%d", adminPort))
 	sandboxDef.MyCnfOptions = append(sandboxDef.MyCnfOptions, fmt.Sprintf("admin-address=%s", sandboxDef.SbHost))
@@ -362,9 +374,20 @@ func sbError(reason, format string, args ...interface{}) error {
 	return fmt.Errorf(reason+" "+format, args...)
 }
 
+// runCmd runs a deployment command, which is killed when the context of the definition is cancelled
+func (sandboxDef SandboxDef) runCmd(command string, args []string, silent bool) (string, error) {
+	if sandboxDef.Context == nil {
+		return common.RunCmdCtrlWithArgs(command, args, silent)
+	}
+	return common.RunCmdContext(sandboxDef.Context, command, args, silent)
+}
+
 func createSingleSandbox(sandboxDef SandboxDef) (execList []concurrent.ExecutionList, err error) {
 
 	var sandboxDir string
+	if sandboxDef.Context != nil && sandboxDef.Context.Err() != nil {
+		return emptyExecutionList, sandboxDef.Context.Err()
+	}
 	if sandboxDef.SBType == "" {
 		sandboxDef.SBType = globals.SbTypeSingle
 	}
@@ -372,8 +395,11 @@ func createSingleSandbox(sandboxDef SandboxDef) (execList []concurrent.Execution
 	if sandboxDef.Flavor == "" {
 		sandboxDef.Flavor = common.MySQLFlavor
 	}
+	if isPostgreSQL(sandboxDef) {
+		return createPostgresSandbox(sandboxDef)
+	}
 
-	if sandboxDef.Flavor == common.TiDbFlavor {
+	if common.BaseFlavor(sandboxDef.Flavor) == common.TiDbFlavor {
 		// Ensures that we can run a client.
 		// Since TiDB tarballs don't include a client, we need to use one from MySQL
 		// In theory, it could be possible to circumvent this necessity by using
@@ -429,7 +455,7 @@ func createSingleSandbox(sandboxDef SandboxDef) (execList []concurrent.Execution
 	}
 	logger.Printf("Single Sandbox Definition: %s\n", sandboxDefToJson(sandboxDef))
 	if !common.DirExists(sandboxDef.Basedir) && !sandboxDef.Imported {
-		return emptyExecutionList, fmt.Errorf(globals.ErrBaseDirectoryNotFound, sandboxDef.Basedir)
+		return emptyExecutionList, WithKind(ErrMissingBinaries, fmt.Errorf(globals.ErrBaseDirectoryNotFound, sandboxDef.Basedir))
 	}
 
 	if sandboxDef.Port <= 1024 {
@@ -457,6 +483,9 @@ func createSingleSandbox(sandboxDef SandboxDef) (execList []concurrent.Execution
 	if sandboxDef.DirName == globals.ForbiddenDirName {
 		return emptyExecutionList, fmt.Errorf("the name %s cannot be used for a sandbox", sandboxDef.DirName)
 	}
+	if sandboxDef.EnableTls && sandboxDef.TlsCaDir == "" {
+		sandboxDef.TlsCaDir = path.Join(sandboxDef.SandboxDir, globals.TlsCaDirName)
+	}
 	sandboxDir = path.Join(sandboxDef.SandboxDir, sandboxDef.DirName)
 	sandboxDef.SandboxDir = sandboxDir
 	logger.Printf("Single Sandbox directory defined as %s\n", sandboxDef.SandboxDir)
@@ -496,7 +525,7 @@ func createSingleSandbox(sandboxDef SandboxDef) (execList []concurrent.Execution
 			return emptyExecutionList, err
 		}
 		if !isMinimumMySQLX {
-			return emptyExecutionList, fmt.Errorf(globals.ErrOptionRequiresVersion, globals.EnableMysqlXLabel,
+			return emptyExecutionList, unsupportedError(globals.ErrOptionRequiresVersion, globals.EnableMysqlXLabel,
 				common.IntSliceToDottedString(globals.MinimumMysqlxVersion))
 		}
 		// If the version is 8.0.11 or later, MySQL X is enabled already
@@ -533,7 +562,7 @@ func createSingleSandbox(sandboxDef SandboxDef) (execList []concurrent.Execution
 			return emptyExecutionList, err
 		}
 		if !isMinimumDataDictionary {
-			return emptyExecutionList, fmt.Errorf(globals.ErrOptionRequiresVersion, "expose-dd-tables", common.IntSliceToDottedString(globals.MinimumDataDictionaryVersion))
+			return emptyExecutionList, unsupportedError(globals.ErrOptionRequiresVersion, "expose-dd-tables", common.IntSliceToDottedString(globals.MinimumDataDictionaryVersion))
 		}
 		sandboxDef.PostGrantsSql = append(sandboxDef.PostGrantsSql, SingleTemplates[globals.TmplExposeDdTables].Contents)
 		if sandboxDef.CustomMysqld != "" && sandboxDef.CustomMysqld != "mysqld-debug" {
@@ -543,12 +572,23 @@ func createSingleSandbox(sandboxDef SandboxDef) (execList []concurrent.Execution
 		sandboxDef.CustomMysqld = "mysqld-debug"
 		logger.Printf("Using mysqld-debug for this sandbox\n")
 	}
+	if sandboxDef.SecureTransportOnly {
+		// 5.7.8
+		isMinimumSecureTransport, err := common.GreaterOrEqualVersion(sandboxDef.Version, globals.MinimumSecureTransportVersion)
+		if err != nil {
+			return emptyExecutionList, err
+		}
+		if !isMinimumSecureTransport {
+			return emptyExecutionList, unsupportedError(globals.ErrOptionRequiresVersion, globals.SecureTransportLabel,
+				common.IntSliceToDottedString(globals.MinimumSecureTransportVersion))
+		}
+	}
 	if sandboxDef.CustomMysqld != "" {
 		customMysqld := path.Join(sandboxDef.Basedir, "bin", sandboxDef.CustomMysqld)
 		if !common.ExecExists(customMysqld) {
-			return emptyExecutionList, fmt.Errorf("file %s not found or not executable\n"+
+			return emptyExecutionList, WithKind(ErrMissingBinaries, fmt.Errorf("file %s not found or not executable\n"+
 				"The file \"%s\" (defined with --custom-mysqld) must be in the same directory as the regular mysqld",
-				customMysqld, sandboxDef.CustomMysqld)
+				customMysqld, sandboxDef.CustomMysqld))
 		}
 		pluginDebugDir := fmt.Sprintf("%s/lib/plugin/debug", sandboxDef.Basedir)
 		if sandboxDef.CustomMysqld == "mysqld-debug" {
@@ -728,6 +768,11 @@ func createSingleSandbox(sandboxDef SandboxDef) (execList []concurrent.Execution
 		"ReportHost":           fmt.Sprintf("report-host=single-%d", sandboxDef.Port),
 		"ReportPort":           fmt.Sprintf("report-port=%d", sandboxDef.Port),
 		"HistoryDir":           sandboxDef.HistoryDir,
+		"TlsDir":               "",
+		"SecureTransportOnly":  sandboxDef.SecureTransportOnly,
+	}
+	if sandboxDef.EnableTls {
+		data["TlsDir"] = path.Join(sandboxDir, globals.TlsDirName)
 	}
 
 	if sandboxDef.TaskUser != "" {
@@ -796,6 +841,14 @@ func createSingleSandbox(sandboxDef SandboxDef) (execList []concurrent.Execution
 		return emptyExecutionList, sbError("tmp dir creation", "%s", err)
 	}
 	logger.Printf("Created directory %s\n", tmpDir)
+	if sandboxDef.EnableTls {
+		tlsDir := path.Join(sandboxDir, globals.TlsDirName)
+		err = writeSandboxCertificates(sandboxDef.TlsCaDir, tlsDir, []string{sandboxDef.SbHost, sandboxDef.BindAddress})
+		if err != nil {
+			return emptyExecutionList, sbError("TLS certificates creation", "%s", err)
+		}
+		logger.Printf("Created TLS certificates in %s\n", tlsDir)
+	}
 	script := ""
 	initScriptFlags := ""
 	// isMinimumDefaultInitialize, err := common.GreaterOrEqualVersion(sandboxDef.Version, globals.MinimumDefaultInitializeVersion)
@@ -867,7 +920,7 @@ func createSingleSandbox(sandboxDef SandboxDef) (execList []concurrent.Execution
 		if !common.FileExists(initDbScript) {
 			return emptyExecutionList, fmt.Errorf(globals.ErrFileNotFound, initDbScript)
 		}
-		initOutput, err := common.RunCmdCtrl(initDbScript, true)
+		initOutput, err := sandboxDef.runCmd(initDbScript, nil, true)
 		if err == nil {
 			if !sandboxDef.Multi {
 				if globals.UsingDbDeployer {
@@ -890,6 +943,10 @@ func createSingleSandbox(sandboxDef SandboxDef) (execList []concurrent.Execution
 		Port:        []int{sandboxDef.Port},
 		Nodes:       []string{},
 		Destination: sandboxDir,
+		Owner:       sandboxDef.Owner,
+		Tags:        sandboxDef.Tags,
+		Note:        sandboxDef.Note,
+		Expires:     sandboxDef.Expires,
 	}
 
 	if sandboxDef.LogFileName != "" {
@@ -906,6 +963,10 @@ func createSingleSandbox(sandboxDef SandboxDef) (execList []concurrent.Execution
 		Nodes:         0,
 		NodeNum:       sandboxDef.NodeNum,
 		LogFile:       sandboxDef.LogFileName,
+		Owner:         sandboxDef.Owner,
+		Tags:          sandboxDef.Tags,
+		Note:          sandboxDef.Note,
+		Expires:       sandboxDef.Expires,
 	}
 	if len(sandboxDef.MorePorts) > 0 {
 		for _, port := range sandboxDef.MorePorts {
@@ -1094,44 +1155,44 @@ func createSingleSandbox(sandboxDef SandboxDef) (execList []concurrent.Execution
 	} else {
 		if !sandboxDef.SkipStart {
 			logger.Printf("Running start script\n")
-			if len(sandboxDef.StartArgs) > 0 {
-				_, err = common.RunCmdWithArgs(path.Join(sandboxDir, globals.ScriptStart), sandboxDef.StartArgs)
-			} else {
-				_, err = common.RunCmd(path.Join(sandboxDir, globals.ScriptStart))
-			}
+			var startOutput string
+			startOutput, err = sandboxDef.runCmd(path.Join(sandboxDir, globals.ScriptStart), sandboxDef.StartArgs, false)
 			if err != nil {
-				return emptyExecutionList, err
+				return emptyExecutionList, StartError(startOutput, err)
 			}
 			logger.Printf("Running after start script\n")
-			_, err = common.RunCmd(path.Join(sandboxDir, globals.ScriptAfterStart))
+			_, err = sandboxDef.runCmd(path.Join(sandboxDir, globals.ScriptAfterStart), nil, false)
 			if err != nil {
 				return emptyExecutionList, err
 			}
 			if sandboxDef.LoadGrants {
 				logger.Printf("Running pre grants script\n")
-				_, err = common.RunCmdWithArgs(path.Join(sandboxDir, globals.ScriptLoadGrants), []string{globals.ScriptPreGrantsSql})
+				_, err = sandboxDef.runCmd(path.Join(sandboxDir, globals.ScriptLoadGrants), []string{globals.ScriptPreGrantsSql}, false)
 				if err != nil {
 					return emptyExecutionList, err
 				}
 				logger.Printf("Running load grants script\n")
-				_, err = common.RunCmd(path.Join(sandboxDir, globals.ScriptLoadGrants))
+				_, err = sandboxDef.runCmd(path.Join(sandboxDir, globals.ScriptLoadGrants), nil, false)
 				if err != nil {
 					return emptyExecutionList, err
 				}
 				if sandboxDef.SBType == "cluster-node" {
 					logger.Printf("Running load grants cluster script\n")
-					_, err = common.RunCmd(path.Join(sandboxDir, globals.ScriptLoadGrantsCluster))
+					_, err = sandboxDef.runCmd(path.Join(sandboxDir, globals.ScriptLoadGrantsCluster), nil, false)
 					if err != nil {
 						return emptyExecutionList, err
 					}
 				}
 				logger.Printf("Running post grants script\n")
-				_, err = common.RunCmdWithArgs(path.Join(sandboxDir, globals.ScriptLoadGrants), []string{globals.ScriptPostGrantsSql})
+				_, err = sandboxDef.runCmd(path.Join(sandboxDir, globals.ScriptLoadGrants), []string{globals.ScriptPostGrantsSql}, false)
 				if err != nil {
 					return emptyExecutionList, err
 				}
 			}
 		}
+		if sandboxDef.SBType == globals.SbTypeSingle || sandboxDef.SBType == globals.SbTypeSingleImported {
+			err = defaults.RunHook(defaults.HookPostDeploy, sandboxDir)
+		}
 	}
 	return execList, err
 }
@@ -1281,6 +1342,10 @@ func RemoveSandbox(sandboxDir, sandbox string, runConcurrently bool) (execList [
 	}
 
 	rmTargets := []string{fullPath, logDirectory}
+	snapshotsDir := SnapshotsDir(sandboxDir, sandbox)
+	if common.DirExists(snapshotsDir) {
+		rmTargets = append(rmTargets, snapshotsDir)
+	}
 
 	for _, target := range rmTargets {
 		if target == "" {
@@ -1313,6 +1378,17 @@ func RemoveSandbox(sandboxDir, sandbox string, runConcurrently bool) (execList [
 	return execList, nil
 }
 
+// UseStopForFlavor tells whether the servers of a sandbox must be halted using "stop"
+// instead of "send_kill destroy". Besides an explicit request, this happens for flavors
+// that don't support "send_kill destroy" (NDB and PXC), and for sandboxes of unknown flavor
+func UseStopForFlavor(flavor string, requested bool) bool {
+	if requested {
+		return true
+	}
+	baseFlavor := common.BaseFlavor(flavor)
+	return baseFlavor == "" || baseFlavor == common.NdbFlavor || baseFlavor == common.PxcFlavor
+}
+
 func RemoveCustomSandbox(sandboxDir, sandbox string, runConcurrently, useStop bool) (execList []concurrent.ExecutionList, err error) {
 	fullPath := path.Join(sandboxDir, sandbox)
 	if !common.DirExists(fullPath) {
@@ -1331,6 +1407,18 @@ func RemoveCustomSandbox(sandboxDir, sandbox string, runConcurrently, useStop bo
 	if err != nil {
 		return emptyExecutionList, err
 	}
+	err = defaults.RunHook(defaults.HookPreDelete, fullPath)
+	if err != nil {
+		return emptyExecutionList, err
+	}
+	// The description of the sandbox is gone when the post-delete hook runs
+	var postDeleteEnv []string
+	if defaults.HasHook(defaults.HookPostDelete) {
+		postDeleteEnv, err = defaults.HookEnvironment(defaults.HookPostDelete, fullPath)
+		if err != nil {
+			return emptyExecutionList, err
+		}
+	}
 
 	stopCmd := ""
 	sandboxDefaultMarker := path.Join(fullPath, "is_default")
@@ -1363,6 +1451,21 @@ func RemoveCustomSandbox(sandboxDir, sandbox string, runConcurrently, useStop bo
 		stopArgs = append(stopArgs, "destroy")
 	}
 
+	// MySQL Router, deployed with InnoDB Cluster, runs independently of the servers
+	routerStop := path.Join(fullPath, globals.RouterDirName, globals.ScriptStop)
+	if common.ExecExists(routerStop) {
+		if runConcurrently {
+			execList = append(execList, concurrent.ExecutionList{Logger: nil, Priority: 0,
+				Command: concurrent.ExecCommand{Cmd: routerStop, Args: []string{}}})
+		} else {
+			common.CondPrintf("Running %s\n", routerStop)
+			_, err = common.RunCmd(routerStop)
+			if err != nil {
+				return emptyExecutionList, fmt.Errorf(globals.ErrWhileStoppingSandbox, path.Join(fullPath, globals.RouterDirName))
+			}
+		}
+	}
+
 	if runConcurrently {
 		var eCommand1 = concurrent.ExecCommand{
 			Cmd:  stopCmd,
@@ -1395,6 +1498,10 @@ func RemoveCustomSandbox(sandboxDir, sandbox string, runConcurrently, useStop bo
 	}
 
 	rmTargets := []string{fullPath, logDirectory}
+	snapshotsDir := SnapshotsDir(sandboxDir, sandbox)
+	if common.DirExists(snapshotsDir) {
+		rmTargets = append(rmTargets, snapshotsDir)
+	}
 
 	for _, target := range rmTargets {
 		if target == "" {
@@ -1424,5 +1531,17 @@ func RemoveCustomSandbox(sandboxDir, sandbox string, runConcurrently, useStop bo
 			}
 		}
 	}
+	hookCmd, hookArgs, found := defaults.HookCommand(defaults.HookPostDelete, postDeleteEnv)
+	if found {
+		if runConcurrently {
+			execList = append(execList, concurrent.ExecutionList{Logger: nil, Priority: 2,
+				Command: concurrent.ExecCommand{Cmd: hookCmd, Args: hookArgs}})
+		} else {
+			_, err = common.RunCmdWithArgs(hookCmd, hookArgs)
+			if err != nil {
+				return emptyExecutionList, fmt.Errorf("hook %s failed for %s: %s", defaults.HookPostDelete, fullPath, err)
+			}
+		}
+	}
 	return execList, nil
 }
@@ -0,0 +1,375 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2022 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sandbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/globals"
+	"github.com/datacharmer/dbdeployer/unpack"
+)
+
+// SnapshotDescription is stored with every snapshot, next to the data archives
+type SnapshotDescription struct {
+	Name      string   `json:"name"`
+	Sandbox   string   `json:"sandbox"`
+	Version   string   `json:"version"`
+	Timestamp string   `json:"timestamp"`
+	Servers   []string `json:"servers"` // server directories, relative to the sandbox. Empty for a single sandbox
+	Size      int64    `json:"size"`    // total size of the archives
+}
+
+var snapshotNameRe = regexp.MustCompile(`^[\w.-]+$`)
+
+// SnapshotsDir returns the directory where the snapshots of a sandbox are stored.
+// It is outside the sandbox, and it is removed together with the sandbox
+func SnapshotsDir(sandboxHome, sandboxName string) string {
+	return path.Join(sandboxHome, globals.SnapshotsDirName, sandboxName)
+}
+
+// sandboxServerDirs returns the directories of the servers in a sandbox, relative to the sandbox.
+// A server directory has a sandbox description and a data directory
+func sandboxServerDirs(sandboxPath, relativeDir string, depth int) ([]string, error) {
+	currentDir := path.Join(sandboxPath, relativeDir)
+	if common.FileExists(path.Join(currentDir, globals.SandboxDescriptionName)) &&
+		common.DirExists(path.Join(currentDir, globals.DataDirName)) {
+		return []string{relativeDir}, nil
+	}
+	if depth == 0 {
+		return nil, nil
+	}
+	entries, err := os.ReadDir(currentDir)
+	if err != nil {
+		return nil, err
+	}
+	var servers []string
+	for _, entry := range entries {
+		if !entry.IsDir() || entry.Name() == globals.DataDirName {
+			continue
+		}
+		found, err := sandboxServerDirs(sandboxPath, path.Join(relativeDir, entry.Name()), depth-1)
+		if err != nil {
+			return nil, err
+		}
+		servers = append(servers, found...)
+	}
+	return servers, nil
+}
+
+// snapshotArchive returns the archive of one server data directory.
+// The snapshot directory has the same layout as the sandbox
+func snapshotArchive(snapshotDir, server string) string {
+	return path.Join(snapshotDir, server, globals.DataDirName+globals.TarGzExt)
+}
+
+// isServerRunning tells whether the server in a directory has a PID file
+func isServerRunning(serverPath string) bool {
+	pidFiles, _ := filepath.Glob(path.Join(serverPath, globals.DataDirName, "*.pid"))
+	return len(pidFiles) > 0
+}
+
+// runSandboxScript runs the script that operates on all the servers of a sandbox (start_all, stop_all)
+// or, for a single sandbox, the script that operates on its only server
+func runSandboxScript(sandboxPath, allScript, singleScript string) error {
+	script := path.Join(sandboxPath, allScript)
+	if !common.ExecExists(script) {
+		script = path.Join(sandboxPath, singleScript)
+	}
+	if !common.ExecExists(script) {
+		return fmt.Errorf(globals.ErrExecutableNotFound, script)
+	}
+	common.CondPrintf("Running %s\n", script)
+	_, err := common.RunCmd(script)
+	return err
+}
+
+// stopSandboxServers stops the servers of a sandbox, and reports whether any of them was running
+func stopSandboxServers(sandboxPath string, servers []string) (bool, error) {
+	running := false
+	for _, server := range servers {
+		if isServerRunning(path.Join(sandboxPath, server)) {
+			running = true
+		}
+	}
+	if !running {
+		return false, nil
+	}
+	err := runSandboxScript(sandboxPath, globals.ScriptStopAll, globals.ScriptStop)
+	if err != nil {
+		return true, fmt.Errorf(globals.ErrWhileStoppingSandbox, sandboxPath)
+	}
+	return true, nil
+}
+
+// Sandbox types whose servers do not join their group when they start (group_replication_start_on_boot=OFF)
+var groupSandboxTypes = []string{
+	globals.SbTypeGroupSinglePrimary,
+	globals.SbTypeGroupMultiPrimary,
+	globals.InnoDBClusterLabel,
+	globals.SbTypeClusterSet,
+}
+
+// startSandboxServers starts the servers of a sandbox. In group sandboxes, group replication
+// is started again, as it would be after a full outage
+func startSandboxServers(sandboxPath string) error {
+	err := runSandboxScript(sandboxPath, globals.ScriptStartAll, globals.ScriptStart)
+	if err != nil {
+		return fmt.Errorf(globals.ErrWhileStartingSandbox, sandboxPath)
+	}
+	sbDesc, err := common.ReadSandboxDescription(sandboxPath)
+	if err != nil {
+		return err
+	}
+	if !slices.Contains(groupSandboxTypes, sbDesc.SBType) {
+		return nil
+	}
+	servers, err := sandboxServerDirs(sandboxPath, "", 2)
+	if err != nil {
+		return err
+	}
+	return startGroupReplication(sandboxPath, servers)
+}
+
+// startGroupReplication bootstraps the group with the first server of each cluster, and makes the other
+// servers join it. A clusterset has one cluster for each top directory, while a group is a cluster of its own
+func startGroupReplication(sandboxPath string, servers []string) error {
+	bootstrapped := make(map[string]bool)
+	for _, server := range servers {
+		nodePath := path.Join(sandboxPath, server)
+		cluster := path.Dir(server)
+		statements := []string{"START GROUP_REPLICATION"}
+		if !bootstrapped[cluster] {
+			bootstrapped[cluster] = true
+			statements = []string{
+				"SET GLOBAL group_replication_bootstrap_group=ON",
+				"START GROUP_REPLICATION",
+				"SET GLOBAL group_replication_bootstrap_group=OFF",
+			}
+		}
+		common.CondPrintf("Starting group replication in %s\n", server)
+		err := runStatements(nodePath, statements)
+		if err != nil {
+			// A failed bootstrap must not leave the node ready to create another group
+			_ = runStatements(nodePath, []string{"SET GLOBAL group_replication_bootstrap_group=OFF"})
+			return fmt.Errorf("error starting group replication: %s", err)
+		}
+	}
+	return nil
+}
+
+func readSnapshotDescription(snapshotDir string) (SnapshotDescription, error) {
+	var description SnapshotDescription
+	fileName := path.Join(snapshotDir, globals.SnapshotDescriptionName)
+	text, err := os.ReadFile(fileName) // #nosec G304
+	if err != nil {
+		return description, err
+	}
+	err = json.Unmarshal(text, &description)
+	if err != nil {
+		return description, fmt.Errorf("error decoding %s: %s", fileName, err)
+	}
+	return description, nil
+}
+
+// CreateSnapshot stops the servers of a sandbox and archives their data directories.
+// The servers that were running are restarted afterwards, also when the snapshot fails.
+// When snapshotName is empty, the snapshot is named after the current time
+func CreateSnapshot(sandboxHome, sandboxName, snapshotName string) (description SnapshotDescription, err error) {
+	sandboxPath := path.Join(sandboxHome, sandboxName)
+	if !common.DirExists(sandboxPath) {
+		return description, fmt.Errorf(globals.ErrDirectoryNotFound, sandboxPath)
+	}
+	sbDesc, err := common.ReadSandboxDescription(sandboxPath)
+	if err != nil {
+		return description, err
+	}
+	if snapshotName == "" {
+		snapshotName = time.Now().Format("20060102-150405")
+	}
+	if !snapshotNameRe.MatchString(snapshotName) {
+		return description, fmt.Errorf("invalid snapshot name '%s'. Use only letters, digits, '_', '-', and '.'", snapshotName)
+	}
+	snapshotDir := path.Join(SnapshotsDir(sandboxHome, sandboxName), snapshotName)
+	if common.DirExists(snapshotDir) {
+		return description, fmt.Errorf("snapshot '%s' already exists for sandbox %s", snapshotName, sandboxName)
+	}
+	servers, err := sandboxServerDirs(sandboxPath, "", 2)
+	if err != nil {
+		return description, err
+	}
+	if len(servers) == 0 {
+		return description, fmt.Errorf("no data directories found in %s", sandboxPath)
+	}
+	wasRunning, err := stopSandboxServers(sandboxPath, servers)
+	if err != nil {
+		return description, err
+	}
+	if wasRunning {
+		defer func() {
+			err = restartSandboxServers(sandboxPath, err)
+		}()
+	}
+	err = os.MkdirAll(snapshotDir, globals.PublicDirectoryAttr)
+	if err != nil {
+		return description, err
+	}
+	description = SnapshotDescription{
+		Name:      snapshotName,
+		Sandbox:   sandboxName,
+		Version:   sbDesc.Version,
+		Timestamp: time.Now().Format(time.RFC3339),
+		Servers:   servers,
+	}
+	for _, server := range servers {
+		archive := snapshotArchive(snapshotDir, server)
+		err = os.MkdirAll(path.Dir(archive), globals.PublicDirectoryAttr)
+		if err == nil {
+			err = unpack.PackTarGz(archive, path.Join(sandboxPath, server, globals.DataDirName))
+		}
+		if err != nil {
+			_ = os.RemoveAll(snapshotDir)
+			return description, fmt.Errorf("error archiving the data directory of %s: %s", path.Join(sandboxName, server), err)
+		}
+		info, err := os.Stat(archive)
+		if err != nil {
+			_ = os.RemoveAll(snapshotDir)
+			return description, err
+		}
+		description.Size += info.Size()
+	}
+	text, err := json.MarshalIndent(description, "", "  ")
+	if err != nil {
+		_ = os.RemoveAll(snapshotDir)
+		return description, err
+	}
+	err = common.WriteString(string(text), path.Join(snapshotDir, globals.SnapshotDescriptionName))
+	if err != nil {
+		_ = os.RemoveAll(snapshotDir)
+		return description, err
+	}
+	return description, nil
+}
+
+// ListSnapshots returns the snapshots of a sandbox, from the oldest one
+func ListSnapshots(sandboxHome, sandboxName string) ([]SnapshotDescription, error) {
+	snapshotsDir := SnapshotsDir(sandboxHome, sandboxName)
+	if !common.DirExists(snapshotsDir) {
+		return nil, nil
+	}
+	entries, err := os.ReadDir(snapshotsDir)
+	if err != nil {
+		return nil, err
+	}
+	var snapshots []SnapshotDescription
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		description, err := readSnapshotDescription(path.Join(snapshotsDir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, description)
+	}
+	sort.SliceStable(snapshots, func(i, j int) bool { return snapshots[i].Timestamp < snapshots[j].Timestamp })
+	return snapshots, nil
+}
+
+// restoreServerData replaces the data directory of one server with the contents of an archive.
+// If the extraction fails, the previous data directory is put back
+func restoreServerData(serverPath, archive string) error {
+	dataDir := path.Join(serverPath, globals.DataDirName)
+	previousDataDir := dataDir + ".before-restore"
+	err := os.Rename(dataDir, previousDataDir)
+	if err != nil {
+		return err
+	}
+	currentDir, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	// UnpackTar changes the current directory
+	defer os.Chdir(currentDir) // #nosec G104
+	err = unpack.UnpackTar(archive, serverPath, unpack.SILENT)
+	if err != nil {
+		_ = os.RemoveAll(dataDir)
+		_ = os.Rename(previousDataDir, dataDir)
+		return err
+	}
+	return os.RemoveAll(previousDataDir)
+}
+
+// RestoreSnapshot stops the servers of a sandbox and replaces their data directories
+// with the ones saved in a snapshot. The servers that were running are restarted afterwards,
+// also when the restore fails
+func RestoreSnapshot(sandboxHome, sandboxName, snapshotName string) (err error) {
+	sandboxPath := path.Join(sandboxHome, sandboxName)
+	if !common.DirExists(sandboxPath) {
+		return fmt.Errorf(globals.ErrDirectoryNotFound, sandboxPath)
+	}
+	if isLocked(sandboxPath) {
+		return fmt.Errorf("sandbox %s is locked. Unlock it with 'dbdeployer admin unlock' before restoring", sandboxName)
+	}
+	snapshotDir := path.Join(SnapshotsDir(sandboxHome, sandboxName), snapshotName)
+	if !common.DirExists(snapshotDir) {
+		return fmt.Errorf("snapshot '%s' not found for sandbox %s", snapshotName, sandboxName)
+	}
+	description, err := readSnapshotDescription(snapshotDir)
+	if err != nil {
+		return err
+	}
+	servers, err := sandboxServerDirs(sandboxPath, "", 2)
+	if err != nil {
+		return err
+	}
+	// Nodes added or removed after the snapshot would not match the archived data
+	if strings.Join(servers, ",") != strings.Join(description.Servers, ",") {
+		return fmt.Errorf("the servers of sandbox %s (%v) do not match the ones in snapshot '%s' (%v)",
+			sandboxName, servers, snapshotName, description.Servers)
+	}
+	for _, server := range servers {
+		err = unpack.VerifyTarFile(snapshotArchive(snapshotDir, server))
+		if err != nil {
+			return err
+		}
+	}
+	wasRunning, err := stopSandboxServers(sandboxPath, servers)
+	if err != nil {
+		return err
+	}
+	if wasRunning {
+		defer func() {
+			err = restartSandboxServers(sandboxPath, err)
+		}()
+	}
+	for _, server := range servers {
+		err = restoreServerData(path.Join(sandboxPath, server), snapshotArchive(snapshotDir, server))
+		if err != nil {
+			return fmt.Errorf("error restoring the data directory of %s: %s", path.Join(sandboxName, server), err)
+		}
+	}
+	return nil
+}
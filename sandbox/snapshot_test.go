@@ -0,0 +1,171 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2022 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sandbox
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/compare"
+	"github.com/datacharmer/dbdeployer/globals"
+)
+
+// makeSnapshotSandbox creates a sandbox with stopped servers, each with one file in its data directory
+func makeSnapshotSandbox(t *testing.T, sandboxHome, sandboxName string, servers []string) {
+	sandboxPath := path.Join(sandboxHome, sandboxName)
+	err := os.MkdirAll(sandboxPath, 0755)
+	compare.OkIsNil("sandbox directory", err, t)
+	err = common.WriteSandboxDescription(sandboxPath, common.SandboxDescription{SBType: "test", Version: "8.0.36"})
+	compare.OkIsNil("sandbox description", err, t)
+	for _, server := range servers {
+		dataDir := path.Join(sandboxPath, server, globals.DataDirName)
+		err = os.MkdirAll(path.Join(dataDir, "test"), 0755)
+		compare.OkIsNil("data directory", err, t)
+		err = common.WriteSandboxDescription(path.Join(sandboxPath, server), common.SandboxDescription{SBType: "node", Version: "8.0.36"})
+		compare.OkIsNil("server description", err, t)
+		err = os.WriteFile(path.Join(dataDir, "test", "t1.ibd"), []byte("original "+server), 0640)
+		compare.OkIsNil("data file", err, t)
+	}
+}
+
+func TestSandboxServerDirs(t *testing.T) {
+	sandboxHome := t.TempDir()
+	makeSnapshotSandbox(t, sandboxHome, "msb_8_0_36", []string{""})
+	makeSnapshotSandbox(t, sandboxHome, "rsandbox_8_0_36", []string{"master", "node1", "node2"})
+	makeSnapshotSandbox(t, sandboxHome, "clusterset_msb_8_0_36", []string{"cluster1/node1", "cluster2/node1"})
+	// Directories without a sandbox description are not servers
+	err := os.MkdirAll(path.Join(sandboxHome, "rsandbox_8_0_36", "router", globals.DataDirName), 0755)
+	compare.OkIsNil("router directory", err, t)
+
+	for sandboxName, expected := range map[string][]string{
+		"msb_8_0_36":            {""},
+		"rsandbox_8_0_36":       {"master", "node1", "node2"},
+		"clusterset_msb_8_0_36": {"cluster1/node1", "cluster2/node1"},
+	} {
+		servers, err := sandboxServerDirs(path.Join(sandboxHome, sandboxName), "", 2)
+		compare.OkIsNil("servers of "+sandboxName, err, t)
+		compare.OkEqualStringSlices(t, servers, expected)
+	}
+}
+
+func TestSnapshotAndRestore(t *testing.T) {
+	sandboxHome := t.TempDir()
+	sandboxName := "rsandbox_8_0_36"
+	makeSnapshotSandbox(t, sandboxHome, sandboxName, []string{"master", "node1"})
+	masterFile := path.Join(sandboxHome, sandboxName, "master", globals.DataDirName, "test", "t1.ibd")
+
+	snapshot, err := CreateSnapshot(sandboxHome, sandboxName, "first")
+	compare.OkIsNil("first snapshot", err, t)
+	compare.OkEqualStringSlices(t, snapshot.Servers, []string{"master", "node1"})
+	compare.OkEqualBool("snapshot size", snapshot.Size > 0, true, t)
+	_, err = CreateSnapshot(sandboxHome, sandboxName, "first")
+	compare.OkIsNotNil("duplicate snapshot", err, t)
+	_, err = CreateSnapshot(sandboxHome, sandboxName, "../outside")
+	compare.OkIsNotNil("invalid snapshot name", err, t)
+
+	err = os.WriteFile(masterFile, []byte("changed"), 0640)
+	compare.OkIsNil("changing data", err, t)
+	err = os.WriteFile(path.Join(path.Dir(masterFile), "t2.ibd"), []byte("new table"), 0640)
+	compare.OkIsNil("adding data", err, t)
+	_, err = CreateSnapshot(sandboxHome, sandboxName, "second")
+	compare.OkIsNil("second snapshot", err, t)
+
+	snapshots, err := ListSnapshots(sandboxHome, sandboxName)
+	compare.OkIsNil("list snapshots", err, t)
+	compare.OkEqualInt("number of snapshots", len(snapshots), 2, t)
+
+	err = RestoreSnapshot(sandboxHome, sandboxName, "first")
+	compare.OkIsNil("restore", err, t)
+	contents, err := os.ReadFile(masterFile)
+	compare.OkIsNil("reading restored data", err, t)
+	compare.OkEqualString("restored data", string(contents), "original master", t)
+	compare.OkEqualBool("table created after the snapshot", common.FileExists(path.Join(path.Dir(masterFile), "t2.ibd")), false, t)
+	compare.OkEqualBool("previous data removed", common.DirExists(path.Join(sandboxHome, sandboxName, "master", globals.DataDirName+".before-restore")), false, t)
+
+	err = RestoreSnapshot(sandboxHome, sandboxName, "missing")
+	compare.OkIsNotNil("missing snapshot", err, t)
+
+	// A node added after the snapshot makes the snapshot incompatible
+	makeSnapshotSandbox(t, sandboxHome, sandboxName, []string{"node2"})
+	err = RestoreSnapshot(sandboxHome, sandboxName, "second")
+	compare.OkIsNotNil("restore with different nodes", err, t)
+}
+
+// makeRunningSandbox turns a snapshot sandbox into a running one: every server has a PID file,
+// and a 'use' script that records the statements it receives. stopCommands run in stop_all
+func makeRunningSandbox(t *testing.T, sandboxPath, sbType string, servers []string, stopCommands string) {
+	err := common.WriteSandboxDescription(sandboxPath, common.SandboxDescription{SBType: sbType, Version: "8.0.36"})
+	compare.OkIsNil("sandbox description", err, t)
+	for _, server := range servers {
+		serverPath := path.Join(sandboxPath, server)
+		err = os.WriteFile(path.Join(serverPath, globals.DataDirName, "mysqld.pid"), []byte("1"), 0640)
+		compare.OkIsNil("PID file", err, t)
+		err = os.WriteFile(path.Join(serverPath, globals.ScriptUse),
+			[]byte("#!/bin/sh\necho \"$5\" >> "+path.Join(serverPath, "statements")+"\n"), 0755) // #nosec G306
+		compare.OkIsNil("use script", err, t)
+	}
+	scripts := map[string]string{
+		globals.ScriptStopAll:  "rm -f " + sandboxPath + "/*/" + globals.DataDirName + "/*.pid\n" + stopCommands,
+		globals.ScriptStartAll: "touch " + path.Join(sandboxPath, "started") + "\n",
+	}
+	for script, body := range scripts {
+		err = os.WriteFile(path.Join(sandboxPath, script), []byte("#!/bin/sh\n"+body), 0755) // #nosec G306
+		compare.OkIsNil("writing "+script, err, t)
+	}
+}
+
+func TestSnapshotRestartsGroup(t *testing.T) {
+	sandboxHome := t.TempDir()
+	sandboxName := "group_msb_8_0_36"
+	sandboxPath := path.Join(sandboxHome, sandboxName)
+	servers := []string{"node1", "node2", "node3"}
+	makeSnapshotSandbox(t, sandboxHome, sandboxName, servers)
+	makeRunningSandbox(t, sandboxPath, globals.SbTypeGroupSinglePrimary, servers, "")
+
+	_, err := CreateSnapshot(sandboxHome, sandboxName, "group")
+	compare.OkIsNil("group snapshot", err, t)
+	compare.OkEqualBool("group restarted", common.FileExists(path.Join(sandboxPath, "started")), true, t)
+	statements, err := common.SlurpAsLines(path.Join(sandboxPath, "node1", "statements"))
+	compare.OkIsNil("node1 statements", err, t)
+	compare.OkEqualStringSlices(t, statements, []string{
+		"SET GLOBAL group_replication_bootstrap_group=ON",
+		"START GROUP_REPLICATION",
+		"SET GLOBAL group_replication_bootstrap_group=OFF",
+	})
+	for _, node := range []string{"node2", "node3"} {
+		statements, err = common.SlurpAsLines(path.Join(sandboxPath, node, "statements"))
+		compare.OkIsNil(node+" statements", err, t)
+		compare.OkEqualStringSlices(t, statements, []string{"START GROUP_REPLICATION"})
+	}
+}
+
+func TestSnapshotFailureRestarts(t *testing.T) {
+	sandboxHome := t.TempDir()
+	sandboxName := "rsandbox_8_0_36"
+	sandboxPath := path.Join(sandboxHome, sandboxName)
+	servers := []string{"master", "node1"}
+	makeSnapshotSandbox(t, sandboxHome, sandboxName, servers)
+	// The data directory of node1 disappears while the servers are stopped, and cannot be archived
+	makeRunningSandbox(t, sandboxPath, globals.MasterSlaveLabel, servers,
+		"rm -rf "+path.Join(sandboxPath, "node1", globals.DataDirName)+"\n")
+
+	_, err := CreateSnapshot(sandboxHome, sandboxName, "broken")
+	compare.OkIsNotNil("failed snapshot", err, t)
+	compare.OkEqualBool("restarted after a failure", common.FileExists(path.Join(sandboxPath, "started")), true, t)
+	compare.OkEqualBool("failed snapshot removed", common.DirExists(path.Join(SnapshotsDir(sandboxHome, sandboxName), "broken")), false, t)
+}
@@ -0,0 +1,579 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2022 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sandbox
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/defaults"
+	"github.com/datacharmer/dbdeployer/globals"
+)
+
+// How many seconds a slave can take to catch up with its master during a switchover
+const replicationWaitTimeout = 60
+
+// gtidSet is a set of transactions, as intervals of sequence numbers for each source UUID (and tag)
+type gtidSet map[string][][2]int64
+
+// parseGtidSet reads a GTID set in the format used by MySQL
+// ("uuid:1-5:7,uuid2:1-100"), where the UUID can be followed by a tag
+func parseGtidSet(text string) (gtidSet, error) {
+	set := make(gtidSet)
+	text = strings.Join(strings.Fields(text), "")
+	if text == "" {
+		return set, nil
+	}
+	reInterval := regexp.MustCompile(`^(\d+)(?:-(\d+))?$`)
+	for _, item := range strings.Split(text, ",") {
+		parts := strings.Split(item, ":")
+		if len(parts) < 2 {
+			return nil, fmt.Errorf("invalid GTID set '%s'", item)
+		}
+		source := strings.ToLower(parts[0])
+		for _, part := range parts[1:] {
+			matches := reInterval.FindStringSubmatch(part)
+			if matches == nil {
+				// a tag: the following intervals belong to uuid:tag
+				source = strings.ToLower(parts[0]) + ":" + part
+				continue
+			}
+			start, _ := strconv.ParseInt(matches[1], 10, 64)
+			end := start
+			if matches[2] != "" {
+				end, _ = strconv.ParseInt(matches[2], 10, 64)
+			}
+			if end < start {
+				return nil, fmt.Errorf("invalid GTID interval '%s' in '%s'", part, item)
+			}
+			set[source] = append(set[source], [2]int64{start, end})
+		}
+	}
+	for source, intervals := range set {
+		set[source] = mergeIntervals(intervals)
+	}
+	return set, nil
+}
+
+func mergeIntervals(intervals [][2]int64) [][2]int64 {
+	sort.Slice(intervals, func(i, j int) bool { return intervals[i][0] < intervals[j][0] })
+	var merged [][2]int64
+	for _, interval := range intervals {
+		last := len(merged) - 1
+		if last >= 0 && interval[0] <= merged[last][1]+1 {
+			if interval[1] > merged[last][1] {
+				merged[last][1] = interval[1]
+			}
+			continue
+		}
+		merged = append(merged, interval)
+	}
+	return merged
+}
+
+// contains returns true if every transaction of other is also in the set
+func (set gtidSet) contains(other gtidSet) bool {
+	for source, intervals := range other {
+		for _, interval := range intervals {
+			found := false
+			for _, own := range set[source] {
+				if interval[0] >= own[0] && interval[1] <= own[1] {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// count returns the number of transactions in the set
+func (set gtidSet) count() int64 {
+	var total int64
+	for _, intervals := range set {
+		for _, interval := range intervals {
+			total += interval[1] - interval[0] + 1
+		}
+	}
+	return total
+}
+
+// replicationStatements holds the statements that differ between legacy and current replication syntax
+type replicationStatements struct {
+	stopReplica      string
+	startReplica     string
+	stopIoThread     string
+	resetReplica     string
+	binaryLogStatus  string
+	waitForPosition  string
+	coordinateFormat string
+}
+
+// replicationSyntax returns the replication statements of a server flavor and version
+func replicationSyntax(flavor, version string) replicationStatements {
+	binaryLogStatus := "show master status"
+	if usesBinaryLogStatusSyntax(flavor, version) {
+		binaryLogStatus = "show binary log status"
+	}
+	if usesChangeMasterSyntax(flavor, version) {
+		return replicationStatements{
+			stopReplica:      "STOP SLAVE",
+			startReplica:     "START SLAVE",
+			stopIoThread:     "STOP SLAVE IO_THREAD",
+			resetReplica:     "RESET SLAVE ALL",
+			binaryLogStatus:  binaryLogStatus,
+			waitForPosition:  "SELECT MASTER_POS_WAIT('%s', %s, %d)",
+			coordinateFormat: `, MASTER_LOG_FILE="%s", MASTER_LOG_POS=%s`,
+		}
+	}
+	return replicationStatements{
+		stopReplica:      "STOP REPLICA",
+		startReplica:     "START REPLICA",
+		stopIoThread:     "STOP REPLICA IO_THREAD",
+		resetReplica:     "RESET REPLICA ALL",
+		binaryLogStatus:  binaryLogStatus,
+		waitForPosition:  "SELECT SOURCE_POS_WAIT('%s', %s, %d)",
+		coordinateFormat: `, SOURCE_LOG_FILE="%s", SOURCE_LOG_POS=%s`,
+	}
+}
+
+// readOnlyStatements returns the statements that enable or disable writes in a server
+func (nl nodeLayout) readOnlyStatements(readOnly bool) ([]string, error) {
+	value := "OFF"
+	if readOnly {
+		value = "ON"
+	}
+	statements := []string{"SET GLOBAL read_only=" + value}
+	hasSuperReadOnly, err := common.HasCapability(nl.sbDesc.Flavor, common.SuperReadOnly, nl.sbDesc.Version)
+	if err != nil {
+		return nil, err
+	}
+	if hasSuperReadOnly {
+		if readOnly {
+			statements = append(statements, "SET GLOBAL super_read_only=ON")
+		} else {
+			// super_read_only must be disabled first, as it implies read_only
+			statements = append([]string{"SET GLOBAL super_read_only=OFF"}, statements...)
+		}
+	}
+	return statements, nil
+}
+
+func runStatements(nodePath string, statements []string) error {
+	for _, statement := range statements {
+		_, err := runInNode(nodePath, statement)
+		if err != nil {
+			return fmt.Errorf("error running '%s' in %s: %s", statement, nodePath, err)
+		}
+	}
+	return nil
+}
+
+// moveReadOnlyOptions transfers the read-only options of the new master configuration
+// to the old master, so that the roles survive a restart
+func moveReadOnlyOptions(newMasterCnf, oldMasterCnf string) error {
+	lines, err := common.SlurpAsLines(newMasterCnf)
+	if err != nil {
+		return err
+	}
+	var kept, moved []string
+	for _, line := range lines {
+		name := optionName(strings.TrimSpace(line))
+		if name == "read-only" || name == "super-read-only" {
+			moved = append(moved, strings.TrimSpace(line))
+			continue
+		}
+		kept = append(kept, line)
+	}
+	if len(moved) == 0 {
+		return nil
+	}
+	err = common.WriteStrings(kept, newMasterCnf, "\n")
+	if err != nil {
+		return err
+	}
+	lines, err = common.SlurpAsLines(oldMasterCnf)
+	if err != nil {
+		return err
+	}
+	var updated []string
+	for _, line := range lines {
+		updated = append(updated, line)
+		if strings.TrimSpace(line) == "[mysqld]" {
+			updated = append(updated, moved...)
+		}
+	}
+	return common.WriteStrings(updated, oldMasterCnf, "\n")
+}
+
+// promote stops replication in a slave and makes it writable
+func (nl nodeLayout) promote(nodePath string, syntax replicationStatements) error {
+	readWrite, err := nl.readOnlyStatements(false)
+	if err != nil {
+		return err
+	}
+	return runStatements(nodePath, append([]string{syntax.stopReplica, syntax.resetReplica}, readWrite...))
+}
+
+// repoint connects a server to the new master, using the data of replicationData
+func repoint(logger *defaults.Logger, nodePath string, data common.StringMap, coordinates string, syntax replicationStatements, legacySyntax bool) error {
+	logger.Printf("Pointing %s to port %d\n", nodePath, data["MasterPort"])
+	statements := append([]string{syntax.stopReplica}, startReplicationStatements(data, coordinates, legacySyntax)...)
+	return runStatements(nodePath, statements)
+}
+
+// newMasterCoordinates returns the binary log coordinates of a new master, to be used
+// when replication does not use GTID
+func newMasterCoordinates(masterPath string, data common.StringMap, syntax replicationStatements) (string, error) {
+	if data["MasterAutoPosition"] != "" {
+		return "", nil
+	}
+	out, err := runInNode(masterPath, syntax.binaryLogStatus)
+	if err != nil {
+		return "", fmt.Errorf("error getting binary log status from %s: %s", masterPath, err)
+	}
+	status := strings.Fields(out)
+	if len(status) < 2 {
+		return "", nil
+	}
+	return fmt.Sprintf(syntax.coordinateFormat, status[0], status[1]), nil
+}
+
+// gtidWait holds the statements that make a slave wait for the transactions executed by its master
+type gtidWait struct {
+	executedQuery string
+	waitFormat    string
+	timeoutResult string
+}
+
+// gtidWaitStatements returns the GTID wait statements for MySQL or MariaDB.
+// WAIT_FOR_EXECUTED_GTID_SET returns 1 on timeout, while MASTER_GTID_WAIT returns -1
+func gtidWaitStatements(mariaDbGtid bool) gtidWait {
+	if mariaDbGtid {
+		return gtidWait{
+			executedQuery: "select @@global.gtid_current_pos",
+			waitFormat:    "SELECT MASTER_GTID_WAIT('%s', %d)",
+			timeoutResult: "-1",
+		}
+	}
+	return gtidWait{
+		executedQuery: "select @@global.gtid_executed",
+		waitFormat:    "SELECT WAIT_FOR_EXECUTED_GTID_SET('%s', %d)",
+		timeoutResult: "1",
+	}
+}
+
+// checkFailoverSupport makes sure that the slaves can be compared by their executed GTID sets.
+// MariaDB GTID positions do not record the full history of a server, and cannot be compared that way
+func checkFailoverSupport(flavor string, masterOptions []string, data common.StringMap) error {
+	if isMariaDbGtid(flavor, masterOptions) {
+		return fmt.Errorf("failover is not supported for MariaDB GTID sandboxes. Use a switchover while the master is running")
+	}
+	if data["MasterAutoPosition"] == "" {
+		return fmt.Errorf("failover requires GTID. Use a switchover for sandboxes without GTID")
+	}
+	return nil
+}
+
+func (nl nodeLayout) checkNewMaster(newMaster string) error {
+	if newMaster == nl.masterDir {
+		return fmt.Errorf("%s is already the master of %s", newMaster, nl.sandboxDir)
+	}
+	for _, dir := range nl.slaveDirs() {
+		if dir == newMaster {
+			return nil
+		}
+	}
+	return fmt.Errorf("node %s not found in %s", newMaster, nl.sandboxDir)
+}
+
+func readSwitchoverLayout(sandboxDir string) (nodeLayout, error) {
+	nl, err := readNodeLayout(sandboxDir)
+	if err != nil {
+		return nl, err
+	}
+	if nl.isMultiple || nl.sbDesc.SBType != globals.MasterSlaveLabel {
+		return nl, fmt.Errorf("sandbox %s has type '%s'. Only '%s' sandboxes can change their master",
+			sandboxDir, nl.sbDesc.SBType, globals.MasterSlaveLabel)
+	}
+	if isLocked(sandboxDir) {
+		return nl, fmt.Errorf("sandbox %s is locked", sandboxDir)
+	}
+	return nl, nil
+}
+
+// Switchover promotes a slave of a running master/slave sandbox to master.
+// The old master is made read-only, and all the slaves wait until they have applied
+// its transactions. Then the old master and the other slaves replicate from the new one,
+// and the sandbox scripts (m, s1, check_slaves, ...) are written again for the new roles
+func Switchover(sandboxDir, newMaster string) error {
+	nl, err := readSwitchoverLayout(sandboxDir)
+	if err != nil {
+		return err
+	}
+	err = nl.checkNewMaster(newMaster)
+	if err != nil {
+		return err
+	}
+	logger, _, err := defaults.NewLogger(common.LogDirName(), "switchover")
+	if err != nil {
+		return err
+	}
+	legacySyntax := usesChangeMasterSyntax(nl.sbDesc.Flavor, nl.sbDesc.Version)
+	syntax := replicationSyntax(nl.sbDesc.Flavor, nl.sbDesc.Version)
+	oldMaster := nl.masterDir
+	oldMasterPath := path.Join(sandboxDir, oldMaster)
+	masterOptions, err := readMysqldOptions(path.Join(oldMasterPath, globals.ScriptMySandboxCnf))
+	if err != nil {
+		return err
+	}
+	data, err := nl.replicationData(logger, masterOptions)
+	if err != nil {
+		return err
+	}
+	usingGtid := data["MasterAutoPosition"] != ""
+
+	common.CondPrintf("Setting %s read-only\n", oldMaster)
+	readOnly, err := nl.readOnlyStatements(true)
+	if err != nil {
+		return err
+	}
+	err = runStatements(oldMasterPath, readOnly)
+	if err != nil {
+		return err
+	}
+
+	waitStatement := ""
+	gtidWait := gtidWaitStatements(isMariaDbGtid(nl.sbDesc.Flavor, masterOptions))
+	if usingGtid {
+		out, err := runInNode(oldMasterPath, gtidWait.executedQuery)
+		if err != nil {
+			return fmt.Errorf("error getting executed transactions from %s: %s", oldMasterPath, err)
+		}
+		executed := strings.Join(strings.Fields(out), "")
+		if executed != "" {
+			waitStatement = fmt.Sprintf(gtidWait.waitFormat, executed, replicationWaitTimeout)
+		}
+	} else {
+		out, err := runInNode(oldMasterPath, syntax.binaryLogStatus)
+		if err != nil {
+			return fmt.Errorf("error getting binary log status from %s: %s", oldMasterPath, err)
+		}
+		status := strings.Fields(out)
+		if len(status) > 1 {
+			waitStatement = fmt.Sprintf(syntax.waitForPosition, status[0], status[1], replicationWaitTimeout)
+		}
+	}
+	if waitStatement != "" {
+		for _, dir := range nl.slaveDirs() {
+			common.CondPrintf("Waiting for %s to catch up with %s\n", dir, oldMaster)
+			out, err := runInNode(path.Join(sandboxDir, dir), waitStatement)
+			result := strings.TrimSpace(out)
+			// *_POS_WAIT returns -1 on timeout and NULL when replication is not running
+			if err != nil || (usingGtid && result == gtidWait.timeoutResult) || (!usingGtid && (result == "-1" || result == "NULL")) {
+				return fmt.Errorf("%s did not catch up with %s. The old master is still read-only", dir, oldMaster)
+			}
+		}
+	}
+
+	newMasterPath := path.Join(sandboxDir, newMaster)
+	common.CondPrintf("Promoting %s\n", newMaster)
+	err = nl.promote(newMasterPath, syntax)
+	if err != nil {
+		return err
+	}
+	newMasterDesc, err := common.ReadSandboxDescription(newMasterPath)
+	if err != nil {
+		return err
+	}
+	data["MasterPort"] = newMasterDesc.Port[0]
+	coordinates, err := newMasterCoordinates(newMasterPath, data, syntax)
+	if err != nil {
+		return err
+	}
+	for _, dir := range nl.members() {
+		if dir == newMaster {
+			continue
+		}
+		common.CondPrintf("Pointing %s to %s\n", dir, newMaster)
+		err = repoint(logger, path.Join(sandboxDir, dir), data, coordinates, syntax, legacySyntax)
+		if err != nil {
+			return err
+		}
+	}
+	err = moveReadOnlyOptions(path.Join(newMasterPath, globals.ScriptMySandboxCnf), path.Join(oldMasterPath, globals.ScriptMySandboxCnf))
+	if err != nil {
+		return err
+	}
+	// The old master stays read-only only if the slaves were configured that way
+	oldMasterOptions, err := readMysqldOptions(path.Join(oldMasterPath, globals.ScriptMySandboxCnf))
+	if err != nil {
+		return err
+	}
+	if !hasOption(oldMasterOptions, "read-only") && !hasOption(oldMasterOptions, "super-read-only") {
+		readWrite, err := nl.readOnlyStatements(false)
+		if err != nil {
+			return err
+		}
+		err = runStatements(oldMasterPath, readWrite)
+		if err != nil {
+			return err
+		}
+	}
+	nl.masterDir = newMaster
+	return nl.refreshNodeScripts(logger)
+}
+
+// Failover promotes the most up-to-date slave of a master/slave sandbox whose master is
+// no longer available. It requires MySQL GTID: the slaves apply the transactions they have received,
+// and the one whose executed GTID set includes all the others becomes the master.
+// If newMaster is not empty, that node is promoted, provided that it is the most up-to-date.
+// Returns the name of the new master
+func Failover(sandboxDir, newMaster string) (string, error) {
+	nl, err := readSwitchoverLayout(sandboxDir)
+	if err != nil {
+		return "", err
+	}
+	if newMaster != "" {
+		err = nl.checkNewMaster(newMaster)
+		if err != nil {
+			return "", err
+		}
+	}
+	logger, _, err := defaults.NewLogger(common.LogDirName(), "failover")
+	if err != nil {
+		return "", err
+	}
+	legacySyntax := usesChangeMasterSyntax(nl.sbDesc.Flavor, nl.sbDesc.Version)
+	syntax := replicationSyntax(nl.sbDesc.Flavor, nl.sbDesc.Version)
+	oldMaster := nl.masterDir
+	// The configuration of the old master is read from file: the server is not contacted
+	masterOptions, err := readMysqldOptions(path.Join(sandboxDir, oldMaster, globals.ScriptMySandboxCnf))
+	if err != nil {
+		return "", err
+	}
+	data, err := nl.replicationData(logger, masterOptions)
+	if err != nil {
+		return "", err
+	}
+	err = checkFailoverSupport(nl.sbDesc.Flavor, masterOptions, data)
+	if err != nil {
+		return "", err
+	}
+
+	executedSets := make(map[string]gtidSet)
+	slaveDirs := nl.slaveDirs()
+	for _, dir := range slaveDirs {
+		nodePath := path.Join(sandboxDir, dir)
+		_, err = runInNode(nodePath, syntax.stopIoThread)
+		if err != nil {
+			return "", fmt.Errorf("error stopping replication in %s: %s", nodePath, err)
+		}
+		out, err := runInNode(nodePath, "select received_transaction_set from performance_schema.replication_connection_status")
+		if err != nil {
+			return "", fmt.Errorf("error getting received transactions from %s: %s", nodePath, err)
+		}
+		received := strings.Join(strings.Fields(out), "")
+		if received != "" {
+			common.CondPrintf("Waiting for %s to apply its relay logs\n", dir)
+			out, err = runInNode(nodePath, fmt.Sprintf("SELECT WAIT_FOR_EXECUTED_GTID_SET('%s', %d)", received, replicationWaitTimeout))
+			if err != nil || strings.TrimSpace(out) == "1" {
+				return "", fmt.Errorf("%s did not apply its relay logs in %d seconds", dir, replicationWaitTimeout)
+			}
+		}
+		out, err = runInNode(nodePath, "select @@global.gtid_executed")
+		if err != nil {
+			return "", fmt.Errorf("error getting executed transactions from %s: %s", nodePath, err)
+		}
+		executedSets[dir], err = parseGtidSet(out)
+		if err != nil {
+			return "", fmt.Errorf("error reading executed transactions from %s: %s", nodePath, err)
+		}
+		logger.Printf("%s has executed %d transactions\n", dir, executedSets[dir].count())
+	}
+
+	candidates := slaveDirs
+	if newMaster != "" {
+		candidates = []string{newMaster}
+	}
+	chosen := ""
+	for _, candidate := range candidates {
+		isComplete := true
+		for _, dir := range slaveDirs {
+			if !executedSets[candidate].contains(executedSets[dir]) {
+				isComplete = false
+				if newMaster != "" {
+					return "", fmt.Errorf("%s is missing transactions that were applied by %s", newMaster, dir)
+				}
+				break
+			}
+		}
+		if isComplete {
+			chosen = candidate
+			break
+		}
+	}
+	if chosen == "" {
+		return "", fmt.Errorf("none of the slaves has applied all the transactions of the others")
+	}
+
+	newMasterPath := path.Join(sandboxDir, chosen)
+	common.CondPrintf("Promoting %s (%d transactions)\n", chosen, executedSets[chosen].count())
+	err = nl.promote(newMasterPath, syntax)
+	if err != nil {
+		return "", err
+	}
+	newMasterDesc, err := common.ReadSandboxDescription(newMasterPath)
+	if err != nil {
+		return "", err
+	}
+	data["MasterPort"] = newMasterDesc.Port[0]
+	for _, dir := range slaveDirs {
+		if dir == chosen {
+			continue
+		}
+		common.CondPrintf("Pointing %s to %s\n", dir, chosen)
+		err = repoint(logger, path.Join(sandboxDir, dir), data, "", syntax, legacySyntax)
+		if err != nil {
+			return "", err
+		}
+	}
+	oldMasterPath := path.Join(sandboxDir, oldMaster)
+	err = moveReadOnlyOptions(path.Join(newMasterPath, globals.ScriptMySandboxCnf), path.Join(oldMasterPath, globals.ScriptMySandboxCnf))
+	if err != nil {
+		return "", err
+	}
+	nl.masterDir = chosen
+	err = nl.refreshNodeScripts(logger)
+	if err != nil {
+		return "", err
+	}
+	// The old master may have transactions that were never replicated. It is left out of
+	// replication until someone inspects it and restarts it.
+	common.CondPrintf("The old master %s was not changed. After restarting it, make it a slave of %s with:\n", oldMaster, chosen)
+	for _, statement := range startReplicationStatements(data, "", legacySyntax) {
+		common.CondPrintf("    %s -u root -e '%s'\n", path.Join(oldMasterPath, globals.ScriptUse), strings.Join(strings.Fields(statement), " "))
+	}
+	return chosen, nil
+}
@@ -0,0 +1,130 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2022 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sandbox
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/compare"
+)
+
+func TestParseGtidSet(t *testing.T) {
+	const uuid1 = "3e11fa47-71ca-11e1-9e33-c80aa9429562"
+	const uuid2 = "4ba0eeba-5e21-11ee-8f4c-0242ac120002"
+	var testCases = []struct {
+		name     string
+		set      string
+		other    string
+		count    int
+		contains bool
+	}{
+		{"empty", "", "", 0, true},
+		{"superset", uuid1 + ":1-10", uuid1 + ":1-8", 10, true},
+		{"subset", uuid1 + ":1-8", uuid1 + ":1-10", 8, false},
+		{"gap", uuid1 + ":1-5:7-10", uuid1 + ":6", 9, false},
+		{"merged", uuid1 + ":1-5:6-10", uuid1 + ":3-8", 10, true},
+		{"two-sources", uuid1 + ":1-5,\n" + uuid2 + ":1-3", uuid2 + ":2", 8, true},
+		{"other-source", uuid1 + ":1-5", uuid2 + ":1", 5, false},
+		{"tagged", uuid1 + ":1-5:mytag:1-2", uuid1 + ":mytag:2", 7, true},
+		{"missing-tag", uuid1 + ":1-5", uuid1 + ":mytag:1", 5, false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			set, err := parseGtidSet(tc.set)
+			compare.OkIsNil("parsing set", err, t)
+			other, err := parseGtidSet(tc.other)
+			compare.OkIsNil("parsing other set", err, t)
+			compare.OkEqualInt("count", int(set.count()), tc.count, t)
+			compare.OkEqualBool("contains", set.contains(other), tc.contains, t)
+		})
+	}
+	for _, invalid := range []string{"no-intervals", uuid1 + ":5-1"} {
+		_, err := parseGtidSet(invalid)
+		compare.OkIsNotNil("invalid set "+invalid, err, t)
+	}
+}
+
+func TestMoveReadOnlyOptions(t *testing.T) {
+	dir := t.TempDir()
+	newMasterCnf := path.Join(dir, "new.cnf")
+	oldMasterCnf := path.Join(dir, "old.cnf")
+	err := os.WriteFile(newMasterCnf, []byte("[mysqld]\nport=5001\nsuper_read_only=on\n"), 0600)
+	compare.OkIsNil("writing new master cnf", err, t)
+	err = os.WriteFile(oldMasterCnf, []byte("[client]\nport=5000\n[mysqld]\nport=5000\n"), 0600)
+	compare.OkIsNil("writing old master cnf", err, t)
+
+	err = moveReadOnlyOptions(newMasterCnf, oldMasterCnf)
+	compare.OkIsNil("moving options", err, t)
+	newOptions, err := readMysqldOptions(newMasterCnf)
+	compare.OkIsNil("reading new master options", err, t)
+	compare.OkEqualBool("new master read-only", hasOption(newOptions, "super-read-only"), false, t)
+	oldOptions, err := readMysqldOptions(oldMasterCnf)
+	compare.OkIsNil("reading old master options", err, t)
+	compare.OkEqualStringSlices(t, oldOptions, []string{"super_read_only=on", "port=5000"})
+}
+
+func TestReplicationSyntax(t *testing.T) {
+	var testCases = []struct {
+		flavor          string
+		version         string
+		startReplica    string
+		binaryLogStatus string
+	}{
+		{common.MySQLFlavor, "5.7.44", "START SLAVE", "show master status"},
+		{common.MySQLFlavor, "8.0.36", "START SLAVE", "show master status"},
+		{common.MySQLFlavor, "8.1.0", "START REPLICA", "show master status"},
+		{common.MySQLFlavor, "8.2.0", "START REPLICA", "show binary log status"},
+		{common.MySQLFlavor, "8.4.0", "START REPLICA", "show binary log status"},
+		{common.MariaDbFlavor, "11.4.2", "START SLAVE", "show master status"},
+	}
+	for _, tc := range testCases {
+		syntax := replicationSyntax(tc.flavor, tc.version)
+		compare.OkEqualString(tc.flavor+" "+tc.version+" start", syntax.startReplica, tc.startReplica, t)
+		compare.OkEqualString(tc.flavor+" "+tc.version+" status", syntax.binaryLogStatus, tc.binaryLogStatus, t)
+	}
+}
+
+func TestGtidWaitStatements(t *testing.T) {
+	mariaDbOptions := []string{"log-slave-updates", "gtid_strict_mode=ON"}
+	mysqlOptions := []string{"gtid_mode=ON", "enforce-gtid-consistency"}
+
+	wait := gtidWaitStatements(isMariaDbGtid(common.MariaDbFlavor, mariaDbOptions))
+	compare.OkEqualString("MariaDB executed query", wait.executedQuery, "select @@global.gtid_current_pos", t)
+	compare.OkEqualString("MariaDB wait", fmt.Sprintf(wait.waitFormat, "0-1-10", 60), "SELECT MASTER_GTID_WAIT('0-1-10', 60)", t)
+	compare.OkEqualString("MariaDB timeout", wait.timeoutResult, "-1", t)
+
+	wait = gtidWaitStatements(isMariaDbGtid(common.MySQLFlavor, mysqlOptions))
+	compare.OkEqualString("MySQL executed query", wait.executedQuery, "select @@global.gtid_executed", t)
+	compare.OkMatchesString("MySQL wait", wait.waitFormat, `^SELECT WAIT_FOR_EXECUTED_GTID_SET`, t)
+	compare.OkEqualString("MySQL timeout", wait.timeoutResult, "1", t)
+}
+
+func TestCheckFailoverSupport(t *testing.T) {
+	gtidData := common.StringMap{"MasterAutoPosition": "MASTER_USE_GTID=slave_pos"}
+	err := checkFailoverSupport(common.MariaDbFlavor, []string{"gtid_strict_mode=ON"}, gtidData)
+	compare.OkIsNotNil("MariaDB GTID failover", err, t)
+	if err != nil {
+		compare.OkMatchesString("MariaDB GTID failover error", err.Error(), `not supported for MariaDB`, t)
+	}
+	err = checkFailoverSupport(common.MySQLFlavor, []string{"gtid_mode=ON"}, common.StringMap{"MasterAutoPosition": "MASTER_AUTO_POSITION=1"})
+	compare.OkIsNil("MySQL GTID failover", err, t)
+	err = checkFailoverSupport(common.MySQLFlavor, nil, common.StringMap{"MasterAutoPosition": ""})
+	compare.OkIsNotNil("failover without GTID", err, t)
+}
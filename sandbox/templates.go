@@ -140,6 +140,9 @@ var (
 	//go:embed templates/single/gtid_options_57.gotxt
 	gtidOptions57 string
 
+	//go:embed templates/single/gtid_options_mariadb.gotxt
+	gtidOptionsMariaDb string
+
 	//go:embed templates/single/expose_dd_tables.gotxt
 	exposeDdTables string
 
@@ -152,6 +155,9 @@ var (
 	//go:embed templates/mock/mysqld_safe_mock.gotxt
 	mysqldSafeMockTemplate string
 
+	//go:embed templates/mock/mysqld_safe_server_mock.gotxt
+	mysqldSafeServerMockTemplate string
+
 	//go:embed templates/mock/tidb_mock.gotxt
 	tidbMockTemplate string
 
@@ -222,6 +228,11 @@ var (
 			Notes:       "",
 			Contents:    gtidOptions57,
 		},
+		globals.TmplGtidOptionsMariaDb: TemplateDesc{
+			Description: "GTID options for my.cnf MariaDB 10.0+",
+			Notes:       "gtid_domain_id is added for each node in replication",
+			Contents:    gtidOptionsMariaDb,
+		},
 		globals.TmplReplCrashSafeOptions: TemplateDesc{
 			Description: "Replication crash safe options",
 			Notes:       "",
@@ -444,6 +455,11 @@ var (
 			Notes:       "Used for internal tests",
 			Contents:    mysqldSafeMockTemplate,
 		},
+		globals.TmplMysqldSafeServerMock: TemplateDesc{
+			Description: "mock script for mysqld_safe that runs a mock server",
+			Notes:       "Used for internal tests",
+			Contents:    mysqldSafeServerMockTemplate,
+		},
 		globals.TmplTidbMock: TemplateDesc{
 			Description: "mock script for tidb-server",
 			Notes:       "Used for internal tests",
@@ -460,7 +476,9 @@ var (
 		"replication": ReplicationTemplates,
 		"group":       GroupTemplates,
 		"pxc":         PxcTemplates,
+		"galera":      GaleraTemplates,
 		"ndb":         NdbTemplates,
+		"postgresql":  PostgresqlTemplates,
 	}
 )
 
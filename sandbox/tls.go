@@ -0,0 +1,212 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2022 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sandbox
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path"
+	"time"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/globals"
+	"github.com/pkg/errors"
+)
+
+// Certificate files in the TLS directory of a sandbox
+const (
+	tlsCaCert     = "ca.pem"
+	tlsCaKey      = "ca-key.pem"
+	tlsServerCert = "server-cert.pem"
+	tlsServerKey  = "server-key.pem"
+	tlsClientCert = "client-cert.pem"
+	tlsClientKey  = "client-key.pem"
+
+	tlsKeyBits      = 2048
+	tlsValidityDays = 3650
+)
+
+type certificateKeyPair struct {
+	cert *x509.Certificate
+	key  *rsa.PrivateKey
+}
+
+func newSerialNumber() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+func writePemFile(fileName, blockType string, contents []byte, mode os.FileMode) error {
+	return os.WriteFile(fileName, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: contents}), mode)
+}
+
+func readPemFile(fileName, blockType string) ([]byte, error) {
+	contents, err := os.ReadFile(fileName) // #nosec G304
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(contents)
+	if block == nil || block.Type != blockType {
+		return nil, fmt.Errorf("no %s found in %s", blockType, fileName)
+	}
+	return block.Bytes, nil
+}
+
+// signCertificate creates a key and a certificate from the given template.
+// When signer is nil, the certificate is self-signed
+func signCertificate(template *x509.Certificate, signer *certificateKeyPair) (certificateKeyPair, error) {
+	var pair certificateKeyPair
+	key, err := rsa.GenerateKey(rand.Reader, tlsKeyBits)
+	if err != nil {
+		return pair, err
+	}
+	template.SerialNumber, err = newSerialNumber()
+	if err != nil {
+		return pair, err
+	}
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().AddDate(0, 0, tlsValidityDays)
+	parent, parentKey := template, key
+	if signer != nil {
+		parent, parentKey = signer.cert, signer.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		return pair, err
+	}
+	pair.cert, err = x509.ParseCertificate(der)
+	if err != nil {
+		return pair, err
+	}
+	pair.key = key
+	return pair, nil
+}
+
+func writeKeyPair(pair certificateKeyPair, certFile, keyFile string) error {
+	err := writePemFile(certFile, "CERTIFICATE", pair.cert.Raw, 0644)
+	if err != nil {
+		return err
+	}
+	return writePemFile(keyFile, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(pair.key), 0600)
+}
+
+// certificateAuthority returns the CA stored in caDir, creating it on first use.
+// All the TLS sandboxes in a sandbox home share the same CA
+func certificateAuthority(caDir string) (certificateKeyPair, error) {
+	var ca certificateKeyPair
+	certFile := path.Join(caDir, tlsCaCert)
+	keyFile := path.Join(caDir, tlsCaKey)
+	if common.FileExists(certFile) && common.FileExists(keyFile) {
+		certBytes, err := readPemFile(certFile, "CERTIFICATE")
+		if err != nil {
+			return ca, err
+		}
+		ca.cert, err = x509.ParseCertificate(certBytes)
+		if err != nil {
+			return ca, errors.Wrapf(err, "error parsing %s", certFile)
+		}
+		keyBytes, err := readPemFile(keyFile, "RSA PRIVATE KEY")
+		if err != nil {
+			return ca, err
+		}
+		ca.key, err = x509.ParsePKCS1PrivateKey(keyBytes)
+		if err != nil {
+			return ca, errors.Wrapf(err, "error parsing %s", keyFile)
+		}
+		return ca, nil
+	}
+	if !common.DirExists(caDir) {
+		err := os.Mkdir(caDir, 0700)
+		if err != nil {
+			return ca, err
+		}
+	}
+	ca, err := signCertificate(&x509.Certificate{
+		Subject:               pkix.Name{CommonName: "dbdeployer sandbox CA"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+	}, nil)
+	if err != nil {
+		return ca, errors.Wrapf(err, "error creating certificate authority")
+	}
+	return ca, writeKeyPair(ca, certFile, keyFile)
+}
+
+// writeSandboxCertificates writes the CA certificate and a pair of server and client
+// certificates signed by the CA into tlsDir. The server certificate is valid for
+// localhost and for the given hosts
+func writeSandboxCertificates(caDir, tlsDir string, hosts []string) error {
+	ca, err := certificateAuthority(caDir)
+	if err != nil {
+		return err
+	}
+	err = os.Mkdir(tlsDir, globals.PublicDirectoryAttr)
+	if err != nil {
+		return err
+	}
+	err = writePemFile(path.Join(tlsDir, tlsCaCert), "CERTIFICATE", ca.cert.Raw, 0644)
+	if err != nil {
+		return err
+	}
+	serverTemplate := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "dbdeployer sandbox server"},
+		KeyUsage:    x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:    []string{"localhost"},
+		IPAddresses: []net.IP{net.ParseIP(globals.LocalHostIP)},
+	}
+	for _, host := range hosts {
+		ip := net.ParseIP(host)
+		switch {
+		case ip == nil:
+			serverTemplate.DNSNames = append(serverTemplate.DNSNames, host)
+		case !ip.IsUnspecified() && !ip.Equal(serverTemplate.IPAddresses[0]):
+			serverTemplate.IPAddresses = append(serverTemplate.IPAddresses, ip)
+		}
+	}
+	server, err := signCertificate(serverTemplate, &ca)
+	if err != nil {
+		return errors.Wrapf(err, "error creating server certificate")
+	}
+	err = writeKeyPair(server, path.Join(tlsDir, tlsServerCert), path.Join(tlsDir, tlsServerKey))
+	if err != nil {
+		return err
+	}
+	client, err := signCertificate(&x509.Certificate{
+		Subject:     pkix.Name{CommonName: "dbdeployer sandbox client"},
+		KeyUsage:    x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, &ca)
+	if err != nil {
+		return errors.Wrapf(err, "error creating client certificate")
+	}
+	return writeKeyPair(client, path.Join(tlsDir, tlsClientCert), path.Join(tlsDir, tlsClientKey))
+}
+
+// tlsChangeMasterOption returns the option that enables TLS in a replication channel
+func tlsChangeMasterOption(flavor, version string) string {
+	if usesChangeMasterSyntax(flavor, version) {
+		return "MASTER_SSL=1"
+	}
+	return "SOURCE_SSL=1"
+}
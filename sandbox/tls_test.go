@@ -0,0 +1,103 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2022 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sandbox
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/compare"
+)
+
+func TestWriteSandboxCertificates(t *testing.T) {
+	home := t.TempDir()
+	caDir := path.Join(home, ".ca")
+	firstDir := path.Join(home, "first")
+	secondDir := path.Join(home, "second")
+
+	err := writeSandboxCertificates(caDir, firstDir, []string{"127.0.0.1", "0.0.0.0", "db.example.com"})
+	compare.OkIsNil("first certificates", err, t)
+	err = writeSandboxCertificates(caDir, secondDir, []string{"192.168.1.10"})
+	compare.OkIsNil("second certificates", err, t)
+
+	// The CA is created once and shared by all the sandboxes
+	firstCa, err := os.ReadFile(path.Join(firstDir, tlsCaCert))
+	compare.OkIsNil("reading first CA", err, t)
+	secondCa, err := os.ReadFile(path.Join(secondDir, tlsCaCert))
+	compare.OkIsNil("reading second CA", err, t)
+	compare.OkEqualString("shared CA", string(secondCa), string(firstCa), t)
+
+	roots := x509.NewCertPool()
+	compare.OkEqualBool("CA loaded", roots.AppendCertsFromPEM(firstCa), true, t)
+	for _, tc := range []struct {
+		dir   string
+		host  string
+		valid bool
+	}{
+		{firstDir, "localhost", true},
+		{firstDir, "127.0.0.1", true},
+		{firstDir, "db.example.com", true},
+		{firstDir, "192.168.1.10", false},
+		{secondDir, "192.168.1.10", true},
+		{secondDir, "db.example.com", false},
+	} {
+		server, err := tls.LoadX509KeyPair(path.Join(tc.dir, tlsServerCert), path.Join(tc.dir, tlsServerKey))
+		compare.OkIsNil("loading server certificate", err, t)
+		cert, err := x509.ParseCertificate(server.Certificate[0])
+		compare.OkIsNil("parsing server certificate", err, t)
+		_, err = cert.Verify(x509.VerifyOptions{
+			DNSName:   tc.host,
+			Roots:     roots,
+			KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		})
+		compare.OkEqualBool(path.Base(tc.dir)+" server valid for "+tc.host, err == nil, tc.valid, t)
+	}
+
+	client, err := tls.LoadX509KeyPair(path.Join(firstDir, tlsClientCert), path.Join(firstDir, tlsClientKey))
+	compare.OkIsNil("loading client certificate", err, t)
+	cert, err := x509.ParseCertificate(client.Certificate[0])
+	compare.OkIsNil("parsing client certificate", err, t)
+	_, err = cert.Verify(x509.VerifyOptions{
+		Roots:     roots,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	compare.OkIsNil("client certificate verification", err, t)
+
+	keyInfo, err := os.Stat(path.Join(firstDir, tlsServerKey))
+	compare.OkIsNil("server key stat", err, t)
+	compare.OkEqualInt("server key permissions", int(keyInfo.Mode().Perm()), 0600, t)
+
+	// An existing TLS directory is not overwritten
+	err = writeSandboxCertificates(caDir, firstDir, nil)
+	compare.OkIsNotNil("existing TLS directory", err, t)
+}
+
+func TestTlsChangeMasterOption(t *testing.T) {
+	for version, expected := range map[string]string{
+		"5.7.44": "MASTER_SSL=1",
+		"8.0.36": "MASTER_SSL=1",
+		"8.4.0":  "SOURCE_SSL=1",
+		"9.1.0":  "SOURCE_SSL=1",
+	} {
+		option := tlsChangeMasterOption(common.MySQLFlavor, version)
+		compare.OkEqualString("TLS option for "+version, option, expected, t)
+	}
+	compare.OkEqualString("TLS option for MariaDB 11.4.2", tlsChangeMasterOption(common.MariaDbFlavor, "11.4.2"), "MASTER_SSL=1", t)
+}
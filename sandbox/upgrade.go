@@ -0,0 +1,590 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2022 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sandbox
+
+import (
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/defaults"
+	"github.com/datacharmer/dbdeployer/globals"
+)
+
+// Release series that a series before 8.0 can be upgraded to
+var upgradePaths = map[string]string{
+	"5.0": "5.1",
+	"5.1": "5.5",
+	"5.5": "5.6",
+	"5.6": "5.7",
+	"5.7": "8.0",
+}
+
+// Long-term support series. From 8.0 on, a server can be upgraded to any
+// later release, up to the next LTS series
+var ltsSeries = [][]int{{8, 0}, {8, 4}}
+
+// Sandbox types that can be upgraded in place, one server at a time
+var rollingUpgradeTypes = []string{
+	globals.SbTypeSingle,
+	globals.SbTypeMultiple,
+	globals.MasterSlaveLabel,
+	globals.SbTypeGroupSinglePrimary,
+	globals.SbTypeGroupMultiPrimary,
+	globals.AllMastersLabel,
+	globals.FanInLabel,
+}
+
+// Replication health is read from performance_schema, which has the replication tables since 5.7
+var minimumReplicationHealthVersion = []int{5, 7, 2}
+
+// Server options that were removed in a given version. An upgraded server does not start if they are in its configuration
+var removedServerOptions = []struct {
+	name      string
+	removedIn []int
+}{
+	{"transaction-write-set-extraction", []int{8, 3, 0}},
+	{"master-info-repository", []int{8, 3, 0}},
+	{"relay-log-info-repository", []int{8, 3, 0}},
+	{"default-authentication-plugin", []int{8, 4, 0}},
+}
+
+// mysql_native_password is disabled by default in 8.4, and removed in 9.0
+var maximumNativePasswordOption = []int{9, 0, 0}
+
+// Queries that return 0 when a server is healthy.
+// Channels of a replica must have both the connection and the applier running
+const (
+	replicaHealthQuery = "select if(count(*) = 0, 1, sum(c.service_state <> 'ON' or a.service_state <> 'ON')) " +
+		"from performance_schema.replication_connection_status c " +
+		"join performance_schema.replication_applier_status a using (channel_name)"
+	channelsHealthQuery = "select count(*) " +
+		"from performance_schema.replication_connection_status c " +
+		"join performance_schema.replication_applier_status a using (channel_name) " +
+		"where c.service_state <> 'ON' or a.service_state <> 'ON'"
+	groupHealthQuery = "select count(*) = 0 from performance_schema.replication_group_members " +
+		"where member_id = @@server_uuid and member_state = 'ONLINE'"
+	groupPrimaryQuery = "select (select variable_value from performance_schema.global_status " +
+		"where variable_name = 'group_replication_primary_member') = @@server_uuid"
+)
+
+// Operations of a rolling upgrade
+const (
+	upgradeServer     = "upgrade"
+	upgradeSwitchover = "switchover"
+	upgradeLeaveGroup = "leave-group"
+	upgradeVerify     = "verify"
+)
+
+// upgradeStep is one operation of a rolling upgrade. An empty node means all the servers
+type upgradeStep struct {
+	action string
+	node   string
+}
+
+func (step upgradeStep) String() string {
+	switch step.action {
+	case upgradeSwitchover:
+		return fmt.Sprintf("switch over to %s, which becomes the master", step.node)
+	case upgradeLeaveGroup:
+		return fmt.Sprintf("%s leaves the group, and a new primary is elected", step.node)
+	case upgradeVerify:
+		if step.node == "" {
+			return "verify replication in all nodes"
+		}
+		return fmt.Sprintf("verify replication in %s", step.node)
+	}
+	if step.node == "" {
+		return "upgrade the server"
+	}
+	return fmt.Sprintf("upgrade %s", step.node)
+}
+
+// RollingUpgradeOptions define an in-place upgrade of a sandbox
+type RollingUpgradeOptions struct {
+	Basedir    string // directory of the new binaries
+	Version    string
+	Flavor     string
+	Switchover bool // promote an upgraded slave instead of upgrading the master in place
+	DryRun     bool // show the plan without running it
+}
+
+type rollingUpgrade struct {
+	sandboxDir        string
+	sbDesc            common.SandboxDescription
+	options           RollingUpgradeOptions
+	servers           []string
+	upgradeWithServer bool
+}
+
+// seriesOf returns major and minor version of a version list
+func seriesOf(vList []int) string {
+	return fmt.Sprintf("%d.%d", vList[0], vList[1])
+}
+
+// CheckUpgradePath makes sure that a server can be upgraded from one version to another.
+// Before 8.0, only the next series is allowed. From 8.0, any series up to the next LTS
+func CheckUpgradePath(oldVersion, newVersion string) error {
+	oldList, err := common.VersionToList(oldVersion)
+	if err != nil {
+		return errors.Wrapf(err, "error converting old version to major/minor/rev")
+	}
+	newList, err := common.VersionToList(newVersion)
+	if err != nil {
+		return errors.Wrapf(err, "error converting new version to major/minor/rev")
+	}
+	notNewer, err := common.GreaterOrEqualVersionList(oldList, newList)
+	if err != nil {
+		return errors.Wrapf(err, globals.ErrWhileComparingVersions)
+	}
+	if notNewer {
+		return fmt.Errorf("version %s must be greater than %s", newVersion, oldVersion)
+	}
+	oldSeries := seriesOf(oldList)
+	newSeries := seriesOf(newList)
+	if oldSeries == newSeries {
+		return nil
+	}
+	if oldList[0] < 8 {
+		if upgradePaths[oldSeries] == newSeries {
+			return nil
+		}
+		return fmt.Errorf("version '%s' can only be upgraded to '%s' or to the same version with a higher revision",
+			oldSeries, upgradePaths[oldSeries])
+	}
+	for _, lts := range ltsSeries {
+		isNextLts, err := common.GreaterOrEqualVersionList(oldList[:2], lts)
+		if err != nil {
+			return err
+		}
+		if isNextLts {
+			continue
+		}
+		withinLts, err := common.GreaterOrEqualVersionList(lts, newList[:2])
+		if err != nil {
+			return err
+		}
+		if !withinLts {
+			return fmt.Errorf("version '%s' can only be upgraded up to '%s'. Upgrade to '%s' first",
+				oldSeries, seriesOf(lts), seriesOf(lts))
+		}
+		return nil
+	}
+	return nil
+}
+
+// upgradeServerOptions removes from a configuration the options that the new version does not accept.
+// The native password plugin, when used, is enabled with the option that replaced default-authentication-plugin
+func upgradeServerOptions(lines []string, oldVersion, newVersion []int) ([]string, error) {
+	var upgraded []string
+	for _, line := range lines {
+		name := optionName(strings.TrimSpace(line))
+		removed := false
+		for _, option := range removedServerOptions {
+			if name != option.name {
+				continue
+			}
+			alreadyRemoved, err := common.GreaterOrEqualVersionList(oldVersion, option.removedIn)
+			if err != nil {
+				return nil, err
+			}
+			removedNow, err := common.GreaterOrEqualVersionList(newVersion, option.removedIn)
+			if err != nil {
+				return nil, err
+			}
+			removed = !alreadyRemoved && removedNow
+		}
+		if !removed {
+			upgraded = append(upgraded, line)
+			continue
+		}
+		if name == "default-authentication-plugin" && strings.Contains(line, "mysql_native_password") {
+			hasNoNativePassword, err := common.GreaterOrEqualVersionList(newVersion, maximumNativePasswordOption)
+			if err != nil {
+				return nil, err
+			}
+			if !hasNoNativePassword {
+				upgraded = append(upgraded, "mysql-native-password=ON")
+			}
+		}
+	}
+	return upgraded, nil
+}
+
+// waitForHealth runs a health query in a server until it returns 0, or until the replication timeout
+func waitForHealth(nodePath, query string) error {
+	var out string
+	var err error
+	for attempt := 0; attempt < replicationWaitTimeout; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Second)
+		}
+		out, err = runInNode(nodePath, query)
+		if err == nil && strings.TrimSpace(out) == "0" {
+			return nil
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf("replication in %s is not healthy after %d seconds", nodePath, replicationWaitTimeout)
+}
+
+func (ru rollingUpgrade) isGroup() bool {
+	return ru.sbDesc.SBType == globals.SbTypeGroupSinglePrimary || ru.sbDesc.SBType == globals.SbTypeGroupMultiPrimary
+}
+
+// verify checks the replication health of one node, or of all the nodes that replicate
+func (ru rollingUpgrade) verify(node string) error {
+	nodes := []string{node}
+	if node == "" {
+		nodes = ru.servers
+	}
+	masterDir := ""
+	if ru.sbDesc.SBType == globals.MasterSlaveLabel {
+		nl, err := readNodeLayout(ru.sandboxDir)
+		if err != nil {
+			return err
+		}
+		masterDir = nl.masterDir
+	}
+	for _, n := range nodes {
+		query := channelsHealthQuery
+		switch {
+		case ru.isGroup():
+			query = groupHealthQuery
+		case ru.sbDesc.SBType == globals.MasterSlaveLabel:
+			if n == masterDir {
+				continue
+			}
+			query = replicaHealthQuery
+		}
+		common.CondPrintf("Verifying replication in %s\n", n)
+		err := waitForHealth(path.Join(ru.sandboxDir, n), query)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// upgradeNode restarts one server with the new binaries. Servers already at the new version are skipped,
+// so that an interrupted upgrade can be run again
+func (ru rollingUpgrade) upgradeNode(node string) error {
+	nodePath := path.Join(ru.sandboxDir, node)
+	nodeDesc, err := common.ReadSandboxDescription(nodePath)
+	if err != nil {
+		return err
+	}
+	if nodeDesc.Version == ru.options.Version {
+		common.CondPrintf("%s is already at version %s\n", nodePath, ru.options.Version)
+		return nil
+	}
+	oldVersion, err := common.VersionToList(nodeDesc.Version)
+	if err != nil {
+		return err
+	}
+	newVersion, err := common.VersionToList(ru.options.Version)
+	if err != nil {
+		return err
+	}
+	common.CondPrintf("Stopping %s\n", nodePath)
+	_, err = common.RunCmd(path.Join(nodePath, globals.ScriptStop))
+	if err != nil {
+		return errors.Wrapf(err, globals.ErrWhileStoppingSandbox, nodePath)
+	}
+	r := relocation{oldPath: nodeDesc.Basedir, newPath: ru.options.Basedir}
+	err = r.rewriteTree(nodePath)
+	if err != nil {
+		return err
+	}
+	nodeDesc, err = common.ReadSandboxDescription(nodePath)
+	if err != nil {
+		return err
+	}
+	nodeDesc.Version = ru.options.Version
+	nodeDesc.Flavor = ru.options.Flavor
+	err = common.WriteSandboxDescription(nodePath, nodeDesc)
+	if err != nil {
+		return errors.Wrapf(err, "unable to write sandbox description")
+	}
+	cnfFile := path.Join(nodePath, globals.ScriptMySandboxCnf)
+	lines, err := common.SlurpAsLines(cnfFile)
+	if err != nil {
+		return err
+	}
+	lines, err = upgradeServerOptions(lines, oldVersion, newVersion)
+	if err != nil {
+		return err
+	}
+	err = common.WriteStrings(lines, cnfFile, "\n")
+	if err != nil {
+		return err
+	}
+
+	scriptStart := path.Join(nodePath, globals.ScriptStart)
+	if ru.upgradeWithServer {
+		common.CondPrintf("Starting %s with --upgrade=FORCE\n", nodePath)
+		_, err = common.RunCmdWithArgs(scriptStart, []string{"--upgrade=FORCE"})
+	} else {
+		common.CondPrintf("Starting %s\n", nodePath)
+		_, err = common.RunCmd(scriptStart)
+	}
+	if err != nil {
+		return errors.Wrapf(err, globals.ErrWhileStartingSandbox, nodePath)
+	}
+	if !ru.upgradeWithServer {
+		_, err = common.RunCmdWithArgs(path.Join(nodePath, globals.ScriptMy), []string{"sql_upgrade"})
+		if err != nil {
+			return errors.Wrapf(err, "error while running mysql_upgrade in %s", nodePath)
+		}
+	}
+	if ru.isGroup() {
+		return runStatements(nodePath, []string{"START GROUP_REPLICATION"})
+	}
+	return nil
+}
+
+// restartReplication restarts the replication threads of the slaves, which would otherwise
+// wait for the connection retry interval before reconnecting to an upgraded master
+func (ru rollingUpgrade) restartReplication(slaves []string) error {
+	syntax := replicationSyntax(ru.options.Flavor, ru.options.Version)
+	for _, slave := range slaves {
+		common.CondPrintf("Restarting replication in %s\n", slave)
+		err := runStatements(path.Join(ru.sandboxDir, slave), []string{syntax.stopReplica, syntax.startReplica})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// groupPrimary returns the primary of a single-primary group, or an empty string if no node reports it
+func (ru rollingUpgrade) groupPrimary() string {
+	if ru.sbDesc.SBType != globals.SbTypeGroupSinglePrimary {
+		return ""
+	}
+	for _, node := range ru.servers {
+		out, err := runInNode(path.Join(ru.sandboxDir, node), groupPrimaryQuery)
+		if err == nil && strings.TrimSpace(out) == "1" {
+			return node
+		}
+	}
+	return ""
+}
+
+// plan lists the steps of the upgrade: slaves and secondaries come before the master or primary
+func (ru rollingUpgrade) plan() ([]upgradeStep, error) {
+	var steps []upgradeStep
+	switch ru.sbDesc.SBType {
+	case globals.SbTypeSingle, globals.SbTypeMultiple:
+		for _, server := range ru.servers {
+			steps = append(steps, upgradeStep{upgradeServer, server})
+		}
+	case globals.MasterSlaveLabel:
+		nl, err := readNodeLayout(ru.sandboxDir)
+		if err != nil {
+			return nil, err
+		}
+		slaves := nl.slaveDirs()
+		for _, slave := range slaves {
+			steps = append(steps, upgradeStep{upgradeServer, slave}, upgradeStep{upgradeVerify, slave})
+		}
+		if ru.options.Switchover {
+			steps = append(steps,
+				upgradeStep{upgradeSwitchover, slaves[0]},
+				upgradeStep{upgradeServer, nl.masterDir},
+				upgradeStep{upgradeVerify, nl.masterDir})
+		} else {
+			steps = append(steps, upgradeStep{upgradeServer, nl.masterDir}, upgradeStep{upgradeVerify, ""})
+		}
+	case globals.SbTypeGroupSinglePrimary, globals.SbTypeGroupMultiPrimary:
+		// Without a known primary, the nodes are upgraded in order, and the group
+		// elects a new primary when the current one leaves
+		primary := ru.groupPrimary()
+		for _, node := range ru.servers {
+			if node != primary {
+				steps = append(steps, upgradeStep{upgradeServer, node}, upgradeStep{upgradeVerify, node})
+			}
+		}
+		if primary != "" {
+			steps = append(steps,
+				upgradeStep{upgradeLeaveGroup, primary},
+				upgradeStep{upgradeServer, primary},
+				upgradeStep{upgradeVerify, primary})
+		}
+	default:
+		// Multi-source topologies: every node can be a master, and all of them are checked after each step
+		for _, node := range ru.servers {
+			steps = append(steps, upgradeStep{upgradeServer, node}, upgradeStep{upgradeVerify, ""})
+		}
+	}
+	return steps, nil
+}
+
+func (ru rollingUpgrade) runStep(step upgradeStep) error {
+	switch step.action {
+	case upgradeSwitchover:
+		return Switchover(ru.sandboxDir, step.node)
+	case upgradeLeaveGroup:
+		return runStatements(path.Join(ru.sandboxDir, step.node), []string{"STOP GROUP_REPLICATION"})
+	case upgradeVerify:
+		return ru.verify(step.node)
+	}
+	err := ru.upgradeNode(step.node)
+	if err != nil {
+		return err
+	}
+	if ru.sbDesc.SBType == globals.MasterSlaveLabel && !ru.options.Switchover {
+		nl, err := readNodeLayout(ru.sandboxDir)
+		if err != nil {
+			return err
+		}
+		if step.node == nl.masterDir {
+			return ru.restartReplication(nl.slaveDirs())
+		}
+	}
+	return nil
+}
+
+// finish records the new version in the sandbox description and in the catalog,
+// and writes again the scripts that depend on the version
+func (ru rollingUpgrade) finish() error {
+	r := relocation{oldPath: ru.sbDesc.Basedir, newPath: ru.options.Basedir}
+	err := r.rewriteTree(ru.sandboxDir)
+	if err != nil {
+		return err
+	}
+	sbDesc, err := common.ReadSandboxDescription(ru.sandboxDir)
+	if err != nil {
+		return err
+	}
+	sbDesc.Version = ru.options.Version
+	sbDesc.Flavor = ru.options.Flavor
+	err = common.WriteSandboxDescription(ru.sandboxDir, sbDesc)
+	if err != nil {
+		return errors.Wrapf(err, "unable to write sandbox description")
+	}
+	catalog, err := defaults.ReadCatalog()
+	if err != nil {
+		return err
+	}
+	if sbItem, found := catalog[ru.sandboxDir]; found {
+		sbItem.Version = ru.options.Version
+		sbItem.Flavor = ru.options.Flavor
+		sbItem.Origin = ru.options.Basedir
+		err = defaults.UpdateCatalog(ru.sandboxDir, sbItem)
+		if err != nil {
+			return errors.Wrapf(err, "unable to update catalog")
+		}
+	}
+	return refreshTopologyScripts(ru.sandboxDir, sbDesc.SBType, "upgrade")
+}
+
+// RollingUpgrade upgrades the servers of a sandbox in place, one at a time, to the binaries in options.Basedir.
+// Slaves and secondaries are upgraded first, and replication health is verified after each step.
+// The master is either upgraded in place or, with options.Switchover, replaced by an upgraded slave.
+// With options.DryRun, only the plan is shown
+func RollingUpgrade(sandboxDir string, options RollingUpgradeOptions) error {
+	ru := rollingUpgrade{sandboxDir: sandboxDir, options: options}
+	if !common.DirExists(sandboxDir) {
+		return fmt.Errorf(globals.ErrDirectoryNotFound, sandboxDir)
+	}
+	if !common.DirExists(options.Basedir) {
+		return fmt.Errorf(globals.ErrDirectoryNotFound, options.Basedir)
+	}
+	sbDesc, err := common.ReadSandboxDescription(sandboxDir)
+	if err != nil {
+		return err
+	}
+	ru.sbDesc = sbDesc
+	if !slices.Contains(rollingUpgradeTypes, sbDesc.SBType) {
+		return fmt.Errorf("sandboxes of type '%s' cannot be upgraded in place", sbDesc.SBType)
+	}
+	if common.BaseFlavor(sbDesc.Flavor) == common.MariaDbFlavor || common.BaseFlavor(options.Flavor) == common.MariaDbFlavor {
+		return fmt.Errorf("upgrade from and to MariaDB is not supported")
+	}
+	err = CheckUpgradePath(sbDesc.Version, options.Version)
+	if err != nil {
+		return err
+	}
+	if options.Switchover && sbDesc.SBType != globals.MasterSlaveLabel {
+		return fmt.Errorf("switchover is only available for '%s' sandboxes", globals.MasterSlaveLabel)
+	}
+	if options.Switchover {
+		// The switchover uses the same statements in old and new servers
+		if replicationSyntax(sbDesc.Flavor, sbDesc.Version) != replicationSyntax(options.Flavor, options.Version) {
+			return fmt.Errorf("versions %s and %s use different replication syntax. Upgrade the master in place instead of switching over",
+				sbDesc.Version, options.Version)
+		}
+	}
+	ru.upgradeWithServer, err = common.HasCapability(options.Flavor, common.UpgradeWithServer, options.Version)
+	if err != nil {
+		return errors.Wrapf(err, "error detecting upgrade capability")
+	}
+	if !ru.upgradeWithServer && !common.ExecExists(path.Join(options.Basedir, "bin", "mysql_upgrade")) {
+		return fmt.Errorf("mysql_upgrade not found in %s. Upgrade is not possible", options.Basedir)
+	}
+	if sbDesc.SBType != globals.SbTypeSingle && sbDesc.SBType != globals.SbTypeMultiple {
+		hasReplicationHealth, err := common.GreaterOrEqualVersion(options.Version, minimumReplicationHealthVersion)
+		if err != nil {
+			return err
+		}
+		if !hasReplicationHealth {
+			return unsupportedError(globals.ErrFeatureRequiresVersion, "rolling upgrade of replicated sandboxes",
+				common.IntSliceToDottedString(minimumReplicationHealthVersion))
+		}
+	}
+	ru.servers, err = sandboxServerDirs(sandboxDir, "", 1)
+	if err != nil {
+		return err
+	}
+	if len(ru.servers) == 0 {
+		return fmt.Errorf("no data directories found in %s", sandboxDir)
+	}
+	if !options.DryRun && len(ru.servers) > 1 && sbDesc.SBType != globals.SbTypeMultiple {
+		for _, server := range ru.servers {
+			if !isServerRunning(path.Join(sandboxDir, server)) {
+				return fmt.Errorf("server %s is not running. A rolling upgrade needs all the servers of the sandbox running", server)
+			}
+		}
+	}
+
+	steps, err := ru.plan()
+	if err != nil {
+		return err
+	}
+	common.CondPrintf("Upgrade plan for %s (%s -> %s)\n", path.Base(sandboxDir), sbDesc.Version, options.Version)
+	for i, step := range steps {
+		common.CondPrintf("%3d. %s\n", i+1, step)
+	}
+	if options.DryRun {
+		return nil
+	}
+	for i, step := range steps {
+		common.CondPrintf("# Step %d: %s\n", i+1, step)
+		err = ru.runStep(step)
+		if err != nil {
+			return fmt.Errorf("step %d (%s) failed: %s", i+1, step, err)
+		}
+	}
+	return ru.finish()
+}
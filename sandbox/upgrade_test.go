@@ -0,0 +1,88 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2022 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sandbox
+
+import (
+	"testing"
+
+	"github.com/datacharmer/dbdeployer/compare"
+)
+
+func TestCheckUpgradePath(t *testing.T) {
+	var testCases = []struct {
+		oldVersion string
+		newVersion string
+		allowed    bool
+	}{
+		{"5.6.51", "5.7.44", true},
+		{"5.6.51", "8.0.36", false},
+		{"5.7.44", "8.0.36", true},
+		{"8.0.35", "8.0.36", true},
+		{"8.0.36", "8.0.36", false},
+		{"8.0.36", "8.0.35", false},
+		{"8.0.36", "8.3.0", true},
+		{"8.0.36", "8.4.0", true},
+		{"8.0.36", "9.1.0", false},
+		{"8.3.0", "8.4.2", true},
+		{"8.4.2", "9.1.0", true},
+		{"9.0.1", "9.1.0", true},
+	}
+	for _, tc := range testCases {
+		err := CheckUpgradePath(tc.oldVersion, tc.newVersion)
+		compare.OkEqualBool(tc.oldVersion+" -> "+tc.newVersion, err == nil, tc.allowed, t)
+	}
+}
+
+func TestUpgradeServerOptions(t *testing.T) {
+	options := []string{
+		"[mysqld]",
+		"port=8036",
+		"transaction_write_set_extraction=XXHASH64",
+		"master-info-repository=table",
+		"relay-log-info-repository=table",
+		"default_authentication_plugin=mysql_native_password",
+		"relay-log-recovery=on",
+	}
+	var testCases = []struct {
+		name       string
+		oldVersion []int
+		newVersion []int
+		expected   []string
+	}{
+		{"8.0 to 8.0", []int{8, 0, 35}, []int{8, 0, 36}, options},
+		{"8.0 to 8.4", []int{8, 0, 36}, []int{8, 4, 0},
+			[]string{"[mysqld]", "port=8036", "mysql-native-password=ON", "relay-log-recovery=on"}},
+		{"8.0 to 9.0", []int{8, 0, 36}, []int{9, 0, 1},
+			[]string{"[mysqld]", "port=8036", "relay-log-recovery=on"}},
+		{"8.3 to 8.4", []int{8, 3, 0}, []int{8, 4, 0},
+			[]string{"[mysqld]", "port=8036", "transaction_write_set_extraction=XXHASH64",
+				"master-info-repository=table", "relay-log-info-repository=table",
+				"mysql-native-password=ON", "relay-log-recovery=on"}},
+	}
+	for _, tc := range testCases {
+		upgraded, err := upgradeServerOptions(options, tc.oldVersion, tc.newVersion)
+		compare.OkIsNil(tc.name, err, t)
+		compare.OkEqualStringSlices(t, upgraded, tc.expected)
+	}
+}
+
+func TestUpgradeStepString(t *testing.T) {
+	compare.OkEqualString("single", upgradeStep{upgradeServer, ""}.String(), "upgrade the server", t)
+	compare.OkEqualString("node", upgradeStep{upgradeServer, "node1"}.String(), "upgrade node1", t)
+	compare.OkEqualString("verify all", upgradeStep{upgradeVerify, ""}.String(), "verify replication in all nodes", t)
+	compare.OkEqualString("switchover", upgradeStep{upgradeSwitchover, "node1"}.String(),
+		"switch over to node1, which becomes the master", t)
+}
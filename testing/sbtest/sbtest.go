@@ -0,0 +1,246 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2022 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package sbtest deploys ephemeral sandboxes for Go tests.
+//
+//	func TestMyService(t *testing.T) {
+//		sb := sbtest.Single(t, "8.0")
+//		db, err := sql.Open("mysql", sb.DSN())
+//		...
+//	}
+//
+// Every sandbox is deployed in a temporary SANDBOX_HOME, and removed when the test ends.
+// Binaries are looked for in $SANDBOX_BINARY or in the sandbox-binary directory of the
+// dbdeployer defaults. When the requested version is not there, the test is skipped.
+// Setting SKIP_SBTEST skips all the tests that use this package.
+package sbtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/datacharmer/dbdeployer/api"
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/compare"
+	"github.com/datacharmer/dbdeployer/defaults"
+	"github.com/datacharmer/dbdeployer/globals"
+	"github.com/datacharmer/dbdeployer/ops"
+	"github.com/datacharmer/dbdeployer/sandbox"
+)
+
+// deployAttempts is how many ports are tried before giving up on port conflicts
+const deployAttempts = 5
+
+// Server is one database server of a sandbox
+type Server struct {
+	Name string // "master", "node1", ... in replication; the sandbox name for a single sandbox
+	Path string // the server directory, where its scripts are
+	Host string
+	Port int
+}
+
+// Sandbox is a deployed sandbox, with the information needed to connect to its servers
+type Sandbox struct {
+	Name     string
+	Path     string
+	Version  string
+	User     string
+	Password string
+	Servers  []Server // the first one is the master in replication sandboxes
+}
+
+// DSN returns the data source name of the first server, in the format used by github.com/go-sql-driver/mysql
+func (sb *Sandbox) DSN() string {
+	return sb.ServerDSN(0)
+}
+
+// ServerDSN returns the data source name of the server at the given index
+func (sb *Sandbox) ServerDSN(index int) string {
+	server := sb.Servers[index]
+	return fmt.Sprintf("%s:%s@tcp(%s:%d)/", sb.User, sb.Password, server.Host, server.Port)
+}
+
+// Ports returns the ports of all the servers
+func (sb *Sandbox) Ports() []int {
+	var ports []int
+	for _, server := range sb.Servers {
+		ports = append(ports, server.Port)
+	}
+	return ports
+}
+
+// Query runs a query in a server, and returns the single value that it produces.
+// The test fails immediately if the query does not succeed
+func Query[T comparable](t *testing.T, server Server, query string) T {
+	t.Helper()
+	result, err := ops.RunSandboxQuery[T](server.Path, query, false)
+	if err != nil {
+		t.Fatalf("error running query '%s' in %s: %s", query, server.Path, err)
+	}
+	return result.(T)
+}
+
+// Single deploys a standalone sandbox. The version can be complete (8.0.36) or abridged (8.0),
+// in which case the latest available release of that series is used
+func Single(t *testing.T, version string) *Sandbox {
+	t.Helper()
+	return deploy(t, version, func(ctx context.Context, sd sandbox.SandboxDef) error {
+		return api.DeploySingle(ctx, sd)
+	})
+}
+
+// Replication deploys a master-slave sandbox with the given number of nodes, master included
+func Replication(t *testing.T, version string, nodes int) *Sandbox {
+	t.Helper()
+	if nodes < 2 {
+		t.Fatalf("a replication sandbox needs at least 2 nodes. Requested: %d", nodes)
+	}
+	return deploy(t, version, func(ctx context.Context, sd sandbox.SandboxDef) error {
+		return api.DeployReplication(ctx, sd, sandbox.ReplicationData{
+			Topology: globals.MasterSlaveLabel,
+			MasterIp: globals.LocalHostIP,
+			Nodes:    nodes,
+		})
+	})
+}
+
+// findVersion returns the binaries directory and the full version wanted by the test, or skips the test
+func findVersion(t *testing.T, version string) (string, string) {
+	t.Helper()
+	compare.SkipOnDemand("SKIP_SBTEST", t)
+	sandboxBinary := os.Getenv("SANDBOX_BINARY")
+	if sandboxBinary == "" {
+		sandboxBinary = defaults.Defaults().SandboxBinary
+	}
+	if !common.DirExists(sandboxBinary) {
+		t.Skipf("sandbox binary directory %s not found", sandboxBinary)
+	}
+	versions, err := common.GetVersionsFromDir(sandboxBinary)
+	if err != nil || len(versions) == 0 {
+		t.Skipf("no versions found in %s", sandboxBinary)
+	}
+	for _, v := range versions {
+		if v == version {
+			return sandboxBinary, version
+		}
+	}
+	sortedVersions := common.SortVersionsSubset(versions, version)
+	if len(sortedVersions) == 0 {
+		t.Skipf("version %s not found in %s", version, sandboxBinary)
+	}
+	return sandboxBinary, sortedVersions[len(sortedVersions)-1]
+}
+
+// deploy runs a deployment function in a new sandbox home, moving to a different port on conflicts
+func deploy(t *testing.T, version string, deployFunc func(context.Context, sandbox.SandboxDef) error) *Sandbox {
+	t.Helper()
+	sandboxBinary, fullVersion := findVersion(t, version)
+	sandboxHome := t.TempDir()
+	sd, err := api.NewSandboxDef(sandboxBinary, sandboxHome, fullVersion)
+	if errors.Is(err, api.ErrMissingBinaries) {
+		t.Skipf("binaries for %s not available: %s", fullVersion, err)
+	}
+	if err != nil {
+		t.Fatalf("error defining sandbox for %s: %s", fullVersion, err)
+	}
+	// Ports of the sandboxes deployed elsewhere may be in use later, even if their servers are stopped now
+	catalog, err := defaults.ReadCatalog()
+	if err == nil {
+		for _, item := range catalog {
+			sd.InstalledPorts = append(sd.InstalledPorts, item.Port...)
+		}
+	}
+	// Registered before deploying, to remove also what a failed deployment left behind
+	t.Cleanup(func() {
+		sandboxes, err := common.GetInstalledSandboxes(sandboxHome)
+		if err != nil {
+			return
+		}
+		for _, sb := range sandboxes {
+			err = api.Delete(context.Background(), sandboxHome, sb.SandboxName)
+			compare.OkIsNil(fmt.Sprintf("removal of sandbox %s", sb.SandboxName), err, t)
+		}
+	})
+	ctx := context.Background()
+	for attempt := 1; ; attempt++ {
+		err = deployFunc(ctx, sd)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, api.ErrPortConflict) || attempt == deployAttempts {
+			t.Fatalf("error deploying sandbox %s: %s", fullVersion, err)
+		}
+		sd.Port += 100
+	}
+	sb, err := describe(sandboxHome, sd)
+	if err != nil {
+		t.Fatalf("error reading deployed sandbox: %s", err)
+	}
+	return sb
+}
+
+// describe collects the information of the only sandbox in sandboxHome
+func describe(sandboxHome string, sd sandbox.SandboxDef) (*Sandbox, error) {
+	sandboxes, err := common.GetInstalledSandboxes(sandboxHome)
+	if err != nil {
+		return nil, err
+	}
+	if len(sandboxes) != 1 {
+		return nil, fmt.Errorf("expected one sandbox in %s - found %d", sandboxHome, len(sandboxes))
+	}
+	sb := &Sandbox{
+		Name:     sandboxes[0].SandboxName,
+		Path:     path.Join(sandboxHome, sandboxes[0].SandboxName),
+		Version:  sd.Version,
+		User:     sd.DbUser,
+		Password: sd.DbPassword,
+	}
+	sbDesc, err := common.ReadSandboxDescription(sb.Path)
+	if err != nil {
+		return sb, err
+	}
+	serverNames := []string{""}
+	if sbDesc.Nodes > 0 {
+		serverNames = []string{defaults.Defaults().MasterName}
+		for node := 1; node <= sbDesc.Nodes; node++ {
+			serverNames = append(serverNames, fmt.Sprintf("%s%d", defaults.Defaults().NodePrefix, node))
+		}
+	}
+	for _, serverName := range serverNames {
+		serverPath := path.Join(sb.Path, serverName)
+		serverDesc, err := common.ReadSandboxDescription(serverPath)
+		if err != nil {
+			return sb, err
+		}
+		if len(serverDesc.Port) == 0 {
+			return sb, fmt.Errorf("no port found for server %s", serverPath)
+		}
+		name := serverName
+		if name == "" {
+			name = sb.Name
+		}
+		sb.Servers = append(sb.Servers, Server{
+			Name: name,
+			Path: serverPath,
+			Host: globals.LocalHostIP,
+			Port: serverDesc.Port[0],
+		})
+	}
+	return sb, nil
+}
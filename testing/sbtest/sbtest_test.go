@@ -0,0 +1,72 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2022 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sbtest
+
+import (
+	"fmt"
+	"path"
+	"testing"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/compare"
+	"github.com/datacharmer/dbdeployer/sandbox"
+)
+
+func TestSandboxesWithMockBinaries(t *testing.T) {
+	err := sandbox.SetMockEnvironment(sandbox.DefaultMockDir)
+	if err != nil {
+		t.Fatalf("mock dir creation failed: %s", err)
+	}
+	defer func() {
+		_ = sandbox.RemoveMockEnvironment(sandbox.DefaultMockDir)
+	}()
+	for _, version := range []string{"8.0.30", "8.0.32"} {
+		err = sandbox.CreateMockVersion(version)
+		compare.OkIsNil("mock version "+version, err, t)
+	}
+
+	var sandboxPath string
+	t.Run("single", func(t *testing.T) {
+		sb := Single(t, "8.0")
+		sandboxPath = sb.Path
+		compare.OkEqualString("version", sb.Version, "8.0.32", t)
+		compare.OkEqualString("name", sb.Name, "msb_8_0_32", t)
+		compare.OkEqualInt("servers", len(sb.Servers), 1, t)
+		compare.OkEqualString("DSN", sb.DSN(), fmt.Sprintf("msandbox:msandbox@tcp(127.0.0.1:%d)/", sb.Servers[0].Port), t)
+		compare.OkEqualBool("sandbox exists", common.DirExists(sb.Path), true, t)
+	})
+	compare.OkEqualBool("sandbox removed", common.DirExists(sandboxPath), false, t)
+
+	t.Run("replication", func(t *testing.T) {
+		sb := Replication(t, "8.0.30", 3)
+		sandboxPath = sb.Path
+		compare.OkEqualInt("servers", len(sb.Servers), 3, t)
+		compare.OkEqualString("master", sb.Servers[0].Name, "master", t)
+		compare.OkEqualString("node 2", sb.Servers[2].Path, path.Join(sb.Path, "node2"), t)
+		ports := sb.Ports()
+		compare.OkEqualBool("distinct ports", ports[0] != ports[1] && ports[1] != ports[2], true, t)
+	})
+	compare.OkEqualBool("replication removed", common.DirExists(sandboxPath), false, t)
+
+	var skipped bool
+	t.Run("missing version", func(t *testing.T) {
+		defer func() {
+			skipped = t.Skipped()
+		}()
+		Single(t, "5.1")
+	})
+	compare.OkEqualBool("skipped missing version", skipped, true, t)
+}
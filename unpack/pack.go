@@ -0,0 +1,104 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2022 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package unpack
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// PackTarGz creates a compressed tarball with the contents of sourceDir.
+// The entries are stored under the base name of sourceDir, so that the
+// tarball can be extracted with UnpackTar
+func PackTarGz(fileName, sourceDir string) (err error) {
+	info, err := os.Stat(sourceDir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("'%s' is not a directory", sourceDir)
+	}
+	// #nosec G304
+	file, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		closeErr := file.Close()
+		if err == nil {
+			err = closeErr
+		}
+	}()
+	compressor := gzip.NewWriter(file)
+	writer := tar.NewWriter(compressor)
+	parentDir := path.Dir(sourceDir)
+	err = filepath.Walk(sourceDir, func(fullName string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		// Sockets are created by a running server, and cannot be archived
+		if info.Mode()&os.ModeSocket != 0 {
+			return nil
+		}
+		name, err := filepath.Rel(parentDir, fullName)
+		if err != nil {
+			return err
+		}
+		link := ""
+		if info.Mode()&os.ModeSymlink != 0 {
+			link, err = os.Readlink(fullName)
+			if err != nil {
+				return err
+			}
+		}
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(name)
+		if info.IsDir() {
+			header.Name += "/"
+		}
+		err = writer.WriteHeader(header)
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		// #nosec G304
+		source, err := os.Open(fullName)
+		if err != nil {
+			return err
+		}
+		defer source.Close() // #nosec G307
+		_, err = io.Copy(writer, source)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	err = writer.Close()
+	if err != nil {
+		return err
+	}
+	return compressor.Close()
+}
@@ -59,6 +59,7 @@ import (
 	"path"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 
@@ -337,7 +338,9 @@ func sanitizedName(filename string) string {
 	return strings.Replace(filename, "..\\", "", -1)
 }
 
-func VerifyTarFile(fileName string) error {
+// VerifyTarFile checks that the archive is readable, and that its top directory has the same name as the archive.
+// otherDirNames are top directory names that are also accepted
+func VerifyTarFile(fileName string, otherDirNames ...string) error {
 	if !validSuffix(fileName) {
 		return fmt.Errorf("unrecognized archive suffix %s", fileName)
 	}
@@ -391,7 +394,7 @@ func VerifyTarFile(fileName string) error {
 	reSlash := regexp.MustCompile(`/.*`)
 	fileDir = reSlash.ReplaceAllString(fileDir, "")
 
-	if fileDir != expectedDirName {
+	if fileDir != expectedDirName && !slices.Contains(otherDirNames, fileDir) {
 		return fmt.Errorf("inner directory name different from tarball name\n"+
 			"Expected: %s - Found: %s", expectedDirName, fileDir)
 	}
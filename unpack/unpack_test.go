@@ -16,6 +16,7 @@
 package unpack
 
 import (
+	"os"
 	"path"
 	"strings"
 	"testing"
@@ -53,3 +54,63 @@ func Test_pathDepth(t *testing.T) {
 		})
 	}
 }
+
+func TestPackTarGz(t *testing.T) {
+	currentDir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(currentDir) // #nosec G104
+	workDir := t.TempDir()
+	sourceDir := path.Join(workDir, "data")
+	files := map[string]string{
+		"ibdata1":          "system tablespace",
+		"test/t1.ibd":      "table one",
+		"mysql/db.opt":     "options",
+		"auto.cnf":         "[auto]\nserver-uuid=1\n",
+		"empty/.gitignore": "",
+	}
+	for name, contents := range files {
+		fileName := path.Join(sourceDir, name)
+		if err = os.MkdirAll(path.Dir(fileName), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err = os.WriteFile(fileName, []byte(contents), 0640); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tarball := path.Join(workDir, "data.tar.gz")
+	if err = PackTarGz(tarball, sourceDir); err != nil {
+		t.Fatalf("error packing %s: %s", sourceDir, err)
+	}
+	if err = VerifyTarFile(tarball); err != nil {
+		t.Fatalf("error verifying %s: %s", tarball, err)
+	}
+	destination := path.Join(workDir, "restored")
+	if err = os.Mkdir(destination, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err = UnpackTar(tarball, destination, SILENT); err != nil {
+		t.Fatalf("error unpacking %s: %s", tarball, err)
+	}
+	for name, contents := range files {
+		fileName := path.Join(destination, "data", name)
+		restored, err := os.ReadFile(fileName)
+		if err != nil {
+			t.Fatalf("error reading %s: %s", fileName, err)
+		}
+		if string(restored) != contents {
+			t.Errorf("file %s: expected %q, found %q", name, contents, restored)
+		}
+		info, err := os.Stat(fileName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != 0640 {
+			t.Errorf("file %s: expected mode 0640, found %o", name, info.Mode().Perm())
+		}
+	}
+	if err = PackTarGz(path.Join(workDir, "file.tar.gz"), tarball); err == nil {
+		t.Errorf("packing a regular file should fail")
+	}
+}